@@ -0,0 +1,308 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nasp-project/model"
+	"nasp-project/structures/merkle_tree"
+	"nasp-project/util"
+	"net"
+	"sort"
+	"time"
+)
+
+// syncTreeDepth is the depth of the merkle_tree.RangeTree compared by Sync, the key space is split into 2^syncTreeDepth ranges.
+const syncTreeDepth = 10
+
+// Anti-entropy protocol. The initiator connects to the replication address of the peer and sends SYNC <depth>\r\n.
+// Then it sends syncRequest and the peer replies with syncResponse, both as JSON values, until the initiator sends
+// the done operation.
+const (
+	syncOpHashes  = "hashes"  // hashes of the given nodes of a level
+	syncOpRecords = "records" // all records in the given leaf ranges, deleted ones included
+	syncOpApply   = "apply"   // records that are newer than the ones of the peer
+	syncOpDone    = "done"
+)
+
+type syncRequest struct {
+	Op      string       `json:"op"`
+	Level   int          `json:"level,omitempty"`
+	Nodes   []int        `json:"nodes,omitempty"`
+	Records []syncRecord `json:"records,omitempty"`
+}
+
+type syncResponse struct {
+	Hashes  [][]byte     `json:"hashes,omitempty"`
+	Records []syncRecord `json:"records,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+type syncRecord struct {
+	Key       string `json:"key"`
+	Value     []byte `json:"value,omitempty"`
+	Tombstone bool   `json:"tombstone,omitempty"`
+	Timestamp uint64 `json:"timestamp"`
+}
+
+// SyncResult describes a finished Sync.
+type SyncResult struct {
+	Ranges   int // key ranges that differed
+	Received int // records taken from the peer
+	Sent     int // records sent to the peer
+}
+
+// Sync repairs the differences between the store and the peer with the given replication address
+// (see ServeReplication). Both stores build a Merkle tree over the key ranges of their data and compare it level by level,
+// then exchange the records of the ranges that differ. For every differing key the record with the newer timestamp wins,
+// a delete wins over a write with the same timestamp, so deletes are repaired as long as the tombstone exists.
+// Only regular keys are compared, the probabilistic structures and other reserved keys are not.
+// Unlike regular operations, Sync does not consume rate limit tokens.
+// Returns an error if the peer can't be reached or the data can't be read or written.
+func (kvs *KeyValueStore) Sync(peerAddress string) (*SyncResult, error) {
+	conn, err := net.DialTimeout("tcp", peerAddress, replicationTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := fmt.Fprintf(conn, "SYNC %d\r\n", syncTreeDepth); err != nil {
+		return nil, err
+	}
+	peer := &syncPeer{conn: conn, enc: json.NewEncoder(conn), dec: json.NewDecoder(bufio.NewReader(conn))}
+
+	kvs.mu.Lock()
+	tree, err := kvs.rangeTree(syncTreeDepth)
+	kvs.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// compare the trees level by level, descending only into the differing nodes
+	nodes := []int{0}
+	for level := 0; level <= syncTreeDepth && len(nodes) > 0; level++ {
+		resp, err := peer.call(syncRequest{Op: syncOpHashes, Level: level, Nodes: nodes})
+		if err != nil {
+			return nil, err
+		}
+		hashes := make([]merkle_tree.Hash, len(resp.Hashes))
+		for i, h := range resp.Hashes {
+			copy(hashes[i][:], h)
+		}
+		nodes = tree.DiffLevel(level, nodes, hashes)
+	}
+	result := &SyncResult{Ranges: len(nodes)}
+	if len(nodes) == 0 {
+		return result, peer.done()
+	}
+
+	resp, err := peer.call(syncRequest{Op: syncOpRecords, Nodes: nodes})
+	if err != nil {
+		return nil, err
+	}
+	kvs.mu.Lock()
+	local, err := kvs.rangeRecords(syncTreeDepth, nodes)
+	kvs.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	send := newerRecords(local, resp.Records)
+	receive := newerRecords(resp.Records, local)
+
+	if len(send) > 0 {
+		if _, err := peer.call(syncRequest{Op: syncOpApply, Records: send}); err != nil {
+			return nil, err
+		}
+	}
+	kvs.mu.Lock()
+	err = kvs.applySyncRecords(receive)
+	kvs.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	result.Sent, result.Received = len(send), len(receive)
+	return result, peer.done()
+}
+
+// newerRecords returns the records of a that are missing in b or win over the record with the same key in b.
+func newerRecords(a, b []syncRecord) []syncRecord {
+	byKey := make(map[string]syncRecord, len(b))
+	for _, rec := range b {
+		byKey[rec.Key] = rec
+	}
+	var result []syncRecord
+	for _, rec := range a {
+		if other, ok := byKey[rec.Key]; !ok || newerRecord(rec, other) {
+			result = append(result, rec)
+		}
+	}
+	return result
+}
+
+// newerRecord returns true if a wins over b: it has a newer timestamp, or it is a delete of a write with the same
+// timestamp, or it has the larger value. Both stores decide the same way, so they converge.
+func newerRecord(a, b syncRecord) bool {
+	if a.Timestamp != b.Timestamp {
+		return a.Timestamp > b.Timestamp
+	}
+	if a.Tombstone != b.Tombstone {
+		return a.Tombstone
+	}
+	return bytes.Compare(a.Value, b.Value) > 0
+}
+
+// syncPeer is the connection of Sync to the peer.
+type syncPeer struct {
+	conn net.Conn
+	enc  *json.Encoder
+	dec  *json.Decoder
+}
+
+// call sends a request and returns the response. Returns an error if the peer replies with one.
+func (p *syncPeer) call(req syncRequest) (*syncResponse, error) {
+	_ = p.conn.SetDeadline(time.Now().Add(time.Minute))
+	if err := p.enc.Encode(req); err != nil {
+		return nil, err
+	}
+	resp := &syncResponse{}
+	if err := p.dec.Decode(resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, errors.New("peer: " + resp.Error)
+	}
+	return resp, nil
+}
+
+func (p *syncPeer) done() error {
+	return p.enc.Encode(syncRequest{Op: syncOpDone})
+}
+
+// handleSync serves an anti-entropy session started by Sync of the peer. The Merkle tree is built once per session.
+func (kvs *KeyValueStore) handleSync(rw *bufio.ReadWriter, depth int) error {
+	enc, dec := json.NewEncoder(rw), json.NewDecoder(rw)
+	reply := func(resp syncResponse) error {
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+		return rw.Flush()
+	}
+	if depth < 0 || depth > 20 {
+		return reply(syncResponse{Error: fmt.Sprintf("invalid tree depth %d", depth)})
+	}
+
+	kvs.mu.Lock()
+	tree, err := kvs.rangeTree(depth)
+	kvs.mu.Unlock()
+	if err != nil {
+		return reply(syncResponse{Error: err.Error()})
+	}
+
+	for {
+		var req syncRequest
+		if err := dec.Decode(&req); err != nil {
+			return err
+		}
+
+		var resp syncResponse
+		err = nil
+		switch req.Op {
+		case syncOpHashes:
+			for _, node := range req.Nodes {
+				h := tree.Hash(req.Level, node)
+				resp.Hashes = append(resp.Hashes, h[:])
+			}
+		case syncOpRecords:
+			kvs.mu.Lock()
+			resp.Records, err = kvs.rangeRecords(depth, req.Nodes)
+			kvs.mu.Unlock()
+		case syncOpApply:
+			kvs.mu.Lock()
+			err = kvs.applySyncRecords(req.Records)
+			kvs.mu.Unlock()
+		case syncOpDone:
+			return nil
+		default:
+			err = fmt.Errorf("unknown operation %q", req.Op)
+		}
+		if err != nil {
+			resp = syncResponse{Error: err.Error()}
+		}
+		if err := reply(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// rangeTree builds a merkle_tree.RangeTree of the given depth over all regular keys.
+func (kvs *KeyValueStore) rangeTree(depth int) (*merkle_tree.RangeTree, error) {
+	iter, err := kvs.recordIterator("")
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Stop()
+
+	tree := merkle_tree.NewRangeTree(depth)
+	for rec := iter.Next(); rec != nil; rec = iter.Next() {
+		if !rec.Tombstone && !util.IsReservedKey(rec.Key) {
+			tree.Add(rec.Key, rec.Value)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	tree.Build()
+	return tree, nil
+}
+
+// rangeRecords returns the records of the regular keys in the given leaf ranges of a tree of the given depth,
+// including the deleted ones.
+func (kvs *KeyValueStore) rangeRecords(depth int, ranges []int) ([]syncRecord, error) {
+	sort.Ints(ranges)
+	iter, err := kvs.recordIterator("")
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Stop()
+
+	records := make([]syncRecord, 0)
+	for rec := iter.Next(); rec != nil; rec = iter.Next() {
+		if util.IsReservedKey(rec.Key) {
+			continue
+		}
+		r := merkle_tree.RangeOf(rec.Key, depth)
+		if idx := sort.SearchInts(ranges, r); idx < len(ranges) && ranges[idx] == r {
+			records = append(records, syncRecord{
+				Key:       string(rec.Key),
+				Value:     rec.Value,
+				Tombstone: rec.Tombstone,
+				Timestamp: rec.Timestamp,
+			})
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// applySyncRecords saves the records received from the peer, keeping their timestamps. Reserved keys are skipped.
+func (kvs *KeyValueStore) applySyncRecords(records []syncRecord) error {
+	for _, rec := range records {
+		if util.IsReservedKey([]byte(rec.Key)) {
+			continue
+		}
+		err := kvs.putRecord(&model.Record{
+			Key:       []byte(rec.Key),
+			Value:     rec.Value,
+			Tombstone: rec.Tombstone,
+			Timestamp: rec.Timestamp,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
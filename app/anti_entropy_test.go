@@ -0,0 +1,132 @@
+package app
+
+import (
+	"bytes"
+	"nasp-project/model"
+	"nasp-project/util"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestSync(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "anti_entropy_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	local := newTestStore(t, path.Join(tmpDir, "local"))
+	peer := newTestStore(t, path.Join(tmpDir, "peer"))
+	server, err := peer.ServeReplication("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the replication server: %v", err)
+	}
+	defer server.Close()
+
+	write := func(kvs *KeyValueStore, key, value string, tombstone bool, timestamp uint64) {
+		kvs.mu.Lock()
+		defer kvs.mu.Unlock()
+		err := kvs.putRecord(&model.Record{Key: []byte(key), Value: []byte(value), Tombstone: tombstone, Timestamp: timestamp})
+		if err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+	for _, kvs := range []*KeyValueStore{local, peer} {
+		write(kvs, "shared", "v", false, 100)
+	}
+	write(local, "local-only", "v", false, 100)
+	write(peer, "peer-only", "v", false, 100)
+	write(local, "conflict", "old", false, 100)
+	write(peer, "conflict", "new", false, 200)
+	write(local, "deleted", "v", false, 100)
+	write(peer, "deleted", "", true, 200)
+
+	result, err := local.Sync(server.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if result.Ranges == 0 || result.Received != 3 || result.Sent != 1 {
+		t.Errorf("Expected 3 records received and 1 sent, got %+v", result)
+	}
+
+	for _, kvs := range []*KeyValueStore{local, peer} {
+		kvs.mu.Lock()
+		for key, expected := range map[string]string{"shared": "v", "local-only": "v", "peer-only": "v", "conflict": "new", "deleted": ""} {
+			if got, err := kvs.get(key); err != nil || string(got) != expected {
+				t.Errorf("Expected %q for key %s, got %q, %v", expected, key, got, err)
+			}
+		}
+		kvs.mu.Unlock()
+	}
+
+	result, err = local.Sync(server.Addr().String())
+	if err != nil || result.Ranges != 0 {
+		t.Errorf("Expected no differing ranges after a sync, got %+v, %v", result, err)
+	}
+}
+
+func TestSync_ReservedKeys(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "anti_entropy_test_reserved_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	longInterval := func(config *util.Config) {
+		config.TokenBucket.Interval = 1_000_000 // definitely long enough not to refill during the test
+	}
+	local := newTestStore(t, path.Join(tmpDir, "local"), longInterval)
+	peer := newTestStore(t, path.Join(tmpDir, "peer"), longInterval)
+	server, err := peer.ServeReplication("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the replication server: %v", err)
+	}
+	defer server.Close()
+
+	// the stores have the same data, but their rate limits are checkpointed after a different number of operations
+	for i, kvs := range []*KeyValueStore{local, peer} {
+		if err := kvs.Put("shared", []byte("v")); err != nil {
+			t.Fatalf("Failed to put key-value pair: %v", err)
+		}
+		for j := 0; j <= i; j++ {
+			if _, err := kvs.Get("shared"); err != nil {
+				t.Fatalf("Failed to get value: %v", err)
+			}
+		}
+		kvs.mu.Lock()
+		err := kvs.checkpointRateLimits(time.Now())
+		kvs.mu.Unlock()
+		if err != nil {
+			t.Fatalf("Failed to checkpoint the rate limits: %v", err)
+		}
+	}
+	localCheckpoint, peerCheckpoint := storedRateLimit(t, local), storedRateLimit(t, peer)
+	if localCheckpoint == nil || bytes.Equal(localCheckpoint, peerCheckpoint) {
+		t.Fatalf("Expected different rate limit checkpoints, got %x and %x", localCheckpoint, peerCheckpoint)
+	}
+
+	result, err := local.Sync(server.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if result.Ranges != 0 {
+		t.Errorf("Expected no differing ranges, got %+v", result)
+	}
+	if got := storedRateLimit(t, peer); !bytes.Equal(got, peerCheckpoint) {
+		t.Errorf("Expected the rate limit checkpoint of the peer to stay %x, got %x", peerCheckpoint, got)
+	}
+}
+
+// storedRateLimit returns the stored rate limit checkpoint of the read bucket of local calls.
+func storedRateLimit(t *testing.T, kvs *KeyValueStore) []byte {
+	t.Helper()
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	data, err := kvs.get(util.RateLimiterKey + string(rateLimitRead) + "/")
+	if err != nil {
+		t.Fatalf("Failed to read the rate limit checkpoint: %v", err)
+	}
+	return data
+}
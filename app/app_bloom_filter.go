@@ -1,7 +1,6 @@
 package app
 
 import (
-	"errors"
 	"nasp-project/structures/bloom_filter"
 	"nasp-project/util"
 )
@@ -11,11 +10,11 @@ import (
 // the expected number of elements (n) and desired false-positive probability (p).
 // Returns an error if the write fails or the rate limit is reached.
 func (kvs *KeyValueStore) NewBF(key string, n uint, p float64) error {
-	if block, err := kvs.rateLimitReached(); block {
+	if block, err := kvs.rateLimitReached(rateLimitWrite, 1); block {
 		if err != nil {
 			return err
 		}
-		return errors.New("rate limit reached")
+		return ErrRateLimitReached
 	}
 	key = util.BloomFilterPrefix + key
 	bf := bloom_filter.NewBloomFilter(n, p)
@@ -25,11 +24,11 @@ func (kvs *KeyValueStore) NewBF(key string, n uint, p float64) error {
 // DeleteBF deletes a bloom filter record with the specified key.
 // Returns an error if the write fails or the rate limit is reached.
 func (kvs *KeyValueStore) DeleteBF(key string) error {
-	if block, err := kvs.rateLimitReached(); block {
+	if block, err := kvs.rateLimitReached(rateLimitWrite, 1); block {
 		if err != nil {
 			return err
 		}
-		return errors.New("rate limit reached")
+		return ErrRateLimitReached
 	}
 	key = util.BloomFilterPrefix + key
 	return kvs.delete(key)
@@ -39,11 +38,11 @@ func (kvs *KeyValueStore) DeleteBF(key string) error {
 // Returns an error if no bloom filter record with the given key exists.
 // Returns an error if the write fails or the rate limit is reached.
 func (kvs *KeyValueStore) BFAdd(key string, val []byte) error {
-	if block, err := kvs.rateLimitReached(); block {
+	if block, err := kvs.rateLimitReached(rateLimitWrite, 1); block {
 		if err != nil {
 			return err
 		}
-		return errors.New("rate limit reached")
+		return ErrRateLimitReached
 	}
 	key = util.BloomFilterPrefix + key
 
@@ -52,7 +51,7 @@ func (kvs *KeyValueStore) BFAdd(key string, val []byte) error {
 		return err
 	}
 	if bfBytes == nil {
-		return errors.New("no bf with given key")
+		return notFoundError("bf")
 	}
 
 	bf := bloom_filter.Deserialize(bfBytes)
@@ -65,11 +64,11 @@ func (kvs *KeyValueStore) BFAdd(key string, val []byte) error {
 // Returns an error if no bloom filter record with the given key exists.
 // Returns an error if the read fails or the rate limit is reached.
 func (kvs *KeyValueStore) BFHasKey(key string, val []byte) (bool, error) {
-	if block, err := kvs.rateLimitReached(); block {
+	if block, err := kvs.rateLimitReached(rateLimitRead, 1); block {
 		if err != nil {
 			return false, err
 		}
-		return false, errors.New("rate limit reached")
+		return false, ErrRateLimitReached
 	}
 	key = util.BloomFilterPrefix + key
 
@@ -78,7 +77,7 @@ func (kvs *KeyValueStore) BFHasKey(key string, val []byte) (bool, error) {
 		return false, err
 	}
 	if bfBytes == nil {
-		return false, errors.New("no bf with given key")
+		return false, notFoundError("bf")
 	}
 
 	bf := bloom_filter.Deserialize(bfBytes)
@@ -1,7 +1,6 @@
 package app
 
 import (
-	"errors"
 	count_min_sketch "nasp-project/structures/count-min-sketch"
 	"nasp-project/util"
 )
@@ -9,11 +8,11 @@ import (
 // NewCMS creates a new count-min sketch record with specified key.
 // Returns an error if the write fails or the rate limit is reached.
 func (kvs *KeyValueStore) NewCMS(key string, epsilon float64, delta float64) error {
-	if block, err := kvs.rateLimitReached(); block {
+	if block, err := kvs.rateLimitReached(rateLimitWrite, 1); block {
 		if err != nil {
 			return err
 		}
-		return errors.New("rate limit reached")
+		return ErrRateLimitReached
 	}
 	key = util.CountMinSketchPrefix + key
 	cms := count_min_sketch.NewCMS(epsilon, delta)
@@ -23,11 +22,11 @@ func (kvs *KeyValueStore) NewCMS(key string, epsilon float64, delta float64) err
 // DeleteCMS deletes a count-min sketch record with the specified key.
 // Returns an error if the write fails or the rate limit is reached.
 func (kvs *KeyValueStore) DeleteCMS(key string) error {
-	if block, err := kvs.rateLimitReached(); block {
+	if block, err := kvs.rateLimitReached(rateLimitWrite, 1); block {
 		if err != nil {
 			return err
 		}
-		return errors.New("rate limit reached")
+		return ErrRateLimitReached
 	}
 	key = util.CountMinSketchPrefix + key
 	return kvs.delete(key)
@@ -36,11 +35,11 @@ func (kvs *KeyValueStore) DeleteCMS(key string) error {
 // CMSAdd performs Add(val) operation on a count-min sketch record with the specified key.
 // Returns an error if no count-min sketch record with the given key exists.
 func (kvs *KeyValueStore) CMSAdd(key string, val []byte) error {
-	if block, err := kvs.rateLimitReached(); block {
+	if block, err := kvs.rateLimitReached(rateLimitWrite, 1); block {
 		if err != nil {
 			return err
 		}
-		return errors.New("rate limit reached")
+		return ErrRateLimitReached
 	}
 	key = util.CountMinSketchPrefix + key
 	CMSBytes, err := kvs.get(key)
@@ -48,7 +47,7 @@ func (kvs *KeyValueStore) CMSAdd(key string, val []byte) error {
 		return err
 	}
 	if CMSBytes == nil {
-		return errors.New("no cms with the given key")
+		return notFoundError("cms")
 	}
 	cms := count_min_sketch.Deserialize(CMSBytes)
 	cms.Add(val)
@@ -59,11 +58,11 @@ func (kvs *KeyValueStore) CMSAdd(key string, val []byte) error {
 // CMSGet performs Estimate(val) operation on a count-min sketch record with the specified key.
 // Returns an error if no count-min sketch record with the given key exists.
 func (kvs *KeyValueStore) CMSGet(key string, val []byte) (int, error) {
-	if block, err := kvs.rateLimitReached(); block {
+	if block, err := kvs.rateLimitReached(rateLimitRead, 1); block {
 		if err != nil {
 			return -1, err
 		}
-		return -1, errors.New("rate limit reached")
+		return -1, ErrRateLimitReached
 	}
 	key = util.CountMinSketchPrefix + key
 	CMSBytes, err := kvs.get(key)
@@ -71,7 +70,7 @@ func (kvs *KeyValueStore) CMSGet(key string, val []byte) (int, error) {
 		return -1, err
 	}
 	if CMSBytes == nil {
-		return -1, errors.New("no cms with given key")
+		return -1, notFoundError("cms")
 	}
 	cms := count_min_sketch.Deserialize(CMSBytes)
 	return cms.Get(val), nil
@@ -1,7 +1,6 @@
 package app
 
 import (
-	"errors"
 	"nasp-project/structures/hyperloglog"
 	"nasp-project/util"
 )
@@ -9,11 +8,11 @@ import (
 // NewHLL creates a new hyperloglog record with specified key.
 // Returns an error if the write fails or the rate limit is reached.
 func (kvs *KeyValueStore) NewHLL(key string, p uint32) error {
-	if block, err := kvs.rateLimitReached(); block {
+	if block, err := kvs.rateLimitReached(rateLimitWrite, 1); block {
 		if err != nil {
 			return err
 		}
-		return errors.New("rate limit reached")
+		return ErrRateLimitReached
 	}
 	key = util.HyperLogLogPrefix + key
 	hll := hyperloglog.NewHyperLogLog(p)
@@ -23,11 +22,11 @@ func (kvs *KeyValueStore) NewHLL(key string, p uint32) error {
 // DeleteHLL deletes a hyperloglog record with the specified key.
 // Returns an error if the write fails or the rate limit is reached.
 func (kvs *KeyValueStore) DeleteHLL(key string) error {
-	if block, err := kvs.rateLimitReached(); block {
+	if block, err := kvs.rateLimitReached(rateLimitWrite, 1); block {
 		if err != nil {
 			return err
 		}
-		return errors.New("rate limit reached")
+		return ErrRateLimitReached
 	}
 	key = util.HyperLogLogPrefix + key
 	return kvs.delete(key)
@@ -36,11 +35,11 @@ func (kvs *KeyValueStore) DeleteHLL(key string) error {
 // HLLAdd performs Add(val) operation on a hyperloglog record with the specified key.
 // Returns an error if no hyperloglog record with the given key exists.
 func (kvs *KeyValueStore) HLLAdd(key string, val []byte) error {
-	if block, err := kvs.rateLimitReached(); block {
+	if block, err := kvs.rateLimitReached(rateLimitWrite, 1); block {
 		if err != nil {
 			return err
 		}
-		return errors.New("rate limit reached")
+		return ErrRateLimitReached
 	}
 	key = util.HyperLogLogPrefix + key
 	hllBytes, err := kvs.get(key)
@@ -48,7 +47,7 @@ func (kvs *KeyValueStore) HLLAdd(key string, val []byte) error {
 		return err
 	}
 	if hllBytes == nil {
-		return errors.New("no hll with given key")
+		return notFoundError("hll")
 	}
 	hll := hyperloglog.Deserialize(hllBytes)
 	hll.Add(val)
@@ -58,11 +57,11 @@ func (kvs *KeyValueStore) HLLAdd(key string, val []byte) error {
 // HLLEstimate performs Add(val) operation on a hyperloglog record with the specified key.
 // Returns an error if no hyperloglog record with the given key exists.
 func (kvs *KeyValueStore) HLLEstimate(key string) (float64, error) {
-	if block, err := kvs.rateLimitReached(); block {
+	if block, err := kvs.rateLimitReached(rateLimitRead, 1); block {
 		if err != nil {
 			return 0, err
 		}
-		return 0, errors.New("rate limit reached")
+		return 0, ErrRateLimitReached
 	}
 	key = util.HyperLogLogPrefix + key
 	hllBytes, err := kvs.get(key)
@@ -70,7 +69,7 @@ func (kvs *KeyValueStore) HLLEstimate(key string) (float64, error) {
 		return -1, err
 	}
 	if hllBytes == nil {
-		return -1, errors.New("no hll with given key")
+		return -1, notFoundError("hll")
 	}
 	hll := hyperloglog.Deserialize(hllBytes)
 	estimation := hll.Estimate()
@@ -1,26 +1,40 @@
 package app
 
 import (
-	"errors"
+	"nasp-project/model"
 	"nasp-project/structures/iterator"
 	"nasp-project/structures/lsm"
 )
 
+// RecordIterator iterates through key-value pairs in key order.
+// It is implemented by Iterator and by the remote iterators of the client package.
+type RecordIterator interface {
+	// Next returns the next key-value pair, or an empty key when the iteration is over.
+	Next() (key string, val []byte)
+	// Stop stops the iteration and releases its resources.
+	Stop()
+	// Err returns the error that ended the iteration early, if any.
+	Err() error
+}
+
 // Iterator through key-value pair records saved in the engine.
 type Iterator struct {
 	iter *iterator.Iterator
 }
 
+var _ RecordIterator = (*Iterator)(nil)
+
 // NewIterator creates new Iterator from iterator.Iterator.
 func NewIterator(iter *iterator.Iterator) *Iterator {
 	return &Iterator{iter: iter}
 }
 
 // Next returns the next key-value pair from the iterator.
+// The iteration ends early when an SSTable can't be read, Err returns the error.
 func (it *Iterator) Next() (key string, val []byte) {
-	rec := it.iter.Next()
+	rec := it.next()
 	for rec != nil && rec.Tombstone {
-		rec = it.iter.Next()
+		rec = it.next()
 	}
 	if rec == nil {
 		return "", nil
@@ -28,50 +42,93 @@ func (it *Iterator) Next() (key string, val []byte) {
 	return string(rec.Key), rec.Value
 }
 
+// next returns the next record, or nil once a read error made the remaining records incomplete.
+func (it *Iterator) next() *model.Record {
+	if it.iter.Err() != nil {
+		return nil
+	}
+	return it.iter.Next()
+}
+
 // Stop stops end invalidates the iterator. Every subsequent call to Next return nil.
 func (it *Iterator) Stop() {
 	it.iter.Stop()
 }
 
+// Err returns the error of the SSTable that couldn't be read during the iteration, if any.
+// The tables that can't be opened at all make RangeIterate and PrefixIterate fail with lsm.ErrUnreadableTable instead.
+func (it *Iterator) Err() error {
+	return it.iter.Err()
+}
+
 // RangeIterate returns an Iterator that iterates through records with key in range [minKey, maxKey].
 func (kvs *KeyValueStore) RangeIterate(minKey, maxKey string) (*Iterator, error) {
-	if block, err := kvs.rateLimitReached(); block {
+	return kvs.rangeIterate(minKey, maxKey, 1)
+}
+
+// rangeIterate implements RangeIterate, charging the given cost to the read budget.
+func (kvs *KeyValueStore) rangeIterate(minKey, maxKey string, cost int64) (*Iterator, error) {
+	if block, err := kvs.rateLimitReached(rateLimitRead, cost); block {
 		if err != nil {
 			return nil, err
 		}
-		return nil, errors.New("rate limit reached")
+		return nil, ErrRateLimitReached
 	}
 
-	compressionDict, err := kvs.getCompressionDict()
+	iter, err := kvs.recordRangeIterator(minKey, maxKey)
 	if err != nil {
 		return nil, err
 	}
+	return NewIterator(iter), nil
+}
 
-	iters := kvs.memtables.GetRangeIterators([]byte(minKey), []byte(maxKey))
+// lastKey is the end of the iterations that have no end. Valid UTF-8 never contains the byte 0xff,
+// so every key of valid UTF-8 is smaller.
+const lastKey = "\xff"
 
-	sstIters, err := lsm.GetRangeIterators([]byte(minKey), []byte(maxKey), compressionDict, kvs.config)
+// recordRangeIterator returns an iterator.Iterator through the records with key in range [minKey, maxKey],
+// including the deleted ones. Unlike rangeIterate, it does not consume rate limit tokens.
+func (kvs *KeyValueStore) recordRangeIterator(minKey, maxKey string) (*iterator.Iterator, error) {
+	compressionDict, err := kvs.getCompressionDict()
 	if err != nil {
 		return nil, err
 	}
-	iters = append(iters, sstIters...)
 
-	iter, err := iterator.NewIterator(iters)
+	iters := kvs.memtables.GetRangeIterators([]byte(minKey), []byte(maxKey))
+
+	sstIters, err := lsm.GetRangeIterators([]byte(minKey), []byte(maxKey), compressionDict, kvs.config)
 	if err != nil {
 		return nil, err
 	}
+	iters = append(iters, sstIters...)
 
-	return NewIterator(iter), nil
+	return iterator.NewIterator(iters)
 }
 
 // PrefixIterate returns an Iterator that iterates through records with a given key prefix.
 func (kvs *KeyValueStore) PrefixIterate(prefix string) (*Iterator, error) {
-	if block, err := kvs.rateLimitReached(); block {
+	return kvs.prefixIterate(prefix, 1)
+}
+
+// prefixIterate implements PrefixIterate, charging the given cost to the read budget.
+func (kvs *KeyValueStore) prefixIterate(prefix string, cost int64) (*Iterator, error) {
+	if block, err := kvs.rateLimitReached(rateLimitRead, cost); block {
 		if err != nil {
 			return nil, err
 		}
-		return nil, errors.New("rate limit reached")
+		return nil, ErrRateLimitReached
 	}
 
+	iter, err := kvs.recordIterator(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return NewIterator(iter), nil
+}
+
+// recordIterator returns an iterator.Iterator through the records with a given key prefix, including the deleted ones.
+// Unlike prefixIterate, it does not consume rate limit tokens.
+func (kvs *KeyValueStore) recordIterator(prefix string) (*iterator.Iterator, error) {
 	compressionDict, err := kvs.getCompressionDict()
 	if err != nil {
 		return nil, err
@@ -85,10 +142,5 @@ func (kvs *KeyValueStore) PrefixIterate(prefix string) (*Iterator, error) {
 	}
 	iters = append(iters, sstIters...)
 
-	iter, err := iterator.NewIterator(iters)
-	if err != nil {
-		return nil, err
-	}
-
-	return NewIterator(iter), nil
+	return iterator.NewIterator(iters)
 }
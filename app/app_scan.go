@@ -2,9 +2,9 @@ package app
 
 import (
 	"bytes"
-	"errors"
 	"nasp-project/model"
 	"nasp-project/structures/lsm"
+	"time"
 )
 
 type Record struct {
@@ -13,12 +13,13 @@ type Record struct {
 }
 
 func (kvs *KeyValueStore) RangeScan(minKey, maxKey string, pageNumber, pageSize int) ([]Record, error) {
-	if block, err := kvs.rateLimitReached(); block {
+	if block, err := kvs.rateLimitReached(rateLimitRead, kvs.scanCost(pageNumber*pageSize)); block {
 		if err != nil {
 			return nil, err
 		}
-		return nil, errors.New("rate limit reached")
+		return nil, ErrRateLimitReached
 	}
+	defer kvs.metrics.observeOperation(opRangeScan, time.Now())
 
 	pageNumber-- // 1-indexed to 0-indexed
 
@@ -45,12 +46,13 @@ func (kvs *KeyValueStore) RangeScan(minKey, maxKey string, pageNumber, pageSize
 }
 
 func (kvs *KeyValueStore) PrefixScan(prefix string, pageNumber, pageSize int) ([]Record, error) {
-	if block, err := kvs.rateLimitReached(); block {
+	if block, err := kvs.rateLimitReached(rateLimitRead, kvs.scanCost(pageNumber*pageSize)); block {
 		if err != nil {
 			return nil, err
 		}
-		return nil, errors.New("rate limit reached")
+		return nil, ErrRateLimitReached
 	}
+	defer kvs.metrics.observeOperation(opPrefixScan, time.Now())
 
 	pageNumber-- // 1-indexed to 0-indexed
 
@@ -1,18 +1,17 @@
 package app
 
 import (
-	"errors"
 	"nasp-project/structures/sim_hash"
 	"nasp-project/util"
 )
 
 // SHAddFingerprint calculates fingerprint of the given text and stores it in the database with the specified key.
 func (kvs *KeyValueStore) SHAddFingerprint(key string, text string) error {
-	if block, err := kvs.rateLimitReached(); block {
+	if block, err := kvs.rateLimitReached(rateLimitWrite, 1); block {
 		if err != nil {
 			return err
 		}
-		return errors.New("rate limit reached")
+		return ErrRateLimitReached
 	}
 	key = util.SimHashPrefix + key
 	shFingerprint, err := sim_hash.SimHashText(text)
@@ -25,11 +24,11 @@ func (kvs *KeyValueStore) SHAddFingerprint(key string, text string) error {
 
 // SHDeleteFingerprint deletes a sim hash record with the specified key.
 func (kvs *KeyValueStore) SHDeleteFingerprint(key string) error {
-	if block, err := kvs.rateLimitReached(); block {
+	if block, err := kvs.rateLimitReached(rateLimitWrite, 1); block {
 		if err != nil {
 			return err
 		}
-		return errors.New("rate limit reached")
+		return ErrRateLimitReached
 	}
 	key = util.SimHashPrefix + key
 	return kvs.delete(key)
@@ -37,11 +36,11 @@ func (kvs *KeyValueStore) SHDeleteFingerprint(key string) error {
 
 // SHGetHammingDistance calculates the hamming distance between two sim hash records with the specified keys.
 func (kvs *KeyValueStore) SHGetHammingDistance(key1 string, key2 string) (uint8, error) {
-	if block, err := kvs.rateLimitReached(); block {
+	if block, err := kvs.rateLimitReached(rateLimitRead, 1); block {
 		if err != nil {
 			return 0, err
 		}
-		return 0, errors.New("rate limit reached")
+		return 0, ErrRateLimitReached
 	}
 	key1 = util.SimHashPrefix + key1
 	key2 = util.SimHashPrefix + key2
@@ -54,7 +53,7 @@ func (kvs *KeyValueStore) SHGetHammingDistance(key1 string, key2 string) (uint8,
 		return 0, err
 	}
 	if shFingerprint1Bytes == nil || shFingerprint2Bytes == nil {
-		return 0, errors.New("no sh with given key")
+		return 0, notFoundError("sh")
 	}
 	fingerprint1 := sim_hash.Deserialize(shFingerprint1Bytes)
 	fingerprint2 := sim_hash.Deserialize(shFingerprint2Bytes)
@@ -0,0 +1,257 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"nasp-project/util"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Access is a level of access to keys. Every level includes the lower ones.
+type Access uint8
+
+const (
+	AccessNone Access = iota
+	AccessRead
+	AccessWrite
+	AccessAdmin // write access, and on the empty prefix also the administrative operations such as Stats
+)
+
+// ParseAccess parses the access level names used in util.PermissionConfig.
+func ParseAccess(s string) (Access, error) {
+	switch s {
+	case "read":
+		return AccessRead, nil
+	case "write":
+		return AccessWrite, nil
+	case "admin":
+		return AccessAdmin, nil
+	default:
+		return AccessNone, fmt.Errorf("unknown access %q", s)
+	}
+}
+
+// Permission grants access to the keys starting with Prefix.
+type Permission struct {
+	Prefix string `json:"prefix"`
+	Access Access `json:"access"`
+}
+
+// User is an authenticated user of the network servers.
+type User struct {
+	Name        string
+	Permissions []Permission
+}
+
+// Access returns the access of the user to key. The permission with the longest matching prefix decides,
+// so that a broad grant can be narrowed for a part of the keyspace.
+func (u *User) Access(key string) Access {
+	access, matched := AccessNone, -1
+	for _, p := range u.Permissions {
+		if strings.HasPrefix(key, p.Prefix) && len(p.Prefix) > matched {
+			access, matched = p.Access, len(p.Prefix)
+		}
+	}
+	return access
+}
+
+// Allowed returns true if the user has at least the given access to key.
+func (u *User) Allowed(key string, access Access) bool {
+	return u.Access(key) >= access
+}
+
+// IsAdmin returns true if the user may perform the administrative operations.
+func (u *User) IsAdmin() bool {
+	return u.Allowed("", AccessAdmin)
+}
+
+// credentials is the record of a user stored under util.AuthUserPrefix.
+// Tokens are indexed separately under util.AuthTokenPrefix by their hash.
+type credentials struct {
+	PasswordHash []byte       `json:"password_hash,omitempty"` // bcrypt hash, empty if the user has no password
+	Permissions  []Permission `json:"permissions"`
+}
+
+// tokenHash returns the hex encoded SHA-256 hash of a token. Unlike passwords, tokens are expected
+// to be long random strings, so a fast hash is enough and allows looking them up by the hash.
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// syncUsers replaces the stored credentials with the users of the configuration.
+// The keys written by the last sync are kept under util.AuthIndexKey, as iterators skip reserved keys.
+// Passwords are stored as bcrypt hashes and tokens as SHA-256 hashes, never in plain text.
+// Returns an error if a user is invalid or the write fails.
+func (kvs *KeyValueStore) syncUsers(users []util.UserConfig) error {
+	records := map[string][]byte{}
+	for _, u := range users {
+		if u.Password == "" && u.Token == "" {
+			return fmt.Errorf("user %s has neither a password nor a token", u.Name)
+		}
+		if _, ok := records[util.AuthUserPrefix+u.Name]; ok {
+			return fmt.Errorf("user %s is defined more than once", u.Name)
+		}
+
+		creds := credentials{Permissions: make([]Permission, 0, len(u.Permissions))}
+		for _, p := range u.Permissions {
+			access, err := ParseAccess(p.Access)
+			if err != nil {
+				return fmt.Errorf("user %s: %w", u.Name, err)
+			}
+			creds.Permissions = append(creds.Permissions, Permission{Prefix: p.Prefix, Access: access})
+		}
+		if u.Password != "" {
+			hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+			if err != nil {
+				return fmt.Errorf("user %s: %w", u.Name, err)
+			}
+			creds.PasswordHash = hash
+		}
+		if u.Token != "" {
+			tokenKey := util.AuthTokenPrefix + tokenHash(u.Token)
+			if _, ok := records[tokenKey]; ok {
+				return fmt.Errorf("user %s: the token is used by another user", u.Name)
+			}
+			records[tokenKey] = []byte(u.Name)
+		}
+
+		value, err := json.Marshal(creds)
+		if err != nil {
+			return err
+		}
+		records[util.AuthUserPrefix+u.Name] = value
+	}
+
+	// delete the users and tokens that were removed from the configuration
+	indexBytes, err := kvs.get(util.AuthIndexKey)
+	if err != nil {
+		return err
+	}
+	var previous []string
+	if indexBytes != nil {
+		if err := json.Unmarshal(indexBytes, &previous); err != nil {
+			return fmt.Errorf("corrupted credentials index: %w", err)
+		}
+	}
+	for _, key := range previous {
+		if _, ok := records[key]; !ok {
+			if err := kvs.delete(key); err != nil {
+				return err
+			}
+		}
+	}
+
+	index := make([]string, 0, len(records))
+	for key, value := range records {
+		if err := kvs.put(key, value); err != nil {
+			return err
+		}
+		index = append(index, key)
+	}
+	indexBytes, err = json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return kvs.put(util.AuthIndexKey, indexBytes)
+}
+
+// loadUser returns the stored credentials of the user with the given name.
+// Returns ErrAuthenticationFailed if there is no such user.
+func (kvs *KeyValueStore) loadUser(name string) (*credentials, error) {
+	value, err := kvs.get(util.AuthUserPrefix + name)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, ErrAuthenticationFailed
+	}
+	creds := &credentials{}
+	if err := json.Unmarshal(value, creds); err != nil {
+		return nil, fmt.Errorf("corrupted credentials of user %s: %w", name, err)
+	}
+	return creds, nil
+}
+
+// Authenticate returns the user with the given name if the password matches.
+// Unlike regular operations, Authenticate does not consume rate limit tokens.
+// Returns ErrAuthenticationFailed if the user does not exist or the password does not match.
+func (kvs *KeyValueStore) Authenticate(name, password string) (*User, error) {
+	creds, err := kvs.loadUser(name)
+	if err != nil {
+		return nil, err
+	}
+	return checkPassword(name, creds, password)
+}
+
+// AuthenticateToken returns the user that owns the token.
+// Unlike regular operations, AuthenticateToken does not consume rate limit tokens.
+// Returns ErrAuthenticationFailed if no user owns the token.
+func (kvs *KeyValueStore) AuthenticateToken(token string) (*User, error) {
+	name, err := kvs.get(util.AuthTokenPrefix + tokenHash(token))
+	if err != nil {
+		return nil, err
+	}
+	if name == nil {
+		return nil, ErrAuthenticationFailed
+	}
+	creds, err := kvs.loadUser(string(name))
+	if err != nil {
+		return nil, err
+	}
+	return &User{Name: string(name), Permissions: creds.Permissions}, nil
+}
+
+func checkPassword(name string, creds *credentials, password string) (*User, error) {
+	if len(creds.PasswordHash) == 0 || bcrypt.CompareHashAndPassword(creds.PasswordHash, []byte(password)) != nil {
+		return nil, ErrAuthenticationFailed
+	}
+	return &User{Name: name, Permissions: creds.Permissions}, nil
+}
+
+// authenticateClient is Authenticate for the network servers. It holds kvs.mu only while reading
+// the stored credentials, so that the slow password comparison does not block the other clients.
+func (kvs *KeyValueStore) authenticateClient(name, password string) (*User, error) {
+	kvs.mu.Lock()
+	creds, err := kvs.loadUser(name)
+	kvs.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return checkPassword(name, creds, password)
+}
+
+// authenticateClientToken is AuthenticateToken for the network servers, it locks kvs.mu.
+func (kvs *KeyValueStore) authenticateClientToken(token string) (*User, error) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	return kvs.AuthenticateToken(token)
+}
+
+// authEnabled returns true if the clients of the network servers must authenticate.
+func (kvs *KeyValueStore) authEnabled() bool {
+	return kvs.config.Auth.Enabled
+}
+
+// authorized returns true if authentication is disabled or the user has at least the given access to key.
+func (kvs *KeyValueStore) authorized(user *User, key string, access Access) bool {
+	return !kvs.authEnabled() || user != nil && user.Allowed(key, access)
+}
+
+// authorizedAdmin returns true if authentication is disabled or the user is an administrator.
+func (kvs *KeyValueStore) authorizedAdmin(user *User) bool {
+	return !kvs.authEnabled() || user != nil && user.IsAdmin()
+}
+
+// permissionError describes a denied operation. It matches ErrPermissionDenied.
+func permissionError(user *User, key string) error {
+	name := ""
+	if user != nil {
+		name = user.Name
+	}
+	return fmt.Errorf("%w: user %s has no access to key %q", ErrPermissionDenied, name, key)
+}
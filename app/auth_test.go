@@ -0,0 +1,241 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"nasp-project/util"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+var testUsers = []util.UserConfig{
+	{
+		Name:     "admin",
+		Password: "admin-secret",
+		Permissions: []util.PermissionConfig{
+			{Prefix: "", Access: "admin"},
+		},
+	},
+	{
+		Name:  "reader",
+		Token: "reader-token",
+		Permissions: []util.PermissionConfig{
+			{Prefix: "", Access: "read"},
+			{Prefix: "own/", Access: "write"},
+			{Prefix: "own/secret/", Access: "read"},
+		},
+	},
+}
+
+// newTestAuthStore creates a store with authentication enabled for testUsers.
+func newTestAuthStore(t *testing.T, tmpDir string) *KeyValueStore {
+	return newTestStore(t, tmpDir, func(config *util.Config) {
+		config.Auth = util.AuthConfig{Enabled: true, Users: testUsers}
+	})
+}
+
+func TestUser_Access(t *testing.T) {
+	user := &User{Name: "u", Permissions: []Permission{
+		{Prefix: "", Access: AccessRead},
+		{Prefix: "a", Access: AccessWrite},
+		{Prefix: "ab", Access: AccessNone},
+	}}
+
+	for key, expected := range map[string]Access{"": AccessRead, "x": AccessRead, "a": AccessWrite, "ac": AccessWrite, "abc": AccessNone} {
+		if got := user.Access(key); got != expected {
+			t.Errorf("Expected access %d to %q, got %d", expected, key, got)
+		}
+	}
+	if user.IsAdmin() {
+		t.Errorf("Expected a non-admin user")
+	}
+	if (&User{}).Allowed("a", AccessRead) {
+		t.Errorf("Expected no access without permissions")
+	}
+}
+
+func TestKeyValueStore_Authenticate(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "auth_test_authenticate_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db := newTestAuthStore(t, tmpDir)
+
+	user, err := db.Authenticate("admin", "admin-secret")
+	if err != nil || user.Name != "admin" || !user.IsAdmin() {
+		t.Errorf("Expected admin, got %v, %v", user, err)
+	}
+	if _, err := db.Authenticate("admin", "wrong"); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Expected ErrAuthenticationFailed, got %v", err)
+	}
+	if _, err := db.Authenticate("nobody", "admin-secret"); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Expected ErrAuthenticationFailed, got %v", err)
+	}
+	// reader has only a token
+	if _, err := db.Authenticate("reader", ""); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Expected ErrAuthenticationFailed, got %v", err)
+	}
+	user, err = db.AuthenticateToken("reader-token")
+	if err != nil || user.Name != "reader" || !user.Allowed("own/x", AccessWrite) {
+		t.Errorf("Expected reader, got %v, %v", user, err)
+	}
+
+	// credentials are hashed and hidden from the regular API
+	creds, err := db.get(util.AuthUserPrefix + "admin")
+	if err != nil || creds == nil || bytes.Contains(creds, []byte("admin-secret")) {
+		t.Errorf("Expected hashed credentials, got %q, %v", creds, err)
+	}
+	if _, err := db.Get(util.AuthUserPrefix + "admin"); !errors.Is(err, ErrReservedKey) {
+		t.Errorf("Expected ErrReservedKey, got %v", err)
+	}
+
+	// users removed from the configuration can no longer authenticate
+	if err := db.syncUsers(testUsers[:1]); err != nil {
+		t.Fatalf("Failed to sync users: %v", err)
+	}
+	if _, err := db.AuthenticateToken("reader-token"); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Expected ErrAuthenticationFailed, got %v", err)
+	}
+	if err := db.syncUsers([]util.UserConfig{{Name: "empty"}}); err == nil {
+		t.Errorf("Expected an error for a user without credentials")
+	}
+}
+
+func TestRESTServer_Auth(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "auth_test_rest_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db := newTestAuthStore(t, tmpDir)
+	server := httptest.NewServer(db.RESTHandler())
+	defer server.Close()
+
+	do := func(method, path, token string, basic ...string) int {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader("v"))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if len(basic) == 2 {
+			req.SetBasicAuth(basic[0], basic[1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for _, tc := range []struct {
+		method, path, token string
+		basic               []string
+		expected            int
+	}{
+		{http.MethodGet, "/kv/a", "", nil, http.StatusUnauthorized},
+		{http.MethodGet, "/kv/a", "wrong", nil, http.StatusUnauthorized},
+		{http.MethodPut, "/kv/a", "", []string{"admin", "wrong"}, http.StatusUnauthorized},
+		{http.MethodPut, "/kv/a", "", []string{"admin", "admin-secret"}, http.StatusNoContent},
+		{http.MethodGet, "/kv/a", "reader-token", nil, http.StatusOK},
+		{http.MethodPut, "/kv/a", "reader-token", nil, http.StatusForbidden},
+		{http.MethodPut, "/kv/own%2Fa", "reader-token", nil, http.StatusNoContent},
+		{http.MethodPut, "/kv/own%2Fsecret%2Fa", "reader-token", nil, http.StatusForbidden},
+		{http.MethodPut, "/bf/a?n=10&p=0.01", "reader-token", nil, http.StatusForbidden},
+		{http.MethodPost, "/bf/a/check", "reader-token", nil, http.StatusNotFound},
+		{http.MethodGet, "/stats", "reader-token", nil, http.StatusForbidden},
+		{http.MethodGet, "/metrics", "reader-token", nil, http.StatusForbidden},
+		{http.MethodGet, "/stats", "", []string{"admin", "admin-secret"}, http.StatusOK},
+		{http.MethodGet, "/health", "", nil, http.StatusOK},
+	} {
+		if got := do(tc.method, tc.path, tc.token, tc.basic...); got != tc.expected {
+			t.Errorf("%s %s: expected status %d, got %d", tc.method, tc.path, tc.expected, got)
+		}
+	}
+}
+
+func TestRESPServer_Auth(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "auth_test_resp_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db := newTestAuthStore(t, tmpDir)
+	server, err := db.ServeRESP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the RESP server: %v", err)
+	}
+	defer server.Close()
+
+	c := dialRESP(t, server.Addr().String())
+	defer c.conn.Close()
+
+	expectError := func(reply any, prefix string) {
+		t.Helper()
+		if err, ok := reply.(error); !ok || !strings.HasPrefix(err.Error(), prefix) {
+			t.Errorf("Expected a %s error, got %v", prefix, reply)
+		}
+	}
+
+	expectError(c.do(t, "GET", "a"), "NOAUTH")
+	expectError(c.do(t, "AUTH", "admin", "wrong"), "WRONGPASS")
+	if got := c.do(t, "AUTH", "reader-token"); got != "OK" {
+		t.Fatalf("Expected OK, got %v", got)
+	}
+	expectError(c.do(t, "SET", "a", "1"), "NOPERM")
+	expectError(c.do(t, "DEL", "own/a", "a"), "NOPERM")
+	if got := c.do(t, "SET", "own/a", "1"); got != "OK" {
+		t.Errorf("Expected OK, got %v", got)
+	}
+	if got := c.do(t, "GET", "own/a"); got != "1" {
+		t.Errorf("Expected 1, got %v", got)
+	}
+}
+
+func TestMemcachedServer_Auth(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "auth_test_memcached_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db := newTestAuthStore(t, tmpDir)
+	server, err := db.ServeMemcached("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the memcached server: %v", err)
+	}
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	for _, tc := range []struct {
+		request  string
+		expected string
+	}{
+		{"get k\r\n", mcErrorNoAuth},
+		{"set auth 0 0 11\r\nadmin wrong\r\n", "CLIENT_ERROR authentication failure"},
+		{"set auth 0 0 18\r\nadmin admin-secret\r\n", "STORED"},
+		{"set k 0 0 1\r\na\r\n", "STORED"},
+		{"get k\r\n", "VALUE k 0 1|a|END"},
+	} {
+		if got := strings.Join(mcRoundTrip(t, conn, r, tc.request), "|"); got != tc.expected {
+			t.Errorf("%q: expected %q, got %q", tc.request, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nasp-project/model"
+	"nasp-project/raft"
+	"nasp-project/util"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Cluster runs the store as a member of a Raft cluster, see StartCluster.
+type Cluster struct {
+	kvs  *KeyValueStore
+	node *raft.Node
+}
+
+// clusterCommand is a write proposed through the Raft log. The leader sets the timestamp,
+// so that every member stores identical records.
+type clusterCommand struct {
+	Op        string `json:"op"` // "put" or "delete"
+	Key       string `json:"key"`
+	Value     []byte `json:"value,omitempty"`
+	Timestamp uint64 `json:"timestamp"`
+}
+
+// StartCluster makes the store a member of a Raft cluster. Writes are proposed to the Raft log through the leader
+// and applied to the store on every member once a majority stored them, so they survive the failure of a minority.
+// Writes of the regular API and the network servers are rejected with ErrReadOnly until Stop is called.
+// The Raft snapshots are logical checkpoints of the live regular records, not copies of the SSTable files,
+// a member that falls behind the compacted log restores the leader's checkpoint, which is sent in chunks
+// of raft.Config.SnapshotChunkSize.
+// Committed writes are applied in the background under kvs.mu, so all other calls must hold it as the network servers do.
+// Returns an error if the Raft state can't be loaded from storage.
+func (kvs *KeyValueStore) StartCluster(config raft.Config, transport raft.Transport, storage raft.Storage) (*Cluster, error) {
+	c := &Cluster{kvs: kvs}
+	node, err := raft.NewNode(config, (*clusterStateMachine)(c), transport, storage)
+	if err != nil {
+		return nil, err
+	}
+	c.node = node
+
+	kvs.mu.Lock()
+	kvs.cluster = c
+	kvs.startCheckpoints()
+	kvs.mu.Unlock()
+	node.Start()
+	return c, nil
+}
+
+// Node returns the Raft node of the member, e.g. to register it with a raft.InMemNetwork.
+func (c *Cluster) Node() *raft.Node {
+	return c.node
+}
+
+// Leader returns the ID of the current leader, or an empty string if unknown.
+func (c *Cluster) Leader() string {
+	return c.node.Leader()
+}
+
+// Stop stops the Raft node. The store accepts regular writes again afterwards.
+func (c *Cluster) Stop() {
+	c.node.Stop()
+	c.kvs.mu.Lock()
+	c.kvs.cluster = nil
+	c.kvs.mu.Unlock()
+}
+
+// Put saves a key-value pair on all members. It returns once the write is committed and applied on this member.
+// Returns raft.ErrNotLeader if the member is not the leader, raft.ErrLeadershipLost if the write may or may not
+// be committed, or an error if the key is reserved or the write fails.
+func (c *Cluster) Put(ctx context.Context, key string, value []byte) error {
+	return c.propose(ctx, clusterCommand{Op: "put", Key: key, Value: value})
+}
+
+// Delete deletes a key on all members, see Put.
+func (c *Cluster) Delete(ctx context.Context, key string) error {
+	return c.propose(ctx, clusterCommand{Op: "delete", Key: key})
+}
+
+func (c *Cluster) propose(ctx context.Context, cmd clusterCommand) error {
+	if util.IsReservedKey([]byte(cmd.Key)) {
+		return ErrReservedKey
+	}
+	cmd.Timestamp = uint64(time.Now().Unix())
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	_, err = c.node.Propose(ctx, data)
+	return err
+}
+
+// Get returns the value of a key with a linearizable read: it reflects all writes committed before the call.
+// Returns nil if the key is not found.
+// Returns raft.ErrNotLeader if the member is not the leader, or an error if the key is reserved or the read fails.
+func (c *Cluster) Get(ctx context.Context, key string) ([]byte, error) {
+	if util.IsReservedKey([]byte(key)) {
+		return nil, ErrReservedKey
+	}
+	if err := c.node.ReadIndex(ctx); err != nil {
+		return nil, err
+	}
+	c.kvs.mu.Lock()
+	defer c.kvs.mu.Unlock()
+	return c.kvs.get(key)
+}
+
+// Serve starts an HTTP server on the given address with the Handler of the cluster.
+// Returns an error if listening on the address fails.
+func (c *Cluster) Serve(address string) (*http.Server, error) {
+	return serveHTTP(address, c.Handler())
+}
+
+// Handler returns an http.Handler that serves the Raft RPCs of the other members under /raft/ (see raft.HTTPTransport),
+// and GET|PUT|DELETE /kv/{key} through the cluster. Requests to a member that is not the leader fail
+// with 421 Misdirected Request and the ID of the leader in the X-Raft-Leader header.
+// The requests are not authenticated, the address must only be reachable by the members and trusted clients.
+func (c *Cluster) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/raft/", c.node.Handler())
+	mux.HandleFunc("/kv/", c.handleKV)
+	return mux
+}
+
+func (c *Cluster) handleKV(w http.ResponseWriter, r *http.Request) {
+	key, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/kv/"))
+	if err != nil || key == "" {
+		writeError(w, fmt.Errorf("%w: invalid key", errBadRequest))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		var value []byte
+		value, err = c.Get(r.Context(), key)
+		if err == nil && value == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "key not found"})
+			return
+		}
+		if err == nil {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write(value)
+			return
+		}
+	case http.MethodPut:
+		var value []byte
+		value, err = readBody(r)
+		if err == nil {
+			err = c.Put(r.Context(), key, value)
+		}
+	case http.MethodDelete:
+		err = c.Delete(r.Context(), key)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+		return
+	}
+
+	var notLeader *raft.NotLeaderError
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.As(err, &notLeader):
+		w.Header().Set("X-Raft-Leader", notLeader.Leader)
+		writeJSON(w, http.StatusMisdirectedRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, raft.ErrLeadershipLost), errors.Is(err, raft.ErrStopped):
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+	default:
+		writeError(w, err)
+	}
+}
+
+// clusterStateMachine applies the committed commands of the Raft log to the store.
+type clusterStateMachine Cluster
+
+func (sm *clusterStateMachine) Apply(command []byte) ([]byte, error) {
+	var cmd clusterCommand
+	if err := json.Unmarshal(command, &cmd); err != nil {
+		return nil, err
+	}
+	record := &model.Record{Key: []byte(cmd.Key), Value: cmd.Value, Timestamp: cmd.Timestamp}
+	switch cmd.Op {
+	case "put":
+	case "delete":
+		record.Value, record.Tombstone = nil, true
+	default:
+		return nil, fmt.Errorf("unknown cluster command %q", cmd.Op)
+	}
+
+	sm.kvs.mu.Lock()
+	defer sm.kvs.mu.Unlock()
+	return nil, sm.kvs.putRecord(record)
+}
+
+// Snapshot returns the live records of the regular keys, read from the memtables and SSTables.
+// The SSTable files themselves can't be shipped: they hold the reserved keys of the member, such as its rate limits,
+// and their layout depends on the member's compression, encryption key and compaction settings.
+func (sm *clusterStateMachine) Snapshot() ([]byte, error) {
+	sm.kvs.mu.Lock()
+	defer sm.kvs.mu.Unlock()
+
+	iter, err := sm.kvs.recordIterator("")
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Stop()
+
+	records := make([]syncRecord, 0)
+	for rec := iter.Next(); rec != nil; rec = iter.Next() {
+		if rec.Tombstone || util.IsReservedKey(rec.Key) {
+			continue
+		}
+		records = append(records, syncRecord{Key: string(rec.Key), Value: rec.Value, Timestamp: rec.Timestamp})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(records)
+}
+
+// Restore replaces the regular keys of the store with the records of a snapshot.
+func (sm *clusterStateMachine) Restore(snapshot []byte) error {
+	var records []syncRecord
+	if err := json.Unmarshal(snapshot, &records); err != nil {
+		return err
+	}
+	keep := make(map[string]bool, len(records))
+	for _, rec := range records {
+		keep[rec.Key] = true
+	}
+
+	sm.kvs.mu.Lock()
+	defer sm.kvs.mu.Unlock()
+
+	iter, err := sm.kvs.recordIterator("")
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for rec := iter.Next(); rec != nil; rec = iter.Next() {
+		if !rec.Tombstone && !keep[string(rec.Key)] && !util.IsReservedKey(rec.Key) {
+			stale = append(stale, string(rec.Key))
+		}
+	}
+	iter.Stop()
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	for _, key := range stale {
+		if err := sm.kvs.delete(key); err != nil {
+			return err
+		}
+	}
+	return sm.kvs.applySyncRecords(records)
+}
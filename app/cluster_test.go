@@ -0,0 +1,110 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"nasp-project/raft"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestCluster(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "cluster_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	network := raft.NewInMemNetwork()
+	ids := []string{"n1", "n2", "n3"}
+	stores := map[string]*KeyValueStore{}
+	clusters := map[string]*Cluster{}
+	for _, id := range ids {
+		kvs := newTestStore(t, path.Join(tmpDir, id))
+		config := raft.DefaultConfig(id, ids)
+		config.ElectionTimeout = 100 * time.Millisecond
+		config.HeartbeatInterval = 20 * time.Millisecond
+		config.SnapshotThreshold = 20
+		cluster, err := kvs.StartCluster(config, network.Transport(id), raft.NewMemoryStorage())
+		if err != nil {
+			t.Fatalf("Failed to start the cluster member %s: %v", id, err)
+		}
+		network.Register(cluster.Node())
+		stores[id], clusters[id] = kvs, cluster
+	}
+	defer func() {
+		for _, cluster := range clusters {
+			cluster.Stop()
+		}
+	}()
+
+	leaderOf := func(except string) *Cluster {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			for id, cluster := range clusters {
+				if id != except && cluster.Node().IsLeader() {
+					return cluster
+				}
+			}
+		}
+		t.Fatalf("No leader elected")
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	leader := leaderOf("")
+	if err := leader.Put(ctx, "key", []byte("value")); err != nil {
+		t.Fatalf("Failed to put through the leader: %v", err)
+	}
+	if value, err := leader.Get(ctx, "key"); err != nil || string(value) != "value" {
+		t.Errorf("Expected value, got %q, %v", value, err)
+	}
+	for id, cluster := range clusters {
+		if cluster != leader {
+			if err := cluster.Put(ctx, "key", []byte("other")); !errors.Is(err, raft.ErrNotLeader) {
+				t.Errorf("Expected ErrNotLeader from %s, got %v", id, err)
+			}
+		}
+		stores[id].mu.Lock()
+		err := stores[id].Put("direct", []byte("v"))
+		stores[id].mu.Unlock()
+		if !errors.Is(err, ErrReadOnly) {
+			t.Errorf("Expected ErrReadOnly for a direct write to %s, got %v", id, err)
+		}
+	}
+	for _, id := range ids {
+		waitFor(t, stores[id], "the write on "+id, func() bool {
+			value, err := stores[id].get("key")
+			return err == nil && string(value) == "value"
+		})
+	}
+
+	// the new leader has the committed writes, the isolated member catches up from a snapshot
+	old := leader.Node().ID()
+	network.SetConnected(old, false)
+	leader = leaderOf(old)
+	if value, err := leader.Get(ctx, "key"); err != nil || string(value) != "value" {
+		t.Errorf("Expected value from the new leader, got %q, %v", value, err)
+	}
+	if err := leader.Delete(ctx, "key"); err != nil {
+		t.Fatalf("Failed to delete through the leader: %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		if err := leader.Put(ctx, fmt.Sprintf("k%d", i), []byte("v")); err != nil {
+			t.Fatalf("Failed to put through the leader: %v", err)
+		}
+	}
+	network.SetConnected(old, true)
+	waitFor(t, stores[old], "the isolated member to catch up", func() bool {
+		deleted, err1 := stores[old].get("key")
+		last, err2 := stores[old].get("k49")
+		return err1 == nil && err2 == nil && deleted == nil && string(last) == "v"
+	})
+	if status := clusters[old].Node().Status(); status.Snapshot == 0 {
+		t.Errorf("Expected the isolated member to install a snapshot, got %+v", status)
+	}
+}
@@ -1,10 +1,16 @@
 package app
 
 import (
+	"errors"
 	"nasp-project/structures/compression"
+	"nasp-project/structures/lsm"
+	"nasp-project/util"
+	"os"
+	"path/filepath"
 )
 
-// getCompressionDict returns the current global compression dictionary.
+// getCompressionDict returns the global compression dictionary, which is only used for the SSTables
+// written before every SSTable kept its own dictionary. It is empty if there are no such tables.
 // If the compression is turned off, returns nil.
 func (kvs *KeyValueStore) getCompressionDict() (*compression.Dictionary, error) {
 	if !kvs.config.SSTable.Compression {
@@ -12,36 +18,46 @@ func (kvs *KeyValueStore) getCompressionDict() (*compression.Dictionary, error)
 		return nil, nil
 	}
 	if kvs.compressionDict == nil {
-		return compression.LoadCompressionDictFromFile(kvs.config.SSTable.SavePath, kvs.config.SSTable.CompressionFilename)
-	}
-	return kvs.compressionDict, nil
-}
-
-// updateCompressionDict adds the given key to the global compression dictionary and returns the updated dictionary.
-// If the compression is turned off, does nothing and returns nil.
-func (kvs *KeyValueStore) updateCompressionDict(key string) (*compression.Dictionary, error) {
-	if !kvs.config.SSTable.Compression {
-		// compression turned off
-		return nil, nil
-	}
-
-	if kvs.compressionDict == nil {
-		compressionDict, err := kvs.getCompressionDict()
+		compressionDict, err := compression.LoadCompressionDictFromFile(kvs.config.SSTable.SavePath, kvs.config.SSTable.CompressionFilename)
 		if err != nil {
 			return nil, err
 		}
 		kvs.compressionDict = compressionDict
 	}
+	return kvs.compressionDict, nil
+}
 
-	added := kvs.compressionDict.Add([]byte(key))
+// dropGlobalCompressionDict deletes the global compression dictionary once compactions have
+// rewritten all SSTables that use it.
+func (kvs *KeyValueStore) dropGlobalCompressionDict() error {
+	path := filepath.Join(kvs.config.SSTable.SavePath, kvs.config.SSTable.CompressionFilename)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
 
-	if added {
-		//err := compression.WriteCompressionDictToFile(kvs.compressionDict, kvs.config.SSTable.SavePath, kvs.config.SSTable.CompressionFilename)
-		err := kvs.compressionDict.AppendLastToFile(kvs.config.SSTable.SavePath, kvs.config.SSTable.CompressionFilename)
+	for level := util.LSMFirstLevelNum; level <= kvs.config.LSMTree.MaxLevel; level++ {
+		tables, err := lsm.GetSSTablesForLevel(kvs.config.SSTable.SavePath, level)
+		if errors.Is(err, lsm.ErrUnreadableTable) {
+			return nil // an unreadable table may still use the dictionary
+		}
 		if err != nil {
-			return nil, err
+			return err
+		}
+		for _, table := range tables {
+			if !table.HasDictionary() {
+				return nil
+			}
 		}
 	}
 
-	return kvs.compressionDict, nil
+	err := os.Remove(path)
+	if err != nil {
+		return err
+	}
+	if kvs.compressionDict != nil {
+		kvs.compressionDict = compression.NewDictionary()
+	}
+	return nil
 }
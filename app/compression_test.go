@@ -0,0 +1,58 @@
+package app
+
+import (
+	"fmt"
+	"nasp-project/structures/compression"
+	"nasp-project/util"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestKeyValueStore_CompressionDict(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "kv_store_test_compression_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := *util.GetConfig()
+	config.SSTable.SavePath = path.Join(tmpDir, "sstable")
+	config.SSTable.Compression = true
+	config.WAL.WALFolderPath = path.Join(tmpDir, "wal")
+	config.Memtable.MaxSize = 20
+	config.LSMTree.SizeTiered.MaxLsmNodesPerLevel = 2
+	config.TokenBucket.MaxTokenSize = 100000
+
+	// a global dictionary without tables that use it is deleted
+	global := compression.NewDictionary()
+	global.Add([]byte("deleted"))
+	err = compression.WriteCompressionDictToFile(global, config.SSTable.SavePath, config.SSTable.CompressionFilename)
+	if err != nil {
+		t.Fatalf("Failed to write the dictionary: %v", err)
+	}
+	db, err := NewKeyValueStore(&config)
+	if err != nil {
+		t.Fatalf("Failed to create key-value store: %v", err)
+	}
+	globalPath := path.Join(config.SSTable.SavePath, config.SSTable.CompressionFilename)
+	if _, err := os.Stat(globalPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the global dictionary to be deleted, got %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if err := db.Put(fmt.Sprintf("key%03d", i), []byte(fmt.Sprintf("value%d", i))); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	if _, err := os.Stat(globalPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no global dictionary, got %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		value, err := db.Get(fmt.Sprintf("key%03d", i))
+		if err != nil || string(value) != fmt.Sprintf("value%d", i) {
+			t.Fatalf("Expected value%d, got %q, %v", i, value, err)
+		}
+	}
+	db.Close()
+}
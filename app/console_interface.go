@@ -8,6 +8,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -18,6 +19,7 @@ const (
 // Start the console interface.
 func Start(db *KeyValueStore) {
 	fmt.Println("Type HELP for list of commands")
+	db.startCheckpoints()
 	for {
 		fmt.Print("> ")
 		var input string
@@ -34,7 +36,7 @@ func Start(db *KeyValueStore) {
 			continue
 		}
 		if exit {
-			err = db.wal.EmptyBuffer()
+			err = db.Close()
 			if err != nil {
 				fmt.Println("Error: " + err.Error())
 			}
@@ -58,6 +60,12 @@ func parseAndExecute(input string, db *KeyValueStore) (bool, error) {
 			parts[i] = parts[i][1 : len(parts[i])-1]
 		}
 	}
+	// the commands hold the lock of the store like the network servers, so that the rate limits can be checkpointed
+	// in the background, Sync takes it by itself
+	if command := strings.ToLower(parts[0]); command != "sync" {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+	}
 	switch strings.ToLower(parts[0]) {
 	case "help", "?", "commands":
 		help()
@@ -311,6 +319,34 @@ func parseAndExecute(input string, db *KeyValueStore) (bool, error) {
 		}
 		fmt.Println(result)
 		return false, nil
+	case "explain":
+		if len(parts) < 3 || strings.ToLower(parts[1]) != "get" {
+			return false, errors.New("invalid arguments")
+		}
+		exp, err := db.ExplainGet(parts[2])
+		if err != nil {
+			return false, err
+		}
+		printExplanation(exp)
+		return false, nil
+	case "stats":
+		stats, err := db.Stats()
+		if err != nil {
+			return false, err
+		}
+		printStats(stats)
+		return false, nil
+	case "sync":
+		if len(parts) < 2 {
+			return false, errors.New("invalid arguments")
+		}
+		result, err := db.Sync(parts[1])
+		if err != nil {
+			return false, err
+		}
+		fmt.Printf("Differing ranges: %d, records received: %d, records sent: %d\n",
+			result.Ranges, result.Received, result.Sent)
+		return false, nil
 	default:
 		return false, errors.New("invalid command")
 	}
@@ -359,12 +395,100 @@ func readFile(path string) ([]byte, error) {
 	return result, nil
 }
 
+// printExplanation prints the read path of a Get.
+func printExplanation(exp *Explanation) {
+	switch exp.Source {
+	case SourceMemtable:
+		fmt.Printf("Answered by: memtable %d\n", exp.MemtableIndex)
+	case SourceCache:
+		fmt.Println("Answered by: cache")
+	case SourceSSTable:
+		fmt.Printf("Answered by: level %d, SSTable %05d\n", exp.Level, exp.Label)
+	default:
+		fmt.Println("Answered by: none (key not found)")
+	}
+	if exp.Tombstone {
+		fmt.Println("Record is deleted")
+	}
+	for _, table := range exp.SSTables {
+		fmt.Printf("  Level %d, SSTable %05d:", table.Level, table.Label)
+		if !table.FilterMaybe {
+			fmt.Print(" filter: no")
+		} else {
+			fmt.Print(" filter: maybe")
+			if table.RangeExcluded {
+				fmt.Print(", excluded by summary range")
+			} else if table.Found {
+				fmt.Print(", found")
+			} else {
+				fmt.Print(", not found (false positive)")
+			}
+		}
+		fmt.Printf(", filter bytes: %d, dictionary bytes: %d, summary bytes: %d, index bytes: %d, data bytes: %d, time: %v\n",
+			table.FilterBytesRead, table.DictionaryBytesRead, table.SummaryBytesRead, table.IndexBytesRead, table.DataBytesRead, table.Duration)
+	}
+	fmt.Printf("Total time: %v\n", exp.Duration)
+}
+
+// printStats prints the engine statistics.
+func printStats(stats *Stats) {
+	fmt.Println("Memtables:")
+	for i, mt := range stats.Memtables {
+		fmt.Printf("  Memtable %d - records: %d, bytes: %d\n", i, mt.Records, mt.Bytes)
+	}
+	fmt.Println("LSM Tree:")
+	for _, level := range stats.Levels {
+		fmt.Printf("  Level %d - SSTables: %d, bytes: %d\n", level.Level, len(level.SSTables), level.Bytes)
+		for _, table := range level.SSTables {
+			fmt.Printf("    SSTable %05d - bytes: %d, filter negatives: %d, true positives: %d, false positives: %d\n",
+				table.Label, table.Bytes, table.Filter.Negatives, table.Filter.TruePositives, table.Filter.FalsePositives)
+		}
+	}
+	fmt.Println("Cache:")
+	fmt.Printf("  hits: %d, misses: %d, hit rate: %.2f%%\n", stats.CacheHits, stats.CacheMisses, stats.CacheHitRate()*100)
+	if bc := stats.BlockCache; bc != nil {
+		fmt.Println("Block Cache:")
+		fmt.Printf("  bytes: %d of %d, evictions: %d\n", bc.Bytes, bc.Capacity, bc.Evictions)
+		for _, kind := range []string{"filter", "summary", "dictionary", "index", "data"} {
+			s := bc.Kinds[kind]
+			fmt.Printf("  %s - hits: %d, misses: %d, hit rate: %.2f%%\n", kind, s.Hits, s.Misses, s.HitRate()*100)
+		}
+	}
+	if of := stats.OpenFiles; of.Max > 0 {
+		fmt.Println("Open SSTable Files:")
+		fmt.Printf("  open: %d of %d, idle: %d, reused: %d, opened: %d\n", of.Open, of.Max, of.Idle, of.Reused, of.Opened)
+	}
+	fmt.Println("WAL:")
+	fmt.Printf("  segments: %d, bytes: %d\n", stats.WALSegments, stats.WALBytes)
+	fmt.Println("Compaction:")
+	fmt.Printf("  compactions: %d, merges: %d, bytes read: %d, bytes written: %d\n",
+		stats.Compaction.Compactions, stats.Compaction.Merges, stats.Compaction.BytesRead, stats.Compaction.BytesWritten)
+	fmt.Printf("  last compaction - bytes read: %d, bytes written: %d\n",
+		stats.Compaction.LastBytesRead, stats.Compaction.LastBytesWritten)
+	if r := stats.Replication; r != nil {
+		fmt.Println("Replication:")
+		fmt.Printf("  leader: %s, connected: %t, applied: %d, lag: %d bytes, last contact: %v\n",
+			r.Leader, r.Connected, r.AppliedPosition, r.Lag(), r.LastContact.Format(time.RFC3339))
+		if r.LastError != "" {
+			fmt.Printf("  last error: %s\n", r.LastError)
+		}
+	}
+	if c := stats.Cluster; c != nil {
+		fmt.Println("Cluster:")
+		fmt.Printf("  node: %s, role: %s, term: %d, leader: %s, commit: %d, applied: %d, snapshot: %d\n",
+			c.ID, c.Role, c.Term, c.Leader, c.CommitIndex, c.LastApplied, c.Snapshot)
+	}
+}
+
 // help prints all commands.
 func help() {
 	fmt.Println("General:")
 	fmt.Println("  PUT key <value | -s valueSourceFile>")
 	fmt.Println("  GET key [-d destinationFile [-a(append)]]")
 	fmt.Println("  DELETE key")
+	fmt.Println("  EXPLAIN GET key")
+	fmt.Println("  STATS")
+	fmt.Println("  SYNC host:port(replication address of the peer)")
 	fmt.Println("  HELP | ? | COMMANDS")
 	fmt.Println("  EXIT | QUIT | Q")
 	fmt.Println()
@@ -0,0 +1,27 @@
+package app
+
+import (
+	"fmt"
+	"nasp-project/structures/encryption"
+	"nasp-project/util"
+)
+
+// enableEncryption sets the key provider of the configuration for the encryption at rest.
+// The provider is shared by all stores of the process, see encryption.SetKeyProvider.
+func enableEncryption(config *util.EncryptionConfig) error {
+	var provider encryption.KeyProvider
+	var err error
+	switch config.Provider {
+	case "env":
+		provider, err = encryption.NewEnvKeyProvider(config.KeyEnv)
+	case "file", "":
+		provider, err = encryption.NewFileKeyProvider(config.KeyFile)
+	default:
+		return fmt.Errorf("unknown encryption key provider %q", config.Provider)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load the encryption keys: %w", err)
+	}
+	encryption.SetKeyProvider(provider)
+	return nil
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"nasp-project/structures/encryption"
+	"nasp-project/util"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyValueStore_Encryption(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "kv_store_test_encryption_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer encryption.SetKeyProvider(nil)
+
+	keyFile := path.Join(tmpDir, "keys.txt")
+	k1 := "k1:" + strings.Repeat("01", 32) + "\n"
+	if err := os.WriteFile(keyFile, []byte(k1), 0600); err != nil {
+		t.Fatalf("Failed to write the key file: %v", err)
+	}
+	config := *util.GetConfig()
+	config.SSTable.SavePath = path.Join(tmpDir, "sstable")
+	config.WAL.WALFolderPath = path.Join(tmpDir, "wal")
+	config.Memtable.MaxSize = 20
+	config.LSMTree.SizeTiered.MaxLsmNodesPerLevel = 2
+	config.TokenBucket.MaxTokenSize = 100000
+	config.Encryption = util.EncryptionConfig{Enabled: true, Provider: "file", KeyFile: keyFile}
+
+	db, err := NewKeyValueStore(&config)
+	if err != nil {
+		t.Fatalf("Failed to create key-value store: %v", err)
+	}
+	put := func(from, to int) {
+		t.Helper()
+		for i := from; i < to; i++ {
+			if err := db.Put(fmt.Sprintf("key%03d", i), []byte(fmt.Sprintf("secret-value-%d", i))); err != nil {
+				t.Fatalf("Failed to put: %v", err)
+			}
+		}
+	}
+	check := func(count int) {
+		t.Helper()
+		for i := 0; i < count; i++ {
+			value, err := db.Get(fmt.Sprintf("key%03d", i))
+			if err != nil || string(value) != fmt.Sprintf("secret-value-%d", i) {
+				t.Fatalf("Expected the value of key%03d, got %q, %v", i, value, err)
+			}
+		}
+	}
+	// blocks of SSTables, the TOC, metadata and manifest files are plain
+	outdatedFiles := func() (files, outdated int) {
+		t.Helper()
+		filepath.WalkDir(config.SSTable.SavePath, func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || strings.Contains(name, "TOC") || strings.Contains(name, "Metadata") || strings.Contains(name, "MANIFEST") {
+				return err
+			}
+			files++
+			if o, err := encryption.Outdated(name); err != nil {
+				t.Fatalf("Failed to check %s: %v", name, err)
+			} else if o {
+				outdated++
+			}
+			return nil
+		})
+		return files, outdated
+	}
+
+	put(0, 80)
+	check(80)
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	filepath.WalkDir(tmpDir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if data, _ := os.ReadFile(name); bytes.Contains(data, []byte("secret-value")) || bytes.Contains(data, []byte("key0")) {
+			t.Errorf("Expected %s to be encrypted", name)
+		}
+		return nil
+	})
+	if files, outdated := outdatedFiles(); files == 0 || outdated != 0 {
+		t.Errorf("Expected all of the %d files to use the current key, %d don't", files, outdated)
+	}
+
+	// after a rotation old data stays readable and compactions rewrite it with the new key
+	k2 := "k2:" + strings.Repeat("02", 32) + "\n"
+	if err := os.WriteFile(keyFile, []byte(k1+k2), 0600); err != nil {
+		t.Fatalf("Failed to write the key file: %v", err)
+	}
+	db, err = NewKeyValueStore(&config)
+	if err != nil {
+		t.Fatalf("Failed to reopen key-value store: %v", err)
+	}
+	check(80)
+	files, before := outdatedFiles()
+	if before == 0 || before != files {
+		t.Errorf("Expected the tables and their dictionaries to keep the old key, %d of %d files are outdated", before, files)
+	}
+	put(80, 240)
+	check(240)
+	if _, after := outdatedFiles(); after >= before {
+		t.Errorf("Expected compactions to re-encrypt tables, %d files were and %d are outdated", before, after)
+	}
+	db.Close()
+
+	// without the keys the data can't be read
+	encryption.SetKeyProvider(nil)
+	config.Encryption.Enabled = false
+	if db, err := NewKeyValueStore(&config); err == nil {
+		if _, err := db.Get("key000"); err == nil {
+			t.Errorf("Expected an error reading encrypted data without the keys")
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package app
+
+import "errors"
+
+// Errors returned by the KeyValueStore methods. Use errors.Is to check for them.
+var (
+	ErrRateLimitReached = errors.New("rate limit reached")
+	ErrReservedKey      = errors.New("reserved key")
+	ErrNotFound         = errors.New("not found") // a probabilistic structure with the given key does not exist
+
+	ErrAuthenticationFailed = errors.New("authentication failed") // returned to network clients with invalid or missing credentials
+	ErrPermissionDenied     = errors.New("permission denied")     // returned to network clients without access to a key
+	ErrReadOnly             = errors.New("read-only replica")     // returned for writes to a follower or a cluster member, see StartReplica and StartCluster
+)
+
+// notFoundError is returned when a probabilistic structure is missing. It matches ErrNotFound.
+type notFoundError string
+
+func (e notFoundError) Error() string {
+	return "no " + string(e) + " with given key"
+}
+
+func (e notFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
@@ -0,0 +1,202 @@
+package app
+
+import (
+	"nasp-project/model"
+	"nasp-project/structures/compression"
+	"nasp-project/structures/lsm"
+	"nasp-project/structures/lsm/compactions"
+	"nasp-project/structures/sstable"
+	"nasp-project/util"
+	"time"
+)
+
+// FlushInfo describes a single memtable flush.
+type FlushInfo struct {
+	MemtableIndex int           // index of the flushed memtable instance
+	Records       int           // number of records written to the SSTable
+	SSTableLabel  int           // label of the created SSTable, 0 in OnFlushBegin
+	Duration      time.Duration // time spent flushing, 0 in OnFlushBegin
+}
+
+// CompactionInfo describes a single LSM Tree compaction.
+type CompactionInfo struct {
+	Algorithm    string        // compaction algorithm from the config
+	Inputs       []string      // TOC file paths of the tables removed by the compaction, nil in OnCompactionBegin
+	Outputs      []string      // TOC file paths of the tables created by the compaction, nil in OnCompactionBegin
+	BytesRead    uint64        // total size of the merged tables
+	BytesWritten uint64        // total size of the written tables
+	Duration     time.Duration // time spent compacting, 0 in OnCompactionBegin
+}
+
+// WALSegmentInfo describes a WAL segment file.
+type WALSegmentInfo struct {
+	FileName string
+}
+
+// WriteStallInfo describes a write that had to wait for a memtable flush.
+type WriteStallInfo struct {
+	Key string
+}
+
+// EventListener receives notifications about background work of the KeyValueStore.
+// Callbacks are called synchronously from the goroutine that does the work,
+// so they should return quickly. Embed NoopEventListener to implement only some of them.
+type EventListener interface {
+	OnFlushBegin(info FlushInfo)
+	OnFlushEnd(info FlushInfo)
+	OnCompactionBegin(info CompactionInfo)
+	OnCompactionEnd(info CompactionInfo)
+	OnWALSegmentCreated(info WALSegmentInfo)
+	OnWALSegmentDeleted(info WALSegmentInfo)
+	OnWriteStall(info WriteStallInfo)
+	OnBackgroundError(err error)
+}
+
+// NoopEventListener implements EventListener with callbacks that do nothing.
+type NoopEventListener struct{}
+
+func (NoopEventListener) OnFlushBegin(FlushInfo)             {}
+func (NoopEventListener) OnFlushEnd(FlushInfo)               {}
+func (NoopEventListener) OnCompactionBegin(CompactionInfo)   {}
+func (NoopEventListener) OnCompactionEnd(CompactionInfo)     {}
+func (NoopEventListener) OnWALSegmentCreated(WALSegmentInfo) {}
+func (NoopEventListener) OnWALSegmentDeleted(WALSegmentInfo) {}
+func (NoopEventListener) OnWriteStall(WriteStallInfo)        {}
+func (NoopEventListener) OnBackgroundError(error)            {}
+
+// eventListeners dispatches every event to all registered listeners in the order of registration.
+// It also implements writeaheadlog.SegmentListener.
+type eventListeners []EventListener
+
+func (els eventListeners) OnSegmentCreated(fileName string) {
+	for _, l := range els {
+		l.OnWALSegmentCreated(WALSegmentInfo{FileName: fileName})
+	}
+}
+
+func (els eventListeners) OnSegmentDeleted(fileName string) {
+	for _, l := range els {
+		l.OnWALSegmentDeleted(WALSegmentInfo{FileName: fileName})
+	}
+}
+
+// AddEventListener registers a listener that is notified about flushes, compactions,
+// WAL segment rotation, write stalls and background errors.
+func (kvs *KeyValueStore) AddEventListener(listener EventListener) {
+	kvs.listeners = append(kvs.listeners, listener)
+	kvs.wal.SetSegmentListener(kvs.listeners)
+}
+
+// flush flushes the oldest memtable into a new SSTable and notifies the listeners.
+// Returns the flushed records and the index of the flushed memtable.
+func (kvs *KeyValueStore) flush(compressionDict *compression.Dictionary) ([]model.Record, int, error) {
+	start := time.Now()
+	for _, l := range kvs.listeners {
+		l.OnFlushBegin(FlushInfo{MemtableIndex: kvs.memtables.LastIndex()})
+	}
+
+	recs, flushedIdx := kvs.memtables.Flush()
+	sst, err := sstable.CreateSSTable(recs, compressionDict, &kvs.config.SSTable)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info := FlushInfo{
+		MemtableIndex: flushedIdx,
+		Records:       len(recs),
+		SSTableLabel:  lsm.GetLabelNumFromSSTable(sst),
+		Duration:      time.Since(start),
+	}
+	util.Logger().Debug("memtable flushed", "memtable_index", info.MemtableIndex, "records", info.Records,
+		util.LogKeyLevel, util.LSMFirstLevelNum, util.LogKeyLabel, info.SSTableLabel, "duration", info.Duration)
+	for _, l := range kvs.listeners {
+		l.OnFlushEnd(info)
+	}
+
+	return recs, flushedIdx, nil
+}
+
+// compact runs the LSM Tree compaction, adds it to the compaction stats of the store
+// and notifies the listeners if the compaction start condition is met.
+func (kvs *KeyValueStore) compact(compressionDict *compression.Dictionary) error {
+	if len(kvs.listeners) == 0 {
+		merges, err := compactions.Compact(compressionDict, &kvs.config.LSMTree, &kvs.config.SSTable)
+		kvs.compactionStats.Add(merges)
+		return err
+	}
+
+	should, err := compactions.ShouldCompact(&kvs.config.LSMTree, &kvs.config.SSTable)
+	if err != nil {
+		return err
+	}
+	if !should {
+		merges, err := compactions.Compact(compressionDict, &kvs.config.LSMTree, &kvs.config.SSTable)
+		kvs.compactionStats.Add(merges)
+		return err
+	}
+
+	start := time.Now()
+	info := CompactionInfo{Algorithm: kvs.config.LSMTree.CompactionAlgorithm}
+	for _, l := range kvs.listeners {
+		l.OnCompactionBegin(info)
+	}
+
+	before, err := kvs.tableTOCPaths()
+	if err != nil {
+		return err
+	}
+	merges, err := compactions.Compact(compressionDict, &kvs.config.LSMTree, &kvs.config.SSTable)
+	kvs.compactionStats.Add(merges)
+	if err != nil {
+		return err
+	}
+	after, err := kvs.tableTOCPaths()
+	if err != nil {
+		return err
+	}
+
+	for path := range before {
+		if !after[path] {
+			info.Inputs = append(info.Inputs, path)
+		}
+	}
+	for path := range after {
+		if !before[path] {
+			info.Outputs = append(info.Outputs, path)
+		}
+	}
+	info.BytesRead = merges.BytesRead
+	info.BytesWritten = merges.BytesWritten
+	info.Duration = time.Since(start)
+	util.Logger().Debug("compaction finished", "algorithm", info.Algorithm, "inputs", len(info.Inputs),
+		"outputs", len(info.Outputs), "duration", info.Duration)
+	for _, l := range kvs.listeners {
+		l.OnCompactionEnd(info)
+	}
+
+	return nil
+}
+
+// tableTOCPaths returns the set of TOC file paths of all SSTables in the LSM Tree.
+func (kvs *KeyValueStore) tableTOCPaths() (map[string]bool, error) {
+	paths := map[string]bool{}
+	for level := util.LSMFirstLevelNum; level <= kvs.config.LSMTree.MaxLevel; level++ {
+		tocPaths, err := lsm.GetTOCFilePathsForLevel(kvs.config.SSTable.SavePath, level)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range tocPaths {
+			paths[path] = true
+		}
+	}
+	return paths, nil
+}
+
+// backgroundError notifies the listeners about a failed flush, compaction or WAL cleanup and returns err.
+func (kvs *KeyValueStore) backgroundError(err error) error {
+	util.Logger().Error("background work failed", util.LogKeyError, err)
+	for _, l := range kvs.listeners {
+		l.OnBackgroundError(err)
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"nasp-project/model"
+	"nasp-project/structures/lsm"
+	"nasp-project/util"
+	"time"
+)
+
+// Components of the read path that can answer a Get.
+const (
+	SourceNone     = "none" // the key was not found
+	SourceMemtable = "memtable"
+	SourceCache    = "cache"
+	SourceSSTable  = "sstable"
+)
+
+// Explanation describes the read path of a single Get.
+type Explanation struct {
+	Key           string
+	Source        string // one of the Source constants
+	MemtableIndex int    // memtable instance that answered, -1 if the source is not SourceMemtable
+	Level         int    // LSM Tree level that answered, 0 if the source is not SourceSSTable
+	Label         int    // label of the SSTable that answered, 0 if the source is not SourceSSTable
+	Tombstone     bool   // true if the found record is deleted
+	Value         []byte
+	SSTables      []lsm.TableReadTrace // SSTables consulted, in order
+	Duration      time.Duration
+}
+
+// answeredBy sets the source of the Explanation and the found record.
+func (exp *Explanation) answeredBy(source string, rec *model.Record) {
+	exp.Source = source
+	exp.Tombstone = rec.Tombstone
+	exp.Value = rec.Value
+}
+
+// ExplainGet performs a Get of the given key and describes which component answered it
+// and how much work was done in each consulted SSTable.
+// Like Get, it uses the cache and consumes a rate limit token.
+// Returns an error if the read fails or the rate limit is reached.
+func (kvs *KeyValueStore) ExplainGet(key string) (*Explanation, error) {
+	if block, err := kvs.rateLimitReached(rateLimitRead, 1); block {
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrRateLimitReached
+	}
+	if util.IsReservedKey([]byte(key)) {
+		return nil, ErrReservedKey
+	}
+
+	exp := &Explanation{
+		Key:           key,
+		Source:        SourceNone,
+		MemtableIndex: -1,
+	}
+	start := time.Now()
+	_, err := kvs.read(key, exp)
+	exp.Duration = time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	if exp.Tombstone {
+		exp.Value = nil
+	}
+
+	return exp, nil
+}
@@ -1,8 +1,8 @@
 package app
 
 import (
-	"errors"
 	"nasp-project/model"
+	"nasp-project/structures/block_cache"
 	"nasp-project/structures/compression"
 	"nasp-project/structures/lru_cache"
 	"nasp-project/structures/lsm"
@@ -11,19 +11,37 @@ import (
 	"nasp-project/structures/sstable"
 	writeaheadlog "nasp-project/structures/write-ahead_log"
 	"nasp-project/util"
+	"sync"
 	"time"
 )
 
 type KeyValueStore struct {
+	mu              sync.Mutex // serializes calls from network servers, the store itself is not safe for concurrent use
+	client          string     // network client the current call is made for, set by lockAs
 	config          *util.Config
 	wal             *writeaheadlog.WAL
 	memtables       *memtable.Memtables
 	cache           *lru_cache.LRUCache
 	compressionDict *compression.Dictionary
+	metrics         *metrics
+	compactionStats compactions.Stats // of the compactions of this store
+	listeners       eventListeners
+	limiters        map[string]*clientLimiter // token buckets by their key under util.RateLimiterKey
+	checkpoints     sync.Once                 // starts the checkpoints of the token buckets, see startCheckpoints
+	stopCheckpoints chan struct{}             // closed by Close
+	replica         *Replica                  // set while the store follows a leader, see StartReplica
+	cluster         *Cluster                  // set while the store is a member of a Raft cluster, see StartCluster
 }
 
 // NewKeyValueStore creates an instance of Key-Value Storage engine with configuration given at ConfigPath.
+// The rate limits of a store used only through its methods are checkpointed by Close, see startCheckpoints.
 func NewKeyValueStore(config *util.Config) (*KeyValueStore, error) {
+	if config.Encryption.Enabled {
+		if err := enableEncryption(&config.Encryption); err != nil {
+			return nil, err
+		}
+	}
+
 	wal, err := writeaheadlog.NewWAL(&config.WAL, config.Memtable.Instances)
 	if err != nil {
 		return nil, err
@@ -42,59 +60,102 @@ func NewKeyValueStore(config *util.Config) (*KeyValueStore, error) {
 	}
 
 	cache := lru_cache.NewLRUCache(config.Cache.MaxSize)
+	if config.Cache.BlockCacheSize > 0 && sstable.GetBlockCache() == nil {
+		// the block cache is shared by all stores of the process
+		sstable.SetBlockCache(block_cache.NewBlockCache(config.Cache.BlockCacheSize))
+	}
+	if config.Cache.MaxOpenFiles > 0 && sstable.GetMaxOpenFiles() == 0 {
+		sstable.SetMaxOpenFiles(config.Cache.MaxOpenFiles)
+	}
 
-	return &KeyValueStore{
+	kvs := &KeyValueStore{
 		config:          config,
 		wal:             wal,
 		memtables:       mts,
 		cache:           &cache,
 		compressionDict: nil,
-	}, nil
+		metrics:         newMetrics(),
+		limiters:        map[string]*clientLimiter{},
+		stopCheckpoints: make(chan struct{}),
+	}
+
+	err = kvs.dropGlobalCompressionDict()
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Auth.Enabled {
+		err = kvs.syncUsers(config.Auth.Users)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return kvs, nil
+}
+
+// Close stops the background checkpoints of the rate limits, checkpoints them and writes the buffered WAL records to disk.
+// It locks kvs.mu, so it must not be called while holding it. The store must not be used after Close.
+func (kvs *KeyValueStore) Close() error {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	select {
+	case <-kvs.stopCheckpoints:
+	default:
+		close(kvs.stopCheckpoints)
+	}
+	if err := kvs.checkpointRateLimits(time.Now()); err != nil {
+		return err
+	}
+	return kvs.wal.EmptyBuffer()
 }
 
 // Get returns a value associated with the specified key from the database.
 // Returns nil if the key is not found.
 // Returns an error if the read fails or the rate limit is reached.
 func (kvs *KeyValueStore) Get(key string) ([]byte, error) {
-	if block, err := kvs.rateLimitReached(); block {
+	if block, err := kvs.rateLimitReached(rateLimitRead, 1); block {
 		if err != nil {
 			return nil, err
 		}
-		return nil, errors.New("rate limit reached")
+		return nil, ErrRateLimitReached
 	}
 	if util.IsReservedKey([]byte(key)) {
-		return nil, errors.New("reserved key")
+		return nil, ErrReservedKey
 	}
+	defer kvs.metrics.observeOperation(opGet, time.Now())
 	return kvs.get(key)
 }
 
 // Put saves a key-value pair to the database.
 // Returns an error if the write fails or the rate limit is reached.
 func (kvs *KeyValueStore) Put(key string, value []byte) error {
-	if block, err := kvs.rateLimitReached(); block {
+	if block, err := kvs.rateLimitReached(rateLimitWrite, 1); block {
 		if err != nil {
 			return err
 		}
-		return errors.New("rate limit reached")
+		return ErrRateLimitReached
 	}
 	if util.IsReservedKey([]byte(key)) {
-		return errors.New("reserved key")
+		return ErrReservedKey
 	}
+	defer kvs.metrics.observeOperation(opPut, time.Now())
 	return kvs.put(key, value)
 }
 
 // Delete deletes a value associated with the specified key from the database.
 // Returns an error if the write fails or the rate limit is reached.
 func (kvs *KeyValueStore) Delete(key string) error {
-	if block, err := kvs.rateLimitReached(); block {
+	if block, err := kvs.rateLimitReached(rateLimitWrite, 1); block {
 		if err != nil {
 			return err
 		}
-		return errors.New("rate limit reached")
+		return ErrRateLimitReached
 	}
 	if util.IsReservedKey([]byte(key)) {
-		return errors.New("reserved key")
+		return ErrReservedKey
 	}
+	defer kvs.metrics.observeOperation(opDelete, time.Now())
 	return kvs.delete(key)
 }
 
@@ -104,6 +165,16 @@ func (kvs *KeyValueStore) Delete(key string) error {
 // Returns an error if the read fails.
 // If the compression is turned on, might make up to a total of two get calls.
 func (kvs *KeyValueStore) get(key string) ([]byte, error) {
+	rec, err := kvs.read(key, nil)
+	if err != nil || rec == nil {
+		return nil, err
+	}
+	return rec.Value, nil
+}
+
+// read implements get. It returns the newest record with the given key, or nil if the key is not found or deleted.
+// If exp is not nil, it is filled with the description of the read path.
+func (kvs *KeyValueStore) read(key string, exp *Explanation) (*model.Record, error) {
 	keyBytes := []byte(key)
 
 	compressionDict, err := kvs.getCompressionDict()
@@ -111,33 +182,52 @@ func (kvs *KeyValueStore) get(key string) ([]byte, error) {
 		return nil, err
 	}
 
-	rec, err := kvs.memtables.Get(keyBytes)
+	rec, memtableIdx, err := kvs.memtables.GetWithIndex(keyBytes)
 	if err == nil && rec != nil {
+		if exp != nil {
+			exp.answeredBy(SourceMemtable, rec)
+			exp.MemtableIndex = memtableIdx
+		}
 		if rec.Tombstone {
 			return nil, nil
 		}
-		return rec.Value, nil
+		return rec, nil
 	}
 
 	rec = kvs.cache.Get(key)
 	if rec != nil {
+		if exp != nil {
+			exp.answeredBy(SourceCache, rec)
+		}
 		if rec.Tombstone {
 			return nil, nil
 		}
-		return rec.Value, nil
+		return rec, nil
 	}
 
-	rec, err = lsm.Read(keyBytes, compressionDict, kvs.config)
+	if exp != nil {
+		rec, exp.SSTables, err = lsm.ReadWithTrace(keyBytes, compressionDict, kvs.config)
+	} else {
+		rec, err = lsm.Read(keyBytes, compressionDict, kvs.config)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	if rec != nil {
+		if exp != nil {
+			exp.answeredBy(SourceSSTable, rec)
+			for _, trace := range exp.SSTables {
+				if trace.Answered {
+					exp.Level, exp.Label = trace.Level, trace.Label
+				}
+			}
+		}
 		kvs.cache.Put(rec)
 		if rec.Tombstone {
 			return nil, nil
 		}
-		return rec.Value, nil
+		return rec, nil
 	}
 
 	return nil, nil
@@ -150,60 +240,79 @@ func (kvs *KeyValueStore) get(key string) ([]byte, error) {
 // Returns an error if the write fails.
 // If the compression is turned on, might make up to a total of one get and two put calls.
 func (kvs *KeyValueStore) put(key string, value []byte) error {
-	record := &model.Record{
+	return kvs.putRecord(&model.Record{
 		Key:       []byte(key),
 		Value:     value,
 		Tombstone: false,
 		Timestamp: uint64(time.Now().Unix()),
-	}
+	})
+}
 
-	compressionDict, err := kvs.updateCompressionDict(key)
+// putRecord saves a record received from another store, keeping its timestamp and tombstone.
+// The WAL keeps the timestamp too, so the record is the same after it's replayed.
+// Returns an error if the write fails.
+func (kvs *KeyValueStore) putRecord(record *model.Record) error {
+	err := kvs.wal.RecordCommit(record)
 	if err != nil {
 		return err
 	}
+	return kvs.insert(record)
+}
 
-	err = kvs.wal.PutCommit(key, value)
+// insert adds a record that was already committed to the WAL to the memtable.
+// If the Memtable is full it flushes its contents into SSTable first.
+// Returns an error if the write fails.
+func (kvs *KeyValueStore) insert(record *model.Record) error {
+	key := string(record.Key)
+	compressionDict, err := kvs.getCompressionDict()
 	if err != nil {
 		return err
 	}
 
 	if kvs.memtables.IsFull() {
-		recs, flushedIdx := kvs.memtables.Flush()
+		kvs.metrics.writeStalls.Add(1)
+		for _, l := range kvs.listeners {
+			l.OnWriteStall(WriteStallInfo{Key: key})
+		}
 
-		_, err := sstable.CreateSSTable(recs, compressionDict, &kvs.config.SSTable)
+		flushStart := time.Now()
+		recs, flushedIdx, err := kvs.flush(compressionDict)
 		if err != nil {
-			return err
+			return kvs.backgroundError(err)
 		}
+		kvs.metrics.flush.ObserveSince(flushStart)
 
-		err = compactions.Compact(compressionDict, &kvs.config.LSMTree, &kvs.config.SSTable)
+		compactionStart := time.Now()
+		err = kvs.compact(compressionDict)
 		if err != nil {
-			return err
+			return kvs.backgroundError(err)
+		}
+		kvs.metrics.compaction.ObserveSince(compactionStart)
+
+		err = kvs.dropGlobalCompressionDict()
+		if err != nil {
+			return kvs.backgroundError(err)
 		}
 
 		err = kvs.wal.FlushedMemtable(flushedIdx)
 		if err != nil {
-			return err
+			return kvs.backgroundError(err)
 		}
 
 		for _, rec := range recs {
-			if kvs.cache.Get(string(rec.Key)) != nil {
+			if kvs.cache.Contains(string(rec.Key)) {
 				kvs.cache.Put(&rec)
 			}
 		}
 	}
 
-	err = kvs.memtables.Add(record)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return kvs.memtables.Add(record)
 }
 
 // delete preforms a logic delete of the key-value pair.
 // If the record is found in the Memtable, its Tombstone field is set.
-// If not found in Memtable, a new record with set Tombstone is created.
-// Uses put operation for adding a new record.
+// If not found in Memtable, a new record with set Tombstone is inserted.
+// Only the delete commit is written to the WAL, so that replaying it can't resurrect the key.
 // Returns an error if the write fails.
 func (kvs *KeyValueStore) delete(key string) error {
 	err := kvs.wal.DeleteCommit(key, nil)
@@ -213,13 +322,12 @@ func (kvs *KeyValueStore) delete(key string) error {
 
 	err = kvs.memtables.Delete([]byte(key)) // sets the tombstone to true
 	if err != nil {
-		// key does not exist in memtables, add it first
-		err = kvs.put(key, nil)
-		if err != nil {
-			return err
-		}
-		// it is now guaranteed to be in a memtable
-		return kvs.memtables.Delete([]byte(key))
+		// key does not exist in memtables, add a tombstone
+		return kvs.insert(&model.Record{
+			Key:       []byte(key),
+			Tombstone: true,
+			Timestamp: uint64(time.Now().Unix()),
+		})
 	}
 	return nil
 }
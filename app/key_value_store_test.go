@@ -1,12 +1,35 @@
 package app
 
 import (
+	"bytes"
+	"fmt"
+	"io/fs"
 	"nasp-project/util"
+	"net/http/httptest"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
+// newTestStore creates a store in dir with its own copy of the configuration, so that tests don't change it for each other
+// and two stores can run at once. The overrides change the copy before the store is created.
+func newTestStore(t *testing.T, dir string, overrides ...func(*util.Config)) *KeyValueStore {
+	config := *util.GetConfig()
+	config.SSTable.SavePath = path.Join(dir, "sstable")
+	config.WAL.WALFolderPath = path.Join(dir, "wal")
+	for _, override := range overrides {
+		override(&config)
+	}
+
+	db, err := NewKeyValueStore(&config)
+	if err != nil {
+		t.Fatalf("Failed to create key-value store: %v", err)
+	}
+	return db
+}
+
 func TestNewKeyValueStore(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "kv_store_test_")
 	if err != nil {
@@ -14,14 +37,7 @@ func TestNewKeyValueStore(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	config := util.GetConfig()
-	config.SSTable.SavePath = path.Join(tmpDir, "sstable")
-	config.WAL.WALFolderPath = path.Join(tmpDir, "wal")
-
-	db, err := NewKeyValueStore(config)
-	if err != nil {
-		t.Fatalf("Failed to create key-value store: %v", err)
-	}
+	db := newTestStore(t, tmpDir)
 
 	if db.config == nil {
 		t.Errorf("config is nil")
@@ -47,14 +63,7 @@ func TestKeyValueStore_Put(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	config := util.GetConfig()
-	config.SSTable.SavePath = path.Join(tmpDir, "sstable")
-	config.WAL.WALFolderPath = path.Join(tmpDir, "wal")
-
-	db, err := NewKeyValueStore(config)
-	if err != nil {
-		t.Fatalf("Failed to create key-value store: %v", err)
-	}
+	db := newTestStore(t, tmpDir)
 
 	key := "key"
 	value := []byte("value")
@@ -72,14 +81,7 @@ func TestKeyValueStore_Get(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	config := util.GetConfig()
-	config.SSTable.SavePath = path.Join(tmpDir, "sstable")
-	config.WAL.WALFolderPath = path.Join(tmpDir, "wal")
-
-	db, err := NewKeyValueStore(config)
-	if err != nil {
-		t.Fatalf("Failed to create key-value store: %v", err)
-	}
+	db := newTestStore(t, tmpDir)
 
 	key := "key"
 	value := []byte("value")
@@ -106,14 +108,7 @@ func TestKeyValueStore_Get_NonExistentKey(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	config := util.GetConfig()
-	config.SSTable.SavePath = path.Join(tmpDir, "sstable")
-	config.WAL.WALFolderPath = path.Join(tmpDir, "wal")
-
-	db, err := NewKeyValueStore(config)
-	if err != nil {
-		t.Fatalf("Failed to create key-value store: %v", err)
-	}
+	db := newTestStore(t, tmpDir)
 
 	key := "key"
 
@@ -134,14 +129,7 @@ func TestKeyValueStore_Delete(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	config := util.GetConfig()
-	config.SSTable.SavePath = path.Join(tmpDir, "sstable")
-	config.WAL.WALFolderPath = path.Join(tmpDir, "wal")
-
-	db, err := NewKeyValueStore(config)
-	if err != nil {
-		t.Fatalf("Failed to create key-value store: %v", err)
-	}
+	db := newTestStore(t, tmpDir)
 
 	key := "non_existent_key"
 
@@ -162,18 +150,11 @@ func TestKeyValueStore_GetRateLimitReached(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	config := util.GetConfig()
-	config.SSTable.SavePath = path.Join(tmpDir, "sstable")
-	config.WAL.WALFolderPath = path.Join(tmpDir, "wal")
-
-	config.TokenBucket.Interval = 1_000_000 // definitely long enough not to reset during the test
+	db := newTestStore(t, tmpDir, func(config *util.Config) {
+		config.TokenBucket.Interval = 1_000_000 // definitely long enough not to reset during the test
+	})
 
-	db, err := NewKeyValueStore(config)
-	if err != nil {
-		t.Fatalf("Failed to create key-value store: %v", err)
-	}
-
-	for i := 0; i < int(util.GetConfig().TokenBucket.MaxTokenSize); i++ {
+	for i := 0; i < int(db.config.TokenBucket.MaxTokenSize); i++ {
 		_, err := db.Get("key")
 		if err != nil {
 			t.Fatalf("Failed to get value: %v", err)
@@ -198,18 +179,11 @@ func TestKeyValueStore_PutRateLimitReached(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	config := util.GetConfig()
-	config.SSTable.SavePath = path.Join(tmpDir, "sstable")
-	config.WAL.WALFolderPath = path.Join(tmpDir, "wal")
-
-	config.TokenBucket.Interval = 1_000_000 // definitely long enough not to reset during the test
+	db := newTestStore(t, tmpDir, func(config *util.Config) {
+		config.TokenBucket.Interval = 1_000_000 // definitely long enough not to reset during the test
+	})
 
-	db, err := NewKeyValueStore(config)
-	if err != nil {
-		t.Fatalf("Failed to create key-value store: %v", err)
-	}
-
-	for i := 0; i < int(util.GetConfig().TokenBucket.MaxTokenSize); i++ {
+	for i := 0; i < int(db.config.TokenBucket.MaxTokenSize); i++ {
 		err := db.Put("key", []byte("value"))
 		if err != nil {
 			t.Fatalf("Failed to put key-value pair: %v", err)
@@ -234,18 +208,11 @@ func TestKeyValueStore_DeleteRateLimitReached(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	config := util.GetConfig()
-	config.SSTable.SavePath = path.Join(tmpDir, "sstable")
-	config.WAL.WALFolderPath = path.Join(tmpDir, "wal")
-
-	config.TokenBucket.Interval = 1_000_000 // definitely long enough not to reset during the test
+	db := newTestStore(t, tmpDir, func(config *util.Config) {
+		config.TokenBucket.Interval = 1_000_000 // definitely long enough not to reset during the test
+	})
 
-	db, err := NewKeyValueStore(config)
-	if err != nil {
-		t.Fatalf("Failed to create key-value store: %v", err)
-	}
-
-	for i := 0; i < int(util.GetConfig().TokenBucket.MaxTokenSize); i++ {
+	for i := 0; i < int(db.config.TokenBucket.MaxTokenSize); i++ {
 		err := db.Delete("key")
 		if err != nil {
 			t.Fatalf("Failed to delete key-value pair: %v", err)
@@ -262,3 +229,301 @@ func TestKeyValueStore_DeleteRateLimitReached(t *testing.T) {
 		t.Fatalf("Expected error 'rate limit reached', got %v", err)
 	}
 }
+
+func TestKeyValueStore_Stats(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "kv_store_test_stats_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db := newTestStore(t, tmpDir)
+
+	err = db.Put("key", []byte("value"))
+	if err != nil {
+		t.Fatalf("Failed to put key-value pair: %v", err)
+	}
+
+	stats, err := db.Stats()
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+
+	if len(stats.Memtables) != db.config.Memtable.Instances {
+		t.Fatalf("Expected %d memtables, got %d", db.config.Memtable.Instances, len(stats.Memtables))
+	}
+	if stats.Memtables[0].Records != 1 {
+		t.Errorf("Expected 1 record in memtable, got %d", stats.Memtables[0].Records)
+	}
+	if stats.Memtables[0].Bytes != int64(len("key")+len("value")) {
+		t.Errorf("Expected %d bytes in memtable, got %d", len("key")+len("value"), stats.Memtables[0].Bytes)
+	}
+	if len(stats.Levels) != db.config.LSMTree.MaxLevel {
+		t.Errorf("Expected %d levels, got %d", db.config.LSMTree.MaxLevel, len(stats.Levels))
+	}
+	if stats.WALSegments != 1 {
+		t.Errorf("Expected 1 WAL segment, got %d", stats.WALSegments)
+	}
+}
+
+func TestKeyValueStore_MetricsHandler(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "kv_store_test_metrics_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db := newTestStore(t, tmpDir)
+
+	err = db.Put("key", []byte("value"))
+	if err != nil {
+		t.Fatalf("Failed to put key-value pair: %v", err)
+	}
+	_, err = db.Get("key")
+	if err != nil {
+		t.Fatalf("Failed to get value: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	db.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+
+	expected := []string{
+		"# TYPE kvs_operation_duration_seconds histogram",
+		`kvs_operation_duration_seconds_bucket{operation="get",le="+Inf"} 1`,
+		`kvs_operation_duration_seconds_count{operation="put"} 1`,
+		`kvs_operation_duration_seconds_count{operation="delete"} 0`,
+		"kvs_flush_duration_seconds_count 0",
+		"kvs_write_stalls_total 0",
+		"kvs_rate_limit_rejections_total 0",
+	}
+	for _, line := range expected {
+		if !strings.Contains(body, line) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+}
+
+func TestKeyValueStore_ExplainGet(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "kv_store_test_explain_get_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db := newTestStore(t, tmpDir, func(config *util.Config) {
+		config.Memtable.MaxSize = 10
+	})
+
+	for i := 0; i < 30; i++ {
+		err = db.Put(fmt.Sprintf("key%02d", i), []byte(fmt.Sprintf("value%02d", i)))
+		if err != nil {
+			t.Fatalf("Failed to put key-value pair: %v", err)
+		}
+	}
+
+	exp, err := db.ExplainGet("key00")
+	if err != nil {
+		t.Fatalf("Failed to explain get: %v", err)
+	}
+	if exp.Source != SourceSSTable {
+		t.Fatalf("Expected source %s, got %s", SourceSSTable, exp.Source)
+	}
+	if string(exp.Value) != "value00" {
+		t.Errorf("Expected value00, got %s", exp.Value)
+	}
+	if len(exp.SSTables) == 0 {
+		t.Fatalf("Expected at least one consulted SSTable")
+	}
+	answered := false
+	for _, table := range exp.SSTables {
+		if table.Answered {
+			answered = true
+			if !table.FilterMaybe || !table.Found || table.DataBytesRead == 0 || table.IndexBytesRead == 0 {
+				t.Errorf("Unexpected trace of the answering SSTable: %+v", table)
+			}
+			if table.Level != exp.Level || table.Label != exp.Label {
+				t.Errorf("Expected level %d and label %d, got %d and %d", exp.Level, exp.Label, table.Level, table.Label)
+			}
+		}
+	}
+	if !answered {
+		t.Errorf("Expected one of the SSTables to answer")
+	}
+
+	exp, err = db.ExplainGet("key00")
+	if err != nil {
+		t.Fatalf("Failed to explain get: %v", err)
+	}
+	if exp.Source != SourceCache {
+		t.Errorf("Expected source %s, got %s", SourceCache, exp.Source)
+	}
+
+	exp, err = db.ExplainGet("key29")
+	if err != nil {
+		t.Fatalf("Failed to explain get: %v", err)
+	}
+	if exp.Source != SourceMemtable || exp.MemtableIndex != 0 {
+		t.Errorf("Expected source %s with index 0, got %s with index %d", SourceMemtable, exp.Source, exp.MemtableIndex)
+	}
+
+	exp, err = db.ExplainGet("missing")
+	if err != nil {
+		t.Fatalf("Failed to explain get: %v", err)
+	}
+	if exp.Source != SourceNone || exp.Value != nil {
+		t.Errorf("Expected source %s, got %s", SourceNone, exp.Source)
+	}
+}
+
+type countingListener struct {
+	NoopEventListener
+	flushBegins, flushEnds, stalls int
+	compactions                    []CompactionInfo
+	labels                         []int
+}
+
+func (l *countingListener) OnFlushBegin(FlushInfo) { l.flushBegins++ }
+func (l *countingListener) OnFlushEnd(info FlushInfo) {
+	l.flushEnds++
+	l.labels = append(l.labels, info.SSTableLabel)
+}
+func (l *countingListener) OnWriteStall(WriteStallInfo) { l.stalls++ }
+func (l *countingListener) OnCompactionEnd(info CompactionInfo) {
+	l.compactions = append(l.compactions, info)
+}
+
+func TestKeyValueStore_EventListener(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "kv_store_test_event_listener_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db := newTestStore(t, tmpDir, func(config *util.Config) {
+		config.Memtable.MaxSize = 10
+		config.LSMTree.SizeTiered.MaxLsmNodesPerLevel = 2
+	})
+	listener := &countingListener{}
+	db.AddEventListener(listener)
+
+	for i := 0; i < 25; i++ {
+		err = db.Put(fmt.Sprintf("key%02d", i), []byte(fmt.Sprintf("value%02d", i)))
+		if err != nil {
+			t.Fatalf("Failed to put key-value pair: %v", err)
+		}
+	}
+
+	if listener.flushBegins != 2 || listener.flushEnds != 2 {
+		t.Errorf("Expected 2 flushes, got %d begins and %d ends", listener.flushBegins, listener.flushEnds)
+	}
+	if listener.stalls != 2 {
+		t.Errorf("Expected 2 write stalls, got %d", listener.stalls)
+	}
+	if len(listener.labels) == 2 && listener.labels[0] == listener.labels[1] {
+		t.Errorf("Expected different SSTable labels, got %v", listener.labels)
+	}
+	if len(listener.compactions) != 1 {
+		t.Fatalf("Expected 1 compaction, got %d", len(listener.compactions))
+	}
+	info := listener.compactions[0]
+	if len(info.Inputs) != 2 || len(info.Outputs) != 1 {
+		t.Errorf("Expected 2 inputs and 1 output, got %v and %v", info.Inputs, info.Outputs)
+	}
+	if info.BytesRead == 0 || info.BytesWritten == 0 {
+		t.Errorf("Expected non-zero compaction bytes, got %d read and %d written", info.BytesRead, info.BytesWritten)
+	}
+}
+
+func TestKeyValueStore_Logger(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "kv_store_test_logger_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var quiet, verbose strings.Builder
+	defer util.SetLogger(nil)
+
+	for _, tc := range []struct {
+		out     *strings.Builder
+		verbose bool
+	}{{&quiet, false}, {&verbose, true}} {
+		util.SetLogger(util.NewConsoleLogger(tc.out, tc.verbose))
+
+		db := newTestStore(t, tmpDir, func(config *util.Config) {
+			config.Memtable.MaxSize = 10
+		})
+		for i := 0; i < 11; i++ {
+			err := db.Put(fmt.Sprintf("key%02d", i), []byte("value"))
+			if err != nil {
+				t.Fatalf("Failed to put key-value pair: %v", err)
+			}
+		}
+	}
+
+	if quiet.Len() != 0 {
+		t.Errorf("Expected no output from the non-verbose logger, got %q", quiet.String())
+	}
+	if !strings.Contains(verbose.String(), "memtable flushed") || !strings.Contains(verbose.String(), util.LogKeyLabel+"=") {
+		t.Errorf("Expected a flush record with a table label, got %q", verbose.String())
+	}
+}
+
+func TestKeyValueStore_IteratorReadError(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "kv_store_test_iterator_read_error_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db := newTestStore(t, tmpDir, func(config *util.Config) {
+		config.Memtable.MaxSize = 10
+		config.SSTable.BlockCompression = ""
+	})
+
+	for i := 0; i < 12; i++ {
+		err = db.Put(fmt.Sprintf("key%02d", i), []byte(fmt.Sprintf("value%02d", i)))
+		if err != nil {
+			t.Fatalf("Failed to put key-value pair: %v", err)
+		}
+	}
+
+	// damage a record in the middle of the flushed SSTable
+	damaged := false
+	err = filepath.WalkDir(db.config.SSTable.SavePath, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if i := bytes.Index(data, []byte("value05")); i >= 0 {
+			data[i+5] = 'X'
+			damaged = true
+			return os.WriteFile(name, data, 0644)
+		}
+		return nil
+	})
+	if err != nil || !damaged {
+		t.Fatalf("Failed to damage the SSTable: %v", err)
+	}
+
+	iter, err := db.RangeIterate("key00", "key99")
+	if err != nil {
+		t.Fatalf("Failed to create iterator: %v", err)
+	}
+	defer iter.Stop()
+
+	var keys []string
+	for key, _ := iter.Next(); key != ""; key, _ = iter.Next() {
+		keys = append(keys, key)
+	}
+	if iter.Err() == nil {
+		t.Errorf("Expected the read error of the damaged record, got nil")
+	}
+	if fmt.Sprint(keys) != "[key00 key01 key02 key03 key04]" {
+		t.Errorf("Expected the iteration to end at the damaged record, got %v", keys)
+	}
+}
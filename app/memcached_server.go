@@ -0,0 +1,487 @@
+package app
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"nasp-project/util"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mcMaxKeyLength     = 250
+	mcMaxValueLength   = 1 << 20 // 1 MiB, the memcached default item size limit
+	mcMaxRelativeTTL   = 60 * 60 * 24 * 30
+	mcItemHeaderSize   = 4 + 8 + 4 // flags, expiration, version
+	mcVersionBits      = 24
+	mcServerVersion    = "1.6.0"
+	mcErrorLineTooLong = "CLIENT_ERROR line too long"
+	mcErrorNoAuth      = "CLIENT_ERROR unauthenticated"
+	mcErrorNoPerm      = "CLIENT_ERROR permission denied"
+)
+
+// mcItem is a memcached item stored in the engine under util.MemcachedPrefix.
+// Value layout: flags (4B) | expiration, unix seconds, 0 if it never expires (8B) | version (4B) | data.
+type mcItem struct {
+	flags      uint32
+	expiration int64
+	version    uint32
+	data       []byte
+	timestamp  uint64 // timestamp of the engine record
+}
+
+// cas returns the CAS token of the item. It is the record timestamp, which has a resolution of one second,
+// extended with the low bits of a per-item version counter so that writes within the same second differ.
+func (it *mcItem) cas() uint64 {
+	return it.timestamp<<mcVersionBits | uint64(it.version)&(1<<mcVersionBits-1)
+}
+
+func (it *mcItem) serialize() []byte {
+	buf := make([]byte, mcItemHeaderSize, mcItemHeaderSize+len(it.data))
+	binary.LittleEndian.PutUint32(buf[0:4], it.flags)
+	binary.LittleEndian.PutUint64(buf[4:12], uint64(it.expiration))
+	binary.LittleEndian.PutUint32(buf[12:16], it.version)
+	return append(buf, it.data...)
+}
+
+func deserializeMCItem(b []byte, timestamp uint64) (*mcItem, error) {
+	if len(b) < mcItemHeaderSize {
+		return nil, errors.New("corrupted memcached item")
+	}
+	return &mcItem{
+		flags:      binary.LittleEndian.Uint32(b[0:4]),
+		expiration: int64(binary.LittleEndian.Uint64(b[4:12])),
+		version:    binary.LittleEndian.Uint32(b[12:16]),
+		data:       b[mcItemHeaderSize:],
+		timestamp:  timestamp,
+	}, nil
+}
+
+// mcExpiration converts a memcached exptime to an absolute unix time.
+// Values up to 30 days are relative to now, larger values are absolute, negative values expire immediately.
+func mcExpiration(exptime int64, now time.Time) int64 {
+	switch {
+	case exptime == 0:
+		return 0
+	case exptime < 0:
+		return now.Unix() - 1
+	case exptime <= mcMaxRelativeTTL:
+		return now.Unix() + exptime
+	default:
+		return exptime
+	}
+}
+
+// ServeMemcached starts a TCP server that speaks the memcached text protocol on the given address.
+// Supported commands are get, gets, set, add, replace, cas, delete, incr, decr, touch, version and quit.
+// Items live in their own namespace and are not visible to Get, scans or the other servers.
+// The server runs in the background until it is closed.
+//
+// If authentication is enabled, a client authenticates like with memcached's ASCII authentication:
+// the first command must be a set, with any key, whose data is "username password".
+// Permissions apply to item keys the same way as to regular keys.
+// Returns an error if listening on the address fails.
+func (kvs *KeyValueStore) ServeMemcached(address string) (*TCPServer, error) {
+	kvs.startCheckpoints()
+	return serveTCP(address, kvs.handleMemcached)
+}
+
+// handleMemcached serves commands of a single connection until the client disconnects or sends quit.
+func (kvs *KeyValueStore) handleMemcached(rw *bufio.ReadWriter, remoteAddr string) error {
+	var user *User // nil until the client authenticates
+	for {
+		line, err := rw.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			_, _ = rw.WriteString(mcErrorLineTooLong + "\r\n")
+			_ = rw.Flush()
+			return errors.New("line too long")
+		}
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fields := strings.Fields(string(line))
+		if len(fields) == 0 {
+			_, _ = rw.WriteString("ERROR\r\n")
+		} else {
+			quit, err := kvs.execMemcached(rw, &user, remoteAddr, fields)
+			if err != nil {
+				return err
+			}
+			if quit {
+				return rw.Flush()
+			}
+		}
+
+		// flush once the pipelined commands are processed
+		if rw.Reader.Buffered() == 0 {
+			if err := rw.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// execMemcached executes a single command of the connection's user and writes its reply.
+// An unauthenticated set authenticates the user instead, if authentication is enabled.
+// Returns true if the connection should be closed, and an error if reading the data block fails.
+func (kvs *KeyValueStore) execMemcached(rw *bufio.ReadWriter, user **User, remoteAddr string, fields []string) (bool, error) {
+	cmd, args := fields[0], fields[1:]
+	noreply := len(args) > 0 && args[len(args)-1] == "noreply"
+	if noreply {
+		args = args[:len(args)-1]
+	}
+	authenticating := kvs.authEnabled() && *user == nil
+
+	var reply string
+	switch cmd {
+	case "quit":
+		return true, nil
+	case "version":
+		if authenticating {
+			reply = mcErrorNoAuth
+			break
+		}
+		reply = "VERSION " + mcServerVersion
+	case "get", "gets":
+		if len(args) == 0 {
+			reply = "ERROR"
+			break
+		}
+		if authenticating {
+			reply = mcErrorNoAuth
+			break
+		}
+		if !kvs.mcAuthorized(*user, args, AccessRead) {
+			reply = mcErrorNoPerm
+			break
+		}
+		kvs.lockAs(clientID(*user, remoteAddr))
+		err := kvs.mcRetrieve(rw.Writer, args, cmd == "gets")
+		kvs.unlock()
+		if err != nil {
+			reply = mcServerError(err)
+		}
+	case "set", "add", "replace", "cas":
+		if cmd == "cas" && len(args) != 5 || cmd != "cas" && len(args) != 4 {
+			reply = "ERROR"
+			break
+		}
+		flags, err1 := strconv.ParseUint(args[1], 10, 32)
+		exptime, err2 := strconv.ParseInt(args[2], 10, 64)
+		size, err3 := strconv.Atoi(args[3])
+		var casToken uint64
+		var err4 error
+		if cmd == "cas" {
+			casToken, err4 = strconv.ParseUint(args[4], 10, 64)
+		}
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil || size < 0 {
+			reply = "CLIENT_ERROR bad command line format"
+			break
+		}
+		if size > mcMaxValueLength {
+			reply = "SERVER_ERROR object too large for cache"
+			// skip the data block
+			if _, err := rw.Discard(size + 2); err != nil {
+				return false, err
+			}
+			break
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(rw, data); err != nil {
+			return false, err
+		}
+		if data[size] != '\r' || data[size+1] != '\n' {
+			reply = "CLIENT_ERROR bad data chunk"
+			break
+		}
+		if authenticating && cmd == "set" {
+			reply = kvs.mcAuth(user, data[:size])
+			break
+		}
+		if authenticating {
+			reply = mcErrorNoAuth
+			break
+		}
+		if !kvs.mcAuthorized(*user, args[:1], AccessWrite) {
+			reply = mcErrorNoPerm
+			break
+		}
+		if !mcValidKey(args[0]) {
+			reply = "CLIENT_ERROR bad command line format"
+			break
+		}
+
+		item := &mcItem{
+			flags:      uint32(flags),
+			expiration: mcExpiration(exptime, time.Now()),
+			data:       data[:size],
+		}
+		kvs.lockAs(clientID(*user, remoteAddr))
+		reply, err1 = kvs.mcStore(cmd, args[0], item, casToken)
+		kvs.unlock()
+		if err1 != nil {
+			reply = mcServerError(err1)
+		}
+	case "delete", "incr", "decr", "touch":
+		if cmd == "delete" && len(args) != 1 || cmd != "delete" && len(args) != 2 {
+			reply = "ERROR"
+			break
+		}
+		if !mcValidKey(args[0]) {
+			reply = "CLIENT_ERROR bad command line format"
+			break
+		}
+		if authenticating {
+			reply = mcErrorNoAuth
+			break
+		}
+		if !kvs.mcAuthorized(*user, args[:1], AccessWrite) {
+			reply = mcErrorNoPerm
+			break
+		}
+		var err error
+		kvs.lockAs(clientID(*user, remoteAddr))
+		switch cmd {
+		case "delete":
+			reply, err = kvs.mcDelete(args[0])
+		case "incr", "decr":
+			reply, err = kvs.mcIncr(args[0], args[1], cmd == "incr")
+		case "touch":
+			reply, err = kvs.mcTouch(args[0], args[1])
+		}
+		kvs.unlock()
+		if err != nil {
+			reply = mcServerError(err)
+		}
+	default:
+		reply = "ERROR"
+	}
+
+	if reply != "" && !noreply {
+		_, _ = rw.WriteString(reply + "\r\n")
+	}
+	return false, nil
+}
+
+// mcAuth authenticates the user with the data of a set command, "username password", and returns the reply line.
+func (kvs *KeyValueStore) mcAuth(user **User, data []byte) string {
+	name, password, ok := strings.Cut(string(data), " ")
+	if !ok {
+		return "CLIENT_ERROR authentication failure"
+	}
+	authenticated, err := kvs.authenticateClient(name, password)
+	if errors.Is(err, ErrAuthenticationFailed) {
+		return "CLIENT_ERROR authentication failure"
+	}
+	if err != nil {
+		return mcServerError(err)
+	}
+	*user = authenticated
+	return "STORED"
+}
+
+// mcAuthorized returns true if the user has at least the given access to all keys.
+func (kvs *KeyValueStore) mcAuthorized(user *User, keys []string, access Access) bool {
+	for _, key := range keys {
+		if !kvs.authorized(user, key, access) {
+			return false
+		}
+	}
+	return true
+}
+
+// mcValidKey returns true if the key is short enough and contains no control characters.
+func mcValidKey(key string) bool {
+	if len(key) == 0 || len(key) > mcMaxKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// mcServerError formats an engine error as a memcached SERVER_ERROR reply.
+func mcServerError(err error) string {
+	return "SERVER_ERROR " + strings.NewReplacer("\r", " ", "\n", " ").Replace(err.Error())
+}
+
+// mcLoad returns the item with the given key, or nil if it does not exist or has expired.
+func (kvs *KeyValueStore) mcLoad(key string) (*mcItem, error) {
+	rec, err := kvs.read(util.MemcachedPrefix+key, nil)
+	if err != nil || rec == nil {
+		return nil, err
+	}
+	item, err := deserializeMCItem(rec.Value, rec.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	if item.expiration != 0 && item.expiration <= time.Now().Unix() {
+		return nil, nil
+	}
+	return item, nil
+}
+
+// mcSave writes the item as the next version of the previous one, which may be nil.
+func (kvs *KeyValueStore) mcSave(key string, item *mcItem, previous *mcItem) error {
+	if previous != nil {
+		item.version = previous.version + 1
+	}
+	return kvs.put(util.MemcachedPrefix+key, item.serialize())
+}
+
+// mcRateLimit returns ErrRateLimitReached if the token bucket of the given class has less than cost tokens.
+// Every command costs one token, a retrieval one per key.
+func (kvs *KeyValueStore) mcRateLimit(class rateLimitClass, cost int64) error {
+	if block, err := kvs.rateLimitReached(class, cost); block {
+		if err != nil {
+			return err
+		}
+		return ErrRateLimitReached
+	}
+	return nil
+}
+
+// mcRetrieve writes the VALUE lines of the found keys followed by END.
+func (kvs *KeyValueStore) mcRetrieve(w *bufio.Writer, keys []string, withCAS bool) error {
+	if err := kvs.mcRateLimit(rateLimitRead, int64(len(keys))); err != nil {
+		return err
+	}
+
+	var out strings.Builder
+	for _, key := range keys {
+		if !mcValidKey(key) {
+			continue
+		}
+		item, err := kvs.mcLoad(key)
+		if err != nil {
+			return err
+		}
+		if item == nil {
+			continue
+		}
+		out.WriteString("VALUE " + key + " " + strconv.FormatUint(uint64(item.flags), 10) + " " + strconv.Itoa(len(item.data)))
+		if withCAS {
+			out.WriteString(" " + strconv.FormatUint(item.cas(), 10))
+		}
+		out.WriteString("\r\n")
+		out.Write(item.data)
+		out.WriteString("\r\n")
+	}
+	out.WriteString("END\r\n")
+	_, _ = w.WriteString(out.String())
+	return nil
+}
+
+// mcStore serves set, add, replace and cas and returns the reply line.
+func (kvs *KeyValueStore) mcStore(cmd, key string, item *mcItem, casToken uint64) (string, error) {
+	if err := kvs.mcRateLimit(rateLimitWrite, 1); err != nil {
+		return "", err
+	}
+	existing, err := kvs.mcLoad(key)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case cmd == "add" && existing != nil, cmd == "replace" && existing == nil:
+		return "NOT_STORED", nil
+	case cmd == "cas" && existing == nil:
+		return "NOT_FOUND", nil
+	case cmd == "cas" && existing.cas() != casToken:
+		return "EXISTS", nil
+	}
+
+	if err := kvs.mcSave(key, item, existing); err != nil {
+		return "", err
+	}
+	return "STORED", nil
+}
+
+// mcDelete serves delete and returns the reply line.
+func (kvs *KeyValueStore) mcDelete(key string) (string, error) {
+	if err := kvs.mcRateLimit(rateLimitWrite, 1); err != nil {
+		return "", err
+	}
+	existing, err := kvs.mcLoad(key)
+	if err != nil {
+		return "", err
+	}
+	if existing == nil {
+		return "NOT_FOUND", nil
+	}
+	if err := kvs.delete(util.MemcachedPrefix + key); err != nil {
+		return "", err
+	}
+	return "DELETED", nil
+}
+
+// mcIncr serves incr and decr and returns the reply line. Increments wrap around at 2^64, decrements stop at 0.
+func (kvs *KeyValueStore) mcIncr(key, deltaArg string, incr bool) (string, error) {
+	delta, err := strconv.ParseUint(deltaArg, 10, 64)
+	if err != nil {
+		return "CLIENT_ERROR invalid numeric delta argument", nil
+	}
+	if err := kvs.mcRateLimit(rateLimitWrite, 1); err != nil {
+		return "", err
+	}
+	existing, err := kvs.mcLoad(key)
+	if err != nil {
+		return "", err
+	}
+	if existing == nil {
+		return "NOT_FOUND", nil
+	}
+
+	value, err := strconv.ParseUint(strings.TrimRight(string(existing.data), " "), 10, 64)
+	if err != nil {
+		return "CLIENT_ERROR cannot increment or decrement non-numeric value", nil
+	}
+	if incr {
+		value += delta
+	} else if delta > value {
+		value = 0
+	} else {
+		value -= delta
+	}
+
+	result := strconv.FormatUint(value, 10)
+	item := &mcItem{flags: existing.flags, expiration: existing.expiration, data: []byte(result)}
+	if err := kvs.mcSave(key, item, existing); err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+// mcTouch serves touch and returns the reply line.
+func (kvs *KeyValueStore) mcTouch(key, exptimeArg string) (string, error) {
+	exptime, err := strconv.ParseInt(exptimeArg, 10, 64)
+	if err != nil {
+		return "CLIENT_ERROR invalid exptime argument", nil
+	}
+	if err := kvs.mcRateLimit(rateLimitWrite, 1); err != nil {
+		return "", err
+	}
+	existing, err := kvs.mcLoad(key)
+	if err != nil {
+		return "", err
+	}
+	if existing == nil {
+		return "NOT_FOUND", nil
+	}
+
+	item := &mcItem{flags: existing.flags, expiration: mcExpiration(exptime, time.Now()), data: existing.data}
+	if err := kvs.mcSave(key, item, existing); err != nil {
+		return "", err
+	}
+	return "TOUCHED", nil
+}
@@ -0,0 +1,143 @@
+package app
+
+import (
+	"bufio"
+	"nasp-project/util"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestMemcachedConn(t *testing.T, tmpDir string) (*TCPServer, net.Conn, *bufio.Reader) {
+	db := newTestStore(t, tmpDir)
+	server, err := db.ServeMemcached("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the memcached server: %v", err)
+	}
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	return server, conn, bufio.NewReader(conn)
+}
+
+// mcRoundTrip sends a request and reads reply lines until one of them is a terminal reply.
+func mcRoundTrip(t *testing.T, conn net.Conn, r *bufio.Reader, request string) []string {
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read reply to %q: %v", request, err)
+		}
+		line = strings.TrimSuffix(line, "\r\n")
+		lines = append(lines, line)
+		if !strings.HasPrefix(line, "VALUE ") && (len(lines) == 1 || !strings.HasPrefix(lines[len(lines)-2], "VALUE ")) {
+			return lines
+		}
+	}
+}
+
+func TestMemcachedServer_Commands(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "memcached_server_test_commands_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	server, conn, r := newTestMemcachedConn(t, tmpDir)
+	defer server.Close()
+	defer conn.Close()
+
+	for _, tc := range []struct {
+		request  string
+		expected string
+	}{
+		{"get missing\r\n", "END"},
+		{"set k1 5 0 3\r\nabc\r\n", "STORED"},
+		{"get k1\r\n", "VALUE k1 5 3|abc|END"},
+		{"add k1 0 0 1\r\nx\r\n", "NOT_STORED"},
+		{"replace missing 0 0 1\r\nx\r\n", "NOT_STORED"},
+		{"replace k1 7 0 2\r\nxy\r\n", "STORED"},
+		{"get k1 missing\r\n", "VALUE k1 7 2|xy|END"},
+		{"set n 0 0 2\r\n10\r\n", "STORED"},
+		{"incr n 5\r\n", "15"},
+		{"decr n 20\r\n", "0"},
+		{"incr k1 1\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value"},
+		{"incr missing 1\r\n", "NOT_FOUND"},
+		{"delete k1\r\n", "DELETED"},
+		{"delete k1\r\n", "NOT_FOUND"},
+		{"set quiet 0 0 1 noreply\r\nq\r\nget quiet\r\n", "VALUE quiet 0 1|q|END"},
+		{"set " + util.RateLimiterKey + " 0 0 1\r\nx\r\n", "STORED"},
+		{"bogus\r\n", "ERROR"},
+	} {
+		if got := strings.Join(mcRoundTrip(t, conn, r, tc.request), "|"); got != tc.expected {
+			t.Errorf("%q: expected %q, got %q", tc.request, tc.expected, got)
+		}
+	}
+}
+
+func TestMemcachedServer_CAS(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "memcached_server_test_cas_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	server, conn, r := newTestMemcachedConn(t, tmpDir)
+	defer server.Close()
+	defer conn.Close()
+
+	if got := mcRoundTrip(t, conn, r, "cas k 0 0 1 1\r\na\r\n"); got[0] != "NOT_FOUND" {
+		t.Errorf("Expected NOT_FOUND, got %v", got)
+	}
+	mcRoundTrip(t, conn, r, "set k 0 0 1\r\na\r\n")
+
+	cas := strings.Fields(mcRoundTrip(t, conn, r, "gets k\r\n")[0])[4]
+	if got := mcRoundTrip(t, conn, r, "cas k 0 0 1 "+cas+"\r\nb\r\n"); got[0] != "STORED" {
+		t.Fatalf("Expected STORED, got %v", got)
+	}
+	// the token changed with the write, even within the same second
+	if got := mcRoundTrip(t, conn, r, "cas k 0 0 1 "+cas+"\r\nc\r\n"); got[0] != "EXISTS" {
+		t.Errorf("Expected EXISTS, got %v", got)
+	}
+	if got := strings.Join(mcRoundTrip(t, conn, r, "get k\r\n"), "|"); got != "VALUE k 0 1|b|END" {
+		t.Errorf("Expected value b, got %q", got)
+	}
+}
+
+func TestMemcachedServer_Expiration(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "memcached_server_test_expiration_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	server, conn, r := newTestMemcachedConn(t, tmpDir)
+	defer server.Close()
+	defer conn.Close()
+
+	mcRoundTrip(t, conn, r, "set gone 0 -1 1\r\na\r\n")
+	if got := mcRoundTrip(t, conn, r, "get gone\r\n"); got[0] != "END" {
+		t.Errorf("Expected an expired item, got %v", got)
+	}
+
+	mcRoundTrip(t, conn, r, "set k 0 1000 1\r\na\r\n")
+	if got := mcRoundTrip(t, conn, r, "touch k -1\r\n"); got[0] != "TOUCHED" {
+		t.Fatalf("Expected TOUCHED, got %v", got)
+	}
+	if got := mcRoundTrip(t, conn, r, "get k\r\n"); got[0] != "END" {
+		t.Errorf("Expected the touched item to expire, got %v", got)
+	}
+
+	if got := mcExpiration(10, time.Unix(1000, 0)); got != 1010 {
+		t.Errorf("Expected relative expiration 1010, got %d", got)
+	}
+	if got := mcExpiration(mcMaxRelativeTTL+1, time.Unix(1000, 0)); got != mcMaxRelativeTTL+1 {
+		t.Errorf("Expected absolute expiration %d, got %d", mcMaxRelativeTTL+1, got)
+	}
+}
@@ -0,0 +1,185 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Names of the operations whose latency is measured.
+const (
+	opGet        = "get"
+	opPut        = "put"
+	opDelete     = "delete"
+	opRangeScan  = "range_scan"
+	opPrefixScan = "prefix_scan"
+)
+
+// latencyBuckets are the upper bounds (in seconds) of the histogram buckets.
+var latencyBuckets = []float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// histogram counts observations in buckets with fixed upper bounds, in the way Prometheus histograms do.
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64 // counts[i] is the number of observations in (bounds[i-1], bounds[i]]
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+// Observe adds a single observation to the histogram.
+func (h *histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	idx := sort.SearchFloat64s(h.bounds, value)
+	if idx < len(h.counts) {
+		h.counts[idx]++
+	}
+	h.sum += value
+	h.count++
+}
+
+// ObserveSince adds the number of seconds elapsed since start to the histogram.
+func (h *histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// write writes the histogram samples in Prometheus text exposition format.
+// labels are added to every sample and must be either empty or of the form `name="value",`.
+func (h *histogram) write(w io.Writer, name, labels string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		_, err := fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, labels, formatFloat(bound), cumulative)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+	if err != nil {
+		return err
+	}
+
+	if labels != "" {
+		labels = "{" + labels[:len(labels)-1] + "}"
+	}
+	_, err = fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", name, labels, formatFloat(h.sum), name, labels, h.count)
+	return err
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// metrics holds the counters and histograms of a KeyValueStore. All methods are safe for concurrent use.
+type metrics struct {
+	operations          map[string]*histogram // by operation name, the set of keys never changes
+	flush               *histogram
+	compaction          *histogram
+	writeStalls         atomic.Uint64
+	rateLimitRejections atomic.Uint64
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		operations: map[string]*histogram{},
+		flush:      newHistogram(latencyBuckets),
+		compaction: newHistogram(latencyBuckets),
+	}
+	for _, op := range []string{opGet, opPut, opDelete, opRangeScan, opPrefixScan} {
+		m.operations[op] = newHistogram(latencyBuckets)
+	}
+	return m
+}
+
+// observeOperation records the latency of an operation that started at start.
+func (m *metrics) observeOperation(op string, start time.Time) {
+	m.operations[op].ObserveSince(start)
+}
+
+// write writes all metrics in Prometheus text exposition format.
+func (m *metrics) write(w io.Writer) error {
+	_, err := io.WriteString(w, "# HELP kvs_operation_duration_seconds Latency of user operations.\n"+
+		"# TYPE kvs_operation_duration_seconds histogram\n")
+	if err != nil {
+		return err
+	}
+	ops := make([]string, 0, len(m.operations))
+	for op := range m.operations {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		err = m.operations[op].write(w, "kvs_operation_duration_seconds", "operation=\""+op+"\",")
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(w, "# HELP kvs_flush_duration_seconds Duration of memtable flushes.\n"+
+		"# TYPE kvs_flush_duration_seconds histogram\n")
+	if err != nil {
+		return err
+	}
+	err = m.flush.write(w, "kvs_flush_duration_seconds", "")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "# HELP kvs_compaction_duration_seconds Duration of LSM Tree compactions.\n"+
+		"# TYPE kvs_compaction_duration_seconds histogram\n")
+	if err != nil {
+		return err
+	}
+	err = m.compaction.write(w, "kvs_compaction_duration_seconds", "")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "# HELP kvs_write_stalls_total Writes that waited for a memtable flush.\n"+
+		"# TYPE kvs_write_stalls_total counter\n"+
+		"kvs_write_stalls_total %d\n", m.writeStalls.Load())
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "# HELP kvs_rate_limit_rejections_total Operations rejected by the rate limiter.\n"+
+		"# TYPE kvs_rate_limit_rejections_total counter\n"+
+		"kvs_rate_limit_rejections_total %d\n", m.rateLimitRejections.Load())
+	return err
+}
+
+// MetricsHandler returns an http.Handler that serves the engine metrics in Prometheus text exposition format.
+func (kvs *KeyValueStore) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = kvs.metrics.write(w)
+	})
+}
+
+// ServeMetrics starts an HTTP server that serves the engine metrics at /metrics on the given address.
+// The server runs in the background until it is closed.
+// Returns an error if listening on the address fails.
+func (kvs *KeyValueStore) ServeMetrics(address string) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", kvs.MetricsHandler())
+	return serveHTTP(address, mux)
+}
@@ -1,34 +1,188 @@
 package app
 
 import (
-	"errors"
 	"nasp-project/structures/token_bucket"
 	"nasp-project/util"
+	"net"
+	"time"
 )
 
-func (kvs *KeyValueStore) rateLimitReached() (bool, error) {
-	tbBytes, err := kvs.get(util.RateLimiterKey)
+// rateLimitClass selects the budget an operation is charged to.
+type rateLimitClass string
+
+const (
+	rateLimitRead  rateLimitClass = "read"
+	rateLimitWrite rateLimitClass = "write"
+)
+
+// clientLimiter is the in-memory token bucket of a client for a class of operations.
+type clientLimiter struct {
+	limiter *token_bucket.Limiter
+	dirty   bool // changed since the last checkpoint
+	stored  bool // a checkpoint exists in the store
+}
+
+// rateLimitReached takes cost tokens from the bucket of the current client for the given class of operations.
+// Every client of the network servers has its own buckets (see lockAs), local calls share the buckets of the empty client.
+// Buckets live in memory and are checkpointed to the store in the background, see startCheckpoints.
+// Writes to a follower or a cluster member are rejected with ErrReadOnly before they are charged.
+func (kvs *KeyValueStore) rateLimitReached(class rateLimitClass, cost int64) (bool, error) {
+	if class == rateLimitWrite && kvs.readOnly() {
+		return true, ErrReadOnly
+	}
+	now := time.Now()
+	key := util.RateLimiterKey + string(class) + "/" + kvs.client
+
+	cl, ok := kvs.limiters[key]
+	if !ok {
+		var err error
+		cl, err = kvs.loadLimiter(key, class)
+		if err != nil {
+			return true, err
+		}
+		kvs.limiters[key] = cl
+	}
+
+	allowed := cl.limiter.Allow(cost, now)
+	cl.dirty = cl.dirty || allowed
+	if !allowed {
+		kvs.metrics.rateLimitRejections.Add(1)
+	}
+	return !allowed, nil
+}
+
+// readOnly returns true if writes must not bypass replication: the store follows a leader
+// or is a member of a Raft cluster, whose writes go through Cluster.
+func (kvs *KeyValueStore) readOnly() bool {
+	return kvs.replica != nil || kvs.cluster != nil
+}
+
+// loadLimiter restores the bucket with the given key from its checkpoint, or creates a full one.
+// A checkpoint made with a different bucket size or interval is discarded.
+func (kvs *KeyValueStore) loadLimiter(key string, class rateLimitClass) (*clientLimiter, error) {
+	size := kvs.bucketSize(class)
+	interval := time.Duration(kvs.config.TokenBucket.Interval) * time.Second
+
+	data, err := kvs.get(key)
 	if err != nil {
-		return true, err
+		return nil, err
 	}
+	if data != nil {
+		limiter, err := token_bucket.DeserializeLimiter(data)
+		if err == nil && limiter.Limit() == size && limiter.Interval() == interval {
+			return &clientLimiter{limiter: limiter, stored: true}, nil
+		}
+	}
+	return &clientLimiter{limiter: token_bucket.NewLimiter(size, interval), stored: data != nil}, nil
+}
 
-	var tb *token_bucket.TokenBucket = nil
-	if tbBytes == nil {
-		tb = token_bucket.NewTokenBucket(kvs.config.TokenBucket.MaxTokenSize, kvs.config.TokenBucket.Interval)
-	} else {
-		tb = token_bucket.Deserialize(tbBytes)
+// startCheckpoints runs checkpointRateLimits every TokenBucket.CheckpointInterval in the background under kvs.mu,
+// until Close. It is started by the console, the network servers, the replication and the cluster, whose calls hold kvs.mu.
+// The calls of a store used as a library don't hold kvs.mu, so it is not started for them: their buckets are only
+// checkpointed by Close, and a crash refills them.
+func (kvs *KeyValueStore) startCheckpoints() {
+	interval := time.Duration(kvs.config.TokenBucket.CheckpointInterval) * time.Second
+	if interval <= 0 {
+		return
 	}
+	kvs.checkpoints.Do(func() {
+		go kvs.checkpointLoop(interval)
+	})
+}
+
+func (kvs *KeyValueStore) checkpointLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-kvs.stopCheckpoints:
+			return
+		case <-ticker.C:
+		}
 
-	if tb == nil {
-		return true, errors.New("token bucket read failed")
+		kvs.mu.Lock()
+		select {
+		case <-kvs.stopCheckpoints: // Close checkpointed the buckets
+			kvs.mu.Unlock()
+			return
+		default:
+		}
+		err := kvs.checkpointRateLimits(time.Now())
+		kvs.mu.Unlock()
+		if err != nil {
+			util.Logger().Warn("failed to checkpoint the rate limits", util.LogKeyError, err)
+		}
 	}
+}
 
-	allowed := tb.CheckTokenCondition()
+// checkpointRateLimits writes the buckets changed since the last checkpoint to the store,
+// so that restarting the store does not refill them. Full buckets are equal to new ones,
+// so they are dropped from memory and the store instead.
+func (kvs *KeyValueStore) checkpointRateLimits(now time.Time) error {
+	for key, cl := range kvs.limiters {
+		if cl.limiter.Full(now) {
+			if cl.stored {
+				if err := kvs.delete(key); err != nil {
+					return err
+				}
+			}
+			delete(kvs.limiters, key)
+			continue
+		}
+		if cl.dirty {
+			if err := kvs.put(key, cl.limiter.Serialize()); err != nil {
+				return err
+			}
+			cl.dirty, cl.stored = false, true
+		}
+	}
+	return nil
+}
+
+// bucketSize returns the number of tokens per interval of the current client for the given class of operations.
+func (kvs *KeyValueStore) bucketSize(class rateLimitClass) int64 {
+	readSize, writeSize := kvs.config.TokenBucket.MaxTokenSize, kvs.config.TokenBucket.WriteMaxTokenSize
+	for _, c := range kvs.config.TokenBucket.Clients {
+		if c.Client == kvs.client && kvs.client != "" {
+			readSize, writeSize = c.MaxTokenSize, c.WriteMaxTokenSize
+		}
+	}
+	if class == rateLimitWrite && writeSize > 0 {
+		return writeSize
+	}
+	return readSize
+}
 
-	err = kvs.put(util.RateLimiterKey, tb.Serialize())
+// scanCost returns the number of tokens a scan that reads n records costs.
+func (kvs *KeyValueStore) scanCost(n int) int64 {
+	perToken := kvs.config.TokenBucket.ScanRecordsPerToken
+	if perToken <= 0 || n <= 0 {
+		return 1
+	}
+	return 1 + int64(n)/perToken
+}
+
+// clientID returns the rate limiting identity of a network client: the user name if the client is authenticated,
+// its IP address otherwise, so that reconnecting does not reset the budget.
+func clientID(user *User, remoteAddr string) string {
+	if user != nil {
+		return user.Name
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
 	if err != nil {
-		return true, err
+		return remoteAddr
 	}
+	return host
+}
 
-	return !allowed, nil
+// lockAs locks kvs.mu for a call made on behalf of the given network client, see clientID.
+func (kvs *KeyValueStore) lockAs(client string) {
+	kvs.mu.Lock()
+	kvs.client = client
+}
+
+// unlock releases the lock taken by lockAs.
+func (kvs *KeyValueStore) unlock() {
+	kvs.client = ""
+	kvs.mu.Unlock()
 }
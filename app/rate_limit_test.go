@@ -0,0 +1,176 @@
+package app
+
+import (
+	"errors"
+	"nasp-project/util"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestRateLimitStore creates a store with the given token bucket configuration that never refills during the test.
+func newTestRateLimitStore(t *testing.T, tmpDir string, tb util.TokenBucketConfig) *KeyValueStore {
+	tb.Interval = 1_000_000 // definitely long enough not to reset during the test
+	return newTestStore(t, tmpDir, func(config *util.Config) {
+		config.TokenBucket = tb
+	})
+}
+
+func TestKeyValueStore_RateLimitClasses(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "rate_limit_test_classes_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db := newTestRateLimitStore(t, tmpDir, util.TokenBucketConfig{MaxTokenSize: 10, WriteMaxTokenSize: 2})
+
+	for i := 0; i < 2; i++ {
+		if err := db.Put("key", []byte("value")); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	if err := db.Put("key", []byte("value")); !errors.Is(err, ErrRateLimitReached) {
+		t.Errorf("Expected ErrRateLimitReached after the write budget, got %v", err)
+	}
+	// reads have their own budget
+	if _, err := db.Get("key"); err != nil {
+		t.Errorf("Expected a read to pass, got %v", err)
+	}
+}
+
+func TestKeyValueStore_RateLimitScanCost(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "rate_limit_test_scan_cost_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db := newTestRateLimitStore(t, tmpDir, util.TokenBucketConfig{MaxTokenSize: 10, ScanRecordsPerToken: 100})
+
+	if got := db.scanCost(1000); got != 11 {
+		t.Errorf("Expected a scan of 1000 records to cost 11 tokens, got %d", got)
+	}
+	// takes 1 + 500/100 = 6 tokens of 10
+	if _, err := db.RangeScan("a", "z", 5, 100); err != nil {
+		t.Fatalf("Failed to scan: %v", err)
+	}
+	if _, err := db.RangeScan("a", "z", 5, 100); !errors.Is(err, ErrRateLimitReached) {
+		t.Errorf("Expected ErrRateLimitReached, got %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if _, err := db.Get("key"); err != nil {
+			t.Errorf("Expected a get to pass, got %v", err)
+		}
+	}
+}
+
+func TestKeyValueStore_RateLimitClients(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "rate_limit_test_clients_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db := newTestRateLimitStore(t, tmpDir, util.TokenBucketConfig{
+		MaxTokenSize: 1,
+		Clients:      []util.ClientLimitConfig{{Client: "vip", MaxTokenSize: 3}},
+	})
+
+	get := func(client string) error {
+		db.lockAs(client)
+		defer db.unlock()
+		_, err := db.Get("key")
+		return err
+	}
+
+	if err := get("10.0.0.1"); err != nil {
+		t.Fatalf("Expected the first get to pass, got %v", err)
+	}
+	if err := get("10.0.0.1"); !errors.Is(err, ErrRateLimitReached) {
+		t.Errorf("Expected ErrRateLimitReached, got %v", err)
+	}
+	// a noisy client does not exhaust the budget of the others
+	if err := get("10.0.0.2"); err != nil {
+		t.Errorf("Expected another client to pass, got %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := get("vip"); err != nil {
+			t.Errorf("Expected the overridden budget to pass, got %v", err)
+		}
+	}
+	if err := get("vip"); !errors.Is(err, ErrRateLimitReached) {
+		t.Errorf("Expected ErrRateLimitReached, got %v", err)
+	}
+
+	if got := clientID(nil, "10.0.0.1:1234"); got != "10.0.0.1" {
+		t.Errorf("Expected the IP address as client identity, got %q", got)
+	}
+	if got := clientID(&User{Name: "alice"}, "10.0.0.1:1234"); got != "alice" {
+		t.Errorf("Expected the user name as client identity, got %q", got)
+	}
+}
+
+func TestKeyValueStore_RateLimitCheckpoint(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "rate_limit_test_checkpoint_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tb := util.TokenBucketConfig{MaxTokenSize: 3, CheckpointInterval: 1}
+	db := newTestRateLimitStore(t, tmpDir, tb)
+	key := util.RateLimiterKey + string(rateLimitRead) + "/"
+
+	for i := 0; i < 3; i++ {
+		if _, err := db.Get("key"); err != nil {
+			t.Fatalf("Failed to get: %v", err)
+		}
+	}
+	// a store used only through its methods doesn't checkpoint in the background, the bucket is only in memory until Close
+	time.Sleep(1500 * time.Millisecond)
+	if value, err := db.get(key); err != nil || value != nil {
+		t.Errorf("Expected no stored bucket before a checkpoint, got %v, %v", value, err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	if value, err := db.get(key); err != nil || value == nil {
+		t.Errorf("Expected a stored bucket after Close, got %v, %v", value, err)
+	}
+
+	// the empty bucket survives a restart
+	db = newTestRateLimitStore(t, tmpDir, tb)
+	if _, err := db.Get("key"); !errors.Is(err, ErrRateLimitReached) {
+		t.Errorf("Expected ErrRateLimitReached after a restart, got %v", err)
+	}
+}
+
+func TestKeyValueStore_RateLimitBackgroundCheckpoint(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "rate_limit_test_background_checkpoint_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db := newTestRateLimitStore(t, tmpDir, util.TokenBucketConfig{MaxTokenSize: 3, CheckpointInterval: 1})
+	key := util.RateLimiterKey + string(rateLimitRead) + "/"
+	db.startCheckpoints()
+
+	db.mu.Lock()
+	if _, err := db.Get("key"); err != nil {
+		t.Fatalf("Failed to get: %v", err)
+	}
+	value, err := db.get(key)
+	db.mu.Unlock()
+	if err != nil || value != nil {
+		t.Errorf("Expected no stored bucket before a checkpoint, got %v, %v", value, err)
+	}
+	waitFor(t, db, "a checkpoint", func() bool {
+		value, err := db.get(key)
+		return err == nil && value != nil
+	})
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+}
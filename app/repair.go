@@ -0,0 +1,31 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"nasp-project/structures/lsm"
+)
+
+// Repair checks the SSTables of the store, rebuilds the damaged ones from the records that can be read,
+// and writes a report of every table to w. See sstable.Repair.
+func (kvs *KeyValueStore) Repair(w io.Writer) error {
+	compressionDict, err := kvs.getCompressionDict()
+	if err != nil {
+		return err
+	}
+
+	reports, err := lsm.Repair(compressionDict, kvs.config)
+	damaged := 0
+	for _, report := range reports {
+		if !report.Healthy() {
+			damaged++
+		}
+		if _, werr := fmt.Fprint(w, report); werr != nil {
+			return werr
+		}
+	}
+	if _, werr := fmt.Fprintf(w, "%d SSTables checked, %d damaged\n", len(reports), damaged); werr != nil {
+		return werr
+	}
+	return err
+}
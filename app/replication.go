@@ -0,0 +1,527 @@
+package app
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	writeaheadlog "nasp-project/structures/write-ahead_log"
+	"nasp-project/util"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+  Replication protocol. A follower connects to the leader and sends
+
+    REPLICATE <position>\r\n
+
+  with the position in the leader's WAL (see writeaheadlog.WAL.Position) up to which it applied the records.
+  The leader then streams batches:
+
+  +----------+---------------------+-----------------------+-------------+-...-----+
+  | 'B' (1B) | Next Position (8B) | Leader Position (8B) | Length (4B) | Records |
+  +----------+---------------------+-----------------------+-------------+-...-----+
+  Records = WAL records in their CRC-framed binary format, ending at Next Position
+  Leader Position = end of the leader's WAL, a batch without records is a heartbeat
+
+  If the WAL no longer has the records from the position, e.g. for a new follower, the leader sends a snapshot:
+
+  +----------+---------------------+-----------------------+-------------+-...-----+
+  | 'S' (1B) | Next Position (8B) | Leader Position (8B) | Length (4B) | Records |
+  +----------+---------------------+-----------------------+-------------+-...-----+
+  Records = JSON array of the live records of the regular keys in key order, following the ones of the previous 'S'
+  Next Position = position the WAL is streamed from after the snapshot, which ends with an 'S' without records
+
+  If the leader can't serve the position it sends 'E' | Length (4B) | Message and closes the connection.
+*/
+
+const (
+	replicationBatchSize    = 256 * 1024
+	replicationPollInterval = 100 * time.Millisecond
+	replicationTimeout      = 5 * time.Second // a follower reconnects if the leader is silent for this long
+	replicationMinBackoff   = 100 * time.Millisecond
+	replicationMaxBackoff   = 5 * time.Second
+
+	replicationBatch    = 'B'
+	replicationSnapshot = 'S'
+	replicationError    = 'E'
+)
+
+// ServeReplication starts a TCPServer on the given address that streams the WAL to followers, see StartReplica,
+// and serves the anti-entropy sessions of peers, see Sync. The connections are not authenticated,
+// the address must only be reachable by the followers and peers.
+// Returns an error if listening on the address fails.
+func (kvs *KeyValueStore) ServeReplication(address string) (*TCPServer, error) {
+	kvs.startCheckpoints()
+	return serveTCP(address, kvs.handleReplication)
+}
+
+// handleReplication streams the WAL to a single follower until it disconnects, or serves an anti-entropy session.
+// The buffered WAL records are written to disk on every poll, so a follower lags at most replicationPollInterval
+// behind the leader when it keeps up. A follower that needs records the WAL no longer has gets a snapshot first.
+func (kvs *KeyValueStore) handleReplication(rw *bufio.ReadWriter, remoteAddr string) error {
+	line, err := rw.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 2 && fields[0] == "SYNC" {
+		depth, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return writeReplicationError(rw, fmt.Errorf("invalid tree depth: %w", err))
+		}
+		return kvs.handleSync(rw, depth)
+	}
+	if len(fields) != 2 || fields[0] != "REPLICATE" {
+		return writeReplicationError(rw, fmt.Errorf("invalid replication request %q", strings.TrimSpace(line)))
+	}
+	pos, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return writeReplicationError(rw, fmt.Errorf("invalid replication position: %w", err))
+	}
+	util.Logger().Info("follower connected", "remote", remoteAddr, "position", pos)
+
+	for {
+		kvs.mu.Lock()
+		records, next, end, err := kvs.readWAL(pos)
+		kvs.mu.Unlock()
+		if errors.Is(err, writeaheadlog.ErrPositionUnavailable) {
+			util.Logger().Info("sending a snapshot to the follower", "remote", remoteAddr, "position", pos)
+			if pos, err = kvs.sendReplicationSnapshot(rw); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return writeReplicationError(rw, err)
+		}
+
+		if err := writeReplicationMessage(rw, replicationBatch, next, end, records); err != nil {
+			return err
+		}
+		pos = next
+		if next == end {
+			time.Sleep(replicationPollInterval)
+		}
+	}
+}
+
+// readWAL writes the buffered WAL records to disk and reads the records from pos.
+// Returns the records, the position after them and the end of the WAL.
+func (kvs *KeyValueStore) readWAL(pos uint64) ([]byte, uint64, uint64, error) {
+	if err := kvs.wal.EmptyBuffer(); err != nil {
+		return nil, pos, pos, err
+	}
+	end, err := kvs.wal.Position()
+	if err != nil {
+		return nil, pos, pos, err
+	}
+	records, next, err := kvs.wal.ReadFrom(pos, replicationBatchSize)
+	return records, next, end, err
+}
+
+// sendReplicationSnapshot sends the live records of the regular keys to a follower and returns the position
+// the WAL is streamed from afterwards. The records are read in batches and kvs.mu is unlocked in between,
+// the writes made meanwhile are after the position in the WAL, so the follower applies them again.
+func (kvs *KeyValueStore) sendReplicationSnapshot(rw *bufio.ReadWriter) (uint64, error) {
+	kvs.mu.Lock()
+	err := kvs.wal.EmptyBuffer()
+	var pos uint64
+	if err == nil {
+		pos, err = kvs.wal.Position()
+	}
+	kvs.mu.Unlock()
+	if err != nil {
+		return 0, writeReplicationError(rw, err)
+	}
+
+	from := ""
+	for {
+		kvs.mu.Lock()
+		records, err := kvs.snapshotRecords(from)
+		kvs.mu.Unlock()
+		if err != nil {
+			return 0, writeReplicationError(rw, err)
+		}
+		data, err := json.Marshal(records)
+		if err != nil {
+			return 0, err
+		}
+		if err := writeReplicationMessage(rw, replicationSnapshot, pos, pos, data); err != nil {
+			return 0, err
+		}
+		if len(records) == 0 {
+			return pos, nil
+		}
+		from = records[len(records)-1].Key + "\x00" // the smallest key greater than the last one
+	}
+}
+
+// snapshotRecords returns the live records of the regular keys from the given key on,
+// at most replicationBatchSize bytes of them.
+func (kvs *KeyValueStore) snapshotRecords(from string) ([]syncRecord, error) {
+	iter, err := kvs.recordRangeIterator(from, lastKey)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Stop()
+
+	records := make([]syncRecord, 0)
+	size := 0
+	for rec := iter.Next(); rec != nil && size < replicationBatchSize; rec = iter.Next() {
+		if rec.Tombstone || util.IsReservedKey(rec.Key) {
+			continue
+		}
+		records = append(records, syncRecord{Key: string(rec.Key), Value: rec.Value, Timestamp: rec.Timestamp})
+		size += len(rec.Key) + len(rec.Value)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func writeReplicationMessage(rw *bufio.ReadWriter, kind byte, next, end uint64, data []byte) error {
+	header := make([]byte, 21)
+	header[0] = kind
+	binary.LittleEndian.PutUint64(header[1:9], next)
+	binary.LittleEndian.PutUint64(header[9:17], end)
+	binary.LittleEndian.PutUint32(header[17:21], uint32(len(data)))
+	if _, err := rw.Write(header); err != nil {
+		return err
+	}
+	if _, err := rw.Write(data); err != nil {
+		return err
+	}
+	return rw.Flush()
+}
+
+func writeReplicationError(rw *bufio.ReadWriter, err error) error {
+	msg := err.Error()
+	header := make([]byte, 5)
+	header[0] = replicationError
+	binary.LittleEndian.PutUint32(header[1:5], uint32(len(msg)))
+	_, _ = rw.Write(header)
+	_, _ = rw.WriteString(msg)
+	_ = rw.Flush()
+	return err
+}
+
+// ReplicaStatus describes the replication of a follower.
+type ReplicaStatus struct {
+	Leader          string
+	Connected       bool
+	AppliedPosition uint64    // position in the leader's WAL up to which the records are applied
+	LeaderPosition  uint64    // end of the leader's WAL at the last contact
+	LastContact     time.Time // zero if the leader was never reached
+	LastError       string
+}
+
+// Lag returns the number of bytes of the leader's WAL that are not applied yet.
+func (s ReplicaStatus) Lag() uint64 {
+	if s.LeaderPosition < s.AppliedPosition {
+		return 0
+	}
+	return s.LeaderPosition - s.AppliedPosition
+}
+
+// Replica follows a leader, see StartReplica.
+type Replica struct {
+	kvs    *KeyValueStore
+	leader string
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu     sync.Mutex
+	conn   net.Conn
+	status ReplicaStatus
+}
+
+// StartReplica makes the store a follower of the leader with the given replication address (see ServeReplication).
+// The follower applies the leader's WAL records with their timestamps and rejects writes of clients
+// with ErrReadOnly until Stop is called. The applied position is stored with the records, so a restarted follower
+// resumes where it stopped. A new follower, or one that fell behind the WAL segments the leader deleted,
+// first replaces its regular keys with a snapshot of the leader's, see handleReplication.
+// The records are applied in the background under kvs.mu, so all other calls must hold it as the network servers do.
+// Returns an error if the stored position can't be read.
+func (kvs *KeyValueStore) StartReplica(leaderAddress string) (*Replica, error) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	pos, err := kvs.replicationPosition()
+	if err != nil {
+		return nil, err
+	}
+	r := &Replica{
+		kvs:    kvs,
+		leader: leaderAddress,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		status: ReplicaStatus{Leader: leaderAddress, AppliedPosition: pos},
+	}
+	kvs.replica = r
+	kvs.startCheckpoints()
+	go r.run()
+	return r, nil
+}
+
+// Stop disconnects from the leader and waits for the records in flight to be applied.
+// The store accepts writes again afterwards, e.g. to promote a standby to the leader.
+func (r *Replica) Stop() {
+	r.mu.Lock()
+	close(r.stop)
+	if r.conn != nil {
+		_ = r.conn.Close()
+	}
+	r.mu.Unlock()
+	<-r.done
+
+	r.kvs.mu.Lock()
+	r.kvs.replica = nil
+	r.kvs.mu.Unlock()
+}
+
+// Status returns a snapshot of the replication status.
+func (r *Replica) Status() ReplicaStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// run follows the leader and reconnects with an exponential backoff until Stop is called.
+func (r *Replica) run() {
+	defer close(r.done)
+	backoff := replicationMinBackoff
+	for {
+		contact := r.Status().LastContact
+		err := r.follow()
+
+		r.mu.Lock()
+		r.conn = nil
+		r.status.Connected = false
+		if err != nil {
+			r.status.LastError = err.Error()
+		}
+		if r.status.LastContact != contact {
+			backoff = replicationMinBackoff
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(backoff):
+		}
+		if err != nil {
+			util.Logger().Warn("replication interrupted", "leader", r.leader, util.LogKeyError, err)
+		}
+		backoff = min(2*backoff, replicationMaxBackoff)
+	}
+}
+
+// follow connects to the leader and applies the received records until the connection fails.
+func (r *Replica) follow() error {
+	conn, err := net.DialTimeout("tcp", r.leader, replicationTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	r.mu.Lock()
+	select {
+	case <-r.stop:
+		r.mu.Unlock()
+		return nil
+	default:
+	}
+	r.conn = conn
+	pos := r.status.AppliedPosition
+	r.mu.Unlock()
+
+	if _, err := fmt.Fprintf(conn, "REPLICATE %d\r\n", pos); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	from := "" // first key of the next snapshot batch
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(replicationTimeout))
+		msg, err := readReplicationMessage(reader)
+		if err != nil {
+			return err
+		}
+		applied := true
+		if msg.kind == replicationSnapshot {
+			err = r.kvs.applyReplicationSnapshot(msg.snapshot, from, msg.next)
+			applied = len(msg.snapshot) == 0
+			from = ""
+			if !applied {
+				from = msg.snapshot[len(msg.snapshot)-1].Key + "\x00"
+			}
+		} else {
+			err = r.kvs.applyReplicated(msg.records, msg.next)
+		}
+		if err != nil {
+			return err
+		}
+
+		r.mu.Lock()
+		r.status.Connected = true
+		if applied {
+			r.status.AppliedPosition = msg.next
+		}
+		r.status.LeaderPosition = msg.end
+		r.status.LastContact = time.Now()
+		r.status.LastError = ""
+		r.mu.Unlock()
+	}
+}
+
+// replicationMessage is a batch or a snapshot message sent by handleReplication.
+type replicationMessage struct {
+	kind     byte
+	records  []*writeaheadlog.Record // of a batch
+	snapshot []syncRecord            // of a snapshot message
+	next     uint64
+	end      uint64
+}
+
+// readReplicationMessage reads a message sent by handleReplication and decodes its records.
+func readReplicationMessage(r *bufio.Reader) (*replicationMessage, error) {
+	kind, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case replicationBatch, replicationSnapshot:
+		header := make([]byte, 20)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, err
+		}
+		data := make([]byte, binary.LittleEndian.Uint32(header[16:20]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		msg := &replicationMessage{
+			kind: kind,
+			next: binary.LittleEndian.Uint64(header[0:8]),
+			end:  binary.LittleEndian.Uint64(header[8:16]),
+		}
+		if kind == replicationSnapshot {
+			err = json.Unmarshal(data, &msg.snapshot)
+		} else {
+			msg.records, err = writeaheadlog.DecodeRecords(data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return msg, nil
+	case replicationError:
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, err
+		}
+		msg := make([]byte, binary.LittleEndian.Uint32(header))
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("leader: " + string(msg))
+	default:
+		return nil, fmt.Errorf("unexpected replication message %q", kind)
+	}
+}
+
+// applyReplicated applies the leader's records with their timestamps and stores the position after them.
+// Rate limits, credentials and replication state are local to every store, so their records are skipped.
+func (kvs *KeyValueStore) applyReplicated(records []*writeaheadlog.Record, pos uint64) error {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	for _, rec := range records {
+		if !replicatedKey(rec.Key) {
+			continue
+		}
+		if err := kvs.putRecord(rec.ToModelRecord()); err != nil {
+			return err
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	return kvs.put(util.ReplicationPositionKey, binary.LittleEndian.AppendUint64(nil, pos))
+}
+
+// applyReplicationSnapshot applies a snapshot message of the leader, whose records follow the key from.
+// The regular keys from `from` up to the last record that the leader doesn't have are deleted.
+// The message without records ends the snapshot, the remaining keys are deleted and pos is stored.
+func (kvs *KeyValueStore) applyReplicationSnapshot(records []syncRecord, from string, pos uint64) error {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	to := lastKey
+	if len(records) > 0 {
+		to = records[len(records)-1].Key
+	}
+	keep := make(map[string]bool, len(records))
+	for _, rec := range records {
+		keep[rec.Key] = true
+	}
+	iter, err := kvs.recordRangeIterator(from, to)
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for rec := iter.Next(); rec != nil; rec = iter.Next() {
+		if !rec.Tombstone && !keep[string(rec.Key)] && !util.IsReservedKey(rec.Key) {
+			stale = append(stale, string(rec.Key))
+		}
+	}
+	iter.Stop()
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	for _, key := range stale {
+		if err := kvs.delete(key); err != nil {
+			return err
+		}
+	}
+	if err := kvs.applySyncRecords(records); err != nil {
+		return err
+	}
+	if len(records) > 0 {
+		return nil
+	}
+	return kvs.put(util.ReplicationPositionKey, binary.LittleEndian.AppendUint64(nil, pos))
+}
+
+// replicatedKey returns false for the keys of the leader that a follower keeps its own state for.
+func replicatedKey(key string) bool {
+	for _, prefix := range []string{util.RateLimiterKey, util.AuthPrefix, util.ReplicationPrefix} {
+		if strings.HasPrefix(key, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// replicationPosition returns the stored position in the leader's WAL, or 0 if the store never followed a leader.
+func (kvs *KeyValueStore) replicationPosition() (uint64, error) {
+	value, err := kvs.get(util.ReplicationPositionKey)
+	if err != nil {
+		return 0, err
+	}
+	if value == nil {
+		return 0, nil
+	}
+	if len(value) != 8 {
+		return 0, errors.New("corrupted replication position")
+	}
+	return binary.LittleEndian.Uint64(value), nil
+}
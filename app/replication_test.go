@@ -0,0 +1,210 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	writeaheadlog "nasp-project/structures/write-ahead_log"
+	"nasp-project/util"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// waitFor polls condition, which is called while holding kvs.mu, until it returns true.
+func waitFor(t *testing.T, kvs *KeyValueStore, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		kvs.mu.Lock()
+		done := condition()
+		kvs.mu.Unlock()
+		if done {
+			return
+		}
+	}
+	t.Fatalf("Timed out waiting for %s", what)
+}
+
+func TestReplication(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "replication_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	leader := newTestStore(t, path.Join(tmpDir, "leader"))
+	server, err := leader.ServeReplication("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the replication server: %v", err)
+	}
+	defer server.Close()
+
+	put := func(key, value string) {
+		leader.mu.Lock()
+		defer leader.mu.Unlock()
+		if err := leader.Put(key, []byte(value)); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		put(fmt.Sprintf("key%02d", i), fmt.Sprintf("value%d", i))
+	}
+	leader.mu.Lock()
+	if err := leader.Delete("key00"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	leader.mu.Unlock()
+
+	follower := newTestStore(t, path.Join(tmpDir, "follower"))
+	replica, err := follower.StartReplica(server.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to start the replica: %v", err)
+	}
+
+	get := func(key string) string {
+		value, err := follower.Get(key)
+		if err != nil {
+			t.Fatalf("Failed to get: %v", err)
+		}
+		return string(value)
+	}
+	waitFor(t, follower, "the initial records", func() bool { return get("key19") == "value19" })
+	follower.mu.Lock()
+	if got := get("key00"); got != "" {
+		t.Errorf("Expected key00 to be deleted, got %q", got)
+	}
+	if err := follower.Put("key", []byte("v")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly, got %v", err)
+	}
+	follower.mu.Unlock()
+
+	put("key20", "value20")
+	waitFor(t, follower, "a new record", func() bool { return get("key20") == "value20" })
+	waitFor(t, follower, "the lag to be reported", func() bool {
+		status := replica.Status()
+		return status.Connected && status.Lag() == 0 && status.AppliedPosition > 0
+	})
+	follower.mu.Lock()
+	stats, err := follower.Stats()
+	follower.mu.Unlock()
+	if err != nil || stats.Replication == nil || stats.Replication.Leader != server.Addr().String() {
+		t.Errorf("Expected the replication status in the stats, got %+v, %v", stats, err)
+	}
+
+	// a restarted follower resumes from the applied position
+	replica.Stop()
+	applied := replica.Status().AppliedPosition
+	if err := follower.Close(); err != nil {
+		t.Fatalf("Failed to close the follower: %v", err)
+	}
+	put("key21", "value21")
+
+	follower = newTestStore(t, path.Join(tmpDir, "follower"))
+	replica, err = follower.StartReplica(server.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to start the replica: %v", err)
+	}
+	if got := replica.Status().AppliedPosition; got != applied {
+		t.Errorf("Expected to resume from position %d, got %d", applied, got)
+	}
+	waitFor(t, follower, "the records written while stopped", func() bool { return get("key21") == "value21" })
+	follower.mu.Lock()
+	if got := get("key00"); got != "" {
+		t.Errorf("Expected key00 to stay deleted, got %q", got)
+	}
+	follower.mu.Unlock()
+
+	// a stopped replica accepts writes again
+	replica.Stop()
+	if err := follower.Put("key", []byte("v")); err != nil {
+		t.Errorf("Expected a promoted follower to accept writes, got %v", err)
+	}
+}
+
+func TestReplication_Snapshot(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "replication_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// the leader deletes the WAL segments of the flushed memtables
+	leader := newTestStore(t, path.Join(tmpDir, "leader"), func(config *util.Config) {
+		config.Memtable.MaxSize = 10
+		config.WAL.SegmentSize = 256
+	})
+	defer leader.Close()
+	server, err := leader.ServeReplication("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the replication server: %v", err)
+	}
+	defer server.Close()
+
+	leader.mu.Lock()
+	for i := 0; i < 100; i++ {
+		if err := leader.Put(fmt.Sprintf("key%03d", i), []byte(fmt.Sprintf("value%d", i))); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	if err := leader.Delete("key000"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	_, _, _, err = leader.readWAL(0)
+	leader.mu.Unlock()
+	if !errors.Is(err, writeaheadlog.ErrPositionUnavailable) {
+		t.Fatalf("Expected the first WAL segment to be deleted, got %v", err)
+	}
+
+	// the keys the leader doesn't have are deleted from the follower
+	follower := newTestStore(t, path.Join(tmpDir, "follower"))
+	defer follower.Close()
+	if err := follower.Put("key000", []byte("stale")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if err := follower.Put("zzz", []byte("stale")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	replica, err := follower.StartReplica(server.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to start the replica: %v", err)
+	}
+	defer replica.Stop()
+
+	waitFor(t, follower, "the snapshot", func() bool {
+		value, err := follower.Get("key099")
+		return err == nil && string(value) == "value99"
+	})
+	leader.mu.Lock()
+	if err := leader.Put("key100", []byte("value100")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	leader.mu.Unlock()
+	waitFor(t, follower, "a record written after the snapshot", func() bool {
+		value, err := follower.Get("key100")
+		return err == nil && string(value) == "value100"
+	})
+
+	follower.mu.Lock()
+	defer follower.mu.Unlock()
+	for _, key := range []string{"key000", "zzz"} {
+		if value, err := follower.Get(key); err != nil || value != nil {
+			t.Errorf("Expected %s to be deleted, got %q, %v", key, value, err)
+		}
+	}
+	for i := 1; i <= 100; i++ {
+		key := fmt.Sprintf("key%03d", i)
+		got, err := follower.read(key, nil)
+		if err != nil || got == nil {
+			t.Fatalf("Expected %s on the follower, got %v", key, err)
+		}
+		leader.mu.Lock()
+		want, err := leader.read(key, nil)
+		leader.mu.Unlock()
+		if err != nil || string(got.Value) != fmt.Sprintf("value%d", i) || got.Timestamp != want.Timestamp {
+			t.Errorf("Expected %s with the leader's value and timestamp, got %+v, %v", key, got, err)
+		}
+	}
+	if pos, err := follower.replicationPosition(); err != nil || pos == 0 {
+		t.Errorf("Expected the position after the snapshot to be stored, got %d, %v", pos, err)
+	}
+}
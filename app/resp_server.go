@@ -0,0 +1,654 @@
+package app
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"nasp-project/util"
+	"strconv"
+	"strings"
+)
+
+const (
+	respMaxBulkLength  = 64 << 20 // 64 MiB
+	respMaxArrayLength = 1 << 20
+	respDefaultCount   = 10
+
+	// Parameters of the structures created implicitly by PFADD and BF.ADD, the same as the Redis defaults.
+	respHLLPrecision  = 14
+	respBFCapacity    = 100
+	respBFErrorRate   = 0.01
+	respCMSMaxCounter = math.MaxUint8 // count-min sketch counters are single bytes
+)
+
+// errRESPQuit is returned by a command handler to close the connection after the reply.
+var errRESPQuit = errors.New("quit")
+
+// respProtocolError is a malformed request. The connection is closed after replying with it.
+type respProtocolError string
+
+func (e respProtocolError) Error() string {
+	return "Protocol error: " + string(e)
+}
+
+// ServeRESP starts a TCP server that speaks the Redis serialization protocol (RESP2) on the given address.
+// Supported commands are AUTH, PING, ECHO, QUIT, GET, SET, DEL, EXISTS, SCAN, PFADD, PFCOUNT,
+// BF.RESERVE, BF.ADD, BF.EXISTS, CMS.INITBYPROB, CMS.INCRBY and CMS.QUERY.
+// If authentication is enabled, a client must send AUTH token or AUTH username password first.
+// The server runs in the background until it is closed.
+// Returns an error if listening on the address fails.
+func (kvs *KeyValueStore) ServeRESP(address string) (*TCPServer, error) {
+	kvs.startCheckpoints()
+	return serveTCP(address, kvs.handleRESP)
+}
+
+// handleRESP serves commands of a single connection until the client disconnects.
+func (kvs *KeyValueStore) handleRESP(rw *bufio.ReadWriter, remoteAddr string) error {
+	w := respWriter{rw.Writer}
+	var user *User // nil until AUTH succeeds
+	for {
+		args, err := readRESPCommand(rw.Reader)
+		if err == io.EOF {
+			return nil
+		}
+		var protoErr respProtocolError
+		if errors.As(err, &protoErr) {
+			w.writeError("ERR " + protoErr.Error())
+			_ = rw.Flush()
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		if strings.EqualFold(string(args[0]), "AUTH") {
+			user = kvs.respAuth(w, user, args)
+		} else {
+			kvs.lockAs(clientID(user, remoteAddr))
+			err = kvs.execRESP(w, user, args)
+			kvs.unlock()
+		}
+
+		// flush once the pipelined commands are processed
+		if rw.Reader.Buffered() == 0 || err != nil {
+			if err := rw.Flush(); err != nil {
+				return err
+			}
+		}
+		if err == errRESPQuit {
+			return nil
+		}
+	}
+}
+
+// readRESPCommand reads a single command, either as a RESP array of bulk strings or as an inline command.
+// Returns io.EOF if the connection is closed between commands.
+func readRESPCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		// inline command, as typed into telnet
+		fields := strings.Fields(string(line))
+		args := make([][]byte, len(fields))
+		for i, f := range fields {
+			args[i] = []byte(f)
+		}
+		return args, nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > respMaxArrayLength {
+		return nil, respProtocolError("invalid multibulk length")
+	}
+	args := make([][]byte, 0, max(n, 0))
+	for i := 0; i < n; i++ {
+		line, err = readRESPLine(r)
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, respProtocolError("expected '$'")
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > respMaxBulkLength {
+			return nil, respProtocolError("invalid bulk length")
+		}
+		arg := make([]byte, size+2)
+		if _, err = io.ReadFull(r, arg); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if arg[size] != '\r' || arg[size+1] != '\n' {
+			return nil, respProtocolError("bulk string is not terminated by CRLF")
+		}
+		args = append(args, arg[:size])
+	}
+	return args, nil
+}
+
+// readRESPLine reads a line terminated by CRLF or LF and returns it without the terminator.
+func readRESPLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, respProtocolError("too big request line")
+	}
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+// respWriter writes RESP2 replies.
+type respWriter struct {
+	w *bufio.Writer
+}
+
+func (w respWriter) writeSimple(s string) {
+	_, _ = w.w.WriteString("+" + s + "\r\n")
+}
+
+func (w respWriter) writeError(s string) {
+	_, _ = w.w.WriteString("-" + strings.NewReplacer("\r", " ", "\n", " ").Replace(s) + "\r\n")
+}
+
+func (w respWriter) writeInt(n int64) {
+	_, _ = w.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+// writeBulk writes a bulk string, or a null bulk string if b is nil.
+func (w respWriter) writeBulk(b []byte) {
+	if b == nil {
+		_, _ = w.w.WriteString("$-1\r\n")
+		return
+	}
+	_, _ = w.w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	_, _ = w.w.Write(b)
+	_, _ = w.w.WriteString("\r\n")
+}
+
+func (w respWriter) writeArrayHeader(n int) {
+	_, _ = w.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// writeEngineError writes an engine error as a RESP error reply.
+func (w respWriter) writeEngineError(err error) {
+	if errors.Is(err, ErrReadOnly) {
+		w.writeError("READONLY You can't write against a read only replica.")
+		return
+	}
+	w.writeError("ERR " + err.Error())
+}
+
+// respAuth serves AUTH token and AUTH username password. It returns the authenticated user, or the current one
+// if the authentication fails. Unlike other commands it is executed without holding kvs.mu.
+func (kvs *KeyValueStore) respAuth(w respWriter, current *User, args [][]byte) *User {
+	if !kvs.authEnabled() {
+		w.writeError("ERR AUTH called without any users configured")
+		return current
+	}
+
+	var user *User
+	var err error
+	switch len(args) {
+	case 2:
+		user, err = kvs.authenticateClientToken(string(args[1]))
+	case 3:
+		user, err = kvs.authenticateClient(string(args[1]), string(args[2]))
+	default:
+		w.writeError("ERR wrong number of arguments for 'auth' command")
+		return current
+	}
+	if errors.Is(err, ErrAuthenticationFailed) {
+		w.writeError("WRONGPASS invalid username-password pair or user is disabled.")
+		return current
+	}
+	if err != nil {
+		w.writeEngineError(err)
+		return current
+	}
+	w.writeSimple("OK")
+	return user
+}
+
+// respKeyAccess maps the commands that access keys to the required access. DEL and EXISTS access all
+// their arguments, the other commands only the first one.
+var respKeyAccess = map[string]Access{
+	"GET":            AccessRead,
+	"SET":            AccessWrite,
+	"DEL":            AccessWrite,
+	"EXISTS":         AccessRead,
+	"PFADD":          AccessWrite,
+	"PFCOUNT":        AccessRead,
+	"BF.RESERVE":     AccessWrite,
+	"BF.ADD":         AccessWrite,
+	"BF.EXISTS":      AccessRead,
+	"CMS.INITBYPROB": AccessWrite,
+	"CMS.INCRBY":     AccessWrite,
+	"CMS.QUERY":      AccessRead,
+}
+
+// respArity maps command names to the minimal number of arguments, including the command name.
+var respArity = map[string]int{
+	"PING":           1,
+	"ECHO":           2,
+	"QUIT":           1,
+	"COMMAND":        1,
+	"GET":            2,
+	"SET":            3,
+	"DEL":            2,
+	"EXISTS":         2,
+	"SCAN":           2,
+	"PFADD":          2,
+	"PFCOUNT":        2,
+	"BF.RESERVE":     4,
+	"BF.ADD":         3,
+	"BF.EXISTS":      3,
+	"CMS.INITBYPROB": 4,
+	"CMS.INCRBY":     4,
+	"CMS.QUERY":      3,
+}
+
+// execRESP executes a single command of the given user and writes its reply.
+// Returns errRESPQuit if the connection should be closed.
+func (kvs *KeyValueStore) execRESP(w respWriter, user *User, args [][]byte) error {
+	name := strings.ToUpper(string(args[0]))
+	if kvs.authEnabled() && user == nil && name != "QUIT" {
+		w.writeError("NOAUTH Authentication required.")
+		return nil
+	}
+	arity, ok := respArity[name]
+	if !ok {
+		w.writeError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return nil
+	}
+	if len(args) < arity {
+		w.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return nil
+	}
+	if access, ok := respKeyAccess[name]; ok {
+		keys := args[1:2]
+		if name == "DEL" || name == "EXISTS" {
+			keys = args[1:]
+		}
+		for _, key := range keys {
+			if !kvs.authorized(user, string(key), access) {
+				w.writeError(fmt.Sprintf("NOPERM User %s has no permissions to access the '%s' key", user.Name, key))
+				return nil
+			}
+		}
+	}
+
+	switch name {
+	case "PING":
+		if len(args) > 1 {
+			w.writeBulk(args[1])
+		} else {
+			w.writeSimple("PONG")
+		}
+	case "ECHO":
+		w.writeBulk(args[1])
+	case "QUIT":
+		w.writeSimple("OK")
+		return errRESPQuit
+	case "COMMAND":
+		// clients such as redis-cli query the command table on connect, an empty one is a valid answer
+		w.writeArrayHeader(0)
+	case "GET":
+		value, err := kvs.Get(string(args[1]))
+		if err != nil {
+			w.writeEngineError(err)
+			return nil
+		}
+		w.writeBulk(value)
+	case "SET":
+		if len(args) > 3 {
+			w.writeError("ERR syntax error")
+			return nil
+		}
+		if err := kvs.Put(string(args[1]), args[2]); err != nil {
+			w.writeEngineError(err)
+			return nil
+		}
+		w.writeSimple("OK")
+	case "DEL", "EXISTS":
+		count := 0
+		for _, key := range args[1:] {
+			value, err := kvs.Get(string(key))
+			if err != nil {
+				w.writeEngineError(err)
+				return nil
+			}
+			if value == nil {
+				continue
+			}
+			count++
+			if name == "DEL" {
+				if err := kvs.Delete(string(key)); err != nil {
+					w.writeEngineError(err)
+					return nil
+				}
+			}
+		}
+		w.writeInt(int64(count))
+	case "SCAN":
+		kvs.respScan(w, user, args[1:])
+	case "PFADD":
+		kvs.respPFAdd(w, string(args[1]), args[2:])
+	case "PFCOUNT":
+		if len(args) > 2 {
+			w.writeError("ERR PFCOUNT of multiple keys is not supported")
+			return nil
+		}
+		estimate, err := kvs.HLLEstimate(string(args[1]))
+		if errors.Is(err, ErrNotFound) {
+			w.writeInt(0)
+			return nil
+		}
+		if err != nil {
+			w.writeEngineError(err)
+			return nil
+		}
+		w.writeInt(int64(math.Round(estimate)))
+	case "BF.RESERVE":
+		errorRate, err1 := strconv.ParseFloat(string(args[2]), 64)
+		capacity, err2 := strconv.ParseUint(string(args[3]), 10, 0)
+		if err1 != nil || err2 != nil || errorRate <= 0 || errorRate >= 1 || capacity == 0 {
+			w.writeError("ERR bad error rate or capacity")
+			return nil
+		}
+		if err := kvs.NewBF(string(args[1]), uint(capacity), errorRate); err != nil {
+			w.writeEngineError(err)
+			return nil
+		}
+		w.writeSimple("OK")
+	case "BF.ADD":
+		present, err := kvs.BFHasKey(string(args[1]), args[2])
+		if errors.Is(err, ErrNotFound) {
+			err = kvs.NewBF(string(args[1]), respBFCapacity, respBFErrorRate)
+		}
+		if err == nil && !present {
+			err = kvs.BFAdd(string(args[1]), args[2])
+		}
+		if err != nil {
+			w.writeEngineError(err)
+			return nil
+		}
+		w.writeInt(boolToInt(!present))
+	case "BF.EXISTS":
+		present, err := kvs.BFHasKey(string(args[1]), args[2])
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			w.writeEngineError(err)
+			return nil
+		}
+		w.writeInt(boolToInt(present))
+	case "CMS.INITBYPROB":
+		epsilon, err1 := strconv.ParseFloat(string(args[2]), 64)
+		delta, err2 := strconv.ParseFloat(string(args[3]), 64)
+		if err1 != nil || err2 != nil || epsilon <= 0 || epsilon >= 1 || delta <= 0 || delta >= 1 {
+			w.writeError("ERR invalid error or probability")
+			return nil
+		}
+		if err := kvs.NewCMS(string(args[1]), epsilon, delta); err != nil {
+			w.writeEngineError(err)
+			return nil
+		}
+		w.writeSimple("OK")
+	case "CMS.INCRBY":
+		kvs.respCMSIncrBy(w, string(args[1]), args[2:])
+	case "CMS.QUERY":
+		counts := make([]int, 0, len(args)-2)
+		for _, item := range args[2:] {
+			count, err := kvs.CMSGet(string(args[1]), item)
+			if err != nil {
+				w.writeEngineError(err)
+				return nil
+			}
+			counts = append(counts, count)
+		}
+		w.writeArrayHeader(len(counts))
+		for _, count := range counts {
+			w.writeInt(int64(count))
+		}
+	}
+	return nil
+}
+
+// respScan serves SCAN cursor [MATCH pattern] [COUNT count].
+// The cursor is the hex encoded last key examined by the previous call, so the next call seeks right after it
+// and the keys written in between are not skipped. Like in Redis, MATCH filters the examined keys, so a reply
+// can be empty even if the iteration is not finished. The keys the user has no read access to are filtered the same way.
+func (kvs *KeyValueStore) respScan(w respWriter, user *User, args [][]byte) {
+	start := ""
+	if cursor := string(args[0]); cursor != "0" {
+		last, err := hex.DecodeString(cursor)
+		if err != nil || len(last) == 0 {
+			w.writeError("ERR invalid cursor")
+			return
+		}
+		start = string(last) + "\x00" // the smallest key greater than the last one
+	}
+	count := respDefaultCount
+	pattern := ""
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			w.writeError("ERR syntax error")
+			return
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = string(args[i+1])
+		case "COUNT":
+			var err error
+			count, err = strconv.Atoi(string(args[i+1]))
+			if err != nil || count < 1 {
+				w.writeError("ERR value is not an integer or out of range")
+				return
+			}
+		default:
+			w.writeError("ERR syntax error")
+			return
+		}
+	}
+
+	iter, err := kvs.rangeIterate(start, lastKey, kvs.scanCost(count))
+	if err != nil {
+		w.writeEngineError(err)
+		return
+	}
+	defer iter.Stop()
+
+	var keys []string
+	examined := 0
+	last := ""
+	next := "0"
+	for key, _ := iter.Next(); key != ""; key, _ = iter.Next() {
+		if util.IsReservedKey([]byte(key)) {
+			continue
+		}
+		if examined == count {
+			next = hex.EncodeToString([]byte(last))
+			break
+		}
+		if (pattern == "" || globMatch(pattern, key)) && kvs.authorized(user, key, AccessRead) {
+			keys = append(keys, key)
+		}
+		last = key
+		examined++
+	}
+	if err := iter.Err(); err != nil {
+		w.writeEngineError(err)
+		return
+	}
+
+	w.writeArrayHeader(2)
+	w.writeBulk([]byte(next))
+	w.writeArrayHeader(len(keys))
+	for _, key := range keys {
+		w.writeBulk([]byte(key))
+	}
+}
+
+// respPFAdd serves PFADD key [element ...]. It creates the HyperLogLog if it does not exist
+// and replies 1 if it was created or its estimate changed.
+func (kvs *KeyValueStore) respPFAdd(w respWriter, key string, elements [][]byte) {
+	before, err := kvs.HLLEstimate(key)
+	created := false
+	if errors.Is(err, ErrNotFound) {
+		err = kvs.NewHLL(key, respHLLPrecision)
+		created = true
+	}
+	if err != nil {
+		w.writeEngineError(err)
+		return
+	}
+
+	for _, el := range elements {
+		if err := kvs.HLLAdd(key, el); err != nil {
+			w.writeEngineError(err)
+			return
+		}
+	}
+
+	changed := created
+	if !created && len(elements) > 0 {
+		after, err := kvs.HLLEstimate(key)
+		if err != nil {
+			w.writeEngineError(err)
+			return
+		}
+		changed = after != before
+	}
+	w.writeInt(boolToInt(changed))
+}
+
+// respCMSIncrBy serves CMS.INCRBY key item increment [item increment ...] and replies with the new counts.
+func (kvs *KeyValueStore) respCMSIncrBy(w respWriter, key string, args [][]byte) {
+	if len(args)%2 != 0 {
+		w.writeError("ERR wrong number of arguments for 'cms.incrby' command")
+		return
+	}
+	for i := 1; i < len(args); i += 2 {
+		incr, err := strconv.Atoi(string(args[i]))
+		if err != nil || incr < 0 || incr > respCMSMaxCounter {
+			w.writeError(fmt.Sprintf("ERR increment must be between 0 and %d", respCMSMaxCounter))
+			return
+		}
+	}
+
+	counts := make([]int, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		item := args[i]
+		incr, _ := strconv.Atoi(string(args[i+1]))
+		for j := 0; j < incr; j++ {
+			if err := kvs.CMSAdd(key, item); err != nil {
+				w.writeEngineError(err)
+				return
+			}
+		}
+		count, err := kvs.CMSGet(key, item)
+		if err != nil {
+			w.writeEngineError(err)
+			return
+		}
+		counts = append(counts, count)
+	}
+
+	w.writeArrayHeader(len(counts))
+	for _, count := range counts {
+		w.writeInt(int64(count))
+	}
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// globMatch reports whether s matches the Redis glob-style pattern.
+// Supported are *, ?, character classes [abc], [^abc], [a-z] and escaping with \.
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				// unterminated class, match '[' literally
+				if s[0] != '[' {
+					return false
+				}
+				break
+			}
+			class := pattern[1 : end+1]
+			negate := len(class) > 0 && class[0] == '^'
+			if negate {
+				class = class[1:]
+			}
+			matched := false
+			for i := 0; i < len(class); i++ {
+				if i+2 < len(class) && class[i+1] == '-' {
+					if class[i] <= s[0] && s[0] <= class[i+2] {
+						matched = true
+					}
+					i += 2
+				} else if class[i] == s[0] {
+					matched = true
+				}
+			}
+			if matched == negate {
+				return false
+			}
+			pattern = pattern[end+1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+	return len(s) == 0
+}
@@ -0,0 +1,230 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"nasp-project/util"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// respClient is a minimal RESP2 client for tests.
+type respClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialRESP(t *testing.T, address string) *respClient {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	return &respClient{conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends a command and returns the reply: string for simple strings and bulk strings,
+// error for errors, int64 for integers, nil for null bulk strings and []any for arrays.
+func (c *respClient) do(t *testing.T, args ...string) any {
+	cmd := "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, arg := range args {
+		cmd += "$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"
+	}
+	if _, err := c.conn.Write([]byte(cmd)); err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+	reply, err := c.readReply()
+	if err != nil {
+		t.Fatalf("Failed to read reply to %v: %v", args, err)
+	}
+	return reply
+}
+
+func (c *respClient) readReply() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return fmt.Errorf("%s", line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		arr := make([]any, n)
+		for i := range arr {
+			if arr[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
+
+func newTestRESPServer(t *testing.T, tmpDir string) (*KeyValueStore, *TCPServer) {
+	db := newTestStore(t, tmpDir)
+	server, err := db.ServeRESP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start the RESP server: %v", err)
+	}
+	return db, server
+}
+
+func TestRESPServer_Strings(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "resp_server_test_strings_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	_, server := newTestRESPServer(t, tmpDir)
+	defer server.Close()
+	c := dialRESP(t, server.Addr().String())
+
+	for _, tc := range []struct {
+		args     []string
+		expected any
+	}{
+		{[]string{"PING"}, "PONG"},
+		{[]string{"SET", "k1", "v\r\n1"}, "OK"},
+		{[]string{"set", "k2", "v2"}, "OK"},
+		{[]string{"GET", "k1"}, "v\r\n1"},
+		{[]string{"GET", "missing"}, nil},
+		{[]string{"EXISTS", "k1", "k2", "missing"}, int64(2)},
+		{[]string{"DEL", "k1", "missing"}, int64(1)},
+		{[]string{"GET", "k1"}, nil},
+	} {
+		if reply := c.do(t, tc.args...); !reflect.DeepEqual(reply, tc.expected) {
+			t.Errorf("%v: expected %#v, got %#v", tc.args, tc.expected, reply)
+		}
+	}
+
+	if _, ok := c.do(t, "GET").(error); !ok {
+		t.Errorf("Expected an error for a wrong number of arguments")
+	}
+	if _, ok := c.do(t, "NOSUCHCOMMAND").(error); !ok {
+		t.Errorf("Expected an error for an unknown command")
+	}
+	if _, ok := c.do(t, "GET", util.RateLimiterKey).(error); !ok {
+		t.Errorf("Expected an error for a reserved key")
+	}
+}
+
+func TestRESPServer_Scan(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "resp_server_test_scan_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	_, server := newTestRESPServer(t, tmpDir)
+	defer server.Close()
+	c := dialRESP(t, server.Addr().String())
+
+	for i := 0; i < 7; i++ {
+		c.do(t, "SET", fmt.Sprintf("user:%d", i), "x")
+		c.do(t, "SET", fmt.Sprintf("order:%d", i), "x")
+	}
+	c.do(t, "PFADD", "visits", "a") // must not show up in the scan
+
+	var keys []any
+	cursor := "0"
+	deleted := false
+	for pages := 0; pages < 20; pages++ {
+		reply, ok := c.do(t, "SCAN", cursor, "MATCH", "user:*", "COUNT", "3").([]any)
+		if !ok || len(reply) != 2 {
+			t.Fatalf("Unexpected SCAN reply %#v", reply)
+		}
+		keys = append(keys, reply[1].([]any)...)
+		cursor = reply[0].(string)
+		if cursor == "0" {
+			break
+		}
+		if len(keys) > 0 && !deleted {
+			c.do(t, "DEL", "order:0") // the keys after the cursor are still found
+			deleted = true
+		}
+	}
+	if len(keys) != 7 {
+		t.Errorf("Expected 7 keys, got %v", keys)
+	}
+	if _, ok := c.do(t, "SCAN", "not-a-cursor").(error); !ok {
+		t.Errorf("Expected an error for an invalid cursor")
+	}
+}
+
+func TestRESPServer_Probabilistic(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "resp_server_test_probabilistic_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	_, server := newTestRESPServer(t, tmpDir)
+	defer server.Close()
+	c := dialRESP(t, server.Addr().String())
+
+	for _, tc := range []struct {
+		args     []string
+		expected any
+	}{
+		{[]string{"PFCOUNT", "visits"}, int64(0)},
+		{[]string{"PFADD", "visits", "alpha", "bravo", "charlie"}, int64(1)},
+		{[]string{"PFCOUNT", "visits"}, int64(3)},
+		{[]string{"BF.EXISTS", "users", "alice"}, int64(0)},
+		{[]string{"BF.ADD", "users", "alice"}, int64(1)},
+		{[]string{"BF.ADD", "users", "alice"}, int64(0)},
+		{[]string{"BF.EXISTS", "users", "alice"}, int64(1)},
+		{[]string{"CMS.INITBYPROB", "clicks", "0.01", "0.01"}, "OK"},
+		{[]string{"CMS.INCRBY", "clicks", "home", "3", "about", "1"}, []any{int64(3), int64(1)}},
+		{[]string{"CMS.QUERY", "clicks", "home", "contact"}, []any{int64(3), int64(0)}},
+	} {
+		if reply := c.do(t, tc.args...); !reflect.DeepEqual(reply, tc.expected) {
+			t.Errorf("%v: expected %#v, got %#v", tc.args, tc.expected, reply)
+		}
+	}
+
+	if _, ok := c.do(t, "CMS.INCRBY", "missing", "home", "1").(error); !ok {
+		t.Errorf("Expected an error for a missing count-min sketch")
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, s string
+		expected   bool
+	}{
+		{"*", "anything", true},
+		{"user:*", "user:1", true},
+		{"user:*", "order:1", false},
+		{"h?llo", "hello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"a\\*", "a*", true},
+		{"a\\*", "ab", false},
+		{"*b*c", "abxc", true},
+	} {
+		if got := globMatch(tc.pattern, tc.s); got != tc.expected {
+			t.Errorf("globMatch(%q, %q) = %t, expected %t", tc.pattern, tc.s, got, tc.expected)
+		}
+	}
+}
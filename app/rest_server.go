@@ -0,0 +1,592 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultScanLimit = 100
+	maxScanLimit     = 10_000
+)
+
+// errBadRequest marks errors caused by invalid request parameters.
+var errBadRequest = errors.New("bad request")
+
+// restScanRecord is a single record of a scan response. Value is base64 encoded by encoding/json.
+type restScanRecord struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// restScanResponse is the response of /scan and /prefix.
+// NextCursor is empty if there are no more records.
+type restScanResponse struct {
+	Records    []restScanRecord `json:"records"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// restServer serves the KeyValueStore over HTTP. It serializes all store calls with kvs.mu.
+type restServer struct {
+	kvs *KeyValueStore
+	mux *http.ServeMux
+}
+
+// RESTHandler returns an http.Handler that exposes the store over HTTP with JSON responses:
+//
+//	GET|PUT|DELETE /kv/{key}                          raw value in the request or response body
+//	GET /scan?start=&end=&cursor=&limit=              range scan, keys in [start, end], to the last key without end
+//	GET /prefix?p=&cursor=&limit=                     prefix scan
+//	PUT /bf/{key}?n=&p=, DELETE /bf/{key}             create or delete a Bloom filter
+//	POST /bf/{key}/add, POST /bf/{key}/check          element in the request body
+//	PUT /cms/{key}?epsilon=&delta=, DELETE /cms/{key} create or delete a Count-Min Sketch
+//	POST /cms/{key}/add, POST /cms/{key}/count        element in the request body
+//	PUT /hll/{key}?p=, DELETE /hll/{key}              create or delete a HyperLogLog
+//	POST /hll/{key}/add, GET /hll/{key}/estimate      element in the request body
+//	PUT /simhash/{key}, DELETE /simhash/{key}         text in the request body
+//	GET /simhash/distance?a=&b=                       Hamming distance of two fingerprints
+//	GET /stats                                        engine statistics
+//	GET /metrics                                      Prometheus metrics
+//	GET /health                                       200 while the server is up, without authentication
+//
+// Keys in the path must be URL encoded. Engine errors are mapped to status codes by statusFromError.
+//
+// If authentication is enabled, every request must carry the credentials of a user, either as a bearer token
+// or with HTTP basic authentication. Tokens are preferable, as checking a password is deliberately slow.
+// /stats and /metrics require the admin access to all keys.
+func (kvs *KeyValueStore) RESTHandler() http.Handler {
+	kvs.startCheckpoints()
+	s := &restServer{kvs: kvs, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/kv/", s.handleKV)
+	s.mux.HandleFunc("/scan", s.handleScan)
+	s.mux.HandleFunc("/prefix", s.handleScan)
+	s.mux.HandleFunc("/bf/", s.handleBF)
+	s.mux.HandleFunc("/cms/", s.handleCMS)
+	s.mux.HandleFunc("/hll/", s.handleHLL)
+	s.mux.HandleFunc("/simhash/", s.handleSimHash)
+	s.mux.Handle("/stats", s.adminOnly(http.HandlerFunc(s.handleStats)))
+	s.mux.Handle("/metrics", s.adminOnly(kvs.MetricsHandler()))
+	if !kvs.authEnabled() {
+		s.mux.HandleFunc("/health", handleHealth)
+		return s.mux
+	}
+	root := http.NewServeMux()
+	root.HandleFunc("/health", handleHealth)
+	root.Handle("/", s.authenticate(s.mux))
+	return root
+}
+
+// handleHealth serves /health. It doesn't touch the store, so clients can check that the server is up
+// without credentials and without consuming rate limit tokens.
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// restUserKey is the context key of the authenticated user of a request.
+type restUserKey struct{}
+
+// authenticate passes the requests with valid credentials to next, with the user stored in the request context.
+func (s *restServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var user *User
+		err := ErrAuthenticationFailed
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			user, err = s.kvs.authenticateClientToken(token)
+		} else if name, password, ok := r.BasicAuth(); ok {
+			user, err = s.kvs.authenticateClient(name, password)
+		}
+		if err != nil {
+			if errors.Is(err, ErrAuthenticationFailed) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="nasp", charset="UTF-8"`)
+			}
+			writeError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), restUserKey{}, user)))
+	})
+}
+
+// requestUser returns the authenticated user of the request, or nil if authentication is disabled.
+func requestUser(r *http.Request) *User {
+	user, _ := r.Context().Value(restUserKey{}).(*User)
+	return user
+}
+
+// requestClient returns the rate limiting identity of the client that sent the request.
+func requestClient(r *http.Request) string {
+	return clientID(requestUser(r), r.RemoteAddr)
+}
+
+// authorize returns true if the user of the request has at least the given access to all keys,
+// and writes a permission error otherwise.
+func (s *restServer) authorize(w http.ResponseWriter, r *http.Request, access Access, keys ...string) bool {
+	user := requestUser(r)
+	for _, key := range keys {
+		if !s.kvs.authorized(user, key, access) {
+			writeError(w, permissionError(user, key))
+			return false
+		}
+	}
+	return true
+}
+
+// adminOnly passes only the requests of administrators to next.
+func (s *restServer) adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := requestUser(r)
+		if !s.kvs.authorizedAdmin(user) {
+			writeError(w, fmt.Errorf("%w: administrative operation", ErrPermissionDenied))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ServeREST starts an HTTP server that serves RESTHandler on the given address.
+// The server runs in the background until it is closed.
+// Returns an error if listening on the address fails.
+func (kvs *KeyValueStore) ServeREST(address string) (*http.Server, error) {
+	return serveHTTP(address, kvs.RESTHandler())
+}
+
+// serveHTTP starts an HTTP server with the given handler in the background.
+func serveHTTP(address string, handler http.Handler) (*http.Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{Handler: handler}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	return server, nil
+}
+
+// statusFromError maps an engine error to an HTTP status code.
+func statusFromError(err error) int {
+	switch {
+	case errors.Is(err, ErrRateLimitReached):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrReservedKey), errors.Is(err, errBadRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrAuthenticationFailed):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, ErrReadOnly):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, statusFromError(err), map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+}
+
+// pathParts splits the escaped request path after prefix into unescaped segments.
+// Returns an error if a segment is not validly escaped or the key segment is empty.
+func pathParts(r *http.Request, prefix string) ([]string, error) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), prefix), "/")
+	for i, part := range parts {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errBadRequest, err)
+		}
+		parts[i] = unescaped
+	}
+	if parts[0] == "" {
+		return nil, fmt.Errorf("%w: empty key", errBadRequest)
+	}
+	return parts, nil
+}
+
+// readBody reads the whole request body.
+func readBody(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errBadRequest, err)
+	}
+	return body, nil
+}
+
+// queryFloat parses a float query parameter.
+func queryFloat(r *http.Request, name string) (float64, error) {
+	f, err := strconv.ParseFloat(r.URL.Query().Get(name), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid parameter %s", errBadRequest, name)
+	}
+	return f, nil
+}
+
+// queryUint parses an unsigned integer query parameter.
+func queryUint(r *http.Request, name string, bitSize int) (uint64, error) {
+	n, err := strconv.ParseUint(r.URL.Query().Get(name), 10, bitSize)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid parameter %s", errBadRequest, name)
+	}
+	return n, nil
+}
+
+func (s *restServer) handleKV(w http.ResponseWriter, r *http.Request) {
+	key, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/kv/"))
+	if err != nil || key == "" {
+		writeError(w, fmt.Errorf("%w: invalid key", errBadRequest))
+		return
+	}
+	access := AccessWrite
+	if r.Method == http.MethodGet {
+		access = AccessRead
+	}
+	if !s.authorize(w, r, access, key) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.kvs.lockAs(requestClient(r))
+		value, err := s.kvs.Get(key)
+		s.kvs.unlock()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if value == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "key not found"})
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(value)
+	case http.MethodPut:
+		value, err := readBody(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		s.kvs.lockAs(requestClient(r))
+		err = s.kvs.Put(key, value)
+		s.kvs.unlock()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		s.kvs.lockAs(requestClient(r))
+		err := s.kvs.Delete(key)
+		s.kvs.unlock()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+// handleScan serves /scan and /prefix. Records are returned in key order, at most limit of them,
+// starting after the cursor key. The cursor of the next page is the key of the last returned record.
+// The keys the user has no read access to are skipped.
+func (s *restServer) handleScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultScanLimit
+	if query.Has("limit") {
+		n, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || n < 1 || n > maxScanLimit {
+			writeError(w, fmt.Errorf("%w: invalid parameter limit", errBadRequest))
+			return
+		}
+		limit = n
+	}
+	cursor := query.Get("cursor")
+	user := requestUser(r)
+
+	s.kvs.lockAs(requestClient(r))
+	defer s.kvs.unlock()
+
+	// both seek right after the cursor, so a page reads the records it returns and the ones the user can't read
+	prefix := ""
+	start, end := query.Get("start"), query.Get("end")
+	if end == "" {
+		end = lastKey
+	}
+	if r.URL.Path == "/prefix" {
+		prefix = query.Get("p")
+		start, end = prefix, prefix+lastKey
+	}
+	if cursor != "" && cursor >= start {
+		start = cursor + "\x00" // the smallest key greater than the cursor
+	}
+	iter, err := s.kvs.rangeIterate(start, end, s.kvs.scanCost(limit))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer iter.Stop()
+
+	resp := restScanResponse{Records: []restScanRecord{}}
+	for key, value := iter.Next(); key != ""; key, value = iter.Next() {
+		if !strings.HasPrefix(key, prefix) {
+			break
+		}
+		if key <= cursor || !s.kvs.authorized(user, key, AccessRead) {
+			continue
+		}
+		if len(resp.Records) == limit {
+			resp.NextCursor = resp.Records[limit-1].Key
+			break
+		}
+		resp.Records = append(resp.Records, restScanRecord{Key: key, Value: value})
+	}
+	if err := iter.Err(); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *restServer) handleBF(w http.ResponseWriter, r *http.Request) {
+	parts, err := pathParts(r, "/bf/")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	key := parts[0]
+	access := AccessWrite
+	if len(parts) == 2 && parts[1] == "check" {
+		access = AccessRead
+	}
+	if !s.authorize(w, r, access, key) {
+		return
+	}
+	val, err := readBody(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.kvs.lockAs(requestClient(r))
+	defer s.kvs.unlock()
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodPut:
+		n, err := queryUint(r, "n", 0)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		p, err := queryFloat(r, "p")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		s.respond(w, s.kvs.NewBF(key, uint(n), p), nil)
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		s.respond(w, s.kvs.DeleteBF(key), nil)
+	case len(parts) == 2 && parts[1] == "add" && r.Method == http.MethodPost:
+		s.respond(w, s.kvs.BFAdd(key, val), nil)
+	case len(parts) == 2 && parts[1] == "check" && r.Method == http.MethodPost:
+		present, err := s.kvs.BFHasKey(key, val)
+		s.respond(w, err, map[string]bool{"present": present})
+	default:
+		writeError(w, fmt.Errorf("%w: unknown bloom filter operation", errBadRequest))
+	}
+}
+
+func (s *restServer) handleCMS(w http.ResponseWriter, r *http.Request) {
+	parts, err := pathParts(r, "/cms/")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	key := parts[0]
+	access := AccessWrite
+	if len(parts) == 2 && parts[1] == "count" {
+		access = AccessRead
+	}
+	if !s.authorize(w, r, access, key) {
+		return
+	}
+	val, err := readBody(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.kvs.lockAs(requestClient(r))
+	defer s.kvs.unlock()
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodPut:
+		epsilon, err := queryFloat(r, "epsilon")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		delta, err := queryFloat(r, "delta")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		s.respond(w, s.kvs.NewCMS(key, epsilon, delta), nil)
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		s.respond(w, s.kvs.DeleteCMS(key), nil)
+	case len(parts) == 2 && parts[1] == "add" && r.Method == http.MethodPost:
+		s.respond(w, s.kvs.CMSAdd(key, val), nil)
+	case len(parts) == 2 && parts[1] == "count" && r.Method == http.MethodPost:
+		count, err := s.kvs.CMSGet(key, val)
+		s.respond(w, err, map[string]int{"count": count})
+	default:
+		writeError(w, fmt.Errorf("%w: unknown count-min sketch operation", errBadRequest))
+	}
+}
+
+func (s *restServer) handleHLL(w http.ResponseWriter, r *http.Request) {
+	parts, err := pathParts(r, "/hll/")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	key := parts[0]
+	access := AccessWrite
+	if len(parts) == 2 && parts[1] == "estimate" {
+		access = AccessRead
+	}
+	if !s.authorize(w, r, access, key) {
+		return
+	}
+	val, err := readBody(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.kvs.lockAs(requestClient(r))
+	defer s.kvs.unlock()
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodPut:
+		p, err := queryUint(r, "p", 32)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		s.respond(w, s.kvs.NewHLL(key, uint32(p)), nil)
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		s.respond(w, s.kvs.DeleteHLL(key), nil)
+	case len(parts) == 2 && parts[1] == "add" && r.Method == http.MethodPost:
+		s.respond(w, s.kvs.HLLAdd(key, val), nil)
+	case len(parts) == 2 && parts[1] == "estimate" && r.Method == http.MethodGet:
+		estimate, err := s.kvs.HLLEstimate(key)
+		s.respond(w, err, map[string]float64{"estimate": estimate})
+	default:
+		writeError(w, fmt.Errorf("%w: unknown hyperloglog operation", errBadRequest))
+	}
+}
+
+func (s *restServer) handleSimHash(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/simhash/distance" {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		a, b := r.URL.Query().Get("a"), r.URL.Query().Get("b")
+		if !s.authorize(w, r, AccessRead, a, b) {
+			return
+		}
+		s.kvs.lockAs(requestClient(r))
+		defer s.kvs.unlock()
+		distance, err := s.kvs.SHGetHammingDistance(a, b)
+		s.respond(w, err, map[string]uint8{"distance": distance})
+		return
+	}
+
+	parts, err := pathParts(r, "/simhash/")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(parts) != 1 {
+		writeError(w, fmt.Errorf("%w: unknown simhash operation", errBadRequest))
+		return
+	}
+	key := parts[0]
+	if !s.authorize(w, r, AccessWrite, key) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		text, err := readBody(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		s.kvs.lockAs(requestClient(r))
+		defer s.kvs.unlock()
+		s.respond(w, s.kvs.SHAddFingerprint(key, string(text)), nil)
+	case http.MethodDelete:
+		s.kvs.lockAs(requestClient(r))
+		defer s.kvs.unlock()
+		s.respond(w, s.kvs.SHDeleteFingerprint(key), nil)
+	default:
+		methodNotAllowed(w, http.MethodPut, http.MethodDelete)
+	}
+}
+
+func (s *restServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	s.kvs.lockAs(requestClient(r))
+	defer s.kvs.unlock()
+	stats, err := s.kvs.Stats()
+	s.respond(w, err, stats)
+}
+
+// respond writes the error if err is not nil, body as JSON if it is not nil, or an empty 204 response otherwise.
+func (s *restServer) respond(w http.ResponseWriter, err error, body any) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if body == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, body)
+}
@@ -0,0 +1,209 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"nasp-project/util"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func newTestRESTServer(t *testing.T, tmpDir string, overrides ...func(*util.Config)) (*KeyValueStore, *httptest.Server) {
+	db := newTestStore(t, tmpDir, overrides...)
+	return db, httptest.NewServer(db.RESTHandler())
+}
+
+func doRequest(t *testing.T, method, url string, body []byte) (int, []byte) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	return resp.StatusCode, respBody
+}
+
+func TestRESTServer_KV(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "rest_server_test_kv_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	_, server := newTestRESTServer(t, tmpDir)
+	defer server.Close()
+
+	keyURL := server.URL + "/kv/" + url.PathEscape("a/b c")
+	value := []byte{0, 1, 2, 255}
+
+	status, _ := doRequest(t, http.MethodPut, keyURL, value)
+	if status != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, status)
+	}
+
+	status, body := doRequest(t, http.MethodGet, keyURL, nil)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, status)
+	}
+	if !bytes.Equal(body, value) {
+		t.Errorf("Expected value %v, got %v", value, body)
+	}
+
+	status, _ = doRequest(t, http.MethodDelete, keyURL, nil)
+	if status != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, status)
+	}
+
+	status, _ = doRequest(t, http.MethodGet, keyURL, nil)
+	if status != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, status)
+	}
+
+	status, _ = doRequest(t, http.MethodGet, server.URL+"/kv/"+url.PathEscape(util.RateLimiterKey), nil)
+	if status != http.StatusBadRequest {
+		t.Errorf("Expected status %d for a reserved key, got %d", http.StatusBadRequest, status)
+	}
+}
+
+func TestRESTServer_ScanCursor(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "rest_server_test_scan_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db, server := newTestRESTServer(t, tmpDir)
+	defer server.Close()
+
+	keys := []string{"a1", "a2", "a3", "a4", "a5", "b1"}
+	for _, key := range keys {
+		if err := db.Put(key, []byte("value-"+key)); err != nil {
+			t.Fatalf("Failed to put key-value pair: %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		query    string
+		expected []string
+	}{
+		{"/scan?start=a1&end=a9&limit=2", []string{"a1", "a2", "a3", "a4", "a5"}},
+		{"/prefix?p=a&limit=3", []string{"a1", "a2", "a3", "a4", "a5"}},
+		{"/prefix?p=a&limit=1", []string{"a1", "a2", "a3", "a4", "a5"}},
+		{"/prefix?p=b&limit=1", []string{"b1"}},
+		{"/prefix?p=&limit=4", keys},
+		{"/scan?start=a0&end=z", keys},
+		{"/scan?start=a4&limit=1", []string{"a4", "a5", "b1"}},
+		{"/scan?limit=4", keys},
+	} {
+		var got []string
+		cursor := ""
+		for pages := 0; pages < 10; pages++ {
+			status, body := doRequest(t, http.MethodGet, server.URL+tc.query+"&cursor="+url.QueryEscape(cursor), nil)
+			if status != http.StatusOK {
+				t.Fatalf("%s: expected status %d, got %d", tc.query, http.StatusOK, status)
+			}
+			var resp restScanResponse
+			if err := json.Unmarshal(body, &resp); err != nil {
+				t.Fatalf("%s: failed to decode response: %v", tc.query, err)
+			}
+			for _, rec := range resp.Records {
+				got = append(got, rec.Key)
+				if string(rec.Value) != "value-"+rec.Key {
+					t.Errorf("%s: unexpected value %q for key %s", tc.query, rec.Value, rec.Key)
+				}
+			}
+			if resp.NextCursor == "" {
+				break
+			}
+			cursor = resp.NextCursor
+		}
+		if len(got) != len(tc.expected) {
+			t.Fatalf("%s: expected keys %v, got %v", tc.query, tc.expected, got)
+		}
+		for i := range got {
+			if got[i] != tc.expected[i] {
+				t.Errorf("%s: expected keys %v, got %v", tc.query, tc.expected, got)
+				break
+			}
+		}
+	}
+}
+
+func TestRESTServer_Probabilistic(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "rest_server_test_probabilistic_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	_, server := newTestRESTServer(t, tmpDir)
+	defer server.Close()
+
+	status, _ := doRequest(t, http.MethodPost, server.URL+"/bf/missing/check", []byte("x"))
+	if status != http.StatusNotFound {
+		t.Errorf("Expected status %d for a missing filter, got %d", http.StatusNotFound, status)
+	}
+
+	status, _ = doRequest(t, http.MethodPut, server.URL+"/bf/users?n=100&p=0.01", nil)
+	if status != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, status)
+	}
+	status, _ = doRequest(t, http.MethodPost, server.URL+"/bf/users/add", []byte("alice"))
+	if status != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, status)
+	}
+	status, body := doRequest(t, http.MethodPost, server.URL+"/bf/users/check", []byte("alice"))
+	if status != http.StatusOK || string(body) != "{\"present\":true}\n" {
+		t.Errorf("Expected alice to be present, got %d %s", status, body)
+	}
+
+	status, _ = doRequest(t, http.MethodPut, server.URL+"/hll/visits?p=abc", nil)
+	if status != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an invalid parameter, got %d", http.StatusBadRequest, status)
+	}
+	status, _ = doRequest(t, http.MethodPut, server.URL+"/hll/visits?p=4", nil)
+	if status != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, status)
+	}
+	for _, v := range []string{"a", "b", "c"} {
+		doRequest(t, http.MethodPost, server.URL+"/hll/visits/add", []byte(v))
+	}
+	status, body = doRequest(t, http.MethodGet, server.URL+"/hll/visits/estimate", nil)
+	var estimate map[string]float64
+	if status != http.StatusOK || json.Unmarshal(body, &estimate) != nil || estimate["estimate"] <= 0 {
+		t.Errorf("Expected a positive estimate, got %d %s", status, body)
+	}
+}
+
+func TestRESTServer_RateLimit(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "rest_server_test_rate_limit_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db, server := newTestRESTServer(t, tmpDir, func(config *util.Config) {
+		config.TokenBucket.Interval = 1_000_000 // definitely long enough not to reset during the test
+	})
+	defer server.Close()
+
+	for i := 0; i < int(db.config.TokenBucket.MaxTokenSize); i++ {
+		doRequest(t, http.MethodGet, server.URL+"/kv/key", nil)
+	}
+
+	status, _ := doRequest(t, http.MethodGet, server.URL+"/kv/key", nil)
+	if status != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, status)
+	}
+}
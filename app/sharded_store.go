@@ -0,0 +1,427 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"nasp-project/structures/hash_ring"
+	"nasp-project/util"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// shardVirtualNodes is the number of points of every shard on the hash ring.
+const shardVirtualNodes = 128
+
+// Errors returned by the ShardedStore methods.
+var (
+	ErrMigrationInProgress = errors.New("shard migration in progress")
+	ErrUnknownShard        = errors.New("unknown shard")
+)
+
+// ShardedStore spreads the keys over independent KeyValueStore instances, each with its own directory, WAL
+// and compaction pipeline, with a consistent hash ring. Operations on different shards run in parallel,
+// so the store can use all cores and disks. It is safe for concurrent use.
+//
+// Adding or removing a shard moves the affected keys in the background. Until the migration is done,
+// a key is looked up on both its new and its previous shard, and a key is moved while both are locked,
+// so every key lives on exactly one shard at any time.
+type ShardedStore struct {
+	config *util.Config
+
+	mu       sync.RWMutex // guards the fields below, held for reading by every operation
+	shards   map[string]*KeyValueStore
+	ring     *hash_ring.HashRing
+	previous *hash_ring.HashRing // ring before the running migration, nil if none
+	removed  string              // shard removed by the running migration, closed when it is done
+	migrated chan struct{}       // closed when the running migration is done
+	stop     chan struct{}
+}
+
+// NewShardedStore opens a KeyValueStore in every directory and places the keys on them with a consistent hash ring.
+// The directories identify the shards on the ring, so the same directories must be passed after a restart.
+// Keys that are on the wrong shard, e.g. after a migration was interrupted, are moved before it returns.
+// Every shard stores its SSTables and WAL in its directory, the rest of the config is shared.
+// Returns an error if a store can't be opened or the keys can't be moved.
+func NewShardedStore(config *util.Config, dirs ...string) (*ShardedStore, error) {
+	if len(dirs) == 0 {
+		return nil, errors.New("no shard directories")
+	}
+	s := &ShardedStore{
+		config: config,
+		shards: map[string]*KeyValueStore{},
+		ring:   hash_ring.NewHashRing(shardVirtualNodes),
+		stop:   make(chan struct{}),
+	}
+	for _, dir := range dirs {
+		kvs, err := s.openShard(dir)
+		if err != nil {
+			_ = s.Close()
+			return nil, err
+		}
+		s.shards[dir] = kvs
+		s.ring.Add(dir)
+	}
+
+	// the previous owner of a misplaced key is unknown, so every shard is scanned
+	if err := s.migrate(s.ring.Members()); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *ShardedStore) openShard(dir string) (*KeyValueStore, error) {
+	config := *s.config
+	config.SSTable.SavePath = path.Join(dir, "sstable")
+	config.WAL.WALFolderPath = path.Join(dir, "wal")
+	kvs, err := NewKeyValueStore(&config)
+	if err != nil {
+		return nil, err
+	}
+	kvs.startCheckpoints()
+	return kvs, nil
+}
+
+// Shards returns the directories of the shards on the hash ring.
+func (s *ShardedStore) Shards() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ring.Members()
+}
+
+// Close waits for a running migration and closes all shards.
+func (s *ShardedStore) Close() error {
+	close(s.stop)
+	s.WaitForMigration()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for _, kvs := range s.shards {
+		errs = append(errs, kvs.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// owners returns the shard of the key and, during a migration, the shard it was on before if that is another one.
+// Must be called with s.mu held.
+func (s *ShardedStore) owners(key string) (*KeyValueStore, *KeyValueStore) {
+	owner := s.shards[s.ring.Lookup([]byte(key))]
+	if s.previous == nil {
+		return owner, nil
+	}
+	previous := s.shards[s.previous.Lookup([]byte(key))]
+	if previous == owner {
+		return owner, nil
+	}
+	return owner, previous
+}
+
+// lockShards locks the given shards in a fixed order, so that concurrent calls can't deadlock. previous may be nil.
+// Returns the function that unlocks them.
+func lockShards(owner, previous *KeyValueStore) func() {
+	if previous == nil {
+		owner.mu.Lock()
+		return owner.mu.Unlock
+	}
+	first, second := owner, previous
+	if shardLess(previous, owner) {
+		first, second = previous, owner
+	}
+	first.mu.Lock()
+	second.mu.Lock()
+	return func() {
+		second.mu.Unlock()
+		first.mu.Unlock()
+	}
+}
+
+// shardLess orders the shards by their directory, the order they are locked in.
+func shardLess(a, b *KeyValueStore) bool {
+	return a.config.WAL.WALFolderPath < b.config.WAL.WALFolderPath
+}
+
+// Get returns the value of a key from its shard, see KeyValueStore.Get.
+func (s *ShardedStore) Get(key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	owner, previous := s.owners(key)
+	defer lockShards(owner, previous)()
+
+	value, err := owner.Get(key)
+	if err != nil || value != nil || previous == nil {
+		return value, err
+	}
+	return previous.get(key)
+}
+
+// Put saves a key-value pair on its shard, see KeyValueStore.Put.
+func (s *ShardedStore) Put(key string, value []byte) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	owner, previous := s.owners(key)
+	defer lockShards(owner, previous)()
+
+	if err := owner.Put(key, value); err != nil {
+		return err
+	}
+	return deleteMoved(previous, key)
+}
+
+// Delete deletes a key from its shard, see KeyValueStore.Delete.
+func (s *ShardedStore) Delete(key string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	owner, previous := s.owners(key)
+	defer lockShards(owner, previous)()
+
+	if err := owner.Delete(key); err != nil {
+		return err
+	}
+	return deleteMoved(previous, key)
+}
+
+// deleteMoved deletes a key that is not moved yet from its previous shard, so that the migration can't overwrite
+// a newer write or resurrect a deleted key. previous may be nil.
+func deleteMoved(previous *KeyValueStore, key string) error {
+	if previous == nil {
+		return nil
+	}
+	value, err := previous.get(key)
+	if err != nil || value == nil {
+		return err
+	}
+	return previous.delete(key)
+}
+
+// RangeScan returns a page of the records with keys in [minKey, maxKey] of all shards, see KeyValueStore.RangeScan.
+func (s *ShardedStore) RangeScan(minKey, maxKey string, pageNumber, pageSize int) ([]Record, error) {
+	return s.scan(pageNumber, pageSize, func(kvs *KeyValueStore, n int) ([]Record, error) {
+		return kvs.RangeScan(minKey, maxKey, 1, n)
+	})
+}
+
+// PrefixScan returns a page of the records with keys starting with prefix of all shards, see KeyValueStore.PrefixScan.
+func (s *ShardedStore) PrefixScan(prefix string, pageNumber, pageSize int) ([]Record, error) {
+	return s.scan(pageNumber, pageSize, func(kvs *KeyValueStore, n int) ([]Record, error) {
+		return kvs.PrefixScan(prefix, 1, n)
+	})
+}
+
+// scan fans out a scan for the first pageNumber pages to all shards in parallel and merges the results in key order.
+// During a migration all shards are locked for the whole scan, so that no key is missed or seen twice while it moves.
+func (s *ShardedStore) scan(pageNumber, pageSize int, scan func(kvs *KeyValueStore, n int) ([]Record, error)) ([]Record, error) {
+	if pageNumber < 1 || pageSize < 1 {
+		return nil, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	shards := make([]*KeyValueStore, 0, len(s.shards))
+	for _, kvs := range s.shards {
+		shards = append(shards, kvs)
+	}
+	if s.previous != nil {
+		sort.Slice(shards, func(i, j int) bool { return shardLess(shards[i], shards[j]) })
+		for _, kvs := range shards {
+			kvs.mu.Lock()
+			defer kvs.mu.Unlock()
+		}
+	}
+
+	results := make([][]Record, len(shards))
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i, kvs := range shards {
+		wg.Add(1)
+		go func(i int, kvs *KeyValueStore) {
+			defer wg.Done()
+			if s.previous == nil {
+				kvs.mu.Lock()
+				defer kvs.mu.Unlock()
+			}
+			results[i], errs[i] = scan(kvs, pageNumber*pageSize)
+		}(i, kvs)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return paginate(mergeRecords(results), pageNumber-1, pageSize), nil
+}
+
+// mergeRecords merges lists of records sorted by key into a single sorted list.
+func mergeRecords(lists [][]Record) []Record {
+	var merged []Record
+	positions := make([]int, len(lists))
+	for {
+		next := -1
+		for i, list := range lists {
+			if positions[i] == len(list) {
+				continue
+			}
+			if next == -1 || list[positions[i]].Key < lists[next][positions[next]].Key {
+				next = i
+			}
+		}
+		if next == -1 {
+			return merged
+		}
+		merged = append(merged, lists[next][positions[next]])
+		positions[next]++
+	}
+}
+
+// AddShard opens a new shard in the directory and moves the keys it owns to it in the background.
+// Returns ErrMigrationInProgress if a migration is running, or an error if the shard exists or can't be opened.
+func (s *ShardedStore) AddShard(dir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.previous != nil {
+		return ErrMigrationInProgress
+	}
+	if s.shards[dir] != nil {
+		return fmt.Errorf("shard %s already exists", dir)
+	}
+	kvs, err := s.openShard(dir)
+	if err != nil {
+		return err
+	}
+
+	previous := s.ring.Clone()
+	s.shards[dir] = kvs
+	s.ring.Add(dir)
+	s.startMigration(previous, "")
+	return nil
+}
+
+// RemoveShard moves all keys of the shard in the directory to the other shards in the background
+// and closes it when they are moved. The directory is not deleted.
+// Returns ErrMigrationInProgress if a migration is running, ErrUnknownShard if the shard doesn't exist,
+// or an error if it is the last shard.
+func (s *ShardedStore) RemoveShard(dir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.previous != nil {
+		return ErrMigrationInProgress
+	}
+	if s.shards[dir] == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownShard, dir)
+	}
+	if len(s.shards) == 1 {
+		return errors.New("can't remove the last shard")
+	}
+
+	previous := s.ring.Clone()
+	s.ring.Remove(dir)
+	s.startMigration(previous, dir)
+	return nil
+}
+
+// WaitForMigration blocks until the running migration, if any, is done.
+func (s *ShardedStore) WaitForMigration() {
+	s.mu.RLock()
+	migrated := s.migrated
+	s.mu.RUnlock()
+	if migrated != nil {
+		<-migrated
+	}
+}
+
+// startMigration moves the keys whose shard changed from the previous ring in the background, retrying until
+// all keys are moved or the store is closed. Must be called with s.mu held.
+func (s *ShardedStore) startMigration(previous *hash_ring.HashRing, removed string) {
+	s.previous, s.removed = previous, removed
+	s.migrated = make(chan struct{})
+	sources := previous.Members()
+
+	go func() {
+		for {
+			err := s.migrate(sources)
+			if err == nil {
+				break
+			}
+			util.Logger().Error("shard migration failed", util.LogKeyError, err)
+			select {
+			case <-s.stop:
+				close(s.migrated)
+				return
+			case <-time.After(time.Second):
+			}
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.removed != "" {
+			if err := s.shards[s.removed].Close(); err != nil {
+				util.Logger().Error("failed to close the removed shard", "shard", s.removed, util.LogKeyError, err)
+			}
+			delete(s.shards, s.removed)
+		}
+		util.Logger().Info("shard migration done", "shards", len(s.shards))
+		s.previous, s.removed = nil, ""
+		close(s.migrated)
+	}()
+}
+
+// migrate moves the keys of the given shards that belong to another shard on the current ring.
+func (s *ShardedStore) migrate(sources []string) error {
+	for _, source := range sources {
+		s.mu.RLock()
+		kvs := s.shards[source]
+		kvs.mu.Lock()
+		keys, err := s.misplacedKeys(source, kvs)
+		kvs.mu.Unlock()
+		s.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := s.move(key, kvs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// misplacedKeys returns the regular keys of the shard that belong to another shard.
+// Must be called with s.mu and kvs.mu held.
+func (s *ShardedStore) misplacedKeys(name string, kvs *KeyValueStore) ([]string, error) {
+	iter, err := kvs.recordIterator("")
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Stop()
+
+	var keys []string
+	for rec := iter.Next(); rec != nil; rec = iter.Next() {
+		if !rec.Tombstone && !util.IsReservedKey(rec.Key) && s.ring.Lookup(rec.Key) != name {
+			keys = append(keys, string(rec.Key))
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// move moves a key from the source shard to its shard, keeping the timestamp of the record.
+// The key is skipped if it was written or deleted since it was found, the write already moved it.
+func (s *ShardedStore) move(key string, source *KeyValueStore) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	owner := s.shards[s.ring.Lookup([]byte(key))]
+	defer lockShards(owner, source)()
+
+	rec, err := source.read(key, nil)
+	if err != nil || rec == nil {
+		return err
+	}
+	if err := owner.putRecord(rec); err != nil {
+		return err
+	}
+	return source.delete(key)
+}
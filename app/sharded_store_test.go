@@ -0,0 +1,191 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"nasp-project/util"
+	"os"
+	"path"
+	"sync"
+	"testing"
+)
+
+func TestShardedStore(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sharded_store_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := *util.GetConfig()
+	dirs := []string{path.Join(tmpDir, "s1"), path.Join(tmpDir, "s2"), path.Join(tmpDir, "s3")}
+	store, err := NewShardedStore(&config, dirs...)
+	if err != nil {
+		t.Fatalf("Failed to create the sharded store: %v", err)
+	}
+
+	const keys = 200
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < keys; i += 4 {
+				if err := store.Put(fmt.Sprintf("key%03d", i), []byte(fmt.Sprint(i))); err != nil {
+					t.Errorf("Failed to put: %v", err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if err := store.Delete("key000"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+
+	for _, dir := range dirs {
+		kvs := store.shards[dir]
+		kvs.mu.Lock()
+		recs, _ := kvs.PrefixScan("key", 1, keys)
+		kvs.mu.Unlock()
+		if len(recs) == 0 || len(recs) == keys-1 {
+			t.Errorf("Expected the keys to be spread over the shards, shard %s has %d", dir, len(recs))
+		}
+	}
+
+	checkScans := func() {
+		t.Helper()
+		page, err := store.PrefixScan("key", 2, 10)
+		if err != nil || len(page) != 10 || page[0].Key != "key011" || page[9].Key != "key020" {
+			t.Errorf("Expected keys key011 to key020 on the second page, got %v, %v", page, err)
+		}
+		recs, err := store.RangeScan("key190", "key195", 1, 100)
+		if err != nil || len(recs) != 6 || recs[0].Key != "key190" || string(recs[5].Value) != "195" {
+			t.Errorf("Expected keys key190 to key195, got %v, %v", recs, err)
+		}
+		all, err := store.PrefixScan("key", 1, 1000)
+		if err != nil || len(all) != keys-1 {
+			t.Errorf("Expected %d keys, got %d, %v", keys-1, len(all), err)
+		}
+	}
+	checkScans()
+
+	// keys keep their values while they move to the new shard
+	added := path.Join(tmpDir, "s4")
+	if err := store.AddShard(added); err != nil {
+		t.Fatalf("Failed to add a shard: %v", err)
+	}
+	if err := store.RemoveShard(dirs[0]); !errors.Is(err, ErrMigrationInProgress) {
+		t.Errorf("Expected ErrMigrationInProgress, got %v", err)
+	}
+	if err := store.Put("key001", []byte("updated")); err != nil {
+		t.Fatalf("Failed to put during the migration: %v", err)
+	}
+	store.WaitForMigration()
+	checkScans()
+	kvs := store.shards[added]
+	kvs.mu.Lock()
+	recs, _ := kvs.PrefixScan("key", 1, keys)
+	kvs.mu.Unlock()
+	if len(recs) == 0 {
+		t.Errorf("Expected keys to move to the new shard")
+	}
+
+	if err := store.RemoveShard(dirs[0]); err != nil {
+		t.Fatalf("Failed to remove a shard: %v", err)
+	}
+	store.WaitForMigration()
+	if shards := store.Shards(); len(shards) != 3 {
+		t.Errorf("Expected 3 shards, got %v", shards)
+	}
+	checkScans()
+	for i := 1; i < keys; i++ {
+		expected := fmt.Sprint(i)
+		if i == 1 {
+			expected = "updated"
+		}
+		if value, err := store.Get(fmt.Sprintf("key%03d", i)); err != nil || string(value) != expected {
+			t.Errorf("Expected %q for key%03d, got %q, %v", expected, i, value, err)
+		}
+	}
+	if value, err := store.Get("key000"); err != nil || value != nil {
+		t.Errorf("Expected the deleted key to stay deleted, got %q, %v", value, err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	// the shards keep their keys after a restart
+	store, err = NewShardedStore(&config, dirs[1], dirs[2], added)
+	if err != nil {
+		t.Fatalf("Failed to reopen the sharded store: %v", err)
+	}
+	defer store.Close()
+	checkScans()
+}
+
+func TestShardedStore_CompactionStats(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sharded_store_test_compaction_stats_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := *util.GetConfig()
+	config.Memtable.MaxSize = 10
+	config.LSMTree.CompactionAlgorithm = "Size-Tiered"
+	config.LSMTree.SizeTiered.MaxLsmNodesPerLevel = 2
+	config.TokenBucket.MaxTokenSize = 100000
+	dirs := []string{path.Join(tmpDir, "s1"), path.Join(tmpDir, "s2"), path.Join(tmpDir, "s3")}
+	store, err := NewShardedStore(&config, dirs...)
+	if err != nil {
+		t.Fatalf("Failed to create the sharded store: %v", err)
+	}
+	defer store.Close()
+	listeners := map[string]*countingListener{}
+	for _, dir := range dirs {
+		listeners[dir] = &countingListener{}
+		store.shards[dir].AddEventListener(listeners[dir])
+	}
+
+	// the shards compact at the same time
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < 400; i += 4 {
+				if err := store.Put(fmt.Sprintf("key%03d", i), []byte(fmt.Sprint(i))); err != nil {
+					t.Errorf("Failed to put: %v", err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// every shard counts only its own compactions
+	total := uint64(0)
+	for _, dir := range dirs {
+		kvs := store.shards[dir]
+		kvs.mu.Lock()
+		stats, err := kvs.Stats()
+		kvs.mu.Unlock()
+		if err != nil {
+			t.Fatalf("Failed to get stats: %v", err)
+		}
+		compactions := listeners[dir].compactions
+		bytesRead := uint64(0)
+		for _, info := range compactions {
+			bytesRead += info.BytesRead
+		}
+		if stats.Compaction.Compactions != uint64(len(compactions)) || stats.Compaction.BytesRead != bytesRead {
+			t.Errorf("Expected %d compactions that read %d bytes in shard %s, got %+v", len(compactions), bytesRead, dir, stats.Compaction)
+		}
+		if len(compactions) > 0 && stats.Compaction.LastBytesRead != compactions[len(compactions)-1].BytesRead {
+			t.Errorf("Expected the last compaction of shard %s to read %d bytes, got %d", dir, compactions[len(compactions)-1].BytesRead, stats.Compaction.LastBytesRead)
+		}
+		total += stats.Compaction.Compactions
+	}
+	if total == 0 {
+		t.Errorf("Expected the shards to compact")
+	}
+}
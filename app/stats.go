@@ -0,0 +1,108 @@
+package app
+
+import (
+	"nasp-project/raft"
+	"nasp-project/structures/block_cache"
+	"nasp-project/structures/lsm"
+	"nasp-project/structures/lsm/compactions"
+	"nasp-project/structures/memtable"
+	"nasp-project/structures/sstable"
+)
+
+// Stats is a snapshot of the internal state of the engine.
+type Stats struct {
+	Memtables   []memtable.MemtableStats // indexed by memtable instance
+	Levels      []LevelStats             // indexed by level number - 1
+	CacheHits   uint64
+	CacheMisses uint64
+	BlockCache  *BlockCacheStats // nil if the block cache is off
+	OpenFiles   sstable.OpenFilesStats
+	WALSegments int
+	WALBytes    int64
+	Compaction  compactions.Stats
+	Replication *ReplicaStatus // nil unless the store follows a leader
+	Cluster     *raft.Status   // nil unless the store is a member of a Raft cluster
+}
+
+// BlockCacheStats describes the block cache of SSTable blocks.
+type BlockCacheStats struct {
+	Bytes     uint64
+	Capacity  uint64
+	Evictions uint64
+	Kinds     map[string]block_cache.Stats // by kind of block: filter, summary, dictionary, index or data
+}
+
+// LevelStats describes a single LSM Tree level.
+type LevelStats struct {
+	Level    int
+	SSTables []SSTableStats
+	Bytes    int64 // total size of all SSTables on the level
+}
+
+// SSTableStats describes a single SSTable.
+type SSTableStats struct {
+	Label  int
+	Bytes  int64
+	Filter sstable.FilterStats
+}
+
+// CacheHitRate returns the ratio of cache hits to all cache lookups, or 0 if there were no lookups.
+func (s *Stats) CacheHitRate() float64 {
+	if s.CacheHits+s.CacheMisses == 0 {
+		return 0
+	}
+	return float64(s.CacheHits) / float64(s.CacheHits+s.CacheMisses)
+}
+
+// Stats returns a snapshot of the engine statistics.
+// Unlike regular operations, Stats does not consume rate limit tokens.
+// Returns an error if the LSM Tree or the WAL could not be read.
+func (kvs *KeyValueStore) Stats() (*Stats, error) {
+	stats := &Stats{
+		Memtables:  kvs.memtables.Stats(),
+		Compaction: kvs.compactionStats,
+		OpenFiles:  sstable.GetOpenFilesStats(),
+	}
+
+	stats.CacheHits, stats.CacheMisses = kvs.cache.Stats()
+	if cache := sstable.GetBlockCache(); cache != nil {
+		stats.BlockCache = &BlockCacheStats{
+			Evictions: cache.Evictions(),
+			Kinds:     cache.Stats(),
+		}
+		stats.BlockCache.Bytes, stats.BlockCache.Capacity = cache.Size()
+	}
+	if kvs.replica != nil {
+		status := kvs.replica.Status()
+		stats.Replication = &status
+	}
+	if kvs.cluster != nil {
+		status := kvs.cluster.node.Status()
+		stats.Cluster = &status
+	}
+
+	var err error
+	stats.WALSegments, stats.WALBytes, err = kvs.wal.Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	for lvl := 1; lvl <= kvs.config.LSMTree.MaxLevel; lvl++ {
+		tables, err := lsm.GetSSTablesForLevel(kvs.config.SSTable.SavePath, lvl)
+		if err != nil {
+			return nil, err
+		}
+		level := LevelStats{Level: lvl}
+		for _, table := range tables {
+			level.SSTables = append(level.SSTables, SSTableStats{
+				Label:  lsm.GetLabelNumFromSSTable(table),
+				Bytes:  table.Size(),
+				Filter: sstable.GetFilterStats(table.TOCFilename),
+			})
+			level.Bytes += table.Size()
+		}
+		stats.Levels = append(stats.Levels, level)
+	}
+
+	return stats, nil
+}
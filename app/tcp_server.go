@@ -0,0 +1,100 @@
+package app
+
+import (
+	"bufio"
+	"errors"
+	"nasp-project/util"
+	"net"
+	"sync"
+)
+
+// TCPServer accepts TCP connections and serves each of them in its own goroutine
+// with a protocol specific handler.
+type TCPServer struct {
+	listener net.Listener
+	handle   func(conn *bufio.ReadWriter, remoteAddr string) error
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// serveTCP starts a TCPServer on the given address that serves every connection with handle.
+// handle returns when the client disconnects or the protocol is violated.
+func serveTCP(address string, handle func(conn *bufio.ReadWriter, remoteAddr string) error) (*TCPServer, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &TCPServer{
+		listener: listener,
+		handle:   handle,
+		conns:    map[net.Conn]struct{}{},
+	}
+	s.wg.Add(1)
+	go s.acceptLoop()
+
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *TCPServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops accepting connections, closes all open connections and waits for their handlers to return.
+func (s *TCPServer) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	err := s.listener.Close()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *TCPServer) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				util.Logger().Error("failed to accept a connection", util.LogKeyError, err)
+			}
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+func (s *TCPServer) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	err := s.handle(rw, conn.RemoteAddr().String())
+	if err != nil {
+		util.Logger().Debug("connection closed", "remote", conn.RemoteAddr().String(), util.LogKeyError, err)
+	}
+}
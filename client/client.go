@@ -0,0 +1,347 @@
+// Package client is a Go client for a KeyValueStore served over HTTP (see app.KeyValueStore.ServeREST).
+// The Client mirrors the KeyValueStore API and returns the same errors, e.g. app.ErrRateLimitReached.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"nasp-project/app"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options configures a Client.
+type Options struct {
+	MaxConns       int           // maximum number of connections to the server, idle ones are kept for reuse
+	Timeout        time.Duration // timeout of a single request, 0 for none
+	MaxRetries     int           // retries of a request rejected by the rate limiter, 0 to fail immediately
+	InitialBackoff time.Duration // wait before the first retry, doubled after every retry
+	MaxBackoff     time.Duration // upper bound of the wait between retries
+	ScanPageSize   int           // number of records fetched at once by iterators
+	Token          string        // token of the user if the server requires authentication, preferred over the password
+	Username       string        // name of the user if the server requires authentication
+	Password       string        // password of the user if the server requires authentication
+}
+
+// DefaultOptions are used by Dial.
+var DefaultOptions = Options{
+	MaxConns:       16,
+	Timeout:        30 * time.Second,
+	MaxRetries:     5,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	ScanPageSize:   100,
+}
+
+// Client is a connection pool to a single server. It is safe for concurrent use.
+type Client struct {
+	baseURL string
+	http    *http.Client
+	opts    Options
+}
+
+// Dial connects to the server at addr with DefaultOptions.
+// addr is either host:port or an http(s) URL.
+// Returns an error if the server is not reachable or not healthy.
+func Dial(addr string) (*Client, error) {
+	return DialWithOptions(addr, DefaultOptions)
+}
+
+// DialWithOptions connects to the server at addr with the given options.
+// Returns an error if the server is not reachable or not healthy.
+func DialWithOptions(addr string, opts Options) (*Client, error) {
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		addr = "http://" + addr
+	}
+	if opts.ScanPageSize < 1 {
+		opts.ScanPageSize = DefaultOptions.ScanPageSize
+	}
+	opts.ScanPageSize = min(opts.ScanPageSize, maxPageSize)
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxConnsPerHost = opts.MaxConns
+	transport.MaxIdleConnsPerHost = opts.MaxConns
+
+	c := &Client{
+		baseURL: strings.TrimSuffix(addr, "/"),
+		http:    &http.Client{Transport: transport, Timeout: opts.Timeout},
+		opts:    opts,
+	}
+
+	// the health endpoint requires no credentials and consumes no rate limit token
+	resp, err := c.http.Get(c.baseURL + "/health")
+	if err != nil {
+		return nil, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s/health: unexpected status %d", c.baseURL, resp.StatusCode)
+	}
+
+	return c, nil
+}
+
+// Close closes the idle connections. The Client must not be used after Close.
+func (c *Client) Close() error {
+	c.http.CloseIdleConnections()
+	return nil
+}
+
+// do sends a request and retries it with exponential backoff while it is rejected by the rate limiter.
+// Returns the status code and the body of the response.
+func (c *Client) do(method, path string, query url.Values, body []byte) (int, []byte, error) {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	backoff := c.opts.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(method, u, bytes.NewReader(body))
+		if err != nil {
+			return 0, nil, err
+		}
+		if c.opts.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.opts.Token)
+		} else if c.opts.Username != "" {
+			req.SetBasicAuth(c.opts.Username, c.opts.Password)
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return 0, nil, err
+		}
+		respBody, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if resp.StatusCode != http.StatusTooManyRequests || attempt >= c.opts.MaxRetries {
+			return resp.StatusCode, respBody, nil
+		}
+
+		// full jitter, so that rejected clients do not retry in lockstep
+		time.Sleep(time.Duration(rand.Int63n(int64(backoff) + 1)))
+		backoff = min(2*backoff, c.opts.MaxBackoff)
+	}
+}
+
+// responseError converts an error response to the matching app error.
+func responseError(status int, body []byte) error {
+	var resp struct {
+		Error string `json:"error"`
+	}
+	_ = json.Unmarshal(body, &resp)
+
+	switch {
+	case status == http.StatusTooManyRequests:
+		return app.ErrRateLimitReached
+	case status == http.StatusNotFound:
+		return fmt.Errorf("%s: %w", resp.Error, app.ErrNotFound)
+	case status == http.StatusConflict && resp.Error == app.ErrReadOnly.Error():
+		return app.ErrReadOnly
+	case status == http.StatusUnauthorized:
+		return app.ErrAuthenticationFailed
+	case status == http.StatusForbidden:
+		return fmt.Errorf("%s: %w", strings.TrimPrefix(resp.Error, app.ErrPermissionDenied.Error()+": "), app.ErrPermissionDenied)
+	case status == http.StatusBadRequest && resp.Error == app.ErrReservedKey.Error():
+		return app.ErrReservedKey
+	case resp.Error != "":
+		return errors.New(resp.Error)
+	default:
+		return fmt.Errorf("unexpected status %d", status)
+	}
+}
+
+// call sends a request and decodes a successful JSON response into out, if out is not nil.
+func (c *Client) call(method, path string, query url.Values, body []byte, out any) error {
+	status, respBody, err := c.do(method, path, query, body)
+	if err != nil {
+		return err
+	}
+	if status >= 300 {
+		return responseError(status, respBody)
+	}
+	if out != nil {
+		return json.Unmarshal(respBody, out)
+	}
+	return nil
+}
+
+func keyPath(prefix, key string, suffix ...string) string {
+	p := prefix + url.PathEscape(key)
+	for _, s := range suffix {
+		p += "/" + s
+	}
+	return p
+}
+
+// Get returns a value associated with the specified key.
+// Returns nil if the key is not found.
+func (c *Client) Get(key string) ([]byte, error) {
+	status, body, err := c.do(http.MethodGet, keyPath("/kv/", key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+	if status != http.StatusOK {
+		return nil, responseError(status, body)
+	}
+	return body, nil
+}
+
+// Put saves a key-value pair.
+func (c *Client) Put(key string, value []byte) error {
+	return c.call(http.MethodPut, keyPath("/kv/", key), nil, value, nil)
+}
+
+// Delete deletes a value associated with the specified key.
+func (c *Client) Delete(key string) error {
+	return c.call(http.MethodDelete, keyPath("/kv/", key), nil, nil, nil)
+}
+
+// RangeScan returns the page with the given 1-indexed number of records with keys in range [minKey, maxKey].
+func (c *Client) RangeScan(minKey, maxKey string, pageNumber, pageSize int) ([]app.Record, error) {
+	return c.scanPage(&Iterator{
+		c:     c,
+		path:  "/scan",
+		query: url.Values{"start": {minKey}, "end": {maxKey}},
+	}, pageNumber, pageSize)
+}
+
+// PrefixScan returns the page with the given 1-indexed number of records with the given key prefix.
+func (c *Client) PrefixScan(prefix string, pageNumber, pageSize int) ([]app.Record, error) {
+	return c.scanPage(&Iterator{c: c, path: "/prefix", query: url.Values{"p": {prefix}}}, pageNumber, pageSize)
+}
+
+// scanPage skips the records of the previous pages and collects a single page using an unstarted iter.
+func (c *Client) scanPage(iter *Iterator, pageNumber, pageSize int) ([]app.Record, error) {
+	defer iter.Stop()
+	iter.pageSize = min(max(pageSize, 1), maxPageSize)
+
+	var recs []app.Record
+	for i := 0; i < pageNumber*pageSize; i++ {
+		key, val := iter.Next()
+		if key == "" {
+			break
+		}
+		if i >= (pageNumber-1)*pageSize {
+			recs = append(recs, app.Record{Key: key, Value: val})
+		}
+	}
+	return recs, iter.Err()
+}
+
+// NewBF creates a new bloom filter with the specified key, sized for n elements
+// with false-positive probability p.
+func (c *Client) NewBF(key string, n uint, p float64) error {
+	query := url.Values{"n": {strconv.FormatUint(uint64(n), 10)}, "p": {strconv.FormatFloat(p, 'g', -1, 64)}}
+	return c.call(http.MethodPut, keyPath("/bf/", key), query, nil, nil)
+}
+
+// DeleteBF deletes a bloom filter with the specified key.
+func (c *Client) DeleteBF(key string) error {
+	return c.call(http.MethodDelete, keyPath("/bf/", key), nil, nil, nil)
+}
+
+// BFAdd adds val to the bloom filter with the specified key.
+func (c *Client) BFAdd(key string, val []byte) error {
+	return c.call(http.MethodPost, keyPath("/bf/", key, "add"), nil, val, nil)
+}
+
+// BFHasKey checks if val may be in the bloom filter with the specified key.
+func (c *Client) BFHasKey(key string, val []byte) (bool, error) {
+	var resp struct {
+		Present bool `json:"present"`
+	}
+	err := c.call(http.MethodPost, keyPath("/bf/", key, "check"), nil, val, &resp)
+	return resp.Present, err
+}
+
+// NewCMS creates a new count-min sketch with the specified key.
+func (c *Client) NewCMS(key string, epsilon float64, delta float64) error {
+	query := url.Values{
+		"epsilon": {strconv.FormatFloat(epsilon, 'g', -1, 64)},
+		"delta":   {strconv.FormatFloat(delta, 'g', -1, 64)},
+	}
+	return c.call(http.MethodPut, keyPath("/cms/", key), query, nil, nil)
+}
+
+// DeleteCMS deletes a count-min sketch with the specified key.
+func (c *Client) DeleteCMS(key string) error {
+	return c.call(http.MethodDelete, keyPath("/cms/", key), nil, nil, nil)
+}
+
+// CMSAdd adds val to the count-min sketch with the specified key.
+func (c *Client) CMSAdd(key string, val []byte) error {
+	return c.call(http.MethodPost, keyPath("/cms/", key, "add"), nil, val, nil)
+}
+
+// CMSGet returns the estimated count of val in the count-min sketch with the specified key.
+func (c *Client) CMSGet(key string, val []byte) (int, error) {
+	var resp struct {
+		Count int `json:"count"`
+	}
+	err := c.call(http.MethodPost, keyPath("/cms/", key, "count"), nil, val, &resp)
+	if err != nil {
+		return -1, err
+	}
+	return resp.Count, nil
+}
+
+// NewHLL creates a new hyperloglog with the specified key and precision p.
+func (c *Client) NewHLL(key string, p uint32) error {
+	query := url.Values{"p": {strconv.FormatUint(uint64(p), 10)}}
+	return c.call(http.MethodPut, keyPath("/hll/", key), query, nil, nil)
+}
+
+// DeleteHLL deletes a hyperloglog with the specified key.
+func (c *Client) DeleteHLL(key string) error {
+	return c.call(http.MethodDelete, keyPath("/hll/", key), nil, nil, nil)
+}
+
+// HLLAdd adds val to the hyperloglog with the specified key.
+func (c *Client) HLLAdd(key string, val []byte) error {
+	return c.call(http.MethodPost, keyPath("/hll/", key, "add"), nil, val, nil)
+}
+
+// HLLEstimate returns the estimated number of distinct values in the hyperloglog with the specified key.
+func (c *Client) HLLEstimate(key string) (float64, error) {
+	var resp struct {
+		Estimate float64 `json:"estimate"`
+	}
+	err := c.call(http.MethodGet, keyPath("/hll/", key, "estimate"), nil, nil, &resp)
+	if err != nil {
+		return -1, err
+	}
+	return resp.Estimate, nil
+}
+
+// SHAddFingerprint stores the sim hash fingerprint of text with the specified key.
+func (c *Client) SHAddFingerprint(key string, text string) error {
+	return c.call(http.MethodPut, keyPath("/simhash/", key), nil, []byte(text), nil)
+}
+
+// SHDeleteFingerprint deletes the sim hash fingerprint with the specified key.
+func (c *Client) SHDeleteFingerprint(key string) error {
+	return c.call(http.MethodDelete, keyPath("/simhash/", key), nil, nil, nil)
+}
+
+// SHGetHammingDistance returns the hamming distance between two sim hash fingerprints.
+func (c *Client) SHGetHammingDistance(key1 string, key2 string) (uint8, error) {
+	var resp struct {
+		Distance uint8 `json:"distance"`
+	}
+	err := c.call(http.MethodGet, "/simhash/distance", url.Values{"a": {key1}, "b": {key2}}, nil, &resp)
+	return resp.Distance, err
+}
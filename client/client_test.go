@@ -0,0 +1,165 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"nasp-project/app"
+	"nasp-project/util"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T, tmpDir string) *httptest.Server {
+	config := *util.GetConfig() // a copy, so that the tests don't change the shared configuration
+	config.SSTable.SavePath = path.Join(tmpDir, "sstable")
+	config.WAL.WALFolderPath = path.Join(tmpDir, "wal")
+
+	db, err := app.NewKeyValueStore(&config)
+	if err != nil {
+		t.Fatalf("Failed to create key-value store: %v", err)
+	}
+	return httptest.NewServer(db.RESTHandler())
+}
+
+func TestClient_KV(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "client_test_kv_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	server := newTestServer(t, tmpDir)
+	defer server.Close()
+
+	c, err := Dial(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Put("key/1", []byte{0, 1, 2}); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	value, err := c.Get("key/1")
+	if err != nil || string(value) != "\x00\x01\x02" {
+		t.Errorf("Expected value [0 1 2], got %v, %v", value, err)
+	}
+	if err := c.Delete("key/1"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	value, err = c.Get("key/1")
+	if err != nil || value != nil {
+		t.Errorf("Expected nil value, got %v, %v", value, err)
+	}
+
+	if _, err := c.Get(util.RateLimiterKey); !errors.Is(err, app.ErrReservedKey) {
+		t.Errorf("Expected ErrReservedKey, got %v", err)
+	}
+	if _, err := c.BFHasKey("missing", []byte("x")); !errors.Is(err, app.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestClient_Iterators(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "client_test_iterators_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	server := newTestServer(t, tmpDir)
+	defer server.Close()
+
+	opts := DefaultOptions
+	opts.ScanPageSize = 2
+	c, err := DialWithOptions(server.URL, opts)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := c.Put(fmt.Sprintf("a%d", i), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+	}
+	if err := c.Put("b0", []byte("v")); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+
+	var iter app.RecordIterator
+	iter, err = c.PrefixIterate("a")
+	if err != nil {
+		t.Fatalf("Failed to iterate: %v", err)
+	}
+	var keys []string
+	for key, _ := iter.Next(); key != ""; key, _ = iter.Next() {
+		keys = append(keys, key)
+	}
+	if iter.Err() != nil || fmt.Sprint(keys) != "[a0 a1 a2 a3 a4]" {
+		t.Errorf("Expected keys [a0 a1 a2 a3 a4], got %v, %v", keys, iter.Err())
+	}
+
+	recs, err := c.RangeScan("a1", "b9", 2, 2)
+	if err != nil || len(recs) != 2 || recs[0].Key != "a3" || string(recs[1].Value) != "v4" {
+		t.Errorf("Expected records a3 and a4, got %v, %v", recs, err)
+	}
+}
+
+func TestClient_DialUnhealthy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	if _, err := Dial(server.URL); err == nil {
+		t.Errorf("Expected dialing an unhealthy server to fail")
+	}
+}
+
+func TestClient_RetryRateLimit(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			return
+		}
+		if requests.Add(1) <= 2 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"error":"rate limit reached"}`))
+			return
+		}
+		_, _ = w.Write([]byte("value"))
+	}))
+	defer server.Close()
+
+	opts := DefaultOptions
+	opts.InitialBackoff = time.Millisecond
+	c, err := DialWithOptions(server.URL, opts)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer c.Close()
+
+	value, err := c.Get("key")
+	if err != nil || string(value) != "value" {
+		t.Errorf("Expected value after retries, got %q, %v", value, err)
+	}
+	if requests.Load() != 3 {
+		t.Errorf("Expected 3 requests, got %d", requests.Load())
+	}
+
+	requests.Store(0)
+	opts.MaxRetries = 1
+	c, err = DialWithOptions(server.URL, opts)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	if _, err := c.Get("key"); !errors.Is(err, app.ErrRateLimitReached) {
+		t.Errorf("Expected ErrRateLimitReached, got %v", err)
+	}
+}
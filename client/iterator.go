@@ -0,0 +1,105 @@
+package client
+
+import (
+	"nasp-project/app"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// maxPageSize is the largest page the server returns.
+const maxPageSize = 10_000
+
+// Iterator streams records from the server page by page. It implements app.RecordIterator.
+// Unlike app.Iterator it does not see a snapshot: writes made during the iteration may or may not be returned.
+type Iterator struct {
+	c        *Client
+	path     string
+	query    url.Values
+	pageSize int
+
+	page    []app.Record
+	cursor  string
+	started bool
+	done    bool
+	err     error
+}
+
+var _ app.RecordIterator = (*Iterator)(nil)
+
+// RangeIterate returns an Iterator through records with key in range [minKey, maxKey].
+// The first page is fetched immediately, the next ones lazily. Errors of the later pages are reported by Err.
+func (c *Client) RangeIterate(minKey, maxKey string) (*Iterator, error) {
+	return c.iterate("/scan", url.Values{"start": {minKey}, "end": {maxKey}})
+}
+
+// PrefixIterate returns an Iterator through records with the given key prefix.
+// The first page is fetched immediately, the next ones lazily. Errors of the later pages are reported by Err.
+func (c *Client) PrefixIterate(prefix string) (*Iterator, error) {
+	return c.iterate("/prefix", url.Values{"p": {prefix}})
+}
+
+func (c *Client) iterate(path string, query url.Values) (*Iterator, error) {
+	it := &Iterator{c: c, path: path, query: query, pageSize: c.opts.ScanPageSize}
+	it.fetch()
+	if it.err != nil {
+		return nil, it.err
+	}
+	return it, nil
+}
+
+// Next returns the next key-value pair, or an empty key when the iteration is over or failed.
+func (it *Iterator) Next() (key string, val []byte) {
+	if len(it.page) == 0 && !it.done {
+		it.fetch()
+	}
+	if len(it.page) == 0 {
+		return "", nil
+	}
+	rec := it.page[0]
+	it.page = it.page[1:]
+	return rec.Key, rec.Value
+}
+
+// fetch loads the next page. It marks the iteration as done when there are no more pages or the request fails.
+func (it *Iterator) fetch() {
+	query := url.Values{}
+	for k, v := range it.query {
+		query[k] = v
+	}
+	query.Set("limit", strconv.Itoa(it.pageSize))
+	if it.started {
+		query.Set("cursor", it.cursor)
+	}
+	it.started = true
+
+	var resp struct {
+		Records []struct {
+			Key   string `json:"key"`
+			Value []byte `json:"value"`
+		} `json:"records"`
+		NextCursor string `json:"next_cursor"`
+	}
+	if err := it.c.call(http.MethodGet, it.path, query, nil, &resp); err != nil {
+		it.err = err
+		it.done = true
+		return
+	}
+
+	for _, rec := range resp.Records {
+		it.page = append(it.page, app.Record{Key: rec.Key, Value: rec.Value})
+	}
+	it.cursor = resp.NextCursor
+	it.done = resp.NextCursor == ""
+}
+
+// Stop stops the iteration. Every subsequent call to Next returns an empty key.
+func (it *Iterator) Stop() {
+	it.page = nil
+	it.done = true
+}
+
+// Err returns the error of the failed page request, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
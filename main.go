@@ -1,11 +1,30 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"nasp-project/app"
+	"nasp-project/raft"
 	"nasp-project/util"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-v] [serve | repair]\n\n"+
+			"Without arguments starts the interactive console.\n"+
+			"serve exposes the store over the network until interrupted.\n"+
+			"repair checks the SSTables, rebuilds the damaged ones and prints a report.\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	verbose := flag.Bool("v", false, "log engine diagnostics (flushes, compactions, WAL rotation)")
+	flag.Parse()
+	util.SetLogger(util.NewConsoleLogger(os.Stderr, *verbose))
+
 	config := util.LoadConfig(util.ConfigPath)
 
 	db, err := app.NewKeyValueStore(config)
@@ -13,5 +32,121 @@ func main() {
 		panic(err)
 	}
 
-	app.Start(db)
+	if config.Metrics.Enabled {
+		server, err := db.ServeMetrics(config.Metrics.Address)
+		if err != nil {
+			util.Logger().Error("failed to start the metrics server", util.LogKeyError, err)
+		} else {
+			defer server.Close()
+		}
+	}
+
+	switch flag.Arg(0) {
+	case "":
+		app.Start(db)
+	case "serve":
+		if err := serve(db, config); err != nil {
+			util.Logger().Error("serve failed", util.LogKeyError, err)
+			os.Exit(1)
+		}
+	case "repair":
+		if err := db.Repair(os.Stdout); err != nil {
+			util.Logger().Error("repair failed", util.LogKeyError, err)
+			os.Exit(1)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// serve runs the network servers, and the replication and the cluster membership if configured, until SIGINT or SIGTERM,
+// then shuts them down and closes the store.
+func serve(db *app.KeyValueStore, config *util.Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var servers []*http.Server
+	var tcpServers []*app.TCPServer
+	var replica *app.Replica
+	if config.Replication.LeaderAddress != "" {
+		var err error
+		replica, err = db.StartReplica(config.Replication.LeaderAddress)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Following " + config.Replication.LeaderAddress)
+	}
+	if config.Replication.Address != "" {
+		server, err := db.ServeReplication(config.Replication.Address)
+		if err != nil {
+			return err
+		}
+		tcpServers = append(tcpServers, server)
+		fmt.Println("Serving replication on " + config.Replication.Address)
+	}
+	var cluster *app.Cluster
+	if config.Cluster.ID != "" {
+		storage, err := raft.NewFileStorage(config.Cluster.Dir)
+		if err != nil {
+			return err
+		}
+		defer storage.Close()
+		var ids []string
+		addresses := map[string]string{}
+		for _, peer := range config.Cluster.Peers {
+			ids = append(ids, peer.ID)
+			addresses[peer.ID] = "http://" + peer.Address
+		}
+		cluster, err = db.StartCluster(raft.DefaultConfig(config.Cluster.ID, ids), raft.NewHTTPTransport(addresses), storage)
+		if err != nil {
+			return err
+		}
+		server, err := cluster.Serve(config.Cluster.Address)
+		if err != nil {
+			cluster.Stop()
+			return err
+		}
+		servers = append(servers, server)
+		fmt.Println("Serving the cluster member " + config.Cluster.ID + " on " + config.Cluster.Address)
+	}
+	if config.Server.RESTAddress != "" {
+		server, err := db.ServeREST(config.Server.RESTAddress)
+		if err != nil {
+			return err
+		}
+		servers = append(servers, server)
+		fmt.Println("Serving HTTP on " + config.Server.RESTAddress)
+	}
+	if config.Server.RESPAddress != "" {
+		server, err := db.ServeRESP(config.Server.RESPAddress)
+		if err != nil {
+			return err
+		}
+		tcpServers = append(tcpServers, server)
+		fmt.Println("Serving RESP on " + config.Server.RESPAddress)
+	}
+	if config.Server.MemcachedAddress != "" {
+		server, err := db.ServeMemcached(config.Server.MemcachedAddress)
+		if err != nil {
+			return err
+		}
+		tcpServers = append(tcpServers, server)
+		fmt.Println("Serving memcached on " + config.Server.MemcachedAddress)
+	}
+
+	<-ctx.Done()
+	for _, server := range servers {
+		_ = server.Shutdown(context.Background())
+	}
+	for _, server := range tcpServers {
+		_ = server.Close()
+	}
+	if replica != nil {
+		replica.Stop()
+	}
+	if cluster != nil {
+		cluster.Stop()
+	}
+	return db.Close()
 }
@@ -0,0 +1,946 @@
+// Package raft implements the Raft consensus algorithm: leader election, log replication and log compaction
+// with snapshots. A Node replicates commands to a StateMachine on every node of a fixed cluster.
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"nasp-project/util"
+	"sync"
+	"time"
+)
+
+// Errors returned by the Node methods. Use errors.Is to check for them.
+var (
+	ErrNotLeader       = errors.New("not the leader")
+	ErrLeadershipLost  = errors.New("leadership lost, the command may or may not be applied")
+	ErrStopped         = errors.New("node stopped")
+	errInvalidSnapshot = errors.New("invalid snapshot")
+)
+
+// NotLeaderError is returned to the proposals and reads sent to a follower. It matches ErrNotLeader.
+type NotLeaderError struct {
+	Leader string // ID of the current leader, empty if unknown
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.Leader == "" {
+		return "not the leader, the leader is unknown"
+	}
+	return "not the leader, the leader is " + e.Leader
+}
+
+func (e *NotLeaderError) Is(target error) bool {
+	return target == ErrNotLeader
+}
+
+// Entry is a single entry of the replicated log. An entry with an empty Command is a no-op,
+// the leader appends one at the start of its term.
+type Entry struct {
+	Index   uint64 `json:"index"`
+	Term    uint64 `json:"term"`
+	Command []byte `json:"command,omitempty"`
+}
+
+// StateMachine is replicated by the Node. Its methods are called from a single goroutine.
+type StateMachine interface {
+	// Apply applies a committed command and returns its result.
+	Apply(command []byte) ([]byte, error)
+	// Snapshot returns the state after the last applied command.
+	Snapshot() ([]byte, error)
+	// Restore replaces the state with a snapshot.
+	Restore(snapshot []byte) error
+}
+
+// Config configures a Node.
+type Config struct {
+	ID                string
+	Peers             []string      // IDs of all nodes of the cluster, including ID
+	ElectionTimeout   time.Duration // a follower starts an election after a random timeout in [ElectionTimeout, 2*ElectionTimeout)
+	HeartbeatInterval time.Duration // must be well below ElectionTimeout
+	SnapshotThreshold uint64        // applied entries after which a snapshot is taken and the log is compacted
+	SnapshotChunkSize int           // bytes of a snapshot sent in a single InstallSnapshot request, 0 sends it whole
+}
+
+// DefaultConfig returns a Config with the default timeouts for the given node.
+func DefaultConfig(id string, peers []string) Config {
+	return Config{
+		ID:                id,
+		Peers:             peers,
+		ElectionTimeout:   300 * time.Millisecond,
+		HeartbeatInterval: 50 * time.Millisecond,
+		SnapshotThreshold: 1000,
+		SnapshotChunkSize: 1 << 20,
+	}
+}
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+func (r role) String() string {
+	return [...]string{"follower", "candidate", "leader"}[r]
+}
+
+type applyResult struct {
+	value []byte
+	err   error
+}
+
+// waiter is a proposal waiting to be applied.
+type waiter struct {
+	term   uint64
+	result chan applyResult
+}
+
+// Node is a member of a Raft cluster.
+type Node struct {
+	config    Config
+	fsm       StateMachine
+	transport Transport
+	storage   Storage
+
+	mu               sync.Mutex
+	role             role
+	state            HardState
+	leader           string
+	log              []Entry // log[0] holds the index and term of the snapshot, the entries follow
+	snapshot         *Snapshot
+	pendingSnapshot  *Snapshot // received from the leader, to be restored by the applier
+	receivedSnapshot *Snapshot // chunks received so far from the leader of receivedTerm
+	receivedTerm     uint64
+	sentSnapshot     map[string]snapshotProgress // chunks of the latest snapshot acknowledged by each peer
+	commitIndex      uint64
+	lastApplied      uint64
+	nextIndex        map[string]uint64
+	matchIndex       map[string]uint64
+	inFlight         map[string]bool   // an AppendEntries or InstallSnapshot is in flight to the peer
+	ackedRound       map[string]uint64 // latest heartbeat round acknowledged by the peer in the current term
+	round            uint64            // heartbeat rounds sent by the leader
+	electionDeadline time.Time
+	lastBroadcast    time.Time
+	waiters          map[uint64]*waiter
+	changed          chan struct{} // closed and replaced when the state changes, see notify
+	stopped          bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewNode creates a Node and restores its state from storage. The node starts as a follower when Start is called.
+// Returns an error if the storage can't be loaded or the snapshot can't be restored.
+func NewNode(config Config, fsm StateMachine, transport Transport, storage Storage) (*Node, error) {
+	state, snapshot, entries, err := storage.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Node{
+		config:       config,
+		fsm:          fsm,
+		transport:    transport,
+		storage:      storage,
+		state:        state,
+		log:          []Entry{{}},
+		nextIndex:    map[string]uint64{},
+		matchIndex:   map[string]uint64{},
+		inFlight:     map[string]bool{},
+		ackedRound:   map[string]uint64{},
+		waiters:      map[uint64]*waiter{},
+		sentSnapshot: map[string]snapshotProgress{},
+		changed:      make(chan struct{}),
+		stop:         make(chan struct{}),
+	}
+	if snapshot != nil {
+		if err := fsm.Restore(snapshot.Data); err != nil {
+			return nil, err
+		}
+		n.snapshot = snapshot
+		n.log[0] = Entry{Index: snapshot.Index, Term: snapshot.Term}
+		n.commitIndex, n.lastApplied = snapshot.Index, snapshot.Index
+	}
+	n.log = append(n.log, entries...)
+	return n, nil
+}
+
+// ID returns the ID of the node.
+func (n *Node) ID() string {
+	return n.config.ID
+}
+
+// Start starts the election timer and the applier in the background.
+func (n *Node) Start() {
+	n.mu.Lock()
+	n.resetElectionTimer()
+	n.mu.Unlock()
+
+	n.wg.Add(2)
+	go n.tickLoop()
+	go n.applyLoop()
+}
+
+// Stop stops the node and waits for its goroutines. Pending proposals fail with ErrStopped.
+func (n *Node) Stop() {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return
+	}
+	n.stopped = true
+	close(n.stop)
+	n.notify()
+	n.mu.Unlock()
+	n.wg.Wait()
+}
+
+// Leader returns the ID of the current leader as known by this node, or an empty string if unknown.
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader
+}
+
+// IsLeader returns true if the node believes it is the leader.
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role == leader
+}
+
+// Status describes the state of a Node.
+type Status struct {
+	ID          string
+	Role        string
+	Term        uint64
+	Leader      string
+	CommitIndex uint64
+	LastApplied uint64
+	LastIndex   uint64
+	Snapshot    uint64 // index of the latest snapshot
+}
+
+// Status returns a snapshot of the state of the node.
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return Status{
+		ID:          n.config.ID,
+		Role:        n.role.String(),
+		Term:        n.state.Term,
+		Leader:      n.leader,
+		CommitIndex: n.commitIndex,
+		LastApplied: n.lastApplied,
+		LastIndex:   n.lastIndex(),
+		Snapshot:    n.log[0].Index,
+	}
+}
+
+// Propose appends a command to the log and waits until it is committed and applied on this node.
+// Returns the result of StateMachine.Apply.
+// Returns a NotLeaderError if the node is not the leader, ErrLeadershipLost if the node lost the leadership
+// before the command was committed, or the error of ctx.
+func (n *Node) Propose(ctx context.Context, command []byte) ([]byte, error) {
+	if len(command) == 0 {
+		return nil, errors.New("empty command")
+	}
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return nil, ErrStopped
+	}
+	if n.role != leader {
+		err := &NotLeaderError{Leader: n.leader}
+		n.mu.Unlock()
+		return nil, err
+	}
+	entry, err := n.appendLocal(command)
+	if err != nil {
+		n.mu.Unlock()
+		return nil, err
+	}
+	w := &waiter{term: entry.Term, result: make(chan applyResult, 1)}
+	n.waiters[entry.Index] = w
+	n.broadcast()
+	n.mu.Unlock()
+
+	select {
+	case res := <-w.result:
+		return res.value, res.err
+	case <-ctx.Done():
+		n.mu.Lock()
+		delete(n.waiters, entry.Index)
+		n.mu.Unlock()
+		return nil, ctx.Err()
+	case <-n.stop:
+		return nil, ErrStopped
+	}
+}
+
+// ReadIndex waits until reading the local StateMachine is linearizable: the node confirms with a majority
+// that it is still the leader, and applies all entries committed before the call.
+// Returns a NotLeaderError if the node is not the leader, or the error of ctx.
+func (n *Node) ReadIndex(ctx context.Context) error {
+	n.mu.Lock()
+	for {
+		if n.stopped {
+			n.mu.Unlock()
+			return ErrStopped
+		}
+		if n.role != leader {
+			err := &NotLeaderError{Leader: n.leader}
+			n.mu.Unlock()
+			return err
+		}
+		// the commit index is only known once an entry of the current term is committed
+		if n.commitIndex >= n.log[0].Index && n.termAt(n.commitIndex) == n.state.Term {
+			break
+		}
+		if err := n.wait(ctx); err != nil {
+			return err
+		}
+	}
+	readIndex, term := n.commitIndex, n.state.Term
+	round := n.broadcast()
+
+	for {
+		if n.stopped {
+			n.mu.Unlock()
+			return ErrStopped
+		}
+		if n.role != leader || n.state.Term != term {
+			err := &NotLeaderError{Leader: n.leader}
+			n.mu.Unlock()
+			return err
+		}
+		acks := 1
+		for _, peer := range n.peers() {
+			if n.ackedRound[peer] >= round {
+				acks++
+			}
+		}
+		if acks >= n.quorum() && n.lastApplied >= readIndex {
+			n.mu.Unlock()
+			return nil
+		}
+		if err := n.wait(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// wait releases the lock until the state changes or ctx is done, and locks it again unless it returns an error.
+func (n *Node) wait(ctx context.Context) error {
+	changed := n.changed
+	n.mu.Unlock()
+	select {
+	case <-changed:
+		n.mu.Lock()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// notify wakes up everyone waiting for a state change.
+func (n *Node) notify() {
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+func (n *Node) peers() []string {
+	peers := make([]string, 0, len(n.config.Peers))
+	for _, peer := range n.config.Peers {
+		if peer != n.config.ID {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+func (n *Node) quorum() int {
+	return len(n.config.Peers)/2 + 1
+}
+
+func (n *Node) lastIndex() uint64 {
+	return n.log[len(n.log)-1].Index
+}
+
+// termAt returns the term of the entry with the given index, or 0 if it is not in the log.
+func (n *Node) termAt(index uint64) uint64 {
+	if index < n.log[0].Index || index > n.lastIndex() {
+		return 0
+	}
+	return n.log[index-n.log[0].Index].Term
+}
+
+func (n *Node) resetElectionTimer() {
+	timeout := n.config.ElectionTimeout + time.Duration(rand.Int63n(int64(n.config.ElectionTimeout)))
+	n.electionDeadline = time.Now().Add(timeout)
+}
+
+func (n *Node) saveState() error {
+	return n.storage.SaveState(n.state)
+}
+
+// appendLocal appends a command of the current term to the log of the leader.
+func (n *Node) appendLocal(command []byte) (Entry, error) {
+	entry := Entry{Index: n.lastIndex() + 1, Term: n.state.Term, Command: command}
+	if err := n.storage.Append([]Entry{entry}); err != nil {
+		return entry, err
+	}
+	n.log = append(n.log, entry)
+	n.advanceCommit()
+	return entry, nil
+}
+
+// stepDown makes the node a follower of the given term.
+func (n *Node) stepDown(term uint64) {
+	if term > n.state.Term {
+		n.state = HardState{Term: term}
+		if err := n.saveState(); err != nil {
+			util.Logger().Error("failed to persist the raft state", util.LogKeyError, err)
+		}
+		n.leader = ""
+	}
+	if n.role == leader {
+		util.Logger().Info("raft leadership lost", "node", n.config.ID, "term", n.state.Term)
+		for index, w := range n.waiters {
+			w.result <- applyResult{err: ErrLeadershipLost}
+			delete(n.waiters, index)
+		}
+	}
+	n.role = follower
+	n.resetElectionTimer()
+	n.notify()
+}
+
+// tickLoop starts elections and sends heartbeats.
+func (n *Node) tickLoop() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.config.HeartbeatInterval / 5)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case now := <-ticker.C:
+			n.mu.Lock()
+			if n.stopped {
+				n.mu.Unlock()
+				return
+			}
+			if n.role == leader {
+				if now.Sub(n.lastBroadcast) >= n.config.HeartbeatInterval {
+					n.broadcast()
+				}
+			} else if now.After(n.electionDeadline) {
+				n.startElection()
+			}
+			n.mu.Unlock()
+		}
+	}
+}
+
+// startElection makes the node a candidate of the next term and requests the votes of the peers.
+func (n *Node) startElection() {
+	n.role = candidate
+	n.state = HardState{Term: n.state.Term + 1, Vote: n.config.ID}
+	n.leader = ""
+	n.resetElectionTimer()
+	if err := n.saveState(); err != nil {
+		util.Logger().Error("failed to persist the raft state", util.LogKeyError, err)
+		return
+	}
+	util.Logger().Debug("raft election started", "node", n.config.ID, "term", n.state.Term)
+
+	req := &RequestVoteRequest{
+		Term:         n.state.Term,
+		CandidateID:  n.config.ID,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.termAt(n.lastIndex()),
+	}
+	votes := 1
+	if votes >= n.quorum() {
+		n.becomeLeader()
+		return
+	}
+	for _, peer := range n.peers() {
+		n.wg.Add(1)
+		go func(peer string) {
+			defer n.wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), n.config.ElectionTimeout)
+			defer cancel()
+			resp, err := n.transport.RequestVote(ctx, peer, req)
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if n.stopped {
+				return
+			}
+			if resp.Term > n.state.Term {
+				n.stepDown(resp.Term)
+				return
+			}
+			if n.role != candidate || n.state.Term != req.Term || !resp.VoteGranted {
+				return
+			}
+			votes++
+			if votes >= n.quorum() {
+				n.becomeLeader()
+			}
+		}(peer)
+	}
+}
+
+// becomeLeader makes the candidate the leader and appends a no-op entry, so that the entries
+// of the previous terms are committed with it.
+func (n *Node) becomeLeader() {
+	util.Logger().Info("raft leader elected", "node", n.config.ID, "term", n.state.Term)
+	n.role = leader
+	n.leader = n.config.ID
+	for _, peer := range n.peers() {
+		n.nextIndex[peer] = n.lastIndex() + 1
+		n.matchIndex[peer] = 0
+		n.ackedRound[peer] = 0
+	}
+	if _, err := n.appendLocal(nil); err != nil {
+		util.Logger().Error("failed to append to the raft log", util.LogKeyError, err)
+	}
+	n.broadcast()
+	n.notify()
+}
+
+// broadcast starts a heartbeat round, sending the missing entries to every peer. Returns the number of the round.
+func (n *Node) broadcast() uint64 {
+	n.round++
+	n.lastBroadcast = time.Now()
+	for _, peer := range n.peers() {
+		if n.inFlight[peer] {
+			continue
+		}
+		n.inFlight[peer] = true
+		n.wg.Add(1)
+		go n.replicate(peer, n.state.Term)
+	}
+	return n.round
+}
+
+// replicate sends AppendEntries or InstallSnapshot to a peer until it has all entries of the leader,
+// or the peer is unreachable, or the node is no longer the leader of the term.
+func (n *Node) replicate(peer string, term uint64) {
+	defer n.wg.Done()
+	n.mu.Lock()
+	defer func() {
+		n.inFlight[peer] = false
+		n.mu.Unlock()
+	}()
+
+	for !n.stopped && n.role == leader && n.state.Term == term {
+		round := n.round
+		next := n.nextIndex[peer]
+		var ok bool
+		if next <= n.log[0].Index {
+			ok = n.sendSnapshot(peer, term)
+		} else {
+			ok = n.sendEntries(peer, term, next)
+		}
+		if !ok {
+			return
+		}
+		if n.role == leader && n.state.Term == term && n.ackedRound[peer] < round {
+			n.ackedRound[peer] = round
+			n.notify()
+		}
+		if n.matchIndex[peer] == n.lastIndex() && n.round == round {
+			return
+		}
+	}
+}
+
+// sendEntries sends the entries from next to a peer, unlocking n.mu during the call. Returns false if the call failed.
+func (n *Node) sendEntries(peer string, term, next uint64) bool {
+	offset := next - n.log[0].Index
+	entries := append([]Entry(nil), n.log[offset:min(uint64(len(n.log)), offset+maxEntriesPerRequest)]...)
+	req := &AppendEntriesRequest{
+		Term:         term,
+		LeaderID:     n.config.ID,
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  n.termAt(next - 1),
+		Entries:      entries,
+		LeaderCommit: n.commitIndex,
+	}
+
+	n.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), n.config.ElectionTimeout)
+	resp, err := n.transport.AppendEntries(ctx, peer, req)
+	cancel()
+	n.mu.Lock()
+	if err != nil || n.stopped {
+		return false
+	}
+
+	if resp.Term > n.state.Term {
+		n.stepDown(resp.Term)
+		return false
+	}
+	if n.role != leader || n.state.Term != term {
+		return false
+	}
+	if resp.Success {
+		n.matchIndex[peer] = max(n.matchIndex[peer], req.PrevLogIndex+uint64(len(entries)))
+		n.nextIndex[peer] = n.matchIndex[peer] + 1
+		n.advanceCommit()
+	} else {
+		n.nextIndex[peer] = max(min(resp.ConflictIndex, next-1), 1)
+	}
+	return true
+}
+
+// maxEntriesPerRequest limits the size of a single AppendEntries request.
+const maxEntriesPerRequest = 512
+
+// snapshotProgress is the part of a snapshot acknowledged by a peer.
+type snapshotProgress struct {
+	index  uint64 // of the snapshot
+	offset uint64 // of the next chunk
+}
+
+// sendSnapshot sends the next chunk of the latest snapshot to a peer, unlocking n.mu during the call.
+// Returns false if the call failed.
+func (n *Node) sendSnapshot(peer string, term uint64) bool {
+	snapshot := n.snapshot
+	sent := n.sentSnapshot[peer]
+	if sent.index != snapshot.Index {
+		sent = snapshotProgress{index: snapshot.Index}
+	}
+	size := uint64(len(snapshot.Data))
+	end := size
+	if n.config.SnapshotChunkSize > 0 {
+		end = min(size, sent.offset+uint64(n.config.SnapshotChunkSize))
+	}
+	req := &InstallSnapshotRequest{
+		Term:          term,
+		LeaderID:      n.config.ID,
+		SnapshotIndex: snapshot.Index,
+		SnapshotTerm:  snapshot.Term,
+		Offset:        sent.offset,
+		Data:          snapshot.Data[sent.offset:end],
+		Done:          end == size,
+	}
+
+	n.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*n.config.ElectionTimeout)
+	resp, err := n.transport.InstallSnapshot(ctx, peer, req)
+	cancel()
+	n.mu.Lock()
+	if err != nil || n.stopped {
+		return false
+	}
+
+	if resp.Term > n.state.Term {
+		n.stepDown(resp.Term)
+		return false
+	}
+	if n.role != leader || n.state.Term != term {
+		return false
+	}
+	if !resp.Done {
+		n.sentSnapshot[peer] = snapshotProgress{index: req.SnapshotIndex, offset: min(resp.Offset, size)}
+		return true
+	}
+	delete(n.sentSnapshot, peer)
+	n.matchIndex[peer] = max(n.matchIndex[peer], req.SnapshotIndex)
+	n.nextIndex[peer] = n.matchIndex[peer] + 1
+	return true
+}
+
+// advanceCommit commits the entries of the current term that are stored on a majority of the nodes.
+// Entries of previous terms are committed indirectly with them.
+func (n *Node) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex && n.termAt(index) == n.state.Term; index-- {
+		count := 1
+		for _, peer := range n.peers() {
+			if n.matchIndex[peer] >= index {
+				count++
+			}
+		}
+		if count >= n.quorum() {
+			n.commitIndex = index
+			n.notify()
+			return
+		}
+	}
+}
+
+// HandleRequestVote serves a RequestVote RPC of a candidate.
+func (n *Node) HandleRequestVote(req *RequestVoteRequest) (*RequestVoteResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return nil, ErrStopped
+	}
+
+	if req.Term > n.state.Term {
+		n.stepDown(req.Term)
+	}
+	resp := &RequestVoteResponse{Term: n.state.Term}
+	if req.Term < n.state.Term || n.state.Vote != "" && n.state.Vote != req.CandidateID {
+		return resp, nil
+	}
+	lastTerm := n.termAt(n.lastIndex())
+	if req.LastLogTerm < lastTerm || req.LastLogTerm == lastTerm && req.LastLogIndex < n.lastIndex() {
+		return resp, nil // the candidate's log is behind
+	}
+
+	n.state.Vote = req.CandidateID
+	if err := n.saveState(); err != nil {
+		return nil, err
+	}
+	n.resetElectionTimer()
+	resp.VoteGranted = true
+	return resp, nil
+}
+
+// HandleAppendEntries serves an AppendEntries RPC of the leader.
+func (n *Node) HandleAppendEntries(req *AppendEntriesRequest) (*AppendEntriesResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return nil, ErrStopped
+	}
+
+	if req.Term < n.state.Term {
+		return &AppendEntriesResponse{Term: n.state.Term}, nil
+	}
+	if req.Term > n.state.Term || n.role != follower {
+		n.stepDown(req.Term)
+	}
+	if n.leader != req.LeaderID {
+		n.leader = req.LeaderID
+		n.notify()
+	}
+	n.resetElectionTimer()
+	resp := &AppendEntriesResponse{Term: n.state.Term}
+
+	// entries covered by the snapshot are committed and match the leader
+	entries := req.Entries
+	prevIndex, prevTerm := req.PrevLogIndex, req.PrevLogTerm
+	if prevIndex < n.log[0].Index {
+		skip := min(n.log[0].Index-prevIndex, uint64(len(entries)))
+		entries = entries[skip:]
+		prevIndex, prevTerm = n.log[0].Index, n.log[0].Term
+	}
+	if prevIndex > n.lastIndex() {
+		resp.ConflictIndex = n.lastIndex() + 1
+		return resp, nil
+	}
+	if n.termAt(prevIndex) != prevTerm {
+		// skip the whole conflicting term
+		conflictTerm := n.termAt(prevIndex)
+		index := prevIndex
+		for index > n.log[0].Index+1 && n.termAt(index-1) == conflictTerm {
+			index--
+		}
+		resp.ConflictIndex = index
+		return resp, nil
+	}
+
+	for i, e := range entries {
+		if e.Index <= n.lastIndex() {
+			if n.termAt(e.Index) == e.Term {
+				continue
+			}
+			if e.Index <= n.commitIndex {
+				return nil, fmt.Errorf("leader %s overwrites the committed entry %d", req.LeaderID, e.Index)
+			}
+			n.log = n.log[:e.Index-n.log[0].Index]
+		}
+		if err := n.storage.Append(entries[i:]); err != nil {
+			return nil, err
+		}
+		n.log = append(n.log, entries[i:]...)
+		break
+	}
+
+	lastNew := prevIndex + uint64(len(entries))
+	if req.LeaderCommit > n.commitIndex {
+		n.commitIndex = max(n.commitIndex, min(req.LeaderCommit, lastNew))
+		n.notify()
+	}
+	resp.Success = true
+	return resp, nil
+}
+
+// HandleInstallSnapshot serves an InstallSnapshot RPC of the leader sent to a follower that is missing
+// the entries compacted into the snapshot. The chunks are collected in order and the snapshot is installed
+// with the last one.
+func (n *Node) HandleInstallSnapshot(req *InstallSnapshotRequest) (*InstallSnapshotResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return nil, ErrStopped
+	}
+
+	if req.Term < n.state.Term {
+		return &InstallSnapshotResponse{Term: n.state.Term}, nil
+	}
+	if req.Term > n.state.Term || n.role != follower {
+		n.stepDown(req.Term)
+	}
+	n.leader = req.LeaderID
+	n.resetElectionTimer()
+	resp := &InstallSnapshotResponse{Term: n.state.Term}
+
+	if req.SnapshotIndex <= n.commitIndex {
+		n.receivedSnapshot = nil
+		resp.Done = true
+		return resp, nil
+	}
+	if req.SnapshotIndex == 0 {
+		return nil, errInvalidSnapshot
+	}
+	if req.Offset == 0 {
+		n.receivedSnapshot = &Snapshot{Index: req.SnapshotIndex, Term: req.SnapshotTerm}
+		n.receivedTerm = req.Term
+	}
+	received := n.receivedSnapshot
+	if received == nil || n.receivedTerm != req.Term || received.Index != req.SnapshotIndex || received.Term != req.SnapshotTerm {
+		return resp, nil // the leader restarts from the first chunk
+	}
+	if req.Offset != uint64(len(received.Data)) {
+		resp.Offset = uint64(len(received.Data))
+		return resp, nil
+	}
+	received.Data = append(received.Data, req.Data...)
+	if !req.Done {
+		resp.Offset = uint64(len(received.Data))
+		return resp, nil
+	}
+
+	n.receivedSnapshot = nil
+	snapshot := *received
+	if err := n.storage.SaveSnapshot(&snapshot); err != nil {
+		return nil, err
+	}
+	// keep the entries after the snapshot only if the log matches it
+	var rest []Entry
+	if n.termAt(snapshot.Index) == snapshot.Term {
+		rest = n.log[snapshot.Index-n.log[0].Index+1:]
+	}
+	n.log = append([]Entry{{Index: snapshot.Index, Term: snapshot.Term}}, rest...)
+	n.snapshot = &snapshot
+	n.pendingSnapshot = &snapshot
+	n.commitIndex = snapshot.Index
+	n.notify()
+	resp.Done = true
+	return resp, nil
+}
+
+// applyLoop applies the committed entries and restores the snapshots received from the leader, in order.
+func (n *Node) applyLoop() {
+	defer n.wg.Done()
+	n.mu.Lock()
+	for {
+		for !n.stopped && n.pendingSnapshot == nil && n.lastApplied >= n.commitIndex {
+			changed := n.changed
+			n.mu.Unlock()
+			<-changed
+			n.mu.Lock()
+		}
+		if n.stopped {
+			n.mu.Unlock()
+			return
+		}
+
+		if snapshot := n.pendingSnapshot; snapshot != nil {
+			n.pendingSnapshot = nil
+			n.mu.Unlock()
+			err := n.fsm.Restore(snapshot.Data)
+			n.mu.Lock()
+			if err != nil {
+				// the entries after the snapshot can't be applied before it is restored, so it is retried
+				util.Logger().Error("failed to restore a raft snapshot, retrying", util.LogKeyError, err)
+				if n.pendingSnapshot == nil {
+					n.pendingSnapshot = snapshot
+				}
+				n.mu.Unlock()
+				select {
+				case <-n.stop:
+				case <-time.After(n.config.HeartbeatInterval):
+				}
+				n.mu.Lock()
+				continue
+			}
+			n.lastApplied = max(n.lastApplied, snapshot.Index)
+			n.notify()
+			continue
+		}
+
+		// the entries up to the commit index can't change, but the log can be compacted while they are applied
+		first := n.lastApplied + 1
+		entries := append([]Entry(nil), n.log[first-n.log[0].Index:n.commitIndex-n.log[0].Index+1]...)
+		n.mu.Unlock()
+
+		results := make([]applyResult, len(entries))
+		for i, e := range entries {
+			if len(e.Command) > 0 {
+				results[i].value, results[i].err = n.fsm.Apply(e.Command)
+			}
+		}
+
+		n.mu.Lock()
+		if n.pendingSnapshot != nil && n.pendingSnapshot.Index >= entries[len(entries)-1].Index {
+			continue // the restore replaces what was applied
+		}
+		for i, e := range entries {
+			if w, ok := n.waiters[e.Index]; ok {
+				if w.term == e.Term {
+					w.result <- results[i]
+				} else {
+					w.result <- applyResult{err: ErrLeadershipLost}
+				}
+				delete(n.waiters, e.Index)
+			}
+		}
+		n.lastApplied = max(n.lastApplied, entries[len(entries)-1].Index)
+		n.notify()
+
+		if n.lastApplied-n.log[0].Index >= n.config.SnapshotThreshold && n.config.SnapshotThreshold > 0 {
+			n.takeSnapshot()
+		}
+	}
+}
+
+// takeSnapshot snapshots the StateMachine at lastApplied and compacts the log. Called by the applier,
+// so that no entries are applied while the snapshot is taken.
+func (n *Node) takeSnapshot() {
+	index, term := n.lastApplied, n.termAt(n.lastApplied)
+	n.mu.Unlock()
+	data, err := n.fsm.Snapshot()
+	n.mu.Lock()
+	if err != nil {
+		util.Logger().Error("failed to take a raft snapshot", util.LogKeyError, err)
+		return
+	}
+	if index <= n.log[0].Index {
+		return // a newer snapshot was installed meanwhile
+	}
+
+	snapshot := &Snapshot{Index: index, Term: term, Data: data}
+	if err := n.storage.SaveSnapshot(snapshot); err != nil {
+		util.Logger().Error("failed to save a raft snapshot", util.LogKeyError, err)
+		return
+	}
+	n.log = append([]Entry{{Index: index, Term: term}}, n.log[index-n.log[0].Index+1:]...)
+	n.snapshot = snapshot
+	util.Logger().Debug("raft snapshot taken", "node", n.config.ID, "index", index)
+}
@@ -0,0 +1,330 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testFSM is a map of keys set by commands of the form key=value.
+type testFSM struct {
+	mu           sync.Mutex
+	data         map[string]string
+	failRestores int // restores that fail before one succeeds
+}
+
+func (f *testFSM) Apply(command []byte) ([]byte, error) {
+	key, value, _ := strings.Cut(string(command), "=")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.data[key] = value
+	return []byte(value), nil
+}
+
+func (f *testFSM) Snapshot() ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return json.Marshal(f.data)
+}
+
+func (f *testFSM) Restore(snapshot []byte) error {
+	f.mu.Lock()
+	if f.failRestores > 0 {
+		f.failRestores--
+		f.mu.Unlock()
+		return errors.New("restore failed")
+	}
+	f.mu.Unlock()
+
+	data := map[string]string{}
+	if err := json.Unmarshal(snapshot, &data); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.data = data
+	return nil
+}
+
+func (f *testFSM) get(key string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.data[key]
+}
+
+type testCluster struct {
+	t       *testing.T
+	network *InMemNetwork
+	nodes   map[string]*Node
+	fsms    map[string]*testFSM
+}
+
+func newTestCluster(t *testing.T, size int, snapshotThreshold uint64) *testCluster {
+	c := &testCluster{t: t, network: NewInMemNetwork(), nodes: map[string]*Node{}, fsms: map[string]*testFSM{}}
+	var peers []string
+	for i := 1; i <= size; i++ {
+		peers = append(peers, fmt.Sprintf("n%d", i))
+	}
+	for _, id := range peers {
+		config := DefaultConfig(id, peers)
+		config.ElectionTimeout = 100 * time.Millisecond
+		config.HeartbeatInterval = 20 * time.Millisecond
+		config.SnapshotThreshold = snapshotThreshold
+		config.SnapshotChunkSize = 64
+		fsm := &testFSM{data: map[string]string{}}
+		node, err := NewNode(config, fsm, c.network.Transport(id), NewMemoryStorage())
+		if err != nil {
+			t.Fatalf("Failed to create node %s: %v", id, err)
+		}
+		c.network.Register(node)
+		c.nodes[id], c.fsms[id] = node, fsm
+	}
+	for _, node := range c.nodes {
+		node.Start()
+	}
+	return c
+}
+
+func (c *testCluster) stop() {
+	for _, node := range c.nodes {
+		node.Stop()
+	}
+}
+
+// waitForLeader waits until a single connected node is the leader and returns it.
+func (c *testCluster) waitForLeader(except string) *Node {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var leaders []*Node
+		for id, node := range c.nodes {
+			if id != except && node.IsLeader() {
+				leaders = append(leaders, node)
+			}
+		}
+		if len(leaders) == 1 {
+			return leaders[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.t.Fatalf("No leader elected")
+	return nil
+}
+
+func (c *testCluster) propose(leader *Node, command string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := leader.Propose(ctx, []byte(command)); err != nil {
+		c.t.Fatalf("Failed to propose %q: %v", command, err)
+	}
+}
+
+// waitForValue waits until the FSM of every node except the given one has the value for the key.
+func (c *testCluster) waitForValue(except, key, value string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for id, fsm := range c.fsms {
+		if id == except {
+			continue
+		}
+		for fsm.get(key) != value {
+			if time.Now().After(deadline) {
+				c.t.Fatalf("Expected %q for key %s on node %s, got %q", value, key, id, fsm.get(key))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestRaft_ElectionAndReplication(t *testing.T) {
+	c := newTestCluster(t, 3, 0)
+	defer c.stop()
+
+	leader := c.waitForLeader("")
+	for id, node := range c.nodes {
+		if node != leader {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			_, err := node.Propose(ctx, []byte("a=1"))
+			cancel()
+			var notLeader *NotLeaderError
+			if !errors.Is(err, ErrNotLeader) || !errors.As(err, &notLeader) {
+				t.Errorf("Expected ErrNotLeader from follower %s, got %v", id, err)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := leader.Propose(ctx, []byte("a=1"))
+	if err != nil || string(result) != "1" {
+		t.Fatalf("Expected result 1, got %q, %v", result, err)
+	}
+	if err := leader.ReadIndex(ctx); err != nil {
+		t.Fatalf("Failed to read through the leader: %v", err)
+	}
+	if got := c.fsms[leader.ID()].get("a"); got != "1" {
+		t.Errorf("Expected the leader to have applied the command, got %q", got)
+	}
+	c.waitForValue("", "a", "1")
+}
+
+func TestRaft_LeaderFailure(t *testing.T) {
+	c := newTestCluster(t, 3, 0)
+	defer c.stop()
+
+	old := c.waitForLeader("")
+	c.propose(old, "a=1")
+	c.network.SetConnected(old.ID(), false)
+
+	leader := c.waitForLeader(old.ID())
+	c.propose(leader, "b=2")
+	c.waitForValue(old.ID(), "b", "2")
+
+	// the old leader can't commit anything without a majority
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := old.ReadIndex(ctx); err == nil {
+		t.Errorf("Expected a partitioned leader to fail a linearizable read")
+	}
+
+	c.network.SetConnected(old.ID(), true)
+	c.waitForValue("", "b", "2")
+	if old.IsLeader() {
+		t.Errorf("Expected the old leader to step down")
+	}
+}
+
+func TestRaft_SnapshotCatchUp(t *testing.T) {
+	c := newTestCluster(t, 3, 10)
+	defer c.stop()
+
+	leader := c.waitForLeader("")
+	var lagging string
+	for id := range c.nodes {
+		if id != leader.ID() {
+			lagging = id
+			break
+		}
+	}
+	c.network.SetConnected(lagging, false)
+	for i := 0; i < 50; i++ {
+		c.propose(leader, fmt.Sprintf("k%d=%d", i, i))
+	}
+	if status := leader.Status(); status.Snapshot == 0 {
+		t.Fatalf("Expected the leader to compact its log, got %+v", status)
+	}
+
+	// the node retries a snapshot that fails to restore instead of applying the entries after it
+	c.fsms[lagging].mu.Lock()
+	c.fsms[lagging].failRestores = 2
+	c.fsms[lagging].mu.Unlock()
+
+	c.network.SetConnected(lagging, true)
+	c.waitForValue("", "k49", "49")
+	c.waitForValue("", "k0", "0")
+	if status := c.nodes[lagging].Status(); status.Snapshot == 0 {
+		t.Errorf("Expected the lagging node to install a snapshot, got %+v", status)
+	}
+	c.fsms[lagging].mu.Lock()
+	defer c.fsms[lagging].mu.Unlock()
+	if c.fsms[lagging].failRestores != 0 {
+		t.Errorf("Expected the failed restores to be retried, %d left", c.fsms[lagging].failRestores)
+	}
+}
+
+func TestRaft_SnapshotChunks(t *testing.T) {
+	node, err := NewNode(DefaultConfig("n2", []string{"n1", "n2"}), &testFSM{data: map[string]string{}}, nil, NewMemoryStorage())
+	if err != nil {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+	data := []byte(`{"a":"1","b":"2"}`)
+	chunk := func(offset, end uint64) *InstallSnapshotResponse {
+		t.Helper()
+		resp, err := node.HandleInstallSnapshot(&InstallSnapshotRequest{
+			Term: 1, LeaderID: "n1", SnapshotIndex: 5, SnapshotTerm: 1,
+			Offset: offset, Data: data[offset:end], Done: end == uint64(len(data)),
+		})
+		if err != nil {
+			t.Fatalf("Failed to install the chunk at %d: %v", offset, err)
+		}
+		return resp
+	}
+
+	if resp := chunk(0, 8); resp.Offset != 8 || resp.Done {
+		t.Fatalf("Expected the next chunk at 8, got %+v", resp)
+	}
+	// a chunk out of order is dropped and the leader is told where to resend from
+	if resp := chunk(12, uint64(len(data))); resp.Offset != 8 || resp.Done {
+		t.Fatalf("Expected the chunk to be resent from 8, got %+v", resp)
+	}
+	if status := node.Status(); status.Snapshot != 0 {
+		t.Fatalf("Expected no snapshot before the last chunk, got %+v", status)
+	}
+	if resp := chunk(8, uint64(len(data))); !resp.Done {
+		t.Fatalf("Expected the snapshot to be installed, got %+v", resp)
+	}
+	if status := node.Status(); status.Snapshot != 5 || status.CommitIndex != 5 {
+		t.Errorf("Expected the snapshot at 5, got %+v", status)
+	}
+	if !bytes.Equal(node.snapshot.Data, data) {
+		t.Errorf("Expected the snapshot %s, got %s", data, node.snapshot.Data)
+	}
+	// a snapshot the follower already has is acknowledged without the chunks
+	if resp := chunk(0, 8); !resp.Done {
+		t.Errorf("Expected an installed snapshot to be acknowledged, got %+v", resp)
+	}
+}
+
+func TestFileStorage(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "raft_storage_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	storage, err := NewFileStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to open the storage: %v", err)
+	}
+	if _, _, _, err := storage.Load(); err != nil {
+		t.Fatalf("Failed to load an empty storage: %v", err)
+	}
+	if err := storage.SaveState(HardState{Term: 3, Vote: "n2"}); err != nil {
+		t.Fatalf("Failed to save the state: %v", err)
+	}
+	entries := []Entry{{Index: 1, Term: 1}, {Index: 2, Term: 1, Command: []byte("a")}, {Index: 3, Term: 2, Command: []byte("b")}}
+	if err := storage.Append(entries); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	if err := storage.Append([]Entry{{Index: 3, Term: 3, Command: []byte("c")}, {Index: 4, Term: 3, Command: []byte("d")}}); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	if err := storage.SaveSnapshot(&Snapshot{Index: 2, Term: 1, Data: []byte("state")}); err != nil {
+		t.Fatalf("Failed to save the snapshot: %v", err)
+	}
+	_ = storage.Close()
+
+	storage, err = NewFileStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to reopen the storage: %v", err)
+	}
+	defer storage.Close()
+	state, snapshot, loaded, err := storage.Load()
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	if state != (HardState{Term: 3, Vote: "n2"}) {
+		t.Errorf("Expected the saved state, got %+v", state)
+	}
+	if snapshot == nil || snapshot.Index != 2 || snapshot.Term != 1 || string(snapshot.Data) != "state" {
+		t.Errorf("Expected the saved snapshot, got %+v", snapshot)
+	}
+	if len(loaded) != 2 || string(loaded[0].Command) != "c" || loaded[1].Index != 4 {
+		t.Errorf("Expected entries 3 and 4 of term 3, got %+v", loaded)
+	}
+}
@@ -0,0 +1,319 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"nasp-project/util"
+	"os"
+	"path/filepath"
+)
+
+// HardState is the state a node must persist before it answers an RPC.
+type HardState struct {
+	Term uint64 `json:"term"`
+	Vote string `json:"vote"` // candidate voted for in Term, empty if none
+}
+
+// Snapshot is the state of the StateMachine after applying all entries up to and including Index.
+type Snapshot struct {
+	Index uint64
+	Term  uint64
+	Data  []byte
+}
+
+// Storage persists the state of a Node. A Node calls it only while holding its lock.
+type Storage interface {
+	// Load returns the persisted state, the latest snapshot or nil, and the entries after the snapshot.
+	Load() (HardState, *Snapshot, []Entry, error)
+	// SaveState persists the term and the vote.
+	SaveState(state HardState) error
+	// Append persists entries. Stored entries with an index of entries[0] or higher are replaced.
+	Append(entries []Entry) error
+	// SaveSnapshot persists a snapshot and drops the stored entries it covers.
+	SaveSnapshot(snapshot *Snapshot) error
+}
+
+// MemoryStorage is a Storage that keeps everything in memory, for tests.
+type MemoryStorage struct {
+	state    HardState
+	snapshot *Snapshot
+	entries  []Entry
+}
+
+var _ Storage = (*MemoryStorage)(nil)
+
+// NewMemoryStorage creates an empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+func (s *MemoryStorage) Load() (HardState, *Snapshot, []Entry, error) {
+	return s.state, s.snapshot, append([]Entry(nil), s.entries...), nil
+}
+
+func (s *MemoryStorage) SaveState(state HardState) error {
+	s.state = state
+	return nil
+}
+
+func (s *MemoryStorage) Append(entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	s.entries = append(truncateEntries(s.entries, entries[0].Index), entries...)
+	return nil
+}
+
+func (s *MemoryStorage) SaveSnapshot(snapshot *Snapshot) error {
+	s.snapshot = snapshot
+	s.entries = dropEntries(s.entries, snapshot.Index)
+	return nil
+}
+
+// truncateEntries returns the entries with an index lower than from.
+func truncateEntries(entries []Entry, from uint64) []Entry {
+	for i, e := range entries {
+		if e.Index >= from {
+			return entries[:i]
+		}
+	}
+	return entries
+}
+
+// dropEntries returns the entries with an index higher than upTo.
+func dropEntries(entries []Entry, upTo uint64) []Entry {
+	for i, e := range entries {
+		if e.Index > upTo {
+			return append([]Entry(nil), entries[i:]...)
+		}
+	}
+	return nil
+}
+
+/*
+  FileStorage keeps three files in its directory:
+    state.json     HardState
+    snapshot.bin   Index (8B) | Term (8B) | Data
+    log.bin        entries after the snapshot:
+
+  +---------------+-------------+------------+-----------------+-...-----+
+  |    CRC (4B)   | Index (8B) | Term (8B) | Command Size (4B) | Command |
+  +---------------+-------------+------------+-----------------+-...-----+
+  CRC = 32bit hash of the Command
+
+  state.json and snapshot.bin are replaced atomically. A torn entry at the end of log.bin is dropped on Load.
+*/
+
+const (
+	entryHeaderSize   = 24
+	snapshotIndexSize = 16
+)
+
+// FileStorage is a Storage that persists the state of a Node in a directory.
+type FileStorage struct {
+	dir     string
+	log     *os.File
+	offsets []int64 // offsets[i] is the position of the i-th stored entry in log.bin
+	indexes []uint64
+}
+
+var _ Storage = (*FileStorage)(nil)
+
+// NewFileStorage opens the FileStorage in dir, creating the directory if it doesn't exist.
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	log, err := os.OpenFile(filepath.Join(dir, "log.bin"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStorage{dir: dir, log: log}, nil
+}
+
+// Close closes the log file.
+func (s *FileStorage) Close() error {
+	return s.log.Close()
+}
+
+func (s *FileStorage) Load() (HardState, *Snapshot, []Entry, error) {
+	var state HardState
+	data, err := os.ReadFile(filepath.Join(s.dir, "state.json"))
+	if err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return state, nil, nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return state, nil, nil, err
+	}
+
+	var snapshot *Snapshot
+	data, err = os.ReadFile(filepath.Join(s.dir, "snapshot.bin"))
+	if err == nil {
+		if len(data) < snapshotIndexSize {
+			return state, nil, nil, errors.New("corrupted snapshot")
+		}
+		snapshot = &Snapshot{
+			Index: binary.LittleEndian.Uint64(data[0:8]),
+			Term:  binary.LittleEndian.Uint64(data[8:16]),
+			Data:  data[snapshotIndexSize:],
+		}
+	} else if !os.IsNotExist(err) {
+		return state, nil, nil, err
+	}
+
+	entries, err := s.readLog()
+	if err != nil {
+		return state, nil, nil, err
+	}
+	if snapshot != nil {
+		entries = dropEntries(entries, snapshot.Index)
+	}
+	return state, snapshot, entries, nil
+}
+
+// readLog reads log.bin and drops a torn entry at its end.
+func (s *FileStorage) readLog() ([]Entry, error) {
+	if _, err := s.log.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(s.log)
+	s.offsets, s.indexes = nil, nil
+
+	var entries []Entry
+	var offset int64
+	header := make([]byte, entryHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			break
+		}
+		command := make([]byte, binary.LittleEndian.Uint32(header[20:24]))
+		if _, err := io.ReadFull(r, command); err != nil {
+			break
+		}
+		if util.CRC32(command) != binary.LittleEndian.Uint32(header[0:4]) {
+			break
+		}
+		e := Entry{
+			Index: binary.LittleEndian.Uint64(header[4:12]),
+			Term:  binary.LittleEndian.Uint64(header[12:20]),
+		}
+		if len(command) > 0 {
+			e.Command = command
+		}
+		entries = append(entries, e)
+		s.offsets = append(s.offsets, offset)
+		s.indexes = append(s.indexes, e.Index)
+		offset += entryHeaderSize + int64(len(command))
+	}
+	if err := s.log.Truncate(offset); err != nil {
+		return nil, err
+	}
+	_, err := s.log.Seek(offset, io.SeekStart)
+	return entries, err
+}
+
+func (s *FileStorage) SaveState(state HardState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.dir, "state.json"), data)
+}
+
+func (s *FileStorage) Append(entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	// drop the replaced entries
+	for i, index := range s.indexes {
+		if index >= entries[0].Index {
+			if err := s.log.Truncate(s.offsets[i]); err != nil {
+				return err
+			}
+			if _, err := s.log.Seek(s.offsets[i], io.SeekStart); err != nil {
+				return err
+			}
+			s.offsets, s.indexes = s.offsets[:i], s.indexes[:i]
+			break
+		}
+	}
+
+	offset, err := s.log.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, 0)
+	for _, e := range entries {
+		s.offsets = append(s.offsets, offset+int64(len(data)))
+		s.indexes = append(s.indexes, e.Index)
+		data = appendEntry(data, e)
+	}
+	if _, err := s.log.Write(data); err != nil {
+		return err
+	}
+	return s.log.Sync()
+}
+
+func (s *FileStorage) SaveSnapshot(snapshot *Snapshot) error {
+	data := make([]byte, snapshotIndexSize, snapshotIndexSize+len(snapshot.Data))
+	binary.LittleEndian.PutUint64(data[0:8], snapshot.Index)
+	binary.LittleEndian.PutUint64(data[8:16], snapshot.Term)
+	data = append(data, snapshot.Data...)
+	if err := writeFileAtomic(filepath.Join(s.dir, "snapshot.bin"), data); err != nil {
+		return err
+	}
+
+	// rewrite the log without the entries covered by the snapshot
+	entries, err := s.readLog()
+	if err != nil {
+		return err
+	}
+	data = make([]byte, 0)
+	for _, e := range dropEntries(entries, snapshot.Index) {
+		data = appendEntry(data, e)
+	}
+	path := filepath.Join(s.dir, "log.bin")
+	if err := writeFileAtomic(path, data); err != nil {
+		return err
+	}
+	_ = s.log.Close()
+	s.log, err = os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = s.readLog()
+	return err
+}
+
+func appendEntry(data []byte, e Entry) []byte {
+	data = binary.LittleEndian.AppendUint32(data, util.CRC32(e.Command))
+	data = binary.LittleEndian.AppendUint64(data, e.Index)
+	data = binary.LittleEndian.AppendUint64(data, e.Term)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(e.Command)))
+	return append(data, e.Command...)
+}
+
+// writeFileAtomic replaces the file at path with data, so that a crash leaves either the old or the new file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
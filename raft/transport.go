@@ -0,0 +1,227 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrUnreachable is returned by the transports if the node can't be reached.
+var ErrUnreachable = errors.New("node unreachable")
+
+type RequestVoteRequest struct {
+	Term         uint64 `json:"term"`
+	CandidateID  string `json:"candidate_id"`
+	LastLogIndex uint64 `json:"last_log_index"`
+	LastLogTerm  uint64 `json:"last_log_term"`
+}
+
+type RequestVoteResponse struct {
+	Term        uint64 `json:"term"`
+	VoteGranted bool   `json:"vote_granted"`
+}
+
+type AppendEntriesRequest struct {
+	Term         uint64  `json:"term"`
+	LeaderID     string  `json:"leader_id"`
+	PrevLogIndex uint64  `json:"prev_log_index"`
+	PrevLogTerm  uint64  `json:"prev_log_term"`
+	Entries      []Entry `json:"entries,omitempty"`
+	LeaderCommit uint64  `json:"leader_commit"`
+}
+
+type AppendEntriesResponse struct {
+	Term    uint64 `json:"term"`
+	Success bool   `json:"success"`
+	// ConflictIndex is the index the leader should retry from if Success is false,
+	// so that a lagging follower is found in one round trip per term instead of one per entry.
+	ConflictIndex uint64 `json:"conflict_index,omitempty"`
+}
+
+// InstallSnapshotRequest carries a chunk of the leader's snapshot, see Config.SnapshotChunkSize.
+type InstallSnapshotRequest struct {
+	Term          uint64 `json:"term"`
+	LeaderID      string `json:"leader_id"`
+	SnapshotIndex uint64 `json:"snapshot_index"`
+	SnapshotTerm  uint64 `json:"snapshot_term"`
+	Offset        uint64 `json:"offset"` // of Data in the snapshot
+	Data          []byte `json:"data,omitempty"`
+	Done          bool   `json:"done"` // Data is the last chunk
+}
+
+type InstallSnapshotResponse struct {
+	Term uint64 `json:"term"`
+	// Offset is where the follower expects the next chunk, the leader resends from it
+	// if the follower is missing chunks or dropped the ones it received.
+	Offset uint64 `json:"offset"`
+	Done   bool   `json:"done"` // the follower installed the snapshot or already has the entries it covers
+}
+
+// Transport sends the RPCs of a Node to the other nodes of the cluster, which serve them
+// with HandleRequestVote, HandleAppendEntries and HandleInstallSnapshot.
+type Transport interface {
+	RequestVote(ctx context.Context, to string, req *RequestVoteRequest) (*RequestVoteResponse, error)
+	AppendEntries(ctx context.Context, to string, req *AppendEntriesRequest) (*AppendEntriesResponse, error)
+	InstallSnapshot(ctx context.Context, to string, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error)
+}
+
+// InMemNetwork connects the nodes of a cluster running in a single process, for tests.
+// Nodes can be disconnected to simulate failures and partitions.
+type InMemNetwork struct {
+	mu           sync.Mutex
+	nodes        map[string]*Node
+	disconnected map[string]bool
+}
+
+// NewInMemNetwork creates an InMemNetwork without nodes.
+func NewInMemNetwork() *InMemNetwork {
+	return &InMemNetwork{nodes: map[string]*Node{}, disconnected: map[string]bool{}}
+}
+
+// Register makes the node reachable by the others.
+func (n *InMemNetwork) Register(node *Node) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.nodes[node.ID()] = node
+}
+
+// SetConnected connects or disconnects the node with the given ID from all other nodes.
+func (n *InMemNetwork) SetConnected(id string, connected bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.disconnected[id] = !connected
+}
+
+// Transport returns the Transport of the node with the given ID.
+func (n *InMemNetwork) Transport(id string) Transport {
+	return &inMemTransport{network: n, from: id}
+}
+
+func (n *InMemNetwork) node(from, to string) (*Node, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	node, ok := n.nodes[to]
+	if !ok || n.disconnected[from] || n.disconnected[to] {
+		return nil, ErrUnreachable
+	}
+	return node, nil
+}
+
+type inMemTransport struct {
+	network *InMemNetwork
+	from    string
+}
+
+func (t *inMemTransport) RequestVote(_ context.Context, to string, req *RequestVoteRequest) (*RequestVoteResponse, error) {
+	node, err := t.network.node(t.from, to)
+	if err != nil {
+		return nil, err
+	}
+	return node.HandleRequestVote(req)
+}
+
+func (t *inMemTransport) AppendEntries(_ context.Context, to string, req *AppendEntriesRequest) (*AppendEntriesResponse, error) {
+	node, err := t.network.node(t.from, to)
+	if err != nil {
+		return nil, err
+	}
+	// the follower must not share the entries with the leader
+	req.Entries = append([]Entry(nil), req.Entries...)
+	return node.HandleAppendEntries(req)
+}
+
+func (t *inMemTransport) InstallSnapshot(_ context.Context, to string, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error) {
+	node, err := t.network.node(t.from, to)
+	if err != nil {
+		return nil, err
+	}
+	return node.HandleInstallSnapshot(req)
+}
+
+// HTTPTransport sends the RPCs as JSON over HTTP to the Handler of the other nodes.
+type HTTPTransport struct {
+	addresses map[string]string
+	client    *http.Client
+}
+
+// NewHTTPTransport creates an HTTPTransport. addresses maps node IDs to the base URLs of their Handler,
+// e.g. http://10.0.0.1:7001.
+func NewHTTPTransport(addresses map[string]string) *HTTPTransport {
+	return &HTTPTransport{addresses: addresses, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (t *HTTPTransport) RequestVote(ctx context.Context, to string, req *RequestVoteRequest) (*RequestVoteResponse, error) {
+	resp := &RequestVoteResponse{}
+	return resp, t.call(ctx, to, "/raft/vote", req, resp)
+}
+
+func (t *HTTPTransport) AppendEntries(ctx context.Context, to string, req *AppendEntriesRequest) (*AppendEntriesResponse, error) {
+	resp := &AppendEntriesResponse{}
+	return resp, t.call(ctx, to, "/raft/append", req, resp)
+}
+
+func (t *HTTPTransport) InstallSnapshot(ctx context.Context, to string, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error) {
+	resp := &InstallSnapshotResponse{}
+	return resp, t.call(ctx, to, "/raft/snapshot", req, resp)
+}
+
+func (t *HTTPTransport) call(ctx context.Context, to, path string, req, resp any) error {
+	address, ok := t.addresses[to]
+	if !ok {
+		return fmt.Errorf("%w: unknown node %s", ErrUnreachable, to)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, address+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpResp, err := t.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s%s: unexpected status %d", address, path, httpResp.StatusCode)
+	}
+	return json.NewDecoder(httpResp.Body).Decode(resp)
+}
+
+// Handler returns an http.Handler that serves the RPCs sent by HTTPTransport at /raft/vote, /raft/append
+// and /raft/snapshot.
+func (n *Node) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/raft/vote", serveRPC(n.HandleRequestVote))
+	mux.HandleFunc("/raft/append", serveRPC(n.HandleAppendEntries))
+	mux.HandleFunc("/raft/snapshot", serveRPC(n.HandleInstallSnapshot))
+	return mux
+}
+
+func serveRPC[Req, Resp any](handle func(*Req) (*Resp, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		req := new(Req)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := handle(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,160 @@
+package block_cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Priority decides which blocks are evicted first, blocks with the Low priority are evicted before any High block.
+type Priority int
+
+const (
+	Low  Priority = iota // index and data pages
+	High                 // filters, summaries and dictionaries, which are needed by every read of a table
+)
+
+// Key identifies a block by its file and the offset of the block in the file.
+type Key struct {
+	Filename string
+	Offset   int64
+}
+
+// Stats counts the lookups of a kind of block.
+type Stats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// HitRate returns the share of the lookups that were hits, or 0 if there were none.
+func (s Stats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type entry struct {
+	key      Key
+	value    any
+	size     uint64
+	priority Priority
+}
+
+// BlockCache is an LRU cache of blocks read from files, bounded by the total size of the blocks in bytes.
+// It is safe for concurrent use.
+type BlockCache struct {
+	mu        sync.Mutex
+	capacity  uint64
+	size      uint64
+	entries   map[Key]*list.Element
+	lists     [High + 1]*list.List // by priority, the most recently used block is at the front
+	stats     map[string]*Stats    // by kind of block
+	evictions uint64
+}
+
+// NewBlockCache creates a BlockCache that holds at most capacity bytes of blocks.
+func NewBlockCache(capacity uint64) *BlockCache {
+	c := &BlockCache{
+		capacity: capacity,
+		entries:  make(map[Key]*list.Element),
+		stats:    make(map[string]*Stats),
+	}
+	for i := range c.lists {
+		c.lists[i] = list.New()
+	}
+	return c
+}
+
+// Get returns the cached block with the given key, and counts a hit or a miss for the kind of block.
+func (c *BlockCache) Get(kind string, key Key) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.stats[kind]
+	if !ok {
+		s = &Stats{}
+		c.stats[kind] = s
+	}
+	element, ok := c.entries[key]
+	if !ok {
+		s.Misses++
+		return nil, false
+	}
+	s.Hits++
+	e := element.Value.(*entry)
+	c.lists[e.priority].MoveToFront(element)
+	return e.value, true
+}
+
+// Put adds or replaces the block with the given key, which takes size bytes.
+// Least recently used blocks are evicted until the cache fits in its capacity, Low blocks first.
+// Blocks larger than the capacity are not cached.
+func (c *BlockCache) Put(key Key, value any, size uint64, priority Priority) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	if size > c.capacity {
+		return
+	}
+	c.entries[key] = c.lists[priority].PushFront(&entry{key, value, size, priority})
+	c.size += size
+
+	for c.size > c.capacity {
+		l := c.lists[Low]
+		if l.Len() == 0 {
+			l = c.lists[High]
+		}
+		c.remove(l.Back())
+		c.evictions++
+	}
+}
+
+// DropFile removes all blocks of the given file, which must be done when the file is deleted, renamed or rewritten.
+func (c *BlockCache) DropFile(filename string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, element := range c.entries {
+		if key.Filename == filename {
+			c.remove(element)
+		}
+	}
+}
+
+func (c *BlockCache) remove(element *list.Element) {
+	e := element.Value.(*entry)
+	c.lists[e.priority].Remove(element)
+	delete(c.entries, e.key)
+	c.size -= e.size
+}
+
+// Stats returns the hits and misses of every kind of block that was looked up.
+func (c *BlockCache) Stats() map[string]Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := make(map[string]Stats, len(c.stats))
+	for kind, s := range c.stats {
+		stats[kind] = *s
+	}
+	return stats
+}
+
+// Size returns the number of bytes of the cached blocks and the capacity of the cache.
+func (c *BlockCache) Size() (size uint64, capacity uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size, c.capacity
+}
+
+// Evictions returns the number of blocks that were evicted to make room for others.
+func (c *BlockCache) Evictions() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.evictions
+}
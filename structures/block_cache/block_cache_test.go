@@ -0,0 +1,72 @@
+package block_cache
+
+import (
+	"testing"
+)
+
+func TestBlockCache(t *testing.T) {
+	cache := NewBlockCache(100)
+	key := func(file string, offset int64) Key {
+		return Key{Filename: file, Offset: offset}
+	}
+
+	cache.Put(key("a", 0), "filter", 40, High)
+	cache.Put(key("a", 40), "page1", 30, Low)
+	cache.Put(key("b", 0), "page2", 30, Low)
+	if size, capacity := cache.Size(); size != 100 || capacity != 100 {
+		t.Fatalf("Expected 100 of 100 bytes, got %d of %d", size, capacity)
+	}
+
+	// the least recently used low priority block is evicted first, even if a high one is older
+	if v, ok := cache.Get("data", key("a", 40)); !ok || v != "page1" {
+		t.Fatalf("Expected page1, got %v", v)
+	}
+	cache.Put(key("b", 30), "page3", 30, Low)
+	if _, ok := cache.Get("data", key("b", 0)); ok {
+		t.Errorf("Expected page2 to be evicted")
+	}
+	if _, ok := cache.Get("filter", key("a", 0)); !ok {
+		t.Errorf("Expected the filter to stay cached")
+	}
+
+	// high priority blocks are evicted once there are no low ones
+	cache.Put(key("c", 0), "summary", 90, High)
+	for _, k := range []Key{key("a", 0), key("a", 40), key("b", 30)} {
+		if _, ok := cache.Get("data", k); ok {
+			t.Errorf("Expected %v to be evicted", k)
+		}
+	}
+	if cache.Evictions() != 4 {
+		t.Errorf("Expected 4 evictions, got %d", cache.Evictions())
+	}
+
+	// blocks larger than the cache are not cached
+	cache.Put(key("d", 0), "huge", 101, High)
+	if _, ok := cache.Get("summary", key("d", 0)); ok {
+		t.Errorf("Expected a block larger than the cache not to be cached")
+	}
+
+	// replacing a block updates the size
+	cache.Put(key("c", 0), "summary", 10, High)
+	if size, _ := cache.Size(); size != 10 {
+		t.Errorf("Expected 10 bytes, got %d", size)
+	}
+
+	cache.Put(key("e", 0), "page", 10, Low)
+	cache.Put(key("e", 10), "page", 10, Low)
+	cache.DropFile("e")
+	if _, ok := cache.Get("data", key("e", 10)); ok {
+		t.Errorf("Expected the blocks of a dropped file to be removed")
+	}
+	if size, _ := cache.Size(); size != 10 {
+		t.Errorf("Expected 10 bytes, got %d", size)
+	}
+
+	stats := cache.Stats()
+	if s := stats["data"]; s.Hits != 1 || s.Misses != 5 {
+		t.Errorf("Expected 1 hit and 5 misses of data blocks, got %+v", s)
+	}
+	if s := stats["filter"]; s.Hits != 1 || s.Misses != 0 || s.HitRate() != 1 {
+		t.Errorf("Expected 1 hit of filters, got %+v", s)
+	}
+}
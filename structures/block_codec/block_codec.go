@@ -0,0 +1,151 @@
+package block_codec
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// ErrUnknownCodec is returned by Get for a codec that was not registered.
+var ErrUnknownCodec = errors.New("unknown block codec")
+
+// ErrCorrupt is returned when compressed data can't be decoded.
+var ErrCorrupt = errors.New("corrupt compressed block")
+
+// Codec compresses the blocks of SSTable data blocks.
+type Codec interface {
+	// Name identifies the codec in the files the blocks are stored in, so it must not change.
+	Name() string
+	// Encode returns the compressed src.
+	Encode(src []byte) ([]byte, error)
+	// Decode returns the decompressed src, which is size bytes long.
+	Decode(src []byte, size int) ([]byte, error)
+}
+
+var codecs = struct {
+	sync.RWMutex
+	byName map[string]Codec
+}{byName: map[string]Codec{}}
+
+func init() {
+	Register(noneCodec{})
+	Register(flateCodec{})
+	Register(zlibCodec{})
+	Register(snappyCodec{})
+}
+
+// Register makes the codec available to Get under its name, replacing a codec with the same name.
+func Register(codec Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.byName[codec.Name()] = codec
+}
+
+// Get returns the codec with the given name, or ErrUnknownCodec.
+func Get(name string) (Codec, error) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	codec, ok := codecs.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, name)
+	}
+	return codec, nil
+}
+
+// Names returns the names of the registered codecs in lexicographic order.
+func Names() []string {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	names := make([]string, 0, len(codecs.byName))
+	for name := range codecs.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// noneCodec stores blocks as they are.
+type noneCodec struct{}
+
+func (noneCodec) Name() string { return "none" }
+
+func (noneCodec) Encode(src []byte) ([]byte, error) {
+	return append([]byte(nil), src...), nil
+}
+
+func (noneCodec) Decode(src []byte, size int) ([]byte, error) {
+	if len(src) != size {
+		return nil, ErrCorrupt
+	}
+	return append([]byte(nil), src...), nil
+}
+
+// flateCodec compresses blocks with DEFLATE (compress/flate).
+type flateCodec struct{}
+
+func (flateCodec) Name() string { return "flate" }
+
+func (flateCodec) Encode(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (flateCodec) Decode(src []byte, size int) ([]byte, error) {
+	return readAll(flate.NewReader(bytes.NewReader(src)), size)
+}
+
+// zlibCodec compresses blocks with DEFLATE in the zlib format, which adds a checksum (compress/zlib).
+type zlibCodec struct{}
+
+func (zlibCodec) Name() string { return "zlib" }
+
+func (zlibCodec) Encode(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (zlibCodec) Decode(src []byte, size int) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorrupt, err)
+	}
+	return readAll(r, size)
+}
+
+// readAll reads exactly size bytes and closes r.
+func readAll(r io.ReadCloser, size int) ([]byte, error) {
+	defer r.Close()
+	dst := make([]byte, size)
+	if _, err := io.ReadFull(r, dst); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorrupt, err)
+	}
+	// the end of the stream is only reached by reading past the data, which also verifies the checksum of zlib
+	if n, err := r.Read(make([]byte, 1)); n != 0 {
+		return nil, fmt.Errorf("%w: longer than %d bytes", ErrCorrupt, size)
+	} else if err != io.EOF {
+		return nil, fmt.Errorf("%w: %w", ErrCorrupt, err)
+	}
+	return dst, nil
+}
@@ -0,0 +1,67 @@
+package block_codec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestCodecs(t *testing.T) {
+	var json bytes.Buffer
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&json, `{"id":%d,"name":"user%d","active":true,"tags":["a","b"]}`, i, i%7)
+	}
+	random := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(random)
+	inputs := map[string][]byte{
+		"empty":  {},
+		"short":  []byte("abc"),
+		"json":   json.Bytes(),
+		"random": random,
+		"zeros":  make([]byte, 100000),
+	}
+
+	for _, name := range Names() {
+		codec, err := Get(name)
+		if err != nil {
+			t.Fatalf("Failed to get codec %s: %v", name, err)
+		}
+		for input, data := range inputs {
+			encoded, err := codec.Encode(data)
+			if err != nil {
+				t.Fatalf("%s: failed to encode %s: %v", name, input, err)
+			}
+			decoded, err := codec.Decode(encoded, len(data))
+			if err != nil || !bytes.Equal(decoded, data) {
+				t.Errorf("%s: expected %s to round trip, got %d bytes, %v", name, input, len(decoded), err)
+			}
+			if name != "none" && input == "json" && len(encoded) > len(data)/3 {
+				t.Errorf("%s: expected repetitive JSON to compress to a third, got %d of %d bytes", name, len(encoded), len(data))
+			}
+			if _, err := codec.Decode(encoded, len(data)+1); err == nil {
+				t.Errorf("%s: expected an error decoding %s with a wrong size", name, input)
+			}
+		}
+	}
+
+	if _, err := Get("lzma"); !errors.Is(err, ErrUnknownCodec) {
+		t.Errorf("Expected ErrUnknownCodec, got %v", err)
+	}
+}
+
+func TestSnappy_Format(t *testing.T) {
+	codec, _ := Get("snappy")
+	// the example from the format description: a literal and an overlapping copy with a 1 byte offset
+	encoded := []byte{20, 1<<2 | snappyTagLiteral, 'a', 'b', (10-4)<<2 | snappyTagCopy1, 2, 7<<2 | snappyTagCopy2, 1, 0}
+	decoded, err := codec.Decode(encoded, 20)
+	if err != nil || string(decoded) != "ababababababbbbbbbbb" {
+		t.Errorf("Expected to decode the copies, got %q, %v", decoded, err)
+	}
+	for _, corrupt := range [][]byte{{5, 0}, {3, 0 << 2, 'a', 2<<2 | snappyTagCopy2, 5, 0}, {1}} {
+		if _, err := codec.Decode(corrupt, int(corrupt[0])); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Expected ErrCorrupt for %v, got %v", corrupt, err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package block_codec
+
+import (
+	"encoding/binary"
+)
+
+/*
+	=== SNAPPY BLOCK FORMAT ===
+
+	The uncompressed length as a uvarint, followed by elements that either copy literal bytes
+	or repeat earlier output. The low 2 bits of the first byte of an element are its tag:
+
+	00 = literal, the upper 6 bits are the length - 1, or 60-63 for a length - 1 in the next 1-4 bytes
+	01 = copy of 4-11 bytes, bits 2-4 are the length - 4, bits 5-7 and the next byte the offset (< 2048)
+	10 = copy of 1-64 bytes, the upper 6 bits are the length - 1, the next 2 bytes the offset
+	11 = copy like 10 with a 4 byte offset
+
+	Multi-byte numbers are little endian. The encoder never emits 11, which the decoder accepts.
+*/
+
+const (
+	snappyTagLiteral = 0
+	snappyTagCopy1   = 1
+	snappyTagCopy2   = 2
+	snappyTagCopy4   = 3
+
+	snappyHashBits   = 14
+	snappyMinMatch   = 4
+	snappyMaxOffset  = 1<<16 - 1 // the offset must fit into a copy with a 2 byte offset
+	snappyInputLimit = 15        // inputs shorter than this are stored as a single literal
+)
+
+// snappyCodec compresses blocks in the Snappy block format, which is fast but compresses less than DEFLATE.
+type snappyCodec struct{}
+
+func (snappyCodec) Name() string { return "snappy" }
+
+func (snappyCodec) Encode(src []byte) ([]byte, error) {
+	dst := binary.AppendUvarint(make([]byte, 0, len(src)+len(src)/6+16), uint64(len(src)))
+	if len(src) < snappyInputLimit {
+		return snappyLiteral(dst, src), nil
+	}
+
+	var table [1 << snappyHashBits]int32 // position + 1 of the last occurrence of a hash, 0 if none
+	literalStart := 0
+	for i := 0; i+snappyMinMatch <= len(src); {
+		h := snappyHash(binary.LittleEndian.Uint32(src[i:]))
+		candidate := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if candidate < 0 || i-candidate > snappyMaxOffset ||
+			binary.LittleEndian.Uint32(src[candidate:]) != binary.LittleEndian.Uint32(src[i:]) {
+			i++
+			continue
+		}
+
+		dst = snappyLiteral(dst, src[literalStart:i])
+		length := snappyMinMatch
+		for i+length < len(src) && src[candidate+length] == src[i+length] {
+			length++
+		}
+		dst = snappyCopy(dst, i-candidate, length)
+		i += length
+		literalStart = i
+	}
+	return snappyLiteral(dst, src[literalStart:]), nil
+}
+
+func (snappyCodec) Decode(src []byte, size int) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 || length != uint64(size) {
+		return nil, ErrCorrupt
+	}
+	dst := make([]byte, 0, size)
+	for s := n; s < len(src); {
+		tag := src[s]
+		var offset, length int
+		switch tag & 3 {
+		case snappyTagLiteral:
+			length = int(tag >> 2)
+			s++
+			if length >= 60 {
+				extra := length - 59
+				if s+extra > len(src) {
+					return nil, ErrCorrupt
+				}
+				length = 0
+				for j := extra - 1; j >= 0; j-- {
+					length = length<<8 | int(src[s+j])
+				}
+				s += extra
+			}
+			length++
+			if length > len(src)-s || length > size-len(dst) {
+				return nil, ErrCorrupt
+			}
+			dst = append(dst, src[s:s+length]...)
+			s += length
+			continue
+		case snappyTagCopy1:
+			if s+2 > len(src) {
+				return nil, ErrCorrupt
+			}
+			length = 4 + int(tag>>2&7)
+			offset = int(tag>>5)<<8 | int(src[s+1])
+			s += 2
+		case snappyTagCopy2:
+			if s+3 > len(src) {
+				return nil, ErrCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[s+1:]))
+			s += 3
+		case snappyTagCopy4:
+			if s+5 > len(src) {
+				return nil, ErrCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[s+1:]))
+			s += 5
+		}
+		if offset <= 0 || offset > len(dst) || length > size-len(dst) {
+			return nil, ErrCorrupt
+		}
+		// the copy can overlap its own output, so it is done byte by byte
+		for j := 0; j < length; j++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if len(dst) != size {
+		return nil, ErrCorrupt
+	}
+	return dst, nil
+}
+
+func snappyHash(u uint32) uint32 {
+	return (u * 0x1e35a7bd) >> (32 - snappyHashBits)
+}
+
+// snappyLiteral appends a literal element with the given bytes to dst.
+func snappyLiteral(dst, literal []byte) []byte {
+	if len(literal) == 0 {
+		return dst
+	}
+	n := len(literal) - 1
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|snappyTagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|snappyTagLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|snappyTagLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, literal...)
+}
+
+// snappyCopy appends copy elements that repeat length bytes from offset bytes back to dst.
+func snappyCopy(dst []byte, offset, length int) []byte {
+	for length > 0 {
+		n := min(length, 64) // a copy holds at most 64 bytes
+		if n >= 4 && n <= 11 && offset < 2048 {
+			dst = append(dst, byte(offset>>8)<<5|byte(n-4)<<2|snappyTagCopy1, byte(offset))
+		} else {
+			dst = append(dst, byte(n-1)<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+		}
+		length -= n
+	}
+	return dst
+}
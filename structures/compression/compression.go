@@ -2,6 +2,7 @@ package compression
 
 import (
 	"encoding/binary"
+	"nasp-project/structures/encryption"
 	"os"
 	"path/filepath"
 )
@@ -65,8 +66,8 @@ func Deserialize(data []byte) *Dictionary {
 	offset := 0
 	for {
 		keySize, n := binary.Uvarint(data[offset:])
-		if n <= 0 {
-			break
+		if n <= 0 || keySize > uint64(len(data)-offset-n) {
+			break // the rest of a damaged dictionary is left out
 		}
 		key := data[offset+n : offset+n+int(keySize)]
 		keys = append(keys, key)
@@ -89,58 +90,38 @@ func LoadCompressionDictFromFile(savePath, filename string) (*Dictionary, error)
 	} else if err != nil {
 		return nil, err
 	}
-	data, err := os.ReadFile(path)
+	data, err := encryption.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	return Deserialize(data), nil
-}
+	dict := Deserialize(data)
 
-func WriteCompressionDictToFile(compressionDict *Dictionary, savePath, filename string) error {
-	file, err := os.Create(filepath.Join(savePath, filename))
-	if os.IsNotExist(err) {
-		err := os.MkdirAll(savePath, 0755)
-		if err != nil {
-			return err
+	// re-encrypt the dictionary with the current key, or encrypt it when encryption was just enabled
+	if outdated, err := encryption.Outdated(path); err != nil {
+		return nil, err
+	} else if outdated {
+		if err := WriteCompressionDictToFile(dict, savePath, filename); err != nil {
+			return nil, err
 		}
-		file, err = os.Create(filepath.Join(savePath, filename))
-	} else if err != nil {
-		return err
 	}
-	defer file.Close()
-	_, err = file.Write(compressionDict.Serialize())
-	if err != nil {
-		return err
-	}
-	return nil
+	return dict, nil
 }
 
-// AppendLastToFile assumes that all but last record are written to file and appends the last record to the end.
-// Call this function after Dictionary.Add returns true to update the structure on disk.
-func (d *Dictionary) AppendLastToFile(savePath, filename string) error {
-	file, err := os.OpenFile(filepath.Join(savePath, filename), os.O_APPEND|os.O_WRONLY, 0644)
+func WriteCompressionDictToFile(compressionDict *Dictionary, savePath, filename string) error {
+	file, err := encryption.Create(filepath.Join(savePath, filename))
 	if os.IsNotExist(err) {
 		err := os.MkdirAll(savePath, 0755)
 		if err != nil {
 			return err
 		}
-		file, err = os.Create(filepath.Join(savePath, filename))
+		file, err = encryption.Create(filepath.Join(savePath, filename))
 	} else if err != nil {
 		return err
 	}
 	defer file.Close()
-
-	buf := make([]byte, binary.MaxVarintLen64)
-	n := binary.PutUvarint(buf, uint64(len(d.keys[len(d.keys)-1])))
-	_, err = file.Write(buf[:n])
-	if err != nil {
-		return err
-	}
-
-	_, err = file.Write(d.keys[len(d.keys)-1])
+	_, err = file.Write(compressionDict.Serialize())
 	if err != nil {
 		return err
 	}
-
 	return nil
 }
@@ -0,0 +1,123 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestEncryptedFile(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "encryption_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	keyFile := path.Join(tmpDir, "keys")
+	if err := os.WriteFile(keyFile, []byte("# keys\nk1:000102030405060708090a0b0c0d0e0f000102030405060708090a0b0c0d0e0f\n"), 0600); err != nil {
+		t.Fatalf("Failed to write the key file: %v", err)
+	}
+	keys, err := NewFileKeyProvider(keyFile)
+	if err != nil {
+		t.Fatalf("Failed to read the key file: %v", err)
+	}
+	SetKeyProvider(keys)
+	defer SetKeyProvider(nil)
+
+	// write across chunk boundaries, then overwrite the middle and append
+	name := path.Join(tmpDir, "data")
+	data := bytes.Repeat([]byte("0123456789secret"), 1000)
+	file, err := Create(name)
+	if err != nil {
+		t.Fatalf("Failed to create: %v", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if _, err := file.Seek(5000, io.SeekStart); err != nil {
+		t.Fatalf("Failed to seek: %v", err)
+	}
+	if _, err := file.Write([]byte("overwritten")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	file.Close()
+	copy(data[5000:], "overwritten")
+
+	file, err = OpenFile(name, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open for appending: %v", err)
+	}
+	file.Write([]byte("appended"))
+	file.Close()
+	data = append(data, "appended"...)
+
+	raw, _ := os.ReadFile(name)
+	if bytes.Contains(raw, []byte("secret")) {
+		t.Errorf("Expected the file to be encrypted")
+	}
+	if read, err := ReadFile(name); err != nil || !bytes.Equal(read, data) {
+		t.Errorf("Expected to read back the written data, got %d bytes, %v", len(read), err)
+	}
+	file, _ = Open(name)
+	buf := make([]byte, 11)
+	if _, err := file.Seek(-int64(len(data))+5000, io.SeekEnd); err != nil {
+		t.Fatalf("Failed to seek: %v", err)
+	}
+	if _, err := io.ReadFull(file, buf); err != nil || string(buf) != "overwritten" {
+		t.Errorf("Expected to read at an offset, got %q, %v", buf, err)
+	}
+	file.Close()
+
+	// a rotated key encrypts new files, old files stay readable
+	if outdated, err := Outdated(name); err != nil || outdated {
+		t.Errorf("Expected the file to use the current key, got %v, %v", outdated, err)
+	}
+	k1, _ := keys.Key("k1")
+	rotated, _ := NewKeyring(map[string][]byte{"k1": k1, "k2": bytes.Repeat([]byte{7}, 16)}, "k2")
+	SetKeyProvider(rotated)
+	if outdated, err := Outdated(name); err != nil || !outdated {
+		t.Errorf("Expected the file to be outdated after the rotation, got %v, %v", outdated, err)
+	}
+	if read, err := ReadFile(name); err != nil || !bytes.Equal(read, data) {
+		t.Errorf("Expected to read with the old key, got %v", err)
+	}
+
+	// tampering is detected
+	raw[len(raw)-1] ^= 1
+	os.WriteFile(name, raw, 0644)
+	if _, err := ReadFile(name); err == nil {
+		t.Errorf("Expected an error for a modified file")
+	}
+
+	SetKeyProvider(nil)
+	if _, err := Open(name); !errors.Is(err, ErrNoKeyProvider) {
+		t.Errorf("Expected ErrNoKeyProvider, got %v", err)
+	}
+}
+
+func TestSeal(t *testing.T) {
+	t.Setenv("TEST_ENCRYPTION_KEYS", "a:00112233445566778899aabbccddeeff,b:ffeeddccbbaa99887766554433221100")
+	keys, err := NewEnvKeyProvider("TEST_ENCRYPTION_KEYS")
+	if err != nil {
+		t.Fatalf("Failed to read the keys: %v", err)
+	}
+	if id, _, _ := keys.CurrentKey(); id != "b" {
+		t.Errorf("Expected the last key to be current, got %s", id)
+	}
+	SetKeyProvider(keys)
+	defer SetKeyProvider(nil)
+
+	envelope, err := Seal([]byte("value"), []byte("ad"))
+	if err != nil {
+		t.Fatalf("Failed to seal: %v", err)
+	}
+	if plaintext, err := Unseal(envelope, []byte("ad")); err != nil || string(plaintext) != "value" {
+		t.Errorf("Expected to open the envelope, got %q, %v", plaintext, err)
+	}
+	if _, err := Unseal(envelope, []byte("other")); err == nil {
+		t.Errorf("Expected an error for different additional data")
+	}
+}
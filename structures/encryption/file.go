@@ -0,0 +1,395 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// magic starts every encrypted file, files without it are read as plain files.
+var magic = []byte("NASPENC1")
+
+const (
+	fileIDSize = 16
+	nonceSize  = 12
+	tagSize    = 16
+	// chunkSize is the number of plaintext bytes sealed together. Chunks are sealed separately so that
+	// a file can be read and written at any offset.
+	chunkSize = 4096
+)
+
+// File is an open file that is either plain or encrypted. *os.File is a File.
+type File interface {
+	io.ReadWriteSeeker
+	io.Closer
+}
+
+// encryptedFile is a File that stores its data in AES-GCM sealed chunks after the header
+// magic | key ID size (1 byte) | key ID | file ID | chunk size (4 bytes).
+// Every chunk is nonce | ciphertext | tag, and is authenticated with the file ID and its index,
+// so chunks can't be moved within or between files. Offsets and sizes are those of the plaintext.
+type encryptedFile struct {
+	file       *os.File
+	aead       cipher.AEAD
+	fileID     []byte
+	headerSize int64
+	chunkSize  int64
+	size       int64 // plaintext size
+	offset     int64 // plaintext offset
+	chunk      []byte
+	chunkIndex int64 // index of the chunk in the buffer, or -1
+	dirty      bool
+}
+
+// Open opens the file for reading, see OpenFile.
+func Open(name string) (File, error) {
+	return OpenFile(name, os.O_RDONLY, 0)
+}
+
+// Create creates or truncates the file for reading and writing, see OpenFile.
+func Create(name string) (File, error) {
+	return OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// OpenFile opens the file like os.OpenFile. Files that start with the header of an encrypted file are decrypted,
+// other existing files are returned as *os.File. New and truncated files are encrypted with the current key
+// if a KeyProvider is set. Returns ErrNoKeyProvider when an encrypted file is opened without a KeyProvider.
+func OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	p := getKeyProvider()
+	if p == nil {
+		if flag&os.O_TRUNC == 0 {
+			if encrypted, err := fileIsEncrypted(name); err != nil {
+				return nil, err
+			} else if encrypted {
+				return nil, fmt.Errorf("%s: %w", name, ErrNoKeyProvider)
+			}
+		}
+		return os.OpenFile(name, flag, perm)
+	}
+
+	// the chunks are read back before they are rewritten, so the file is never write-only
+	writing := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	osFlag := flag &^ (os.O_WRONLY | os.O_APPEND)
+	if writing {
+		osFlag |= os.O_RDWR
+	}
+	file, err := os.OpenFile(name, osFlag, perm)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	var f *encryptedFile
+	if info.Size() == 0 && writing {
+		f, err = newEncryptedFile(file, p)
+	} else {
+		var encrypted bool
+		encrypted, err = isEncrypted(file)
+		if err == nil && !encrypted {
+			// a plain file written before the encryption was enabled
+			if flag&os.O_APPEND != 0 {
+				_, err = file.Seek(0, io.SeekEnd)
+			}
+			if err == nil {
+				return file, nil
+			}
+		} else if err == nil {
+			f, err = openEncryptedFile(file, p, info.Size())
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if flag&os.O_APPEND != 0 {
+		f.offset = f.size
+	}
+	return f, nil
+}
+
+// ReadFile reads a plain or encrypted file, see OpenFile.
+func ReadFile(name string) ([]byte, error) {
+	file, err := Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// Outdated returns true if the file is not encrypted with the current key, i.e. if it is plain while a KeyProvider
+// is set, or encrypted with an older key. Such files are re-encrypted when they are rewritten.
+func Outdated(name string) (bool, error) {
+	p := getKeyProvider()
+	file, err := os.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	encrypted, err := isEncrypted(file)
+	if err != nil || !encrypted {
+		return err == nil && p != nil, err
+	}
+	if p == nil {
+		return false, nil
+	}
+	id, err := readKeyID(file)
+	if err != nil {
+		return false, err
+	}
+	current, _, err := p.CurrentKey()
+	return id != current, err
+}
+
+func fileIsEncrypted(name string) (bool, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer file.Close()
+	return isEncrypted(file)
+}
+
+func isEncrypted(file *os.File) (bool, error) {
+	header := make([]byte, len(magic))
+	if _, err := file.ReadAt(header, 0); err != nil {
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	return bytes.Equal(header, magic), nil
+}
+
+func readKeyID(file *os.File) (string, error) {
+	size := make([]byte, 1)
+	if _, err := file.ReadAt(size, int64(len(magic))); err != nil {
+		return "", err
+	}
+	id := make([]byte, size[0])
+	if _, err := file.ReadAt(id, int64(len(magic))+1); err != nil {
+		return "", err
+	}
+	return string(id), nil
+}
+
+func newEncryptedFile(file *os.File, p KeyProvider) (*encryptedFile, error) {
+	id, key, err := p.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(id, key)
+	if err != nil {
+		return nil, err
+	}
+	fileID := make([]byte, fileIDSize)
+	if _, err := rand.Read(fileID); err != nil {
+		return nil, err
+	}
+	header := append([]byte{}, magic...)
+	header = append(header, byte(len(id)))
+	header = append(header, id...)
+	header = append(header, fileID...)
+	header = binary.BigEndian.AppendUint32(header, chunkSize)
+	if _, err := file.WriteAt(header, 0); err != nil {
+		return nil, err
+	}
+	return &encryptedFile{
+		file:       file,
+		aead:       aead,
+		fileID:     fileID,
+		headerSize: int64(len(header)),
+		chunkSize:  chunkSize,
+		chunkIndex: -1,
+	}, nil
+}
+
+func openEncryptedFile(file *os.File, p KeyProvider, fileSize int64) (*encryptedFile, error) {
+	id, err := readKeyID(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := p.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(id, key)
+	if err != nil {
+		return nil, err
+	}
+	headerSize := int64(len(magic)) + 1 + int64(len(id)) + fileIDSize + 4
+	rest := make([]byte, fileIDSize+4)
+	if _, err := file.ReadAt(rest, headerSize-int64(len(rest))); err != nil {
+		return nil, err
+	}
+	f := &encryptedFile{
+		file:       file,
+		aead:       aead,
+		fileID:     rest[:fileIDSize],
+		headerSize: headerSize,
+		chunkSize:  int64(binary.BigEndian.Uint32(rest[fileIDSize:])),
+		chunkIndex: -1,
+	}
+	if f.chunkSize == 0 {
+		return nil, errors.New("invalid encrypted file header")
+	}
+
+	// the plaintext size follows from the size of the last, possibly partial, chunk
+	data := fileSize - headerSize
+	sealed := f.chunkSize + nonceSize + tagSize
+	f.size = data / sealed * f.chunkSize
+	if last := data % sealed; last > 0 {
+		if last <= nonceSize+tagSize {
+			return nil, errors.New("truncated encrypted file")
+		}
+		f.size += last - nonceSize - tagSize
+	}
+	return f, nil
+}
+
+var aeads sync.Map // key ID and key -> cipher.AEAD
+
+func newAEAD(id string, key []byte) (cipher.AEAD, error) {
+	cacheKey := id + "\x00" + string(key)
+	if aead, ok := aeads.Load(cacheKey); ok {
+		return aead.(cipher.AEAD), nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	aeads.Store(cacheKey, aead)
+	return aead, nil
+}
+
+func (f *encryptedFile) chunkOffset(index int64) int64 {
+	return f.headerSize + index*(f.chunkSize+nonceSize+tagSize)
+}
+
+func (f *encryptedFile) additionalData(index int64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte{}, f.fileID...), uint64(index))
+}
+
+// loadChunk makes the chunk with the given index the buffered one.
+func (f *encryptedFile) loadChunk(index int64) error {
+	if f.chunkIndex == index {
+		return nil
+	}
+	if err := f.flush(); err != nil {
+		return err
+	}
+	f.chunk = f.chunk[:0]
+	f.chunkIndex = -1
+	length := min(f.chunkSize, f.size-index*f.chunkSize)
+	if length > 0 {
+		sealed := make([]byte, nonceSize+length+tagSize)
+		if _, err := f.file.ReadAt(sealed, f.chunkOffset(index)); err != nil {
+			return err
+		}
+		chunk, err := f.aead.Open(f.chunk, sealed[:nonceSize], sealed[nonceSize:], f.additionalData(index))
+		if err != nil {
+			return fmt.Errorf("chunk %d: %w", index, err)
+		}
+		f.chunk = chunk
+	}
+	f.chunkIndex = index
+	return nil
+}
+
+// flush seals the buffered chunk with a new nonce and writes it, if it was changed.
+func (f *encryptedFile) flush() error {
+	if !f.dirty {
+		return nil
+	}
+	sealed := make([]byte, nonceSize, nonceSize+len(f.chunk)+tagSize)
+	if _, err := rand.Read(sealed); err != nil {
+		return err
+	}
+	sealed = f.aead.Seal(sealed, sealed, f.chunk, f.additionalData(f.chunkIndex))
+	if _, err := f.file.WriteAt(sealed, f.chunkOffset(f.chunkIndex)); err != nil {
+		return err
+	}
+	f.dirty = false
+	return nil
+}
+
+func (f *encryptedFile) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) && f.offset < f.size {
+		if err := f.loadChunk(f.offset / f.chunkSize); err != nil {
+			return n, err
+		}
+		copied := copy(p[n:], f.chunk[f.offset%f.chunkSize:])
+		n += copied
+		f.offset += int64(copied)
+	}
+	if n == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (f *encryptedFile) Write(p []byte) (int, error) {
+	if f.offset > f.size {
+		// fill the gap left by seeking past the end with zeros, like a sparse file
+		offset := f.offset
+		f.offset = f.size
+		if _, err := f.Write(make([]byte, offset-f.size)); err != nil {
+			return 0, err
+		}
+	}
+	n := 0
+	for n < len(p) {
+		if err := f.loadChunk(f.offset / f.chunkSize); err != nil {
+			return n, err
+		}
+		start := int(f.offset % f.chunkSize)
+		end := min(start+len(p)-n, int(f.chunkSize))
+		if end > len(f.chunk) {
+			f.chunk = append(f.chunk, make([]byte, end-len(f.chunk))...)
+		}
+		copy(f.chunk[start:end], p[n:])
+		f.dirty = true
+		n += end - start
+		f.offset += int64(end - start)
+		f.size = max(f.size, f.offset)
+	}
+	return n, nil
+}
+
+func (f *encryptedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *encryptedFile) Close() error {
+	err := f.flush()
+	return errors.Join(err, f.file.Close())
+}
@@ -0,0 +1,133 @@
+package encryption
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownKey is returned when data was encrypted with a key the KeyProvider doesn't have.
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// ErrNoKeyProvider is returned when encrypted data is read while encryption is not configured.
+var ErrNoKeyProvider = errors.New("encrypted data, but no encryption key provider is set")
+
+// KeyProvider supplies the AES keys of the encryption at rest. Every key has an ID that is stored with the data
+// it encrypted, so that old data stays readable after the current key is rotated.
+type KeyProvider interface {
+	// CurrentKey returns the ID and the key new data is encrypted with.
+	CurrentKey() (string, []byte, error)
+	// Key returns the key with the given ID, or ErrUnknownKey.
+	Key(id string) ([]byte, error)
+}
+
+// Keyring is a KeyProvider with a fixed set of keys.
+type Keyring struct {
+	keys    map[string][]byte
+	current string
+}
+
+var _ KeyProvider = (*Keyring)(nil)
+
+// NewKeyring creates a Keyring that encrypts with the key with the given ID.
+// Returns an error if the current key is missing or a key is not 16, 24 or 32 bytes long.
+func NewKeyring(keys map[string][]byte, current string) (*Keyring, error) {
+	for id, key := range keys {
+		if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+			return nil, fmt.Errorf("key %s: invalid AES key size %d", id, len(key))
+		}
+		if len(id) == 0 || len(id) > 255 {
+			return nil, fmt.Errorf("key %q: the ID must have between 1 and 255 bytes", id)
+		}
+	}
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, current)
+	}
+	return &Keyring{keys: keys, current: current}, nil
+}
+
+// NewFileKeyProvider reads the keys from a file with a key per line in the form id:hex-encoded-key.
+// Empty lines and lines starting with # are skipped. The last key is the current one, so a key is rotated
+// by appending a new one, and the old ones must be kept while data encrypted with them exists.
+func NewFileKeyProvider(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseKeys(strings.Split(string(data), "\n"))
+}
+
+// NewEnvKeyProvider reads the keys from an environment variable with comma separated keys
+// in the form id:hex-encoded-key. The last key is the current one, see NewFileKeyProvider.
+func NewEnvKeyProvider(variable string) (*Keyring, error) {
+	value, ok := os.LookupEnv(variable)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", variable)
+	}
+	return parseKeys(strings.Split(value, ","))
+}
+
+func parseKeys(lines []string) (*Keyring, error) {
+	keys := map[string][]byte{}
+	current := ""
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, errors.New("invalid key, expected id:hex-encoded-key")
+		}
+		key, err := hex.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		keys[strings.TrimSpace(id)] = key
+		current = strings.TrimSpace(id)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption keys")
+	}
+	return NewKeyring(keys, current)
+}
+
+func (k *Keyring) CurrentKey() (string, []byte, error) {
+	return k.current, k.keys[k.current], nil
+}
+
+func (k *Keyring) Key(id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+var provider struct {
+	sync.RWMutex
+	KeyProvider
+}
+
+// SetKeyProvider enables the encryption of the files created from now on with the keys of p,
+// or disables it if p is nil. Encrypted files can only be read while a KeyProvider with their keys is set.
+// The provider is shared by all stores of the process.
+func SetKeyProvider(p KeyProvider) {
+	provider.Lock()
+	defer provider.Unlock()
+	provider.KeyProvider = p
+}
+
+// Enabled returns true if new files are encrypted.
+func Enabled() bool {
+	return getKeyProvider() != nil
+}
+
+func getKeyProvider() KeyProvider {
+	provider.RLock()
+	defer provider.RUnlock()
+	return provider.KeyProvider
+}
@@ -0,0 +1,55 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"errors"
+)
+
+// Seal encrypts a single value with the current key into an envelope of
+// key ID size (1 byte) | key ID | nonce | ciphertext | tag, authenticated together with additionalData.
+// Used for data that is not stored in a File, like the WAL records.
+func Seal(plaintext, additionalData []byte) ([]byte, error) {
+	p := getKeyProvider()
+	if p == nil {
+		return nil, ErrNoKeyProvider
+	}
+	id, key, err := p.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(id, key)
+	if err != nil {
+		return nil, err
+	}
+	envelope := make([]byte, 0, 1+len(id)+nonceSize+len(plaintext)+tagSize)
+	envelope = append(envelope, byte(len(id)))
+	envelope = append(envelope, id...)
+	nonce := envelope[len(envelope) : len(envelope)+nonceSize]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	envelope = envelope[:len(envelope)+nonceSize]
+	return aead.Seal(envelope, nonce, plaintext, additionalData), nil
+}
+
+// Unseal decrypts an envelope created by Seal.
+func Unseal(envelope, additionalData []byte) ([]byte, error) {
+	p := getKeyProvider()
+	if p == nil {
+		return nil, ErrNoKeyProvider
+	}
+	if len(envelope) < 1 || len(envelope) < 1+int(envelope[0])+nonceSize+tagSize {
+		return nil, errors.New("invalid encryption envelope")
+	}
+	id := string(envelope[1 : 1+envelope[0]])
+	key, err := p.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(id, key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := envelope[1+len(id) : 1+len(id)+nonceSize]
+	return aead.Open(nil, nonce, envelope[1+len(id)+nonceSize:], additionalData)
+}
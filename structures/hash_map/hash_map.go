@@ -2,7 +2,6 @@ package hash_map
 
 import (
 	"errors"
-	"fmt"
 	"nasp-project/model"
 	"sort"
 	"time"
@@ -57,7 +56,6 @@ func (hm *HashMap) Flush() []model.Record {
 	}
 
 	hm.data = make(map[string]*model.Record)
-	fmt.Println(records)
 	return records
 }
 
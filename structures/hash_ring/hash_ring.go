@@ -0,0 +1,105 @@
+package hash_ring
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// HashRing assigns keys to members with consistent hashing. Every member owns virtualNodes points on a ring
+// of 64-bit hashes, and a key belongs to the member of the first point at or after the hash of the key.
+// Adding or removing a member only moves the keys of the ranges next to its points, about 1/n of all keys.
+type HashRing struct {
+	virtualNodes int
+	points       []point // sorted by hash
+	members      map[string]bool
+}
+
+type point struct {
+	hash   uint64
+	member string
+}
+
+// NewHashRing creates an empty HashRing with the given number of virtual nodes per member.
+func NewHashRing(virtualNodes int) *HashRing {
+	return &HashRing{virtualNodes: max(virtualNodes, 1), members: map[string]bool{}}
+}
+
+// Clone returns a copy of the ring that is not affected by changes of the original.
+func (r *HashRing) Clone() *HashRing {
+	clone := &HashRing{
+		virtualNodes: r.virtualNodes,
+		points:       append([]point(nil), r.points...),
+		members:      make(map[string]bool, len(r.members)),
+	}
+	for member := range r.members {
+		clone.members[member] = true
+	}
+	return clone
+}
+
+// Add adds a member to the ring. Adding an existing member has no effect.
+func (r *HashRing) Add(member string) {
+	if r.members[member] {
+		return
+	}
+	r.members[member] = true
+	for i := 0; i < r.virtualNodes; i++ {
+		r.points = append(r.points, point{hash: hash([]byte(member + "#" + strconv.Itoa(i))), member: member})
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash != r.points[j].hash {
+			return r.points[i].hash < r.points[j].hash
+		}
+		return r.points[i].member < r.points[j].member
+	})
+}
+
+// Remove removes a member from the ring. Removing a missing member has no effect.
+func (r *HashRing) Remove(member string) {
+	if !r.members[member] {
+		return
+	}
+	delete(r.members, member)
+	points := r.points[:0]
+	for _, p := range r.points {
+		if p.member != member {
+			points = append(points, p)
+		}
+	}
+	r.points = points
+}
+
+// Has returns true if the member is in the ring.
+func (r *HashRing) Has(member string) bool {
+	return r.members[member]
+}
+
+// Members returns the members of the ring in lexicographic order.
+func (r *HashRing) Members() []string {
+	members := make([]string, 0, len(r.members))
+	for member := range r.members {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// Lookup returns the member that owns the key, or an empty string if the ring is empty.
+func (r *HashRing) Lookup(key []byte) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) {
+		i = 0 // wrap around
+	}
+	return r.points[i].member
+}
+
+func hash(data []byte) uint64 {
+	sum := sha1.Sum(data)
+	return binary.BigEndian.Uint64(sum[:8])
+}
@@ -0,0 +1,59 @@
+package hash_ring
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHashRing(t *testing.T) {
+	ring := NewHashRing(100)
+	if member := ring.Lookup([]byte("key")); member != "" {
+		t.Errorf("Expected no member in an empty ring, got %s", member)
+	}
+	for _, member := range []string{"a", "b", "c"} {
+		ring.Add(member)
+	}
+
+	const keys = 30000
+	before := map[string]string{}
+	counts := map[string]int{}
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key%d", i)
+		before[key] = ring.Lookup([]byte(key))
+		counts[before[key]]++
+	}
+	for member, count := range counts {
+		if count < keys/3/2 || count > keys/3*2 {
+			t.Errorf("Expected about %d keys for member %s, got %d", keys/3, member, count)
+		}
+	}
+
+	clone := ring.Clone()
+	ring.Add("d")
+	moved := 0
+	for key, member := range before {
+		after := ring.Lookup([]byte(key))
+		if after != member {
+			moved++
+			if after != "d" {
+				t.Fatalf("Expected key %s to move only to the new member, moved from %s to %s", key, member, after)
+			}
+		}
+		if clone.Lookup([]byte(key)) != member {
+			t.Fatalf("Expected the clone to be unaffected by Add")
+		}
+	}
+	if moved < keys/4/2 || moved > keys/4*2 {
+		t.Errorf("Expected about %d keys to move, got %d", keys/4, moved)
+	}
+
+	ring.Remove("d")
+	for key, member := range before {
+		if after := ring.Lookup([]byte(key)); after != member {
+			t.Fatalf("Expected key %s to return to %s after Remove, got %s", key, member, after)
+		}
+	}
+	if members := ring.Members(); len(members) != 3 || ring.Has("d") {
+		t.Errorf("Expected members a, b and c, got %v", members)
+	}
+}
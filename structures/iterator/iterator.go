@@ -9,7 +9,8 @@ import (
 
 // Iterator combines multiple key-sorted iterators into a single key-sorted iterator.
 type Iterator struct {
-	pq PriorityQueue
+	pq  PriorityQueue
+	err error // the first read error of the combined iterators
 }
 
 // NewIterator creates a new iterator that iterates through the given iterators.
@@ -21,7 +22,7 @@ func NewIterator(iterators []util.Iterator) (*Iterator, error) {
 		}
 	}
 	heap.Init(&pq)
-	return &Iterator{pq}, nil
+	return &Iterator{pq: pq}, nil
 }
 
 // Value returns the current record of the iterator.
@@ -52,6 +53,7 @@ func (it *Iterator) Next() *model.Record {
 		if iter.Next() {
 			heap.Fix(&it.pq, 0)
 		} else {
+			it.setErr(iter)
 			heap.Pop(&it.pq)
 		}
 
@@ -60,7 +62,25 @@ func (it *Iterator) Next() *model.Record {
 	return rec
 }
 
-// Stop stops end invalidates the iterator.
+// Err returns the first read error that stopped one of the combined iterators, or nil.
+// The records returned after it are incomplete, the records of the stopped iterator are missing.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// setErr remembers the error of a combined iterator that stopped, if it reports one.
+func (it *Iterator) setErr(iter util.Iterator) {
+	if failer, ok := iter.(interface{ Err() error }); ok && it.err == nil {
+		it.err = failer.Err()
+	}
+}
+
+// Stop stops end invalidates the iterator. The combined iterators that can be stopped are stopped too.
 func (it *Iterator) Stop() {
+	for _, iter := range it.pq {
+		if stopper, ok := iter.(interface{ Stop() }); ok {
+			stopper.Stop()
+		}
+	}
 	it.pq = nil
 }
@@ -10,6 +10,8 @@ type LRUCache struct {
 	capacity uint64
 	cache    map[string]*list.Element
 	list     *list.List
+	hits     uint64
+	misses   uint64
 }
 
 func NewLRUCache(capacity uint64) LRUCache {
@@ -26,11 +28,29 @@ func NewLRUCache(capacity uint64) LRUCache {
 func (LRU *LRUCache) Get(key string) *model.Record {
 	element := LRU.get(key)
 	if element != nil {
+		LRU.hits++
 		return element.Value.(*model.Record)
 	}
+	LRU.misses++
 	return nil
 }
 
+// Contains reports whether the key is cached without counting a hit or miss and without changing the eviction order.
+func (LRU *LRUCache) Contains(key string) bool {
+	_, ok := LRU.cache[key]
+	return ok
+}
+
+// Stats returns the number of cache hits and misses recorded by Get.
+func (LRU *LRUCache) Stats() (hits uint64, misses uint64) {
+	return LRU.hits, LRU.misses
+}
+
+// Len returns the number of records currently in the cache.
+func (LRU *LRUCache) Len() int {
+	return LRU.list.Len()
+}
+
 // Used for my Put function to get list element
 func (LRU *LRUCache) get(key string) *list.Element {
 
@@ -71,3 +71,23 @@ func TestLRUCacheGetNonExistentKey(t *testing.T) {
 		t.Errorf("Expected Get for a non-existent key to return nil, but it didn't.")
 	}
 }
+
+func TestLRUCacheStats(t *testing.T) {
+	lruCache := NewLRUCache(2)
+	lruCache.Put(&model.Record{Key: []byte("one"), Value: []byte("value1")})
+
+	lruCache.Get("one")
+	lruCache.Get("one")
+	lruCache.Get("two")
+	if !lruCache.Contains("one") || lruCache.Contains("two") {
+		t.Errorf("Contains returned a wrong result.")
+	}
+
+	hits, misses := lruCache.Stats()
+	if hits != 2 {
+		t.Errorf("Expected 2 hits, got %d", hits)
+	}
+	if misses != 1 {
+		t.Errorf("Expected 1 miss, got %d", misses)
+	}
+}
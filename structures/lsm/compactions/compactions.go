@@ -4,22 +4,55 @@ import (
 	"nasp-project/structures/compression"
 	"nasp-project/structures/lsm/compactions/leveled_compaction"
 	"nasp-project/structures/lsm/compactions/size_tiered_compaction"
+	"nasp-project/structures/sstable"
 	"nasp-project/util"
 )
 
+// Stats contains the compaction statistics of a store.
+type Stats struct {
+	Compactions      uint64 // number of Compact calls that merged at least one pair of SSTables
+	Merges           uint64 // number of SSTable merge operations
+	BytesRead        uint64 // total size of SSTables read by compactions
+	BytesWritten     uint64 // total size of SSTables written by compactions
+	LastBytesRead    uint64 // size of SSTables read by the last compaction
+	LastBytesWritten uint64 // size of SSTables written by the last compaction
+}
+
+// Add adds a compaction with the given merges, as returned by Compact. A compaction without merges is not counted.
+func (s *Stats) Add(merges sstable.MergeStats) {
+	if merges.Merges == 0 {
+		return
+	}
+	s.Compactions++
+	s.Merges += merges.Merges
+	s.LastBytesRead = merges.BytesRead
+	s.LastBytesWritten = merges.BytesWritten
+	s.BytesRead += merges.BytesRead
+	s.BytesWritten += merges.BytesWritten
+}
+
+// ShouldCompact returns true if the compaction start condition is met, i.e. if Compact would merge SSTables.
+func ShouldCompact(config *util.LSMTreeConfig, sstConfig *util.SSTableConfig) (bool, error) {
+	if config.CompactionAlgorithm == "Size-Tiered" {
+		return size_tiered_compaction.ShouldCompact(sstConfig, config)
+	} else if config.CompactionAlgorithm == "Leveled" {
+		return leveled_compaction.ShouldCompact(sstConfig, config)
+	}
+	return false, nil
+}
+
 // Compact compacts the LSM tree by merging SSTables.
 // Runs compaction only if the compaction start condition is met.
 // The compaction algorithm used is determined by the config.
-func Compact(compressionDict *compression.Dictionary, config *util.LSMTreeConfig, sstConfig *util.SSTableConfig) error {
+// Returns the stats of the merges done by this call, also when it fails.
+func Compact(compressionDict *compression.Dictionary, config *util.LSMTreeConfig, sstConfig *util.SSTableConfig) (sstable.MergeStats, error) {
 	if config.CompactionAlgorithm == "Size-Tiered" {
 		// TODO: Add condition for compaction call
-		size_tiered_compaction.Compact(compressionDict, sstConfig, config)
+		return size_tiered_compaction.Compact(compressionDict, sstConfig, config)
 	} else if config.CompactionAlgorithm == "Leveled" {
 		// TODO: Add condition for compaction call
-		if err := leveled_compaction.Compact(compressionDict, sstConfig, config); err != nil {
-			return err
-		}
+		return leveled_compaction.Compact(compressionDict, sstConfig, config)
 	}
 
-	return nil
+	return sstable.MergeStats{}, nil
 }
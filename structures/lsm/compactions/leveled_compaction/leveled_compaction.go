@@ -69,13 +69,23 @@ func calcSize(levelNum, firstLevelTotalDataSize, fanoutSize int64) int64 {
 	return result
 }
 
+// ShouldCompact returns true if Compact would merge at least one table, i.e. if the first level exceeds its size limit.
+func ShouldCompact(sstableConfig *util.SSTableConfig, lsmConfig *util.LSMTreeConfig) (bool, error) {
+	return shouldCompact(util.LSMFirstLevelNum, sstableConfig, lsmConfig)
+}
+
 // Compact starts the leveled compaction process from the first level of the lsm tree. The compaction will take place only if
 // it should occur according the the given config. Starting a compaction from a level may trigger compactions from higher levels.
-func Compact(compressionDict *compression.Dictionary, sstableConfig *util.SSTableConfig, lsmConfig *util.LSMTreeConfig) error {
-	return triggerCompaction(util.LSMFirstLevelNum, compressionDict, sstableConfig, lsmConfig)
+// Returns the stats of the merges, including the ones done before an error.
+func Compact(compressionDict *compression.Dictionary, sstableConfig *util.SSTableConfig, lsmConfig *util.LSMTreeConfig) (sstable.MergeStats, error) {
+	// every step is committed on its own, the compaction only sums their stats
+	var c sstable.Compaction
+	err := triggerCompaction(&c, util.LSMFirstLevelNum, compressionDict, sstableConfig, lsmConfig)
+	return c.MergeStats(), err
 }
 
 func triggerCompaction(
+	c *sstable.Compaction,
 	levelNum int,
 	compressionDict *compression.Dictionary,
 	sstableConfig *util.SSTableConfig,
@@ -94,59 +104,89 @@ func triggerCompaction(
 			break
 		}
 
-		// table from this level that will take part in the compaction
-		var selectedTable *sstable.SSTable
-		// selected tables and result tables from the next level
-		var overlapTables, resultTables []*sstable.SSTable
-
-		// selecting the first table
-		if useSpecialSelectionForFirstLevel && levelNum == util.LSMFirstLevelNum {
-			// special selection only at first level where memtables are flushed to
-			selectedTable, err = selectTableFirstLevel(compressionDict, sstableConfig) // modifies the first level
-		} else {
-			selectedTable, err = selectTable(sstableConfig.SavePath, levelNum)
+		// the tables written by this step replace the merged ones in a single manifest edit
+		if err := compactLevel(c, levelNum, compressionDict, sstableConfig, lsmConfig); err != nil {
+			return err
 		}
+	}
 
-		if err != nil {
-			return fmt.Errorf("compaction from level %d failed, couldn't select the table for compaction : %w", levelNum, err)
-		}
+	if hadCompacted {
+		// we trigger compaction from next level only if a compaction from this level occurred
+		return triggerCompaction(c, levelNum+1, compressionDict, sstableConfig, lsmConfig)
+	}
 
-		// making sure that we can check the key range from the selected table
-		if !selectedTable.Summary.HasRangeLoaded() {
-			if err := selectedTable.Summary.LoadRange(compressionDict); err != nil {
-				return fmt.Errorf("compaction from level %d failed, couldn't load summary for selected table : %w", levelNum, err)
-			}
-		}
+	return nil
+}
 
-		nextLevelNum := levelNum + 1
-		// selecting the range of tables from next level to compact with
-		overlapTables, firstDeletedIdx, err := getSSTablesForLevelThatOverlapRange(nextLevelNum, selectedTable.Summary.StartKey, selectedTable.Summary.EndKey, sstableConfig.SavePath, compressionDict)
+// compactLevel merges a table from the level with the tables of the next level that overlap it. The result tables and
+// the tables of the next level that follow them are published in one step, so a crash leaves the level either as it was
+// before the step or after it.
+func compactLevel(
+	c *sstable.Compaction,
+	levelNum int,
+	compressionDict *compression.Dictionary,
+	sstableConfig *util.SSTableConfig,
+	lsmConfig *util.LSMTreeConfig,
+) (err error) {
+
+	defer func() {
 		if err != nil {
-			return fmt.Errorf("compaction from level %d failed, couldn't select overlap tables from next level : %w", levelNum, err)
+			if aerr := c.Abort(); aerr != nil {
+				err = fmt.Errorf("%w [while handling the previous error new one occured : %w]", err, aerr)
+			}
 		}
+	}()
 
-		// merge the selectedTable and overlapTables writing resultTables to next level
-		resultTables, err = sstable.MergeTableWithRun(compressionDict, sstableConfig, lsmConfig, nextLevelNum, selectedTable, overlapTables...)
-		if err != nil {
-			return fmt.Errorf("compaction from level %d failed, couldn't merge the selected tables into next level : %w", levelNum, err)
+	// table from this level that will take part in the compaction
+	var selectedTable *sstable.SSTable
+
+	// selecting the first table
+	if useSpecialSelectionForFirstLevel && levelNum == util.LSMFirstLevelNum {
+		// special selection only at first level where memtables are flushed to
+		selectedTable, err = selectTableFirstLevel(c, compressionDict, sstableConfig)
+	} else {
+		selectedTable, err = selectTable(sstableConfig.SavePath, levelNum)
+	}
+
+	if err != nil {
+		return fmt.Errorf("compaction from level %d failed, couldn't select the table for compaction : %w", levelNum, err)
+	}
+
+	// making sure that we can check the key range from the selected table
+	if !selectedTable.Summary.HasRangeLoaded() {
+		if err := selectedTable.LoadRange(compressionDict); err != nil {
+			return fmt.Errorf("compaction from level %d failed, couldn't load summary for selected table : %w", levelNum, err)
 		}
+	}
+
+	nextLevelNum := levelNum + 1
+	// selecting the range of tables from next level to compact with
+	overlapTables, firstDeletedIdx, err := getSSTablesForLevelThatOverlapRange(nextLevelNum, selectedTable.Summary.StartKey, selectedTable.Summary.EndKey, sstableConfig.SavePath, compressionDict)
+	if err != nil {
+		return fmt.Errorf("compaction from level %d failed, couldn't select overlap tables from next level : %w", levelNum, err)
+	}
+
+	// merge the selectedTable and overlapTables writing resultTables to next level
+	resultTables, err := c.MergeTableWithRun(compressionDict, sstableConfig, lsmConfig, nextLevelNum, selectedTable, overlapTables...)
+	if err != nil {
+		return fmt.Errorf("compaction from level %d failed, couldn't merge the selected tables into next level : %w", levelNum, err)
+	}
 
-		// fix table labeling
-		err = fixLabelsForLevel(firstDeletedIdx, len(overlapTables), resultTables, nextLevelNum, sstableConfig.SavePath)
+	// fix table labeling
+	if len(resultTables) > 0 {
+		err = relabelFollowingTables(c, firstDeletedIdx+len(overlapTables), nextLevelNum, sstableConfig.SavePath)
 		if err != nil {
 			return fmt.Errorf("compaction from level %d failed, couldn't fix table labels after compaction : %w", levelNum, err)
 		}
 	}
 
-	if hadCompacted {
-		// we trigger compaction from next level only if a compaction from this level occurred
-		return triggerCompaction(levelNum+1, compressionDict, sstableConfig, lsmConfig)
+	if err = c.Commit(); err != nil {
+		return fmt.Errorf("compaction from level %d failed, couldn't publish the merged tables : %w", levelNum, err)
 	}
-
 	return nil
 }
 
-func selectTableFirstLevel(compressionDict *compression.Dictionary, sstableConfig *util.SSTableConfig) (*sstable.SSTable, error) {
+func selectTableFirstLevel(c *sstable.Compaction, compressionDict *compression.Dictionary, sstableConfig *util.SSTableConfig) (*sstable.SSTable, error) {
 	level, err := lsm.GetSSTablesForLevel(sstableConfig.SavePath, util.LSMFirstLevelNum)
 	if err != nil {
 		return nil, err
@@ -157,7 +197,7 @@ func selectTableFirstLevel(compressionDict *compression.Dictionary, sstableConfi
 	}
 
 	// we merge all tables from first level into one, and that is the selected one
-	selected, merr := sstable.MergeMultipleSSTables(level, util.LSMFirstLevelNum, sstableConfig, compressionDict)
+	selected, merr := c.MergeMultipleSSTables(level, util.LSMFirstLevelNum, sstableConfig, compressionDict)
 	if merr != nil {
 		return nil, fmt.Errorf("failed to merge all tables from first level [%d] together : %w", util.LSMFirstLevelNum, merr)
 	}
@@ -198,7 +238,7 @@ func getSSTablesForLevelThatOverlapRange(
 	for i, table := range tables {
 		// ensuring that we can check the key range from summary of the table
 		if !table.Summary.HasRangeLoaded() {
-			err = table.Summary.LoadRange(compressionDict)
+			err = table.LoadRange(compressionDict)
 			if err != nil {
 				return nil, -1, err
 			}
@@ -221,31 +261,16 @@ func getSSTablesForLevelThatOverlapRange(
 	return selection, idx, nil
 }
 
-func fixLabelsForLevel(firstDeletedIndex, numDeleted int, addedTables []*sstable.SSTable, levelNum int, savePath string) error {
+// relabelFollowingTables moves the tables of the level from the given index on after the result tables of the compaction,
+// which got the next free labels of the level, so the tables of the level stay sorted by their keys.
+func relabelFollowingTables(c *sstable.Compaction, firstFollowingIndex int, levelNum int, savePath string) error {
 	tables, err := lsm.GetSSTablesForLevel(savePath, levelNum)
 	if err != nil {
 		return err
 	}
 
-	firstDeletedLabel := firstDeletedIndex + 1
-
-	if numDeleted >= len(addedTables) {
-		if numDeleted > len(addedTables) {
-			for i := firstDeletedIndex; i < len(tables)-len(addedTables); i++ {
-				if err := tables[i].Rename(firstDeletedLabel + i); err != nil {
-					return err
-				}
-			}
-		}
-	} else if numDeleted < len(addedTables) {
-		for i := len(tables) - 1; i >= firstDeletedIndex; i-- {
-			if err := tables[i].Rename(i + firstDeletedIndex + numDeleted + 1); err != nil {
-				return err
-			}
-		}
-	}
-	for i, table := range addedTables {
-		if err := table.Rename(firstDeletedLabel + i); err != nil {
+	for _, table := range tables[firstFollowingIndex:] {
+		if _, err := c.Relabel(table); err != nil {
 			return err
 		}
 	}
@@ -2,14 +2,21 @@ package size_tiered_compaction
 
 import (
 	"fmt"
-	"io/ioutil"
 	"nasp-project/structures/compression"
+	"nasp-project/structures/lsm"
 	"nasp-project/structures/sstable"
 	"nasp-project/util"
+	"path"
 )
 
 // Compact performs compaction on the LSM tree.
-func Compact(compressionDict *compression.Dictionary, sstableConfig *util.SSTableConfig, lsmConfig *util.LSMTreeConfig) {
+// Every merge replaces the pair of merged tables with the result in a single manifest edit, so a crash leaves
+// either the pair or the result. Returns the stats of the merges, and the error of the first merge that fails,
+// the tables of the merge stay unchanged.
+func Compact(compressionDict *compression.Dictionary, sstableConfig *util.SSTableConfig, lsmConfig *util.LSMTreeConfig) (sstable.MergeStats, error) {
+	// every merge is committed on its own, the compaction only sums their stats
+	var c sstable.Compaction
+
 	// maximum number of levels in the LSM tree
 	maxLsmLevel := lsmConfig.MaxLevel
 	// maximum number of SSTables in each level of the LSM Tree
@@ -24,18 +31,21 @@ func Compact(compressionDict *compression.Dictionary, sstableConfig *util.SSTabl
 		// path to the TOC file of the current level
 		pathToToc := filepath + "/L" + fmt.Sprintf("%03d", level) + "/TOC"
 		// search for all SSTables in the current level
-		fileNames := FindSSTables(pathToToc)
+		fileNames, err := FindSSTables(pathToToc)
+		if err != nil {
+			return c.MergeStats(), fmt.Errorf("compaction from level %d failed : %w", level, err)
+		}
 		// if there are no SSTables in the current level
 		if len(fileNames) == 0 {
-			return
+			return c.MergeStats(), nil
 		}
 		// if there is only one SSTable in the current level
 		if level == 1 {
 			if len(fileNames) == 1 {
-				return
+				return c.MergeStats(), nil
 			}
 			if len(fileNames) < maxLsmNodesPerLevel {
-				return
+				return c.MergeStats(), nil
 			}
 		}
 		// if the number of SSTables is greater than the maximum number of nodes in the current level
@@ -53,35 +63,67 @@ func Compact(compressionDict *compression.Dictionary, sstableConfig *util.SSTabl
 			// merge the first two SSTables from fileNames
 			sstable1, err := sstable.OpenSSTableFromToc(pathToToc + "/" + fileNames[0])
 			if err != nil {
-				return
+				return c.MergeStats(), fmt.Errorf("compaction from level %d failed, couldn't open '%s' : %w", level, fileNames[0], err)
 			}
 			sstable2, err := sstable.OpenSSTableFromToc(pathToToc + "/" + fileNames[1])
 			if err != nil {
-				return
+				return c.MergeStats(), fmt.Errorf("compaction from level %d failed, couldn't open '%s' : %w", level, fileNames[1], err)
 			}
 			// merge the two SSTables and save the result in the next level
-			_, err = sstable.MergeSSTables(sstable1, sstable2, level+1, sstableConfig, compressionDict)
+			_, err = c.MergeSSTables(sstable1, sstable2, level+1, sstableConfig, compressionDict)
+			if err == nil {
+				err = c.Commit()
+			} else if aerr := c.Abort(); aerr != nil {
+				err = fmt.Errorf("%w [while handling the previous error new one occured : %w]", err, aerr)
+			}
 			if err != nil {
-				return
+				return c.MergeStats(), fmt.Errorf("compaction from level %d failed, couldn't merge '%s' and '%s' : %w", level, fileNames[0], fileNames[1], err)
 			}
 			// set fileNames to the remaining SSTables
-			fileNames = FindSSTables(pathToToc)
+			fileNames, err = FindSSTables(pathToToc)
+			if err != nil {
+				return c.MergeStats(), fmt.Errorf("compaction from level %d failed : %w", level, err)
+			}
 		}
 
 		level++
 	}
+	return c.MergeStats(), nil
 }
 
-// FindSSTables returns the names of the SSTables in the given directory.
-func FindSSTables(filepath string) []string {
-	// read the directory
-	files, _ := ioutil.ReadDir(filepath)
+// ShouldCompact returns true if Compact would merge at least one pair of tables,
+// i.e. if the first level has reached the maximum number of tables.
+// Returns an error if the tables of the first level can't be listed.
+func ShouldCompact(sstableConfig *util.SSTableConfig, lsmConfig *util.LSMTreeConfig) (bool, error) {
+	if lsmConfig.MaxLevel <= 1 {
+		return false, nil
+	}
+	pathToToc := sstableConfig.SavePath + "/L" + fmt.Sprintf("%03d", 1) + "/TOC"
+	fileNames, err := FindSSTables(pathToToc)
+	if err != nil {
+		return false, err
+	}
+	return len(fileNames) > 1 && len(fileNames) >= lsmConfig.SizeTiered.MaxLsmNodesPerLevel, nil
+}
+
+// FindSSTables returns the names of the TOC files of the SSTables in the given TOC directory of a level.
+// The SSTables are those of the current version in the manifest, other TOC files in the directory are garbage.
+// Returns an error if the directory is not the TOC directory of a level or the tables of the level can't be listed.
+func FindSSTables(tocDir string) ([]string, error) {
+	levelDir := path.Dir(tocDir)
+	var level int
+	if _, err := fmt.Sscanf(path.Base(levelDir), "L%03d", &level); err != nil {
+		return nil, fmt.Errorf("'%s' is not the TOC directory of a level : %w", tocDir, err)
+	}
+	tocPaths, err := lsm.GetTOCFilePathsForLevel(path.Dir(levelDir), level)
+	if err != nil {
+		return nil, err
+	}
 	// used for storing the names of the SSTables
 	var sstableNames []string
-	// for each filename in directory I want to append it to the sstableNames
-	for _, file := range files {
-		sstableNames = append(sstableNames, file.Name())
+	for _, tocPath := range tocPaths {
+		sstableNames = append(sstableNames, path.Base(tocPath))
 	}
 
-	return sstableNames
+	return sstableNames, nil
 }
@@ -3,6 +3,7 @@ package size_tiered_compaction
 import (
 	"fmt"
 	"nasp-project/model"
+	"nasp-project/structures/manifest"
 	"nasp-project/structures/sstable"
 	"nasp-project/util"
 	"os"
@@ -53,34 +54,87 @@ func TestCompact(t *testing.T) {
 			MaxLsmNodesPerLevel: 2,
 		},
 	}
-	Compact(nil, config, lsmConfig)
+	merges, err := Compact(nil, config, lsmConfig)
+	if err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	// two merges from the first level and one of their results
+	if merges.Merges != 3 || merges.BytesRead == 0 || merges.BytesWritten == 0 {
+		t.Errorf("Expected the stats of 3 merges, got %+v", merges)
+	}
 	// Check if compaction has been performed correctly
 
 	// Example assertion: Check if the number of SSTables in the first level is as expected after compaction.
 	pathToToc := tmpDir + "/L001/TOC"
-	fileNames := FindSSTables(pathToToc)
+	fileNames, err := FindSSTables(pathToToc)
+	if err != nil {
+		t.Fatalf("Failed to find the SSTables: %v", err)
+	}
 	expectedSSTables := 0
 	if len(fileNames) != expectedSSTables {
 		t.Errorf("Expected %d SSTables after compaction, but got %d", expectedSSTables, len(fileNames))
 	}
 	// check the L002 level
 	pathToToc = tmpDir + "/L002/TOC"
-	fileNames = FindSSTables(pathToToc)
+	fileNames, err = FindSSTables(pathToToc)
+	if err != nil {
+		t.Fatalf("Failed to find the SSTables: %v", err)
+	}
 	expectedSSTables = 0
 	if len(fileNames) != expectedSSTables {
 		t.Errorf("Expected %d SSTables after compaction, but got %d", expectedSSTables, len(fileNames))
 	}
 	// check the L003 level
 	pathToToc = tmpDir + "/L003/TOC"
-	fileNames = FindSSTables(pathToToc)
+	fileNames, err = FindSSTables(pathToToc)
+	if err != nil {
+		t.Fatalf("Failed to find the SSTables: %v", err)
+	}
 	expectedSSTables = 1
 	if len(fileNames) != expectedSSTables {
 		t.Errorf("Expected %d SSTables after compaction, but got %d", expectedSSTables, len(fileNames))
 	}
 	// console output
 	fmt.Println("We have created 4 sstables and after the compacting we have:")
-	fmt.Println("L001: ", len(FindSSTables(tmpDir+"/L001/TOC")))
-	fmt.Println("L002: ", len(FindSSTables(tmpDir+"/L002/TOC")))
-	fmt.Println("L003: ", len(FindSSTables(tmpDir+"/L003/TOC")))
+	for _, level := range []string{"L001", "L002", "L003"} {
+		fileNames, err := FindSSTables(tmpDir + "/" + level + "/TOC")
+		if err != nil {
+			t.Fatalf("Failed to find the SSTables: %v", err)
+		}
+		fmt.Println(level+": ", len(fileNames))
+	}
+
+}
+
+func TestCompact_ManifestError(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sstable_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
 
+	// the manifest can't be opened, so the tables of the levels can't be listed
+	if err := os.Mkdir(tmpDir+"/"+manifest.Filename, 0755); err != nil {
+		t.Fatalf("Failed to create the directory: %v", err)
+	}
+	config := &util.SSTableConfig{SavePath: tmpDir}
+	lsmConfig := &util.LSMTreeConfig{
+		MaxLevel: 3,
+		SizeTiered: util.SizeTieredConfig{
+			MaxLsmNodesPerLevel: 2,
+		},
+	}
+
+	if _, err := FindSSTables(tmpDir + "/L001/TOC"); err == nil {
+		t.Errorf("Expected FindSSTables to fail")
+	}
+	if _, err := FindSSTables(tmpDir); err == nil {
+		t.Errorf("Expected FindSSTables to fail for a directory that is not a level")
+	}
+	if _, err := ShouldCompact(config, lsmConfig); err == nil {
+		t.Errorf("Expected ShouldCompact to fail")
+	}
+	if _, err := Compact(nil, config, lsmConfig); err == nil {
+		t.Errorf("Expected Compact to fail")
+	}
 }
@@ -2,41 +2,87 @@ package lsm
 
 import (
 	"nasp-project/structures/compression"
+	"nasp-project/structures/manifest"
+	"nasp-project/structures/sstable"
 	"nasp-project/util"
 )
 
-func GetRangeIterators(startKey, endKey []byte, compressionDict *compression.Dictionary, config *util.Config) ([]util.Iterator, error) {
-	var iterators []util.Iterator
-	for lvl := 1; lvl <= config.LSMTree.MaxLevel; lvl++ {
-		tables, err := GetSSTablesForLevel(config.SSTable.SavePath, lvl)
-		if err != nil {
-			return nil, err
-		}
-		for _, table := range tables {
-			it, err := table.NewRangeIterator(startKey, endKey, compressionDict)
-			if err != nil {
-				return nil, err
-			}
-			iterators = append(iterators, it)
-		}
+// versionIterator holds a reference to the version of the LSM tree that its SSTable belongs to,
+// so the table isn't deleted before the iteration ends or is stopped.
+type versionIterator struct {
+	util.Iterator
+	version *manifest.Version
+}
+
+func (it *versionIterator) Next() bool {
+	if it.Iterator.Next() {
+		return true
 	}
-	return iterators, nil
+	it.Stop()
+	return false
 }
 
-func GetPrefixIterators(prefix []byte, compressionDict *compression.Dictionary, config *util.Config) ([]util.Iterator, error) {
+// Err returns the read error that stopped the SSTable iterator, if any.
+func (it *versionIterator) Err() error {
+	if failer, ok := it.Iterator.(interface{ Err() error }); ok {
+		return failer.Err()
+	}
+	return nil
+}
+
+// Stop releases the version of the LSM tree.
+func (it *versionIterator) Stop() {
+	if it.version != nil {
+		it.version.Unref()
+		it.version = nil
+	}
+}
+
+// getIterators returns an iterator created by newIterator for every SSTable of the current version of the LSM tree.
+// Every iterator holds a reference to the version until it ends or is stopped.
+func getIterators(config *util.Config, newIterator func(*sstable.SSTable) (util.Iterator, error)) ([]util.Iterator, error) {
+	version, err := sstable.PinVersion(config.SSTable.SavePath)
+	if err != nil {
+		return nil, err
+	}
+	defer version.Unref()
+
 	var iterators []util.Iterator
+	stop := func() {
+		for _, it := range iterators {
+			it.(*versionIterator).Stop()
+		}
+	}
 	for lvl := 1; lvl <= config.LSMTree.MaxLevel; lvl++ {
-		tables, err := GetSSTablesForLevel(config.SSTable.SavePath, lvl)
+		tables, err := getSSTablesForLevel(config.SSTable.SavePath, lvl, version)
 		if err != nil {
+			stop()
 			return nil, err
 		}
 		for _, table := range tables {
-			it, err := table.NewPrefixIterator(prefix, compressionDict)
+			it, err := newIterator(table)
 			if err != nil {
+				stop()
 				return nil, err
 			}
-			iterators = append(iterators, it)
+			if it.Value() == nil {
+				continue
+			}
+			version.Ref()
+			iterators = append(iterators, &versionIterator{it, version})
 		}
 	}
 	return iterators, nil
 }
+
+func GetRangeIterators(startKey, endKey []byte, compressionDict *compression.Dictionary, config *util.Config) ([]util.Iterator, error) {
+	return getIterators(config, func(table *sstable.SSTable) (util.Iterator, error) {
+		return table.NewRangeIterator(startKey, endKey, compressionDict)
+	})
+}
+
+func GetPrefixIterators(prefix []byte, compressionDict *compression.Dictionary, config *util.Config) ([]util.Iterator, error) {
+	return getIterators(config, func(table *sstable.SSTable) (util.Iterator, error) {
+		return table.NewPrefixIterator(prefix, compressionDict)
+	})
+}
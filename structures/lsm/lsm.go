@@ -1,23 +1,32 @@
 package lsm
 
 import (
+	"errors"
 	"fmt"
+	"nasp-project/structures/manifest"
 	"nasp-project/structures/sstable"
 	"nasp-project/util"
-	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
+	"sync"
 )
 
 /*
 	=== SSTable save directory organisation ===
 
 	- SSTable.savePath
+  		- /^MANIFEST$/
+  		- /^quarantine$/ (the files of the damaged tables that were replaced by repair, and its reports)
+    		- /^L\d{3,}$/
+      			- /^usertable-(\d{5,})-\d{8}T\d{6}\.\d{9}$/
+        			- /^report.txt$/
+        			- ...
   		- /^L\d{3,}$/
     		- /^TOC$/
       			- /^usertable-(\d{5,})-TOC.txt$/
+      			- /^usertable-(\d{5,})-TOC.txt.tmp$/ (of a table that is being written and isn't published yet)
       			- ...
     		- /^usertable-(\d{5,})-Metadata.txt$/
     		- /^usertable-(\d{5,})-SSTable.db$/
@@ -31,6 +40,10 @@ import (
 		/^usertable-(\d{5,})-Index.db$/
   		/^usertable-(\d{5,})-Summary.db$/
   		/^usertable-(\d{5,})-Filter.db$/
+
+	The MANIFEST logs the tables that are added to and removed from the levels, see package manifest.
+	Tables in the level directories that are not in the MANIFEST are garbage left by a crash. A compaction writes
+	its tables, syncs them to disk and publishes them together with the removal of the merged tables in one edit.
 */
 
 func levelDirPath(savePath string, level int) string {
@@ -47,57 +60,99 @@ func tOCFileName(labelNum int) string {
 
 // GetTOCFilePathsForLevel returns the file paths for TOC files from every SSTable on the given level.
 // It takes savePath, the base directory where all levels of LSM tree are stored, and the level to consider.
-// Returns error if reading the TOC directory of the level fails.
+// The SSTables are those of the current version in the manifest, other TOC files in the directory are garbage.
+// Returns error if the manifest can't be read.
 func GetTOCFilePathsForLevel(savePath string, level int) ([]string, error) {
-	dir := tOCDirPath(savePath, level)
-	entries, err := os.ReadDir(dir)
+	version, err := sstable.PinVersion(savePath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil
-		}
-
-		return nil, fmt.Errorf("failed to read level %d TOC directory '%s' : %w", level, dir, err)
+		return nil, fmt.Errorf("failed to read the manifest of '%s' : %w", savePath, err)
 	}
+	defer version.Unref()
 
 	var tocPaths []string
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			// we assume that all files in toc directory are toc files
-			tocPaths = append(tocPaths, filepath.Join(dir, entry.Name()))
-		}
+	for _, name := range version.Tables(level) {
+		tocPaths = append(tocPaths, filepath.Join(tOCDirPath(savePath, level), name))
 	}
 
 	return tocPaths, nil
 }
 
+// tableCache keeps the SSTables of every level that was read, so that the TOC files are only parsed
+// again after a flush or a compaction changes the set of tables on the level.
+var tableCache struct {
+	sync.Mutex
+	levels map[string]cachedLevel // by TOC directory
+}
+
+type cachedLevel struct {
+	version uint64 // number of the manifest version the tables are from
+	tables  []*sstable.SSTable
+	err     error // of the tables that couldn't be opened
+}
+
+// ErrUnreadableTable is returned with the readable SSTables of a level when the TOC files of some of its tables can't be read.
+// Reads fail with it, because the newest record of a key may be in an unreadable table, until the tables are repaired,
+// see sstable.Repair. Only callers that can do with some of the tables use the readable ones.
+var ErrUnreadableTable = errors.New("unreadable SSTable")
+
 // Get all SSTables sorted by label from the given level. It takes savePath, a base directory where all levels of LSM tree are stored, and the level to consider.
-// If reading a TOC directory fails it returns nil and error.
-// If opening an SSTable from a TOC file fails it returns a slice of successfully opened SSTables and error.
+// If reading the manifest fails it returns nil and error.
+// If opening an SSTable from a TOC file fails it returns a slice of successfully opened SSTables and an error that wraps ErrUnreadableTable.
+// The tables are cached until the set of tables on the level changes, so they are shared by the callers.
 func GetSSTablesForLevel(savePath string, level int) ([]*sstable.SSTable, error) {
-	tocPaths, err := GetTOCFilePathsForLevel(savePath, level)
+	version, err := sstable.PinVersion(savePath)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to read the manifest of '%s' : %w", savePath, err)
 	}
+	defer version.Unref()
 
-	var tables []*sstable.SSTable
+	return getSSTablesForLevel(savePath, level, version)
+}
+
+// getSSTablesForLevel returns the SSTables of the version on the given level, see GetSSTablesForLevel.
+func getSSTablesForLevel(savePath string, level int, version *manifest.Version) ([]*sstable.SSTable, error) {
+	dir := tOCDirPath(savePath, level)
+
+	tableCache.Lock()
+	cached, ok := tableCache.levels[dir]
+	tableCache.Unlock()
+	if ok && cached.version == version.Number() {
+		return append([]*sstable.SSTable(nil), cached.tables...), cached.err
+	}
 
-	for _, tocPath := range tocPaths {
+	// a table may have the label of a deleted one, so all tables are opened again
+	var tables []*sstable.SSTable
+	var unreadable error
+	for _, name := range version.Tables(level) {
+		tocPath := filepath.Join(dir, name)
 		table, err := sstable.OpenSSTableFromToc(tocPath)
 		if err != nil {
-			return tables, fmt.Errorf("failed to open SSTable from TOC file '%s' : %w", tocPath, err)
+			// the rest of the level is still opened for the callers that use the readable tables
+			util.Logger().Warn("an SSTable can't be opened, run repair to fix it", "path", tocPath, util.LogKeyError, err)
+			if unreadable == nil {
+				unreadable = fmt.Errorf("failed to open SSTable from TOC file '%s' : %w: %w", tocPath, ErrUnreadableTable, err)
+			}
+			continue
 		}
 
 		tables = append(tables, table)
 	}
-
 	SortSSTablesByLabelNum(tables)
-	return tables, nil
+
+	tableCache.Lock()
+	defer tableCache.Unlock()
+	if tableCache.levels == nil {
+		tableCache.levels = make(map[string]cachedLevel)
+	}
+	tableCache.levels[dir] = cachedLevel{version.Number(), append([]*sstable.SSTable(nil), tables...), unreadable}
+	return tables, unreadable
 }
 
+var labelRegexp = regexp.MustCompile(`usertable-(\d+)-TOC.txt`)
+
 // GetLabelNumFromSSTable extracts label number from TOC file name
-func getLabelNumFromSSTable(table *sstable.SSTable) int {
-	re := regexp.MustCompile(`usertable-(\d+)-TOC.txt`)
-	match := re.FindStringSubmatch(table.TOCFilename)
+func GetLabelNumFromSSTable(table *sstable.SSTable) int {
+	match := labelRegexp.FindStringSubmatch(table.TOCFilename)
 	if match != nil {
 		labelNum, err := strconv.Atoi(match[1])
 
@@ -117,12 +172,12 @@ func SortSSTablesByLabelNum(tables []*sstable.SSTable, reverse ...bool) {
 	if inAscendingOrder {
 		// sorting in ascending order from smallest label number to largest
 		sort.Slice(tables, func(i, j int) bool {
-			return getLabelNumFromSSTable(tables[i]) < getLabelNumFromSSTable(tables[j])
+			return GetLabelNumFromSSTable(tables[i]) < GetLabelNumFromSSTable(tables[j])
 		})
 	} else {
 		// sorting in descending order from largest label number to smallest
 		sort.Slice(tables, func(i, j int) bool {
-			return getLabelNumFromSSTable(tables[i]) > getLabelNumFromSSTable(tables[j])
+			return GetLabelNumFromSSTable(tables[i]) > GetLabelNumFromSSTable(tables[j])
 		})
 	}
 }
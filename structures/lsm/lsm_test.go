@@ -2,10 +2,12 @@ package lsm
 
 import (
 	"bytes"
+	"errors"
 	"nasp-project/model"
 	"nasp-project/structures/sstable"
 	"nasp-project/util"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -94,3 +96,160 @@ func TestRead(t *testing.T) {
 		t.Errorf("Expected value of 'value33', got %v", dr.Value)
 	}
 }
+
+func TestGetSSTablesForLevelCache(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sstable_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sstConfig := &util.SSTableConfig{
+		SavePath:            tmpDir,
+		IndexDegree:         2,
+		SummaryDegree:       3,
+		FilterPrecision:     0.01,
+		MerkleTreeChunkSize: 16,
+	}
+	create := func(key string, timestamp uint64) *sstable.SSTable {
+		t.Helper()
+		table, err := sstable.CreateSSTable([]model.Record{{Key: []byte(key), Value: []byte(key), Timestamp: timestamp}}, nil, sstConfig)
+		if err != nil {
+			t.Fatalf("Failed to create SSTable: %v", err)
+		}
+		return table
+	}
+	level := func(levelNum, expected int) []*sstable.SSTable {
+		t.Helper()
+		tables, err := GetSSTablesForLevel(tmpDir, levelNum)
+		if err != nil {
+			t.Fatalf("Failed to get SSTables: %v", err)
+		}
+		if len(tables) != expected {
+			t.Fatalf("Expected %d SSTables on level %d, got %d", expected, levelNum, len(tables))
+		}
+		return tables
+	}
+
+	table1 := create("key1", 1)
+	table2 := create("key2", 2)
+	first := level(1, 2)
+
+	// the tables are not read again until the level changes
+	again := level(1, 2)
+	if first[0] != again[0] || first[1] != again[1] {
+		t.Errorf("Expected the cached SSTables")
+	}
+	again[0] = nil
+	if level(1, 2)[0] == nil {
+		t.Errorf("Expected the cache not to be modified through the returned slice")
+	}
+
+	// a flush adds a table
+	create("key3", 3)
+	tables := level(1, 3)
+	if GetLabelNumFromSSTable(tables[2]) != 3 {
+		t.Errorf("Expected the new SSTable to be the last, got label %d", GetLabelNumFromSSTable(tables[2]))
+	}
+
+	// a compaction removes the tables from one level and adds one to the next
+	level(2, 0)
+	if _, err := sstable.MergeSSTables(table1, table2, 2, sstConfig, nil); err != nil {
+		t.Fatalf("Failed to merge SSTables: %v", err)
+	}
+	level(1, 1)
+	level(2, 1)
+}
+
+func TestUnreadableTable(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sstable_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &util.Config{
+		SSTable: util.SSTableConfig{
+			SavePath:            tmpDir,
+			IndexDegree:         2,
+			SummaryDegree:       3,
+			FilterPrecision:     0.01,
+			MerkleTreeChunkSize: 16,
+		},
+		LSMTree: util.LSMTreeConfig{
+			MaxLevel: 3,
+		},
+	}
+	var tables []*sstable.SSTable
+	for _, key := range []string{"key1", "key2", "key3"} {
+		table, err := sstable.CreateSSTable([]model.Record{{Key: []byte(key), Value: []byte(key), Timestamp: 1}}, nil, &config.SSTable)
+		if err != nil {
+			t.Fatalf("Failed to create SSTable: %v", err)
+		}
+		tables = append(tables, table)
+	}
+	read := func(key string, expected bool) {
+		t.Helper()
+		rec, err := Read([]byte(key), nil, config)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", key, err)
+		}
+		if (rec != nil) != expected {
+			t.Errorf("Expected %s to be found: %t, got %v", key, expected, rec)
+		}
+	}
+
+	// a damaged TOC file leaves the other tables of the level readable, but reads fail
+	// because the newest record of a key may be in the damaged table
+	if err := os.WriteFile(tables[1].TOCFilename, []byte("garbage"), 0644); err != nil {
+		t.Fatalf("Failed to write the TOC file: %v", err)
+	}
+	level, err := GetSSTablesForLevel(tmpDir, 1)
+	if !errors.Is(err, ErrUnreadableTable) {
+		t.Errorf("Expected ErrUnreadableTable, got %v", err)
+	}
+	if len(level) != 2 {
+		t.Errorf("Expected the 2 readable SSTables, got %d", len(level))
+	}
+	if _, err := Read([]byte("key1"), nil, config); !errors.Is(err, ErrUnreadableTable) {
+		t.Errorf("Expected the read to fail with ErrUnreadableTable, got %v", err)
+	}
+	if _, err := RangeScan([]byte("key1"), []byte("key3"), -1, nil, config); !errors.Is(err, ErrUnreadableTable) {
+		t.Errorf("Expected the scan to fail with ErrUnreadableTable, got %v", err)
+	}
+	if _, err := GetRangeIterators([]byte("key1"), []byte("key3"), nil, config); !errors.Is(err, ErrUnreadableTable) {
+		t.Errorf("Expected the iterators to fail with ErrUnreadableTable, got %v", err)
+	}
+
+	// the repair rebuilds the table from its data block and keeps the order of the level
+	reports, err := Repair(nil, config)
+	if err != nil {
+		t.Fatalf("Failed to repair: %v", err)
+	}
+	if len(reports) != 3 {
+		t.Fatalf("Expected 3 reports, got %d", len(reports))
+	}
+	for _, report := range reports {
+		if report.Healthy() != (report.TOCFilename != tables[1].TOCFilename) {
+			t.Errorf("Unexpected report: %s", report)
+		}
+	}
+	level, err = GetSSTablesForLevel(tmpDir, 1)
+	if err != nil {
+		t.Fatalf("Failed to get SSTables: %v", err)
+	}
+	var first []string
+	for _, table := range level {
+		rec, _, err := table.GetFirstRecord(nil)
+		if err != nil || rec == nil {
+			t.Fatalf("Failed to read the first record of %s: %v", table.TOCFilename, err)
+		}
+		first = append(first, string(rec.Key))
+	}
+	if strings.Join(first, " ") != "key1 key2 key3" {
+		t.Errorf("Expected the tables in the order key1 key2 key3, got %v", first)
+	}
+	read("key1", true)
+	read("key2", true)
+	read("key3", true)
+}
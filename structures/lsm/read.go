@@ -14,8 +14,36 @@ import (
 // If the record is found in multiple same-level SSTables, the record with the latest timestamp is returned.
 // Returns an error if the read fails.
 func Read(key []byte, compressionDict *compression.Dictionary, config *util.Config) (*model.Record, error) {
+	return read(key, compressionDict, config, nil)
+}
+
+// TableReadTrace is the sstable.ReadTrace of a single SSTable consulted by ReadWithTrace.
+type TableReadTrace struct {
+	Level    int
+	Label    int
+	Answered bool // true if the returned record was read from this table
+	sstable.ReadTrace
+}
+
+// ReadWithTrace does the same as Read, and also returns a TableReadTrace for every SSTable that was consulted,
+// in the order in which they were consulted.
+func ReadWithTrace(key []byte, compressionDict *compression.Dictionary, config *util.Config) (*model.Record, []TableReadTrace, error) {
+	traces := make([]TableReadTrace, 0)
+	rec, err := read(key, compressionDict, config, &traces)
+	return rec, traces, err
+}
+
+// read implements Read. If traces is not nil, a TableReadTrace is appended to it for every consulted SSTable.
+func read(key []byte, compressionDict *compression.Dictionary, config *util.Config, traces *[]TableReadTrace) (*model.Record, error) {
+	// the tables are read from a pinned version, so a compaction doesn't delete them while they are read
+	version, err := sstable.PinVersion(config.SSTable.SavePath)
+	if err != nil {
+		return nil, err
+	}
+	defer version.Unref()
+
 	for lvl := 1; lvl <= config.LSMTree.MaxLevel; lvl++ {
-		tables, err := GetSSTablesForLevel(config.SSTable.SavePath, lvl)
+		tables, err := getSSTablesForLevel(config.SSTable.SavePath, lvl, version)
 		if err != nil {
 			return nil, err
 		}
@@ -33,8 +61,16 @@ func Read(key []byte, compressionDict *compression.Dictionary, config *util.Conf
 		}
 
 		var record *model.Record = nil
+		answeredIdx := -1 // index of the trace of the table that the record was read from
 		for _, table := range tables {
-			rec, err := table.Read(key, compressionDict)
+			rec, trace, err := table.ReadWithTrace(key, compressionDict)
+			if traces != nil {
+				*traces = append(*traces, TableReadTrace{
+					Level:     lvl,
+					Label:     GetLabelNumFromSSTable(table),
+					ReadTrace: *trace,
+				})
+			}
 			if err != nil {
 				return nil, err
 			}
@@ -43,10 +79,16 @@ func Read(key []byte, compressionDict *compression.Dictionary, config *util.Conf
 			}
 			if record == nil || rec.Timestamp > record.Timestamp {
 				record = rec
+				if traces != nil {
+					answeredIdx = len(*traces) - 1
+				}
 			}
 		}
 
 		if record != nil {
+			if answeredIdx != -1 {
+				(*traces)[answeredIdx].Answered = true
+			}
 			return record, nil
 		}
 	}
@@ -62,7 +104,7 @@ func leveledFindTableWithKey(key []byte, tables []*sstable.SSTable, compressionD
 	for l <= r {
 		m := l + (r-l)/2
 		if !tables[m].Summary.HasRangeLoaded() {
-			err := tables[m].Summary.LoadRange(compressionDict)
+			err := tables[m].LoadRange(compressionDict)
 			if err != nil {
 				return nil, err
 			}
@@ -0,0 +1,52 @@
+package lsm
+
+import (
+	"errors"
+	"nasp-project/structures/compression"
+	"nasp-project/structures/sstable"
+	"nasp-project/util"
+	"sort"
+	"strconv"
+)
+
+// Repair checks every SSTable of the LSM tree with sstable.Repair, which rebuilds the damaged ones.
+// The tables of a level are checked from the last one, so the tables that a repair relabels are already checked.
+// A table that can't be repaired doesn't stop the others from being checked.
+// Returns the reports of the checked tables, and the errors of the tables that couldn't be checked or repaired.
+func Repair(compressionDict *compression.Dictionary, config *util.Config) ([]*sstable.RepairReport, error) {
+	var reports []*sstable.RepairReport
+	var errs []error
+	for lvl := util.LSMFirstLevelNum; lvl <= config.LSMTree.MaxLevel; lvl++ {
+		tocPaths, err := GetTOCFilePathsForLevel(config.SSTable.SavePath, lvl)
+		if err != nil {
+			return reports, err
+		}
+		sort.Slice(tocPaths, func(i, j int) bool {
+			return labelNum(tocPaths[i]) > labelNum(tocPaths[j])
+		})
+
+		for _, tocPath := range tocPaths {
+			report, err := sstable.Repair(tocPath, compressionDict, &config.SSTable)
+			if report != nil {
+				reports = append(reports, report)
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return reports, errors.Join(errs...)
+}
+
+// labelNum returns the label number of the TOC file at tocPath, or -1 if it doesn't have one.
+func labelNum(tocPath string) int {
+	match := labelRegexp.FindStringSubmatch(tocPath)
+	if match == nil {
+		return -1
+	}
+	num, err := strconv.Atoi(match[1])
+	if err != nil {
+		return -1
+	}
+	return num
+}
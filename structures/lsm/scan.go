@@ -4,13 +4,20 @@ import (
 	"bytes"
 	"nasp-project/model"
 	"nasp-project/structures/compression"
+	"nasp-project/structures/sstable"
 	"nasp-project/util"
 )
 
 func RangeScan(startKey, endKey []byte, maxRecords int, compressionDict *compression.Dictionary, config *util.Config) ([]*model.Record, error) {
 	var scans [][]*model.Record
+	version, err := sstable.PinVersion(config.SSTable.SavePath)
+	if err != nil {
+		return nil, err
+	}
+	defer version.Unref()
+
 	for lvl := 1; lvl <= config.LSMTree.MaxLevel; lvl++ {
-		tables, err := GetSSTablesForLevel(config.SSTable.SavePath, lvl)
+		tables, err := getSSTablesForLevel(config.SSTable.SavePath, lvl, version)
 		if err != nil {
 			return nil, err
 		}
@@ -32,8 +39,14 @@ func RangeScan(startKey, endKey []byte, maxRecords int, compressionDict *compres
 
 func PrefixScan(prefix []byte, maxRecords int, compressionDict *compression.Dictionary, config *util.Config) ([]*model.Record, error) {
 	var scans [][]*model.Record
+	version, err := sstable.PinVersion(config.SSTable.SavePath)
+	if err != nil {
+		return nil, err
+	}
+	defer version.Unref()
+
 	for lvl := 1; lvl <= config.LSMTree.MaxLevel; lvl++ {
-		tables, err := GetSSTablesForLevel(config.SSTable.SavePath, lvl)
+		tables, err := getSSTablesForLevel(config.SSTable.SavePath, lvl, version)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,421 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+	=== MANIFEST ===
+
+	+------------+-------------+--------+-...-+--------+
+	|  CRC (4B)  |  Size (4B)  |  Op 1  |     |  Op N  |   one record per edit
+	+------------+-------------+--------+-...-+--------+
+	CRC = 32bit hash computed over the ops using CRC
+	Size = Size of the ops in bytes
+	Op = Type (1B) | Level (VAR) | Name Size (VAR) | Name
+	Type = 1 if the table is added to the level, 2 if it is removed from the level
+	Name = Name of the TOC file of the table
+
+	An edit is applied as a whole or not at all. A record that is cut short or doesn't match its CRC
+	ends the log, the process crashed while appending it. The log is rewritten as a single edit that
+	adds all tables when it is opened, and when it grows over maxEdits edits.
+*/
+
+// Filename is the name of the manifest in the directory of the LSM tree.
+const Filename = "MANIFEST"
+
+const (
+	headerSize = 8
+	opAdd      = 1
+	opRemove   = 2
+	maxEdits   = 1000
+)
+
+var (
+	ErrTableExists   = errors.New("table is already in the manifest")
+	ErrTableNotFound = errors.New("table is not in the manifest")
+)
+
+// Table identifies an SSTable by its level and the name of its TOC file.
+type Table struct {
+	Level int
+	Name  string
+}
+
+// Edit adds tables to and removes tables from the LSM tree atomically.
+type Edit struct {
+	Added   []Table
+	Removed []Table
+}
+
+// versionNumbers numbers the versions of all manifests, so a version number is never reused.
+var versionNumbers atomic.Uint64
+
+// Version is the set of tables of the LSM tree after an edit. Versions don't change, and the tables
+// of a version are not deleted while the version is referenced.
+type Version struct {
+	m      *Manifest
+	number uint64
+	levels map[int][]string // names of the TOC files by level, sorted
+	refs   int              // references, including the one of the manifest while the version is current
+}
+
+// Number returns a number that identifies the version, a newer version has a larger number.
+func (v *Version) Number() uint64 {
+	return v.number
+}
+
+// Tables returns the sorted names of the TOC files of the tables on the given level.
+func (v *Version) Tables(level int) []string {
+	return append([]string(nil), v.levels[level]...)
+}
+
+// Levels returns the levels that have tables, in ascending order.
+func (v *Version) Levels() []int {
+	var levels []int
+	for level := range v.levels {
+		levels = append(levels, level)
+	}
+	sort.Ints(levels)
+	return levels
+}
+
+// Contains returns true if the table is in the version.
+func (v *Version) Contains(t Table) bool {
+	names := v.levels[t.Level]
+	i := sort.SearchStrings(names, t.Name)
+	return i < len(names) && names[i] == t.Name
+}
+
+// Ref adds a reference to the version, which must be released with Unref.
+func (v *Version) Ref() {
+	v.m.mu.Lock()
+	defer v.m.mu.Unlock()
+
+	v.refs++
+}
+
+// Unref releases a reference to the version. Tables that were removed from the LSM tree are deleted
+// once no referenced version contains them.
+func (v *Version) Unref() {
+	v.m.mu.Lock()
+	v.refs--
+	if v.refs > 0 {
+		v.m.mu.Unlock()
+		return
+	}
+	delete(v.m.live, v)
+	obsolete := v.m.collectObsolete()
+	v.m.mu.Unlock()
+
+	v.m.deleteTables(obsolete)
+}
+
+// apply returns a new version with the edit applied.
+func (v *Version) apply(edit Edit) (*Version, error) {
+	levels := make(map[int][]string, len(v.levels))
+	for level, names := range v.levels {
+		levels[level] = names
+	}
+	next := &Version{m: v.m, levels: levels}
+	for _, t := range edit.Removed {
+		if !next.Contains(t) {
+			return nil, fmt.Errorf("removing %s from level %d: %w", t.Name, t.Level, ErrTableNotFound)
+		}
+		names := levels[t.Level]
+		i := sort.SearchStrings(names, t.Name)
+		levels[t.Level] = append(append([]string(nil), names[:i]...), names[i+1:]...)
+		if len(levels[t.Level]) == 0 {
+			delete(levels, t.Level)
+		}
+	}
+	for _, t := range edit.Added {
+		if next.Contains(t) {
+			return nil, fmt.Errorf("adding %s to level %d: %w", t.Name, t.Level, ErrTableExists)
+		}
+		names := levels[t.Level]
+		i := sort.SearchStrings(names, t.Name)
+		levels[t.Level] = append(append(append([]string(nil), names[:i]...), t.Name), names[i:]...)
+	}
+	return next, nil
+}
+
+// Manifest is a log of the edits of the tables of an LSM tree, which is replayed when it's opened.
+// It's safe for concurrent use.
+type Manifest struct {
+	mu         sync.Mutex
+	path       string
+	current    *Version
+	live       map[*Version]struct{} // versions with references
+	obsolete   map[Table]struct{}    // removed tables that a referenced version may contain
+	onObsolete func(Table)
+	edits      int // edits appended since the log was rewritten
+}
+
+// Open opens the manifest in the given directory and replays its edits. If there is no manifest, it's created
+// with the tables returned by bootstrap. onObsolete is called to delete a table that was removed from the LSM tree,
+// once no referenced version contains it.
+func Open(dir string, bootstrap func() ([]Table, error), onObsolete func(Table)) (*Manifest, error) {
+	m := &Manifest{
+		path:       filepath.Join(dir, Filename),
+		live:       make(map[*Version]struct{}),
+		obsolete:   make(map[Table]struct{}),
+		onObsolete: onObsolete,
+	}
+	version := &Version{m: m, levels: make(map[int][]string)}
+
+	data, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		tables, err := bootstrap()
+		if err != nil {
+			return nil, err
+		}
+		if version, err = version.apply(Edit{Added: tables}); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		for len(data) > 0 {
+			edit, n, err := decodeEdit(data)
+			if err != nil {
+				break // the edit was being appended when the process crashed
+			}
+			if version, err = version.apply(edit); err != nil {
+				return nil, fmt.Errorf("%s: %w", m.path, err)
+			}
+			data = data[n:]
+		}
+	}
+
+	version.number = versionNumbers.Add(1)
+	version.refs = 1
+	m.current = version
+	m.live[version] = struct{}{}
+	if err := m.rewrite(version); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Current returns the current version with a reference, which must be released with Unref.
+func (m *Manifest) Current() *Version {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.current.refs++
+	return m.current
+}
+
+// Apply appends the edit to the log, and makes the version with the edit applied current.
+// Removed tables are deleted once no referenced version contains them. The edit isn't applied if an error is returned.
+func (m *Manifest) Apply(edit Edit) error {
+	m.mu.Lock()
+	version, err := m.current.apply(edit)
+	if err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	if m.edits >= maxEdits {
+		// the log is replaced by the new version instead of growing further
+		err = m.rewrite(version)
+	} else if err = m.append(edit); err != nil {
+		// a part of the record may have been written, the edits appended after it wouldn't be replayed
+		if rerr := m.rewrite(m.current); rerr != nil {
+			m.edits = maxEdits // the next edit rewrites the log instead of appending to it
+			err = errors.Join(err, rerr)
+		}
+	}
+	if err != nil {
+		m.mu.Unlock()
+		return err
+	}
+
+	version.number = versionNumbers.Add(1)
+	version.refs = 1
+	previous := m.current
+	m.current = version
+	m.live[version] = struct{}{}
+	for _, t := range edit.Removed {
+		m.obsolete[t] = struct{}{}
+	}
+	previous.refs--
+	if previous.refs == 0 {
+		delete(m.live, previous)
+	}
+	obsolete := m.collectObsolete()
+	m.mu.Unlock()
+
+	m.deleteTables(obsolete)
+	return nil
+}
+
+// collectObsolete returns the removed tables that no referenced version contains.
+func (m *Manifest) collectObsolete() []Table {
+	var tables []Table
+	for t := range m.obsolete {
+		referenced := false
+		for v := range m.live {
+			if v.Contains(t) {
+				referenced = true
+				break
+			}
+		}
+		if !referenced {
+			tables = append(tables, t)
+			delete(m.obsolete, t)
+		}
+	}
+	return tables
+}
+
+func (m *Manifest) deleteTables(tables []Table) {
+	if m.onObsolete == nil {
+		return
+	}
+	for _, t := range tables {
+		m.onObsolete(t)
+	}
+}
+
+// append appends the edit to the log and syncs it to disk.
+func (m *Manifest) append(edit Edit) error {
+	file, err := os.OpenFile(m.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(encodeEdit(edit))
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", m.path, err)
+	}
+	m.edits++
+	return nil
+}
+
+// rewrite replaces the log with a single edit that adds the tables of the version.
+// The new log is written next to the old one and renamed over it, so one of them is always whole.
+func (m *Manifest) rewrite(version *Version) error {
+	var edit Edit
+	for _, level := range version.Levels() {
+		for _, name := range version.levels[level] {
+			edit.Added = append(edit.Added, Table{level, name})
+		}
+	}
+
+	tmp := m.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(encodeEdit(edit))
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, m.path)
+	}
+	if err == nil {
+		err = syncDir(filepath.Dir(m.path))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("%s: %w", m.path, err)
+	}
+	m.edits = 0
+	return nil
+}
+
+// syncDir syncs the directory, so that the files created and renamed in it are on disk.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+func encodeEdit(edit Edit) []byte {
+	var ops []byte
+	encode := func(op byte, t Table) {
+		ops = append(ops, op)
+		ops = binary.AppendUvarint(ops, uint64(t.Level))
+		ops = binary.AppendUvarint(ops, uint64(len(t.Name)))
+		ops = append(ops, t.Name...)
+	}
+	for _, t := range edit.Removed {
+		encode(opRemove, t)
+	}
+	for _, t := range edit.Added {
+		encode(opAdd, t)
+	}
+
+	record := make([]byte, headerSize, headerSize+len(ops))
+	binary.LittleEndian.PutUint32(record, crc32.ChecksumIEEE(ops))
+	binary.LittleEndian.PutUint32(record[4:], uint32(len(ops)))
+	return append(record, ops...)
+}
+
+// decodeEdit decodes the edit at the start of data and returns the size of its record.
+func decodeEdit(data []byte) (Edit, int, error) {
+	var edit Edit
+	if len(data) < headerSize {
+		return edit, 0, io.ErrUnexpectedEOF
+	}
+	size := int(binary.LittleEndian.Uint32(data[4:]))
+	if size > len(data)-headerSize {
+		return edit, 0, io.ErrUnexpectedEOF
+	}
+	ops := data[headerSize : headerSize+size]
+	if crc32.ChecksumIEEE(ops) != binary.LittleEndian.Uint32(data) {
+		return edit, 0, errors.New("invalid CRC")
+	}
+
+	r := bytes.NewReader(ops)
+	for r.Len() > 0 {
+		op, _ := r.ReadByte()
+		level, err := binary.ReadUvarint(r)
+		if err != nil {
+			return edit, 0, err
+		}
+		nameSize, err := binary.ReadUvarint(r)
+		if err != nil || nameSize > uint64(r.Len()) {
+			return edit, 0, io.ErrUnexpectedEOF
+		}
+		name := make([]byte, nameSize)
+		r.Read(name)
+
+		t := Table{int(level), string(name)}
+		switch op {
+		case opAdd:
+			edit.Added = append(edit.Added, t)
+		case opRemove:
+			edit.Removed = append(edit.Removed, t)
+		default:
+			return edit, 0, fmt.Errorf("invalid op %d", op)
+		}
+	}
+	return edit, headerSize + size, nil
+}
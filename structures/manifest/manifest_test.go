@@ -0,0 +1,115 @@
+package manifest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "manifest_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var deleted []Table
+	open := func() *Manifest {
+		t.Helper()
+		m, err := Open(tmpDir, func() ([]Table, error) {
+			return []Table{{1, "a"}, {1, "b"}}, nil
+		}, func(table Table) {
+			deleted = append(deleted, table)
+		})
+		if err != nil {
+			t.Fatalf("Failed to open the manifest: %v", err)
+		}
+		return m
+	}
+	tables := func(m *Manifest, level int, expected ...string) {
+		t.Helper()
+		v := m.Current()
+		defer v.Unref()
+		if names := v.Tables(level); !reflect.DeepEqual(names, expected) {
+			t.Errorf("Expected %v on level %d, got %v", expected, level, names)
+		}
+	}
+
+	// a new manifest starts with the tables of the bootstrap
+	m := open()
+	tables(m, 1, "a", "b")
+
+	// a pinned version keeps the removed tables until it's released
+	pinned := m.Current()
+	if err := m.Apply(Edit{Added: []Table{{2, "c"}}, Removed: []Table{{1, "a"}, {1, "b"}}}); err != nil {
+		t.Fatalf("Failed to apply the edit: %v", err)
+	}
+	tables(m, 1)
+	tables(m, 2, "c")
+	if len(deleted) != 0 {
+		t.Errorf("Expected the tables of the pinned version not to be deleted, got %v", deleted)
+	}
+	if !pinned.Contains(Table{1, "a"}) || pinned.Number() >= m.Current().Number() {
+		t.Errorf("Expected the pinned version not to change")
+	}
+	pinned.Unref()
+	if len(deleted) != 2 {
+		t.Errorf("Expected the removed tables to be deleted, got %v", deleted)
+	}
+
+	// invalid edits are not applied
+	if err := m.Apply(Edit{Removed: []Table{{1, "a"}}}); !errors.Is(err, ErrTableNotFound) {
+		t.Errorf("Expected ErrTableNotFound, got %v", err)
+	}
+	if err := m.Apply(Edit{Added: []Table{{3, "d"}, {2, "c"}}}); !errors.Is(err, ErrTableExists) {
+		t.Errorf("Expected ErrTableExists, got %v", err)
+	}
+	tables(m, 3)
+
+	// the edits are replayed, an edit that was cut short by a crash is not
+	if err := m.Apply(Edit{Added: []Table{{2, "d"}}}); err != nil {
+		t.Fatalf("Failed to apply the edit: %v", err)
+	}
+	file, err := os.OpenFile(filepath.Join(tmpDir, Filename), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open the manifest: %v", err)
+	}
+	record := encodeEdit(Edit{Removed: []Table{{2, "c"}}})
+	file.Write(record[:len(record)-1])
+	file.Close()
+
+	m = open()
+	tables(m, 1)
+	tables(m, 2, "c", "d")
+
+	// the log is rewritten when it's opened, so new edits follow the last whole one
+	if err := m.Apply(Edit{Removed: []Table{{2, "d"}}}); err != nil {
+		t.Fatalf("Failed to apply the edit: %v", err)
+	}
+	m = open()
+	tables(m, 2, "c")
+
+	// an edit that would have to rewrite the log isn't applied if the rewrite fails
+	m.edits = maxEdits
+	tmp := filepath.Join(tmpDir, Filename+".tmp")
+	if err := os.Mkdir(tmp, 0755); err != nil {
+		t.Fatalf("Failed to create a directory in place of the new log: %v", err)
+	}
+	if err := m.Apply(Edit{Added: []Table{{2, "e"}}}); err == nil {
+		t.Errorf("Expected the failed rewrite to fail the edit")
+	}
+	tables(m, 2, "c")
+	if err := os.Remove(tmp); err != nil {
+		t.Fatalf("Failed to remove the directory: %v", err)
+	}
+	if err := m.Apply(Edit{Added: []Table{{2, "e"}}}); err != nil {
+		t.Fatalf("Failed to apply the edit: %v", err)
+	}
+	if m.edits != 0 {
+		t.Errorf("Expected the log to be rewritten, got %d edits", m.edits)
+	}
+	m = open()
+	tables(m, 2, "c", "e")
+}
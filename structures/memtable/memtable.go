@@ -3,7 +3,6 @@ package memtable
 import (
 	"bytes"
 	"errors"
-	"log"
 	"nasp-project/model"
 	"nasp-project/structures/b_tree"
 	"nasp-project/structures/hash_map"
@@ -65,7 +64,8 @@ func CreateMemtables(config *util.MemtableConfig) *Memtables {
 			})
 		}
 	default:
-		log.Print("warning: The memtable structure is invalid. The default structure (SkipList) will be used.")
+		util.Logger().Warn("the memtable structure is invalid, the default structure (SkipList) will be used",
+			"structure", config.Structure)
 		structure = "SkipList"
 		for i := 0; i < instances; i++ {
 			memts.tables = append(memts.tables, &Memtable{
@@ -109,11 +109,17 @@ func (mts *Memtables) Delete(key []byte) error {
 
 // Get key from structure. Return error if key does not exist.
 func (mts *Memtables) Get(key []byte) (*model.Record, error) {
+	record, _, err := mts.GetWithIndex(key)
+	return record, err
+}
+
+// GetWithIndex does the same as Get, and also returns the index of the Memtable instance that contains the key.
+func (mts *Memtables) GetWithIndex(key []byte) (*model.Record, int, error) {
 	index := mts.currentIndex
 	for {
 		record, err := mts.tables[index].structure.Get(key)
 		if err == nil {
-			return record, nil
+			return record, index, nil
 		}
 		index -= 1
 		if index < 0 {
@@ -123,7 +129,7 @@ func (mts *Memtables) Get(key []byte) (*model.Record, error) {
 			break
 		}
 	}
-	return nil, errors.New("error: key '" + string(key) + "' not found in " + util.GetConfig().Memtable.Structure)
+	return nil, -1, errors.New("error: key '" + string(key) + "' not found in " + util.GetConfig().Memtable.Structure)
 }
 
 // IsFull returns true if all memtables are completely filled.
@@ -131,6 +137,11 @@ func (mts *Memtables) IsFull() bool {
 	return mts.tables[mts.currentIndex].structure.IsFull() && (mts.currentIndex+1)%mts.maxTables == mts.lastIndex
 }
 
+// LastIndex returns the index of the memtable that will be flushed next.
+func (mts *Memtables) LastIndex() int {
+	return mts.lastIndex
+}
+
 // Flush returns all records from the last memtable and last table index, clears the memtable and rotates accordingly.
 func (mts *Memtables) Flush() ([]model.Record, int) {
 	flushIdx := mts.lastIndex
@@ -198,7 +209,8 @@ func (mts *Memtables) Reconstruct(records []*model.Record, fileIndex []uint32, w
 			byteOffsets = append(byteOffsets, walOffset[idx])
 			mts.currentIndex = (mts.currentIndex + 1) % mts.maxTables
 			if mts.currentIndex == 0 {
-				log.Printf("warning: Can't fit all data into memtables, couldn't load %d commits.\n", len(records)-idx)
+				util.Logger().Warn("can't fit all data into memtables",
+					"lost_commits", len(records)-idx, util.LogKeyWALIndex, fileIndex[idx])
 				break
 			}
 			mt = mts.tables[mts.currentIndex]
@@ -208,3 +220,32 @@ func (mts *Memtables) Reconstruct(records []*model.Record, fileIndex []uint32, w
 
 	return fileIndexes, byteOffsets
 }
+
+// MemtableStats contains the number of user records and their total key and value size for a single Memtable.
+type MemtableStats struct {
+	Records int
+	Bytes   int64
+}
+
+// Stats returns MemtableStats for every Memtable instance, ordered by instance index.
+// Records with reserved keys are not counted.
+func (mts *Memtables) Stats() []MemtableStats {
+	stats := make([]MemtableStats, mts.maxTables)
+	for i := 0; i < mts.maxTables; i++ {
+		iter, err := mts.tables[i].structure.NewIterator()
+		if err != nil {
+			// empty memtable
+			continue
+		}
+		for rec := iter.Value(); rec != nil; rec = iter.Value() {
+			if !util.IsReservedKey(rec.Key) {
+				stats[i].Records++
+				stats[i].Bytes += int64(len(rec.Key) + len(rec.Value))
+			}
+			if !iter.Next() {
+				break
+			}
+		}
+	}
+	return stats
+}
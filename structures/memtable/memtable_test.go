@@ -567,3 +567,30 @@ func TestReservedScan(t *testing.T) {
 	testReservedScan("BTree", t)
 	testReservedScan("SkipList", t)
 }
+
+func testReservedStats(structure string, t *testing.T) {
+	util.GetConfig().Memtable.MaxSize = 3
+	util.GetConfig().Memtable.Instances = 5
+	util.GetConfig().Memtable.Structure = structure
+	mts := CreateMemtables(&util.GetConfig().Memtable)
+	addReserved(mts)
+
+	records, bytes := 0, int64(0)
+	for _, stats := range mts.Stats() {
+		records += stats.Records
+		bytes += stats.Bytes
+	}
+
+	if records != 9 {
+		t.Errorf("error: [%s] expected 9 regular records, got %d", structure, records)
+	}
+	if bytes != 42 {
+		t.Errorf("error: [%s] expected 42 bytes of regular records, got %d", structure, bytes)
+	}
+}
+
+func TestReservedStats(t *testing.T) {
+	testReservedStats("HashMap", t)
+	testReservedStats("BTree", t)
+	testReservedStats("SkipList", t)
+}
@@ -6,9 +6,9 @@ import (
 	"crypto/sha1"
 	"fmt"
 	"io"
+	"nasp-project/structures/encryption"
 	hashFn "nasp-project/structures/hash"
 	"nasp-project/util"
-	"os"
 )
 
 type Node struct {
@@ -103,9 +103,9 @@ func readFile(inFile util.BinaryFile, chunkSize int64) []Hashable {
 	// return hashed data
 	// will read every file chunk by chunk and hash them immediately
 	var hashedData []Hashable
-	file, err := os.Open(inFile.Filename)
+	file, err := encryption.Open(inFile.Filename)
 	if err != nil {
-		fmt.Println("Error opening file:", err)
+		util.Logger().Error("failed to open file", "file", inFile.Filename, util.LogKeyError, err)
 		return nil
 	}
 	defer file.Close()
@@ -126,7 +126,7 @@ func readFile(inFile util.BinaryFile, chunkSize int64) []Hashable {
 			}
 			break
 		} else if err != nil {
-			fmt.Println("Error opening file:", err)
+			util.Logger().Error("failed to read file", "file", inFile.Filename, util.LogKeyError, err)
 			return nil
 		}
 		if totalRead+int64(n) > inFile.Size {
@@ -0,0 +1,98 @@
+package merkle_tree
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+)
+
+// RangeTree is a Merkle tree over the key space split into 2^depth ranges by the hash of the key.
+// A leaf is the XOR of the hashes of the key-value pairs in its range, so it doesn't depend on the order
+// of insertion, and every inner node is the hash of its two children. Two stores with the same data
+// have the same trees, and the ranges they differ in are found by comparing the trees level by level.
+type RangeTree struct {
+	depth  int
+	levels [][]Hash // levels[0] holds the root, levels[depth] the leaves
+}
+
+// NewRangeTree creates a RangeTree of empty ranges with 2^depth leaves.
+func NewRangeTree(depth int) *RangeTree {
+	levels := make([][]Hash, depth+1)
+	for level := range levels {
+		levels[level] = make([]Hash, 1<<level)
+	}
+	return &RangeTree{depth: depth, levels: levels}
+}
+
+// Depth returns the number of levels below the root.
+func (t *RangeTree) Depth() int {
+	return t.depth
+}
+
+// RangeOf returns the index of the leaf that contains key in a tree of the given depth.
+func RangeOf(key []byte, depth int) int {
+	sum := sha1.Sum(key)
+	return int(binary.BigEndian.Uint64(sum[:8]) >> (64 - depth))
+}
+
+// Add adds a key-value pair to its range. Build must be called after the last Add.
+func (t *RangeTree) Add(key, value []byte) {
+	data := binary.LittleEndian.AppendUint64(make([]byte, 0, 8+len(key)+len(value)), uint64(len(key)))
+	data = append(append(data, key...), value...)
+	h := hash(data)
+
+	leaf := &t.levels[t.depth][RangeOf(key, t.depth)]
+	for i := range leaf {
+		leaf[i] ^= h[i]
+	}
+}
+
+// Build computes the inner nodes from the leaves.
+func (t *RangeTree) Build() {
+	for level := t.depth - 1; level >= 0; level-- {
+		for node := range t.levels[level] {
+			l, r := t.levels[level+1][2*node], t.levels[level+1][2*node+1]
+			t.levels[level][node] = hash(append(l[:], r[:]...))
+		}
+	}
+}
+
+// Hash returns the hash of a node. Nodes are numbered from 0 within every level,
+// the children of node n are 2n and 2n+1 on the level below.
+// Returns the zero Hash if the node doesn't exist.
+func (t *RangeTree) Hash(level, node int) Hash {
+	if level < 0 || level > t.depth || node < 0 || node >= len(t.levels[level]) {
+		return Hash{}
+	}
+	return t.levels[level][node]
+}
+
+// Diff returns the leaves that differ between the trees, comparing only the subtrees whose roots differ.
+// Both trees must have the same depth.
+func (t *RangeTree) Diff(other *RangeTree) []int {
+	nodes := []int{0}
+	for level := 0; level <= t.depth && len(nodes) > 0; level++ {
+		remote := make([]Hash, len(nodes))
+		for i, node := range nodes {
+			remote[i] = other.Hash(level, node)
+		}
+		nodes = t.DiffLevel(level, nodes, remote)
+	}
+	return nodes
+}
+
+// DiffLevel compares the given nodes of a level with their hashes in another tree. Returns the children
+// of the differing nodes, which are to be compared next, or the differing nodes themselves on the leaf level.
+func (t *RangeTree) DiffLevel(level int, nodes []int, hashes []Hash) []int {
+	next := make([]int, 0)
+	for i, node := range nodes {
+		if i < len(hashes) && t.Hash(level, node) == hashes[i] {
+			continue
+		}
+		if level == t.depth {
+			next = append(next, node)
+		} else {
+			next = append(next, 2*node, 2*node+1)
+		}
+	}
+	return next
+}
@@ -0,0 +1,46 @@
+package merkle_tree
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestRangeTree(t *testing.T) {
+	a, b := NewRangeTree(6), NewRangeTree(6)
+	for i := 0; i < 100; i++ {
+		key := []byte("key" + strconv.Itoa(i))
+		a.Add(key, []byte("value"))
+		// the same data in a different order
+		b.Add([]byte("key"+strconv.Itoa(99-i)), []byte("value"))
+	}
+	a.Build()
+	b.Build()
+
+	if a.Hash(0, 0) != b.Hash(0, 0) {
+		t.Fatalf("Expected trees of the same data to be equal")
+	}
+	if diff := a.Diff(b); len(diff) != 0 {
+		t.Errorf("Expected no differing ranges, got %v", diff)
+	}
+
+	c := NewRangeTree(6)
+	for i := 0; i < 100; i++ {
+		value := "value"
+		if i == 42 {
+			value = "changed"
+		}
+		if i != 7 {
+			c.Add([]byte("key"+strconv.Itoa(i)), []byte(value))
+		}
+	}
+	c.Build()
+
+	expected := []int{RangeOf([]byte("key7"), 6), RangeOf([]byte("key42"), 6)}
+	if expected[0] > expected[1] {
+		expected[0], expected[1] = expected[1], expected[0]
+	}
+	if diff := a.Diff(c); !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected differing ranges %v, got %v", expected, diff)
+	}
+}
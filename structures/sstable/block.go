@@ -0,0 +1,374 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"nasp-project/structures/block_codec"
+	"nasp-project/structures/compression"
+	"nasp-project/structures/encryption"
+	"nasp-project/util"
+	"os"
+	"sort"
+)
+
+/*
+	=== COMPRESSED DATA BLOCK ===
+
+	+-----------+-...-+-----------+---------------+---------------------+----------------------+
+	|  Block 1  |     |  Block N  |  Block Table  |  Table Offset (8B)  |  Records Size (8B)   |
+	+-----------+-...-+-----------+---------------+---------------------+----------------------+
+	Block = Compressed Size (VAR) | Records Size (VAR) | records compressed with the codec of the data block
+	Block Table = Records Offset (VAR) | Offset (VAR) of every block
+	Table Offset = Number of bytes from the start of the data block to the start of the block table
+	Records Size = Size of all records, as if they were not compressed
+
+	Records Offset is the offset of the first record of the block in the uncompressed records, which is
+	what index records and iterators point at, so a block is found by its records offset.
+	NOTE: A block holds whole records, a record is never split between blocks.
+*/
+
+const blockTrailerSize = 16
+
+type blockHandle struct {
+	recordsOffset int64 // offset of the first record in the uncompressed records
+	offset        int64 // offset of the block from the start of the data block
+}
+
+// blockReader reads the records of a compressed data block as if they were stored uncompressed
+// starting at the data block's StartOffset.
+type blockReader struct {
+	file    encryption.File
+	codec   block_codec.Codec
+	start   int64
+	size    int64 // uncompressed size of the records
+	end     int64 // offset of the block table, which follows the last block
+	blocks  []blockHandle
+	pos     int64
+	current int // index of the block in buf, or -1
+	buf     []byte
+}
+
+// open opens the data block file for reading records, decompressing them if the data block uses a codec.
+func (db *DataBlock) open() (encryption.File, error) {
+	file, err := openPaged(db.Filename, dataKind, db.StartOffset, db.Size)
+	if err != nil {
+		return nil, err
+	}
+	if db.Codec != "" {
+		reader, err := newBlockReader(file, db)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%s: %w", db.Filename, err)
+		}
+		file = reader
+	}
+	if db.PrefixKeys {
+		file = &prefixFile{File: file}
+	}
+	return file, nil
+}
+
+// asBlockReader returns the blockReader of a file opened with open, if the data block is compressed.
+func asBlockReader(file encryption.File) (*blockReader, bool) {
+	if f, ok := file.(*prefixFile); ok {
+		file = f.File
+	}
+	reader, ok := file.(*blockReader)
+	return reader, ok
+}
+
+// end returns the offset after the last record of the data block in a file opened with open.
+func (db *DataBlock) end(file encryption.File) int64 {
+	if reader, ok := asBlockReader(file); ok {
+		return reader.start + reader.size
+	}
+	return db.StartOffset + db.Size
+}
+
+// blockStarts returns the offsets of the first records of the compressed blocks relative to the start
+// of the data block, or nil if the file is not compressed.
+func blockStarts(file encryption.File) []int64 {
+	reader, ok := asBlockReader(file)
+	if !ok {
+		return nil
+	}
+	starts := make([]int64, len(reader.blocks))
+	for i, block := range reader.blocks {
+		starts[i] = block.recordsOffset
+	}
+	return starts
+}
+
+func newBlockReader(file encryption.File, db *DataBlock) (*blockReader, error) {
+	codec, err := block_codec.Get(db.Codec)
+	if err != nil {
+		return nil, err
+	}
+	if db.Size < blockTrailerSize {
+		return nil, errors.New("compressed data block is too small")
+	}
+	trailer := make([]byte, blockTrailerSize)
+	if _, err := file.Seek(db.StartOffset+db.Size-blockTrailerSize, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(file, trailer); err != nil {
+		return nil, err
+	}
+	tableOffset := int64(binary.LittleEndian.Uint64(trailer))
+	reader := &blockReader{
+		file:    file,
+		codec:   codec,
+		start:   db.StartOffset,
+		size:    int64(binary.LittleEndian.Uint64(trailer[8:])),
+		end:     db.StartOffset + tableOffset,
+		pos:     db.StartOffset,
+		current: -1,
+	}
+
+	if tableOffset < 0 || tableOffset > db.Size-blockTrailerSize {
+		return nil, errors.New("invalid block table offset")
+	}
+	if _, err := file.Seek(db.StartOffset+tableOffset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	table := make([]byte, db.Size-blockTrailerSize-tableOffset)
+	if _, err := io.ReadFull(file, table); err != nil {
+		return nil, err
+	}
+	for len(table) > 0 {
+		recordsOffset, n := binary.Uvarint(table)
+		if n <= 0 {
+			return nil, errors.New("invalid block table")
+		}
+		offset, m := binary.Uvarint(table[n:])
+		if m <= 0 {
+			return nil, errors.New("invalid block table")
+		}
+		table = table[n+m:]
+		reader.blocks = append(reader.blocks, blockHandle{int64(recordsOffset), int64(offset)})
+	}
+	return reader, nil
+}
+
+// load decompresses the block with the given index into buf.
+func (r *blockReader) load(index int) error {
+	if index == r.current {
+		return nil
+	}
+	if _, err := r.file.Seek(r.start+r.blocks[index].offset, io.SeekStart); err != nil {
+		return err
+	}
+	compressedSize, err := util.ReadUvarint(r.file)
+	if err != nil {
+		return err
+	}
+	size, err := util.ReadUvarint(r.file)
+	if err != nil {
+		return err
+	}
+	pos, err := r.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if compressedSize > uint64(max(r.end-pos, 0)) || size > uint64(r.size) {
+		return fmt.Errorf("block %d: %w", index, block_codec.ErrCorrupt)
+	}
+	compressed := make([]byte, compressedSize)
+	if _, err := io.ReadFull(r.file, compressed); err != nil {
+		return err
+	}
+	r.buf, err = r.codec.Decode(compressed, int(size))
+	if err != nil {
+		return fmt.Errorf("block %d: %w", index, err)
+	}
+	r.current = index
+	return nil
+}
+
+func (r *blockReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) && r.pos < r.start+r.size {
+		offset := r.pos - r.start
+		index := sort.Search(len(r.blocks), func(i int) bool { return r.blocks[i].recordsOffset > offset }) - 1
+		if index < 0 {
+			return n, errors.New("invalid block table")
+		}
+		if err := r.load(index); err != nil {
+			return n, err
+		}
+		inBlock := offset - r.blocks[index].recordsOffset
+		if inBlock >= int64(len(r.buf)) {
+			return n, errors.New("invalid block table")
+		}
+		copied := copy(p[n:], r.buf[inBlock:])
+		n += copied
+		r.pos += int64(copied)
+	}
+	if n == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (r *blockReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.start + r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *blockReader) Write([]byte) (int, error) {
+	return 0, errors.New("compressed data blocks are read-only")
+}
+
+func (r *blockReader) Close() error {
+	return r.file.Close()
+}
+
+// dataWriter writes the records of a data block, in compressed blocks if the data block uses a codec.
+type dataWriter struct {
+	db          *DataBlock
+	file        encryption.File
+	codec       block_codec.Codec // nil if the records are stored as they are
+	block       []byte            // records of the unfinished block
+	blocks      []blockHandle
+	recordsSize int64
+	size        int64   // bytes written after the start of the data block
+	count       int     // number of records written
+	lastKey     []byte  // key of the last record, if the keys are prefix encoded
+	restarts    []int64 // offsets of the restart points of the uncompressed records
+}
+
+// newWriter opens the data block file for writing records at the start of the data block.
+func (db *DataBlock) newWriter() (*dataWriter, error) {
+	w := &dataWriter{db: db}
+	if db.Codec != "" {
+		codec, err := block_codec.Get(db.Codec)
+		if err != nil {
+			return nil, err
+		}
+		w.codec = codec
+	}
+	file, err := encryption.OpenFile(db.Filename, os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(db.StartOffset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	w.file = file
+	return w, nil
+}
+
+// writeRecord writes a record and returns its size before the compression.
+// The key is added to the dictionary of the table, if the table has one.
+// If the keys are prefix encoded, the first record of every compressed block, or every
+// restartInterval-th record if the records are not compressed, is a restart point.
+func (w *dataWriter) writeRecord(rec *DataRecord, compressionDict *compression.Dictionary) (int, error) {
+	if compressionDict != nil {
+		compressionDict.Add(rec.Key)
+	}
+	prefix := w.db.prefixKeys(compressionDict)
+	cnt, prevKey := w.count, w.lastKey
+	w.count++
+	if prefix {
+		w.lastKey = rec.Key
+	}
+	if w.codec == nil {
+		if prefix && cnt%max(w.db.restartInterval, 1) == 0 {
+			prevKey = nil
+			w.restarts = append(w.restarts, w.recordsSize)
+		}
+		n, err := w.file.Write(rec.encode(compressionDict, prefix, prevKey))
+		w.size += int64(n)
+		w.recordsSize += int64(n)
+		return n, err
+	}
+
+	data := rec.encode(compressionDict, prefix, prevKey)
+	if len(w.block) > 0 && len(w.block)+len(data) > w.db.BlockSize {
+		if err := w.flushBlock(); err != nil {
+			return 0, err
+		}
+	}
+	if len(w.block) == 0 {
+		w.blocks = append(w.blocks, blockHandle{recordsOffset: w.recordsSize, offset: w.size})
+		if prefix {
+			data = rec.encode(compressionDict, prefix, nil)
+		}
+	}
+	w.block = append(w.block, data...)
+	w.recordsSize += int64(len(data))
+	return len(data), nil
+}
+
+// flushBlock compresses and writes the unfinished block.
+func (w *dataWriter) flushBlock() error {
+	compressed, err := w.codec.Encode(w.block)
+	if err != nil {
+		return err
+	}
+	header := binary.AppendUvarint(nil, uint64(len(compressed)))
+	header = binary.AppendUvarint(header, uint64(len(w.block)))
+	n, err := w.file.Write(append(header, compressed...))
+	w.size += int64(n)
+	w.block = w.block[:0]
+	return err
+}
+
+// close finishes the data block and closes the file. It also sets the size of the data block,
+// and the offsets of the compressed blocks or the restart points for indexing them.
+func (w *dataWriter) close() error {
+	err := w.finish()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.file = nil
+	return err
+}
+
+func (w *dataWriter) finish() error {
+	if w.codec != nil {
+		if len(w.block) > 0 {
+			if err := w.flushBlock(); err != nil {
+				return err
+			}
+		}
+		var table []byte
+		w.db.restarts = make([]int64, len(w.blocks))
+		for i, block := range w.blocks {
+			table = binary.AppendUvarint(table, uint64(block.recordsOffset))
+			table = binary.AppendUvarint(table, uint64(block.offset))
+			w.db.restarts[i] = block.recordsOffset
+		}
+		table = binary.LittleEndian.AppendUint64(table, uint64(w.size))
+		table = binary.LittleEndian.AppendUint64(table, uint64(w.recordsSize))
+		n, err := w.file.Write(table)
+		w.size += int64(n)
+		if err != nil {
+			return err
+		}
+	} else {
+		w.db.restarts = w.restarts
+	}
+	w.db.Size = w.size
+	return nil
+}
+
+// abort closes the file of a data block that won't be finished.
+func (w *dataWriter) abort() {
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+}
@@ -0,0 +1,186 @@
+package sstable
+
+import (
+	"fmt"
+	"nasp-project/model"
+	"nasp-project/structures/compression"
+	"nasp-project/util"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBlockCompression(t *testing.T) {
+	for _, codec := range []string{"", "none", "flate", "zlib", "snappy"} {
+		for _, singleFile := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/singleFile=%t", codec, singleFile), func(t *testing.T) {
+				testBlockCompression(t, codec, singleFile)
+			})
+		}
+	}
+}
+
+func testBlockCompression(t *testing.T, codec string, singleFile bool) {
+	tmpDir, err := os.MkdirTemp("", "sstable_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &util.SSTableConfig{
+		SavePath:            tmpDir,
+		SingleFile:          singleFile,
+		IndexDegree:         2,
+		SummaryDegree:       3,
+		FilterPrecision:     0.01,
+		MerkleTreeChunkSize: 16,
+		BlockCompression:    codec,
+		BlockSize:           512,
+	}
+	dict := compression.NewDictionary()
+	records := func(from, to, timestamp int) []model.Record {
+		var recs []model.Record
+		for i := from; i < to; i++ {
+			key := []byte(fmt.Sprintf("key%04d", i))
+			dict.Add(key)
+			value := fmt.Sprintf(`{"id":%d,"name":"user","active":true,"timestamp":%d}`, i, timestamp)
+			recs = append(recs, model.Record{Key: key, Value: []byte(value), Timestamp: uint64(timestamp), Tombstone: i%50 == 7})
+		}
+		return recs
+	}
+	check := func(table *SSTable, from, to, timestamp int) {
+		t.Helper()
+		for i := from; i < to; i++ {
+			rec, err := table.Read([]byte(fmt.Sprintf("key%04d", i)), dict)
+			value := fmt.Sprintf(`{"id":%d,"name":"user","active":true,"timestamp":%d}`, i, timestamp)
+			if err != nil || rec == nil || rec.Tombstone != (i%50 == 7) || !rec.Tombstone && string(rec.Value) != value {
+				t.Fatalf("Expected %s for key%04d, got %v, %v", value, i, rec, err)
+			}
+		}
+		if rec, err := table.Read([]byte("key"), dict); err != nil || rec != nil {
+			t.Errorf("Expected no record for a missing key, got %v, %v", rec, err)
+		}
+		if to-from >= 105 {
+			recs, err := table.RangeScan([]byte(fmt.Sprintf("key%04d", from+95)), []byte(fmt.Sprintf("key%04d", from+104)), -1, dict)
+			if err != nil || len(recs) != 10 || string(recs[9].Key) != fmt.Sprintf("key%04d", from+104) {
+				t.Errorf("Expected 10 records from the range scan, got %d, %v", len(recs), err)
+			}
+		}
+	}
+	count := func(table *SSTable) int {
+		t.Helper()
+		it, err := table.NewIterator(dict)
+		if err != nil {
+			t.Fatalf("Failed to create iterator: %v", err)
+		}
+		cnt := 0
+		for ; it.Value() != nil; it.Next() {
+			cnt++
+		}
+		return cnt
+	}
+
+	table, err := CreateSSTable(records(0, 300, 1), dict, config)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable: %v", err)
+	}
+	check(table, 0, 300, 1)
+	if cnt := count(table); cnt != 300 {
+		t.Errorf("Expected to iterate over 300 records, got %d", cnt)
+	}
+
+	// the codec is kept in the TOC, and the index points at the blocks
+	table, err = OpenSSTableFromToc(table.TOCFilename)
+	if err != nil {
+		t.Fatalf("Failed to open SSTable: %v", err)
+	}
+	if table.Data.Codec != codec {
+		t.Errorf("Expected codec %q, got %q", codec, table.Data.Codec)
+	}
+	check(table, 0, 300, 1)
+	uncompressed := int64(0)
+	for _, rec := range dataRecordsFromRecords(records(0, 300, 1)) {
+		uncompressed += int64(rec.sizeOnDisk(dict, false, nil))
+	}
+	indexRecords := 0
+	file, _ := os.Open(table.Index.Filename)
+	for offset := int64(0); offset < table.Index.Size; indexRecords++ {
+		ir, err := table.Index.getRecordAtOffset(file, offset, dict)
+		if err != nil {
+			t.Fatalf("Failed to read the index: %v", err)
+		}
+		offset += int64(ir.sizeOnDisk(dict, false, nil))
+	}
+	file.Close()
+	switch codec {
+	case "":
+		if table.Data.Size != uncompressed || indexRecords != 151 {
+			t.Errorf("Expected %d bytes and 151 index records, got %d and %d", uncompressed, table.Data.Size, indexRecords)
+		}
+	case "none":
+		if int64(indexRecords) > uncompressed/400+2 {
+			t.Errorf("Expected an index record per block, got %d for %d bytes", indexRecords, uncompressed)
+		}
+	default:
+		if table.Data.Size > uncompressed/2 {
+			t.Errorf("Expected the records to compress to less than half, got %d of %d bytes", table.Data.Size, uncompressed)
+		}
+	}
+
+	// merges read and write compressed blocks
+	newer, err := CreateSSTable(records(150, 450, 2), dict, config)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable: %v", err)
+	}
+	merged, err := MergeSSTables(table, newer, 2, config, dict)
+	if err != nil {
+		t.Fatalf("Failed to merge SSTables: %v", err)
+	}
+	check(merged, 0, 150, 1)
+	check(merged, 150, 450, 2)
+	if cnt := count(merged); cnt != 450 {
+		t.Errorf("Expected to iterate over 450 records, got %d", cnt)
+	}
+
+	run, err := MergeTableWithRun(dict, config, &util.LSMTreeConfig{
+		MaxLevel: 4,
+		Leveled:  util.LeveledConfig{DataBlockSize: 8000},
+	}, 3, merged)
+	if err != nil {
+		t.Fatalf("Failed to merge into a run: %v", err)
+	}
+	if len(run) < 2 {
+		t.Fatalf("Expected the run to be split into several tables, got %d", len(run))
+	}
+	total := 0
+	for _, table := range run {
+		total += count(table)
+		it, err := table.NewIterator(dict)
+		if err != nil || it.Value() == nil {
+			t.Fatalf("Failed to iterate over a table of the run: %v", err)
+		}
+		first := 0
+		fmt.Sscanf(string(it.Value().Key), "key%04d", &first)
+		last := first
+		for ; it.Value() != nil; it.Next() {
+			fmt.Sscanf(string(it.Value().Key), "key%04d", &last)
+		}
+		if first < 150 {
+			check(table, first, min(last+1, 150), 1)
+		}
+		if last >= 150 {
+			check(table, max(first, 150), last+1, 2)
+		}
+	}
+	if total != 450 {
+		t.Errorf("Expected 450 records in the run, got %d", total)
+	}
+
+	toc, err := os.ReadFile(run[0].TOCFilename)
+	if err != nil {
+		t.Fatalf("Failed to read the TOC: %v", err)
+	}
+	if strings.Contains(string(toc), "codec") != (codec != "") {
+		t.Errorf("Expected the TOC to name the codec %q, got %s", codec, toc)
+	}
+}
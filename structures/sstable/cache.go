@@ -0,0 +1,160 @@
+package sstable
+
+import (
+	"errors"
+	"io"
+	"nasp-project/structures/block_cache"
+	"nasp-project/structures/encryption"
+	"sync"
+)
+
+// pageSize is the size of the index and data pages kept in the block cache.
+const pageSize = 4096
+
+// Kinds of blocks in the block cache, used for its statistics.
+const (
+	filterKind     = "filter"
+	summaryKind    = "summary"
+	dictionaryKind = "dictionary"
+	indexKind      = "index"
+	dataKind       = "data"
+)
+
+var blockCache struct {
+	sync.RWMutex
+	cache *block_cache.BlockCache
+}
+
+// SetBlockCache sets the block cache that is shared by all SSTables, nil turns the caching off.
+func SetBlockCache(cache *block_cache.BlockCache) {
+	blockCache.Lock()
+	defer blockCache.Unlock()
+
+	blockCache.cache = cache
+}
+
+// GetBlockCache returns the block cache that is shared by all SSTables, or nil if there is none.
+func GetBlockCache() *block_cache.BlockCache {
+	blockCache.RLock()
+	defer blockCache.RUnlock()
+
+	return blockCache.cache
+}
+
+// getCached returns the block of the given kind at offset in the file from the block cache.
+func getCached(kind, filename string, offset int64) (any, bool) {
+	cache := GetBlockCache()
+	if cache == nil {
+		return nil, false
+	}
+	return cache.Get(kind, block_cache.Key{Filename: filename, Offset: offset})
+}
+
+// putCached adds the block at offset in the file to the block cache.
+func putCached(filename string, offset int64, value any, size int64, priority block_cache.Priority) {
+	cache := GetBlockCache()
+	if cache == nil {
+		return
+	}
+	cache.Put(block_cache.Key{Filename: filename, Offset: offset}, value, uint64(size), priority)
+}
+
+// dropCached removes the blocks of the given files from the block cache, and closes the files if they are kept open.
+func dropCached(filenames ...string) {
+	dropOpenFiles(filenames...)
+	cache := GetBlockCache()
+	if cache == nil {
+		return
+	}
+	for _, filename := range filenames {
+		cache.DropFile(filename)
+	}
+}
+
+// pagedFile reads a block of a file in pages that are kept in the block cache. The pages start at the
+// start of the block, and the last one ends with the block. The file is only opened when a page is not cached.
+type pagedFile struct {
+	filename string
+	kind     string
+	start    int64 // offset of the block in the file
+	end      int64 // offset after the block
+	file     encryption.File
+	pos      int64
+}
+
+// openPaged opens the block of the file for reading through the block cache,
+// or the whole file as it is if there is no block cache.
+func openPaged(filename, kind string, start, size int64) (encryption.File, error) {
+	if GetBlockCache() == nil {
+		return openFile(filename)
+	}
+	return &pagedFile{filename: filename, kind: kind, start: start, end: start + size}, nil
+}
+
+// page returns the page that starts at the given offset.
+func (f *pagedFile) page(offset int64) ([]byte, error) {
+	if page, ok := getCached(f.kind, f.filename, offset); ok {
+		return page.([]byte), nil
+	}
+	if f.file == nil {
+		file, err := openFile(f.filename)
+		if err != nil {
+			return nil, err
+		}
+		f.file = file
+	}
+	_, err := f.file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	page := make([]byte, min(pageSize, f.end-offset))
+	_, err = io.ReadFull(f.file, page)
+	if err != nil {
+		return nil, err
+	}
+	putCached(f.filename, offset, page, int64(len(page)), block_cache.Low)
+	return page, nil
+}
+
+func (f *pagedFile) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) && f.pos >= f.start && f.pos < f.end {
+		start := f.pos - (f.pos-f.start)%pageSize
+		page, err := f.page(start)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(p[n:], page[f.pos-start:])
+		n += copied
+		f.pos += int64(copied)
+	}
+	if n == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (f *pagedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.end
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *pagedFile) Write([]byte) (int, error) {
+	return 0, errors.New("paged files are read-only")
+}
+
+func (f *pagedFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
@@ -0,0 +1,133 @@
+package sstable
+
+import (
+	"fmt"
+	"nasp-project/model"
+	"nasp-project/structures/block_cache"
+	"nasp-project/util"
+	"os"
+	"testing"
+)
+
+func TestBlockCache(t *testing.T) {
+	for _, codec := range []string{"", "snappy"} {
+		for _, singleFile := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/singleFile=%t", codec, singleFile), func(t *testing.T) {
+				testBlockCache(t, codec, singleFile)
+			})
+		}
+	}
+}
+
+func testBlockCache(t *testing.T, codec string, singleFile bool) {
+	tmpDir, err := os.MkdirTemp("", "sstable_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cache := block_cache.NewBlockCache(1 << 20)
+	SetBlockCache(cache)
+	defer SetBlockCache(nil)
+
+	config := &util.SSTableConfig{
+		SavePath:            tmpDir,
+		SingleFile:          singleFile,
+		IndexDegree:         4,
+		SummaryDegree:       3,
+		FilterPrecision:     0.01,
+		MerkleTreeChunkSize: 16,
+		BlockCompression:    codec,
+		BlockSize:           1024,
+		KeyPrefixEncoding:   true,
+	}
+	records := func(from, to, timestamp int) []model.Record {
+		var recs []model.Record
+		for i := from; i < to; i++ {
+			recs = append(recs, model.Record{
+				Key:       []byte(fmt.Sprintf("key%04d", i)),
+				Value:     []byte(fmt.Sprintf("value%d-%d", i, timestamp)),
+				Timestamp: uint64(timestamp),
+			})
+		}
+		return recs
+	}
+	check := func(table *SSTable, from, to, timestamp int) {
+		t.Helper()
+		for i := from; i < to; i++ {
+			rec, err := table.Read([]byte(fmt.Sprintf("key%04d", i)), nil)
+			if err != nil || rec == nil || string(rec.Value) != fmt.Sprintf("value%d-%d", i, timestamp) {
+				t.Fatalf("Expected value%d-%d, got %v, %v", i, timestamp, rec, err)
+			}
+		}
+	}
+
+	table, err := CreateSSTable(records(0, 500, 1), nil, config)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable: %v", err)
+	}
+	table, err = OpenSSTableFromToc(table.TOCFilename)
+	if err != nil {
+		t.Fatalf("Failed to open SSTable: %v", err)
+	}
+	check(table, 0, 500, 1)
+
+	// the second read of a key is served from the cache
+	_, trace, err := table.ReadWithTrace([]byte("key0250"), nil)
+	if err != nil || !trace.Found {
+		t.Fatalf("Expected to find the key, got %v", err)
+	}
+	if trace.FilterBytesRead != 0 || trace.SummaryBytesRead != 0 {
+		t.Errorf("Expected the filter and the summary to be cached, got %+v", trace)
+	}
+	stats := cache.Stats()
+	if s := stats[filterKind]; s.Misses != 1 || s.Hits != 500 {
+		t.Errorf("Expected a single filter miss, got %+v", s)
+	}
+	if s := stats[summaryKind]; s.Misses != 2 || s.Hits != 1000 {
+		t.Errorf("Expected the range and the records of the summary to miss once, got %+v", s)
+	}
+	for _, kind := range []string{indexKind, dataKind} {
+		if s := stats[kind]; s.Hits == 0 {
+			t.Errorf("Expected %s hits, got %+v", kind, s)
+		}
+	}
+
+	// the pages of the filter, summary, index and data blocks don't outgrow the cache
+	small := block_cache.NewBlockCache(3 * pageSize)
+	SetBlockCache(small)
+	check(table, 0, 500, 1)
+	if size, capacity := small.Size(); size > capacity {
+		t.Errorf("Expected at most %d bytes in the cache, got %d", capacity, size)
+	}
+	SetBlockCache(cache)
+
+	// merged tables are written to the next level, and new tables reuse the labels of the deleted ones,
+	// whose blocks are dropped from the cache
+	newer, err := CreateSSTable(records(250, 750, 2), nil, config)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable: %v", err)
+	}
+	check(newer, 250, 750, 2)
+	merged, err := MergeSSTables(table, newer, 2, config, nil)
+	if err != nil {
+		t.Fatalf("Failed to merge SSTables: %v", err)
+	}
+	check(merged, 0, 250, 1)
+	check(merged, 250, 750, 2)
+	reused, err := CreateSSTable(records(100, 400, 3), nil, config)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable: %v", err)
+	}
+	if reused.TOCFilename != table.TOCFilename {
+		t.Fatalf("Expected the new table to reuse the label of %s, got %s", table.TOCFilename, reused.TOCFilename)
+	}
+	check(reused, 100, 400, 3)
+	cnt := 0
+	for it, err := reused.NewIterator(nil); err == nil && it.Value() != nil; it.Next() {
+		cnt++
+	}
+	if cnt != 300 {
+		t.Errorf("Expected to iterate over 300 records, got %d", cnt)
+	}
+}
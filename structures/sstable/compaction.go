@@ -0,0 +1,160 @@
+package sstable
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tmpSuffix is added to the name of the TOC file of a table until it's published, so a table that was being written
+// when the process stopped is never taken for a whole one.
+const tmpSuffix = ".tmp"
+
+// crashPoint is called after every step that changes the files of the LSM tree while tables are written, published
+// and deleted. Tests replace it to see the files as a crash after the step would leave them.
+var crashPoint = func(step string) {}
+
+// Compaction collects the tables that a compaction writes and the tables that they replace. The written tables aren't
+// part of the LSM tree, and are deleted as garbage after a crash, until Commit publishes them and removes the replaced
+// tables in a single manifest edit. A crash at any point leaves either all replaced tables or all written ones.
+type Compaction struct {
+	added     []*SSTable // written tables
+	removed   []*SSTable // published tables that the written tables replace
+	relabeled map[*SSTable]string
+	merges    MergeStats // kept by Commit and Abort, so that a Compaction can sum the merges of several steps
+}
+
+// add adds the tables written by the compaction.
+func (c *Compaction) add(tables ...*SSTable) {
+	c.added = append(c.added, tables...)
+}
+
+// remove marks the tables as replaced. A table that was written by the compaction is deleted right away,
+// it was never published.
+func (c *Compaction) remove(tables ...*SSTable) error {
+	for _, table := range tables {
+		written := false
+		for i, added := range c.added {
+			if added == table {
+				c.added = append(c.added[:i], c.added[i+1:]...)
+				written = true
+				break
+			}
+		}
+		if !written {
+			c.removed = append(c.removed, table)
+		} else if err := table.deleteFiles(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Relabel links the files of the table under the next free label of its level, and replaces the table with the
+// relabeled one. Tables that are relabeled come after all tables of the level, in the order they were relabeled.
+func (c *Compaction) Relabel(table *SSTable) (*SSTable, error) {
+	label, err := GetNextSStableLabel(filepath.Dir(table.TOCFilename))
+	if err != nil {
+		return nil, err
+	}
+	return c.relabel(table, label)
+}
+
+func (c *Compaction) relabel(table *SSTable, label int) (*SSTable, error) {
+	renamed, err := table.relabel(label)
+	if err != nil {
+		return nil, err
+	}
+	c.add(renamed)
+	if c.relabeled == nil {
+		c.relabeled = make(map[*SSTable]string)
+	}
+	c.relabeled[renamed] = table.TOCFilename
+	return renamed, c.remove(table)
+}
+
+// Commit syncs the written tables to disk and publishes them, removing the replaced tables from the LSM tree.
+// The replaced tables are deleted once no pinned version contains them. If the tables can't be published,
+// the written ones are deleted.
+func (c *Compaction) Commit() error {
+	if err := applyEdit(c.added, c.removed); err != nil {
+		if aerr := c.Abort(); aerr != nil {
+			return fmt.Errorf("%w && failed to delete the written tables : %w", err, aerr)
+		}
+		return err
+	}
+	for table, tocFilename := range c.relabeled {
+		moveFilterStats(tocFilename, table.TOCFilename)
+	}
+	*c = Compaction{merges: c.merges}
+	return nil
+}
+
+// Abort deletes the tables written by the compaction, the LSM tree stays unchanged.
+func (c *Compaction) Abort() error {
+	var err error
+	for _, table := range c.added {
+		if derr := table.deleteFiles(); derr != nil && !os.IsNotExist(derr) {
+			err = fmt.Errorf("%w && failed to delete files for SSTable '%s' : %w", err, table.TOCFilename, derr)
+		}
+		// we delete all tables even if one deletion fails
+	}
+	*c = Compaction{merges: c.merges}
+	return err
+}
+
+// finish commits the compaction if err is nil, otherwise it aborts it and returns err.
+func (c *Compaction) finish(err error) error {
+	if err == nil {
+		return c.Commit()
+	}
+	if aerr := c.Abort(); aerr != nil {
+		return fmt.Errorf("%w [while handling the previous error new one occured : %w]", err, aerr)
+	}
+	return err
+}
+
+// persist syncs the files of a written table to disk and gives its TOC file the final name, so it can be published.
+func (sst *SSTable) persist() error {
+	filenames := []string{sst.Data.Filename, sst.Index.Filename, sst.Summary.Filename, sst.Filter.Filename,
+		sst.Dictionary.Filename, sst.MetadataFilename, sst.TOCFilename}
+	synced := make(map[string]bool)
+	for _, filename := range filenames {
+		if filename == "" || synced[filename] {
+			continue
+		}
+		synced[filename] = true
+		if err := syncFile(filename); err != nil {
+			return err
+		}
+	}
+	if err := syncFile(filepath.Dir(sst.Data.Filename)); err != nil {
+		return err
+	}
+	crashPoint("sync")
+
+	tocFilename, ok := strings.CutSuffix(sst.TOCFilename, tmpSuffix)
+	if !ok {
+		return nil
+	}
+	if err := os.Rename(sst.TOCFilename, tocFilename); err != nil {
+		return err
+	}
+	sst.TOCFilename = tocFilename
+	if err := syncFile(filepath.Dir(tocFilename)); err != nil {
+		return err
+	}
+	crashPoint("rename")
+	return nil
+}
+
+// syncFile flushes the file or directory to disk.
+func syncFile(name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
@@ -0,0 +1,202 @@
+package sstable_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"nasp-project/model"
+	"nasp-project/structures/lsm/compactions"
+	"nasp-project/structures/manifest"
+	"nasp-project/structures/sstable"
+	"nasp-project/util"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// TestCompactionCrash stops the compaction after every step that changes the files of the LSM tree, by copying the
+// files as they are after the step, and checks that every copy is opened with either the merged tables or the result.
+func TestCompactionCrash(t *testing.T) {
+	for _, algorithm := range []string{"Size-Tiered", "Leveled"} {
+		for _, singleFile := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/singleFile=%t", algorithm, singleFile), func(t *testing.T) {
+				testCompactionCrash(t, algorithm, singleFile)
+			})
+		}
+	}
+}
+
+func testCompactionCrash(t *testing.T, algorithm string, singleFile bool) {
+	tmpDir, err := os.MkdirTemp("", "sstable_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	newConfig := func(savePath string) *util.SSTableConfig {
+		return &util.SSTableConfig{
+			SavePath:            savePath,
+			SingleFile:          singleFile,
+			IndexDegree:         2,
+			SummaryDegree:       3,
+			FilterPrecision:     0.01,
+			MerkleTreeChunkSize: 16,
+		}
+	}
+	config := newConfig(filepath.Join(tmpDir, "store"))
+	lsmConfig := &util.LSMTreeConfig{
+		MaxLevel:            3,
+		CompactionAlgorithm: algorithm,
+		SizeTiered:          util.SizeTieredConfig{MaxLsmNodesPerLevel: 2},
+		Leveled: util.LeveledConfig{
+			DataBlockSize:           200,
+			FirstLevelTotalDataSize: 100,
+			FanoutSize:              100,
+		},
+	}
+
+	expected := make(map[string]string)
+	flush := func(keys ...int) {
+		t.Helper()
+		var records []model.Record
+		for _, key := range keys {
+			records = append(records, model.Record{
+				Key:       []byte(fmt.Sprintf("key%03d", key)),
+				Value:     []byte(fmt.Sprintf("value%03d", key)),
+				Timestamp: uint64(key),
+			})
+			expected[fmt.Sprintf("key%03d", key)] = fmt.Sprintf("value%03d", key)
+		}
+		if _, err := sstable.CreateSSTable(records, nil, config); err != nil {
+			t.Fatalf("Failed to create SSTable: %v", err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		var keys []int
+		for key := i * 2; key < 100; key += 8 {
+			keys = append(keys, key)
+		}
+		flush(keys...)
+	}
+	if _, err := compactions.Compact(nil, lsmConfig, config); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	// the new tables overlap only the first tables of the next level
+	flush(1, 3, 5)
+	flush(7, 9)
+
+	var crashes []string
+	steps := make(map[string]int)
+	sstable.SetCrashPoint(func(step string) {
+		crash := filepath.Join(tmpDir, fmt.Sprintf("crash%03d", len(crashes)))
+		if err := copyDir(config.SavePath, crash); err != nil {
+			t.Fatalf("Failed to copy the files after step %s: %v", step, err)
+		}
+		crashes = append(crashes, crash)
+		steps[step]++
+	})
+	_, err = compactions.Compact(nil, lsmConfig, config)
+	sstable.SetCrashPoint(func(string) {})
+	if err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+
+	for _, step := range []string{"create", "write", "sync", "rename", "publish", "delete"} {
+		if steps[step] == 0 {
+			t.Errorf("Expected the compaction to %s files", step)
+		}
+	}
+	if algorithm == "Leveled" && steps["link"] == 0 {
+		t.Errorf("Expected the compaction to relabel tables")
+	}
+
+	checkTables(t, config.SavePath, expected)
+	for _, crash := range crashes {
+		checkTables(t, crash, expected)
+		// the compaction continues from the tables that were left
+		if _, err := compactions.Compact(nil, lsmConfig, newConfig(crash)); err != nil {
+			t.Fatalf("Failed to compact after the crash in %s: %v", crash, err)
+		}
+		checkTables(t, crash, expected)
+	}
+}
+
+// checkTables checks that every key is in exactly one table of the LSM tree in savePath, and that all files in
+// the level directories belong to the tables of the LSM tree.
+func checkTables(t *testing.T, savePath string, expected map[string]string) {
+	t.Helper()
+	version, err := sstable.PinVersion(savePath)
+	if err != nil {
+		t.Fatalf("Failed to pin the version of %s: %v", savePath, err)
+	}
+	defer version.Unref()
+
+	labelRegexp := regexp.MustCompile(`^usertable-(\d+)-`)
+	var tables []*sstable.SSTable
+	for _, level := range version.Levels() {
+		labels := make(map[string]bool)
+		for _, name := range version.Tables(level) {
+			table, err := sstable.OpenSSTableFromToc(sstable.TOCPath(savePath, manifest.Table{Level: level, Name: name}))
+			if err != nil {
+				t.Fatalf("Failed to open %s on level %d of %s: %v", name, level, savePath, err)
+			}
+			tables = append(tables, table)
+			labels[labelRegexp.FindStringSubmatch(name)[1]] = true
+		}
+
+		levelDir := filepath.Join(savePath, fmt.Sprintf("L%03d", level))
+		filepath.WalkDir(levelDir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			if match := labelRegexp.FindStringSubmatch(entry.Name()); match == nil || !labels[match[1]] {
+				t.Errorf("Expected %s not to be left in %s", entry.Name(), savePath)
+			}
+			return nil
+		})
+	}
+
+	for key, value := range expected {
+		found := 0
+		for _, table := range tables {
+			rec, err := table.Read([]byte(key), nil)
+			if err != nil {
+				t.Fatalf("Failed to read %s from %s: %v", key, table.TOCFilename, err)
+			}
+			if rec != nil {
+				found++
+				if string(rec.Value) != value {
+					t.Errorf("Expected %s for %s in %s, got %s", value, key, savePath, rec.Value)
+				}
+			}
+		}
+		if found != 1 {
+			t.Errorf("Expected %s in exactly one table of %s, found it in %d", key, savePath, found)
+		}
+	}
+}
+
+// copyDir copies the files in src to dst. The TOC files name the files of a table by their paths, which are changed to dst.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if filepath.Base(filepath.Dir(path)) == "TOC" {
+			data = bytes.ReplaceAll(data, []byte(src), []byte(dst))
+		}
+		return os.WriteFile(filepath.Join(dst, rel), data, 0644)
+	})
+}
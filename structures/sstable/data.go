@@ -4,10 +4,11 @@ import (
 	bytesUtil "bytes"
 	"encoding/binary"
 	"errors"
+	"io"
 	"nasp-project/model"
 	"nasp-project/structures/compression"
+	"nasp-project/structures/encryption"
 	"nasp-project/util"
-	"os"
 )
 
 /*
@@ -27,8 +28,13 @@ import (
 	NOTE: Value and Value Size are left out if Tombstone is set.
 	NOTE: Fields marked with VAR are encoded using variable encoding and take up between 1 and 10 bytes.
 	NOTE: Records are sorted by Key
+	NOTE: Tables with prefix encoded keys store the keys as described in prefix.go
 */
 
+// errBadCRC is returned for a record whose CRC doesn't match its contents. The record is read whole,
+// so the reading can continue with the next one.
+var errBadCRC = errors.New("CRC check failed")
+
 // DataRecord represents a record in an SSTable.
 type DataRecord struct {
 	CRC       uint32
@@ -40,15 +46,31 @@ type DataRecord struct {
 
 // DataBlock represents a data block in an SSTable.
 type DataBlock struct {
-	util.BinaryFile // Only file block because nothing is ever loaded into memory
+	util.BinaryFile         // Only file block because nothing is ever loaded into memory
+	Codec           string  // block_codec.Codec the records are compressed with in blocks, empty if they are not
+	BlockSize       int     // records size at which the next record starts a new compressed block, used when writing
+	PrefixKeys      bool    // whether the keys are prefix encoded
+	restartInterval int     // number of records between the restart points of prefix encoded keys, used when writing
+	restarts        []int64 // offsets of the records that are read from, the first records of the compressed blocks or the restart points, set when writing
+}
+
+// prefixKeys returns true if the keys are prefix encoded, which they are not if the table uses a compression dictionary.
+func (db *DataBlock) prefixKeys(compressionDict *compression.Dictionary) bool {
+	return db.PrefixKeys && compressionDict == nil
 }
 
 // sizeOnDisk returns the number of bytes that DataRecord would occupy on disk.
-func (dr *DataRecord) sizeOnDisk(compressionDict *compression.Dictionary) int {
+// If prefix is true, the key is prefix encoded against prevKey, which is nil at restart points.
+func (dr *DataRecord) sizeOnDisk(compressionDict *compression.Dictionary, prefix bool, prevKey []byte) int {
 	buf := make([]byte, binary.MaxVarintLen64)
 	res := 4 + binary.PutUvarint(buf, dr.Timestamp) + 1
 	if compressionDict == nil {
-		res += binary.PutUvarint(buf, uint64(len(dr.Key))) + len(dr.Key)
+		shared := 0
+		if prefix {
+			shared = sharedPrefixLen(prevKey, dr.Key)
+			res += binary.PutUvarint(buf, uint64(shared))
+		}
+		res += binary.PutUvarint(buf, uint64(len(dr.Key)-shared)) + len(dr.Key) - shared
 	} else {
 		res += binary.PutUvarint(buf, uint64(compressionDict.GetIdx(dr.Key)))
 	}
@@ -101,184 +123,76 @@ func dataRecordsFromRecords(recs []model.Record) []DataRecord {
 // Write writes the records to the data block file.
 // It also sets the size of the data block.
 func (db *DataBlock) Write(recs []DataRecord, compressionDict *compression.Dictionary) error {
-	file, err := os.OpenFile(db.Filename, os.O_WRONLY, 0644)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-	_, err = file.Seek(db.StartOffset, 0)
+	w, err := db.newWriter()
 	if err != nil {
 		return err
 	}
+	defer w.abort()
 
 	for _, rec := range recs {
-		err = db.writeRecord(file, &rec, compressionDict)
+		_, err = w.writeRecord(&rec, compressionDict)
 		if err != nil {
 			return err
 		}
 	}
 
-	db.Size, err = file.Seek(0, 1)
-	if err != nil {
-		return err
-	}
-	db.Size -= db.StartOffset
-
-	return nil
+	return w.close()
 }
 
-// writeRecord writes a record to the data block file.
-func (db *DataBlock) writeRecord(file *os.File, rec *DataRecord, compressionDict *compression.Dictionary) error {
-	bytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(bytes, rec.CRC)
-	_, err := file.Write(bytes)
-	if err != nil {
-		return err
-	}
-
-	err = util.WriteUvarint(file, rec.Timestamp)
-	if err != nil {
-		return err
-	}
+// encode returns the record in its format on disk.
+// If prefix is true, the key is prefix encoded against prevKey, which is nil at restart points.
+func (dr *DataRecord) encode(compressionDict *compression.Dictionary, prefix bool, prevKey []byte) []byte {
+	bytes := make([]byte, 0, dr.sizeOnDisk(compressionDict, prefix, prevKey))
+	bytes = binary.LittleEndian.AppendUint32(bytes, dr.CRC)
+	bytes = binary.AppendUvarint(bytes, dr.Timestamp)
 
-	if rec.Tombstone {
-		_, err = file.Write([]byte{1})
-		if err != nil {
-			return err
-		}
+	if dr.Tombstone {
+		bytes = append(bytes, 1)
 	} else {
-		_, err = file.Write([]byte{0})
-		if err != nil {
-			return err
-		}
+		bytes = append(bytes, 0)
 	}
 
-	if compressionDict == nil {
-		// KeySize is left out if the compression is turned on
-		err = util.WriteUvarint(file, uint64(len(rec.Key)))
-		if err != nil {
-			return err
-		}
+	shared := 0
+	if prefix {
+		shared = sharedPrefixLen(prevKey, dr.Key)
+		bytes = binary.AppendUvarint(bytes, uint64(shared))
 	}
-
-	if !rec.Tombstone {
-		err = util.WriteUvarint(file, uint64(len(rec.Value)))
-		if err != nil {
-			return err
-		}
-	}
-
-	if compressionDict == nil {
-		// compression if off, write the key bytes as-is
-		_, err = file.Write(rec.Key)
-		if err != nil {
-			return err
-		}
-	} else {
-		// compression is on, write only index from compression dictionary
-		err = util.WriteUvarint(file, uint64(compressionDict.GetIdx(rec.Key)))
-		if err != nil {
-			return err
-		}
-	}
-
-	if !rec.Tombstone {
-		_, err = file.Write(rec.Value)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-// writeRecordLen writes a record to the data block file and returns the number of bytes written.
-func (db *DataBlock) writeRecordLen(file *os.File, rec *DataRecord, compressionDict *compression.Dictionary) (int, error) {
-	size := 0
-
-	bytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(bytes, rec.CRC)
-	_, err := file.Write(bytes)
-	if err != nil {
-		return size, err
-	}
-	size += 4
-
-	n, err := util.WriteUvarintLen(file, rec.Timestamp)
-	if err != nil {
-		return size, err
-	}
-	size += n
-
-	if rec.Tombstone {
-		_, err = file.Write([]byte{1})
-		if err != nil {
-			return size, err
-		}
-	} else {
-		_, err = file.Write([]byte{0})
-		if err != nil {
-			return size, err
-		}
-	}
-	size += 1
-
 	if compressionDict == nil {
 		// KeySize is left out if the compression is turned on
-		n, err = util.WriteUvarintLen(file, uint64(len(rec.Key)))
-		if err != nil {
-			return size, err
-		}
-		size += n
+		bytes = binary.AppendUvarint(bytes, uint64(len(dr.Key)-shared))
 	}
 
-	if !rec.Tombstone {
-		n, err = util.WriteUvarintLen(file, uint64(len(rec.Value)))
-		if err != nil {
-			return size, err
-		}
-		size += n
+	if !dr.Tombstone {
+		bytes = binary.AppendUvarint(bytes, uint64(len(dr.Value)))
 	}
 
 	if compressionDict == nil {
 		// compression if off, write the key bytes as-is
-		n, err = file.Write(rec.Key)
-		if err != nil {
-			return size, err
-		}
-		size += n
+		bytes = append(bytes, dr.Key[shared:]...)
 	} else {
 		// compression is on, write only index from compression dictionary
-		n, err = util.WriteUvarintLen(file, uint64(compressionDict.GetIdx(rec.Key)))
-		if err != nil {
-			return size, err
-		}
-		size += n
+		bytes = binary.AppendUvarint(bytes, uint64(compressionDict.GetIdx(dr.Key)))
 	}
 
-	if !rec.Tombstone {
-		n, err = file.Write(rec.Value)
-		if err != nil {
-			return size, err
-		}
-		size += n
+	if !dr.Tombstone {
+		bytes = append(bytes, dr.Value...)
 	}
 
-	return size, nil
+	return bytes
 }
 
 // isEndOfBlock return true if file pointer is positioned at the end of the given data block.
-func (db *DataBlock) isEndOfBlock(file *os.File) (bool, error) {
+func (db *DataBlock) isEndOfBlock(file encryption.File) (bool, error) {
 	pos, err := file.Seek(0, 1)
 	if err != nil {
 		return false, err
 	}
-	return pos == db.StartOffset+db.Size, nil
+	return pos == db.end(file), nil
 }
 
 // getNextRecord assumes the provided file is at the start of the record and reads the next record.
 // Returns nil if positioned at the end of data block.
-func (db *DataBlock) getNextRecord(file *os.File, compressionDict *compression.Dictionary) (*DataRecord, error) {
+func (db *DataBlock) getNextRecord(file encryption.File, compressionDict *compression.Dictionary) (*DataRecord, error) {
 	end, err := db.isEndOfBlock(file)
 	if err != nil {
 		return nil, err
@@ -307,11 +221,14 @@ func (db *DataBlock) getNextRecord(file *os.File, compressionDict *compression.D
 	if err != nil {
 		return nil, err
 	}
+	if bytes[0] > 1 {
+		return nil, errors.New("invalid tombstone")
+	}
 	tombstone := bytes[0] == 1
 
-	var keySize uint64 // only if compression is turned off
+	var shared, keySize uint64 // only if compression is turned off
 	if compressionDict == nil {
-		keySize, err = util.ReadUvarint(file)
+		shared, keySize, _, err = readKeySizes(file, db.prefixKeys(compressionDict))
 		if err != nil {
 			return nil, err
 		}
@@ -325,8 +242,23 @@ func (db *DataBlock) getNextRecord(file *os.File, compressionDict *compression.D
 		}
 	}
 
+	// the sizes of a damaged record may point past the data block
+	pos, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	left := uint64(max(db.end(file)-pos, 0))
+	if keySize > left || valueSize > left-keySize {
+		return nil, errors.New("record is longer than the data block")
+	}
+
 	var key []byte
-	if compressionDict == nil {
+	if db.prefixKeys(compressionDict) {
+		key, err = readPrefixKey(file, shared, keySize)
+		if err != nil {
+			return nil, err
+		}
+	} else if compressionDict == nil {
 		// compression is off, read the key as-is
 		key = make([]byte, keySize)
 		_, err = file.Read(key)
@@ -360,14 +292,14 @@ func (db *DataBlock) getNextRecord(file *os.File, compressionDict *compression.D
 	}
 
 	if !rec.isCRCValid() {
-		return rec, errors.New("CRC check failed")
+		return rec, errBadCRC
 	}
 	return rec, nil
 }
 
 // getRecordAtOffset reads a record from the data block file at the given offset.
 func (db *DataBlock) getRecordAtOffset(offset int64, compressionDict *compression.Dictionary) (*DataRecord, error) {
-	file, err := os.Open(db.Filename)
+	file, err := db.open()
 	if err != nil {
 		return nil, err
 	}
@@ -384,36 +316,50 @@ func (db *DataBlock) getRecordAtOffset(offset int64, compressionDict *compressio
 // GetRecordWithKeyFromOffset reads a record with the given key from the data block file, starting from the offset.
 // Returns nil if the record is not found.
 func (db *DataBlock) GetRecordWithKeyFromOffset(key []byte, offset int64, compressionDict *compression.Dictionary) (*DataRecord, error) {
-	file, err := os.Open(db.Filename)
+	dataRec, _, err := db.getRecordWithKeyFromOffsetLen(key, offset, compressionDict)
+	return dataRec, err
+}
+
+// getRecordWithKeyFromOffsetLen does the same as GetRecordWithKeyFromOffset,
+// and also returns the number of bytes read from the data block.
+func (db *DataBlock) getRecordWithKeyFromOffsetLen(key []byte, offset int64, compressionDict *compression.Dictionary) (*DataRecord, int64, error) {
+	file, err := db.open()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer file.Close()
 
-	_, err = file.Seek(db.StartOffset+offset, 0)
+	start, err := file.Seek(db.StartOffset+offset, 0)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	bytesRead := func() int64 {
+		pos, err := file.Seek(0, 1)
+		if err != nil {
+			return 0
+		}
+		return pos - start
 	}
 
 	for {
 		dataRec, err := db.getNextRecord(file, compressionDict)
 		if err != nil {
-			return nil, err
+			return nil, bytesRead(), err
 		}
 		if dataRec == nil {
-			return nil, nil
+			return nil, bytesRead(), nil
 		}
 		cmp := bytesUtil.Compare(dataRec.Key, key)
 		if cmp == 0 {
-			return dataRec, nil
+			return dataRec, bytesRead(), nil
 		} else if cmp > 0 {
-			return nil, nil
+			return nil, bytesRead(), nil
 		}
 	}
 }
 
 func (db *DataBlock) GetRecordAtKeyFromOffset(key []byte, offset int64, compressionDict *compression.Dictionary) (*DataRecord, int64, error) {
-	file, err := os.Open(db.Filename)
+	file, err := db.open()
 	if err != nil {
 		return nil, -1, err
 	}
@@ -447,38 +393,53 @@ func (db *DataBlock) GetRecordAtKeyFromOffset(key []byte, offset int64, compress
 	}
 }
 
-// DataRecordGenerator is used for iterating over a list of all records from consecutive data blocks.
+// DataRecordGenerator is used for iterating over a list of all records from the data blocks of consecutive tables.
 // Use GetNextRecord method to return next record, starting from the first one.
 // Please remember to call Clear too free up resources after the usage.
 type DataRecordGenerator struct {
-	blocks          []*DataBlock
-	blockPtr        int                     // index of the block with the next record
-	file            *os.File                // currently open data block file
+	tables          []*SSTable
+	tablePtr        int                     // index of the table with the next record
+	file            encryption.File         // currently open data block file
+	dict            *compression.Dictionary // dictionary of the current table
 	reachedEnd      bool                    // true if there are no more records to be read
 	compressionDict *compression.Dictionary // pass to NewDataRecordGenerator if compression was used
 }
 
-// NewDataRecordGenerator creates a DataRecordGenerator for the given blocks.
-func NewDataRecordGenerator(blocks []*DataBlock, compressionDict *compression.Dictionary) (*DataRecordGenerator, error) {
-	var firstFile *os.File
-	var reachedEnd = false
-	if len(blocks) < 1 {
-		firstFile = nil
-		reachedEnd = true
-	} else {
-		tmpFileVariable, err := os.Open(blocks[0].Filename) // I hate GO.
+// NewDataRecordGenerator creates a DataRecordGenerator for the data blocks of the given tables.
+func NewDataRecordGenerator(tables []*SSTable, compressionDict *compression.Dictionary) (*DataRecordGenerator, error) {
+	gen := &DataRecordGenerator{
+		tables:          tables,
+		tablePtr:        0,
+		reachedEnd:      len(tables) < 1,
+		compressionDict: compressionDict,
+	}
+	if !gen.reachedEnd {
+		err := gen.openTable()
 		if err != nil {
 			return nil, err
 		}
-		firstFile = tmpFileVariable
 	}
-	return &DataRecordGenerator{
-		blocks:          blocks,
-		blockPtr:        0,
-		file:            firstFile,
-		reachedEnd:      reachedEnd,
-		compressionDict: compressionDict,
-	}, nil
+	return gen, nil
+}
+
+// openTable opens the data block and loads the dictionary of the current table.
+func (gen *DataRecordGenerator) openTable() error {
+	table := gen.tables[gen.tablePtr]
+	if !table.Dictionary.HasLoaded() {
+		defer func() {
+			table.Dictionary.Dict = nil // the generator holds the dictionary of one table at a time
+		}()
+	}
+	dict, err := table.dictionary(gen.compressionDict)
+	if err != nil {
+		return err
+	}
+	gen.file, err = table.Data.open()
+	if err != nil {
+		return err
+	}
+	gen.dict = dict
+	return nil
 }
 
 // GetNextRecord returns the next record from the data block sequence, or nil if the end is reached.
@@ -486,19 +447,20 @@ func (gen *DataRecordGenerator) GetNextRecord() (*DataRecord, error) {
 	if gen.reachedEnd {
 		return nil, nil
 	}
-	nextRec, err := gen.blocks[gen.blockPtr].getNextRecord(gen.file, gen.compressionDict)
+	nextRec, err := gen.tables[gen.tablePtr].Data.getNextRecord(gen.file, gen.dict)
 	if err != nil {
 		return nil, err
 	}
 	if nextRec == nil {
 		gen.file.Close()
-		gen.blockPtr++
-		if gen.blockPtr >= len(gen.blocks) {
+		gen.file = nil
+		gen.dict = nil
+		gen.tablePtr++
+		if gen.tablePtr >= len(gen.tables) {
 			gen.reachedEnd = true
-			gen.file = nil
 			return nil, nil
 		}
-		gen.file, err = os.Open(gen.blocks[gen.blockPtr].Filename)
+		err = gen.openTable()
 		if err != nil {
 			return nil, err
 		}
@@ -510,8 +472,8 @@ func (gen *DataRecordGenerator) GetNextRecord() (*DataRecord, error) {
 // Clear frees up resources that the DataRecordGenerator uses.
 // After the call to Clear, each next call of GetNextRecord will return nil.
 func (gen *DataRecordGenerator) Clear() error {
-	gen.blocks = nil
-	gen.blockPtr = 0
+	gen.tables = nil
+	gen.tablePtr = 0
 	if gen.file != nil {
 		err := gen.file.Close()
 		if err != nil {
@@ -520,37 +482,39 @@ func (gen *DataRecordGenerator) Clear() error {
 		gen.file = nil
 	}
 	gen.reachedEnd = true
+	gen.dict = nil
 	gen.compressionDict = nil
 	return nil
 }
 
 // WriteMerged merges db1 and db2 and writes the result to db.
 // It also sets the size of the new data block.
+// dict1 and dict2 are the dictionaries of db1 and db2, the keys are added to compressionDict, the dictionary of db.
 // Returns the number of records in the merged data block.
-func (db *DataBlock) WriteMerged(db1, db2 *DataBlock, compressionDict *compression.Dictionary) (uint, error) {
-	file, err := os.OpenFile(db.Filename, os.O_WRONLY, 0644)
+func (db *DataBlock) WriteMerged(db1, db2 *DataBlock, dict1, dict2, compressionDict *compression.Dictionary) (uint, error) {
+	w, err := db.newWriter()
 	if err != nil {
 		return 0, err
 	}
-	defer file.Close()
+	defer w.abort()
 
-	file1, err := os.Open(db1.Filename)
+	file1, err := db1.open()
 	if err != nil {
 		return 0, err
 	}
 	defer file1.Close()
 
-	file2, err := os.Open(db2.Filename)
+	file2, err := db2.open()
 	if err != nil {
 		return 0, err
 	}
 	defer file2.Close()
 
-	rec1, err := db1.getNextRecord(file1, compressionDict)
+	rec1, err := db1.getNextRecord(file1, dict1)
 	if err != nil {
 		return 0, err
 	}
-	rec2, err := db2.getNextRecord(file2, compressionDict)
+	rec2, err := db2.getNextRecord(file2, dict2)
 	if err != nil {
 		return 0, err
 	}
@@ -560,66 +524,66 @@ func (db *DataBlock) WriteMerged(db1, db2 *DataBlock, compressionDict *compressi
 		if rec1 == nil && rec2 == nil {
 			break
 		} else if rec1 == nil {
-			err = db.writeRecord(file, rec2, compressionDict)
+			_, err = w.writeRecord(rec2, compressionDict)
 			if err != nil {
 				return cnt, err
 			}
-			rec2, err = db2.getNextRecord(file2, compressionDict)
+			rec2, err = db2.getNextRecord(file2, dict2)
 			if err != nil {
 				return cnt, err
 			}
 		} else if rec2 == nil {
-			err = db.writeRecord(file, rec1, compressionDict)
+			_, err = w.writeRecord(rec1, compressionDict)
 			if err != nil {
 				return cnt, err
 			}
-			rec1, err = db1.getNextRecord(file1, compressionDict)
+			rec1, err = db1.getNextRecord(file1, dict1)
 			if err != nil {
 				return cnt, err
 			}
 		} else {
 			cmp := bytesUtil.Compare(rec1.Key, rec2.Key)
 			if cmp < 0 {
-				err = db.writeRecord(file, rec1, compressionDict)
+				_, err = w.writeRecord(rec1, compressionDict)
 				if err != nil {
 					return cnt, err
 				}
-				rec1, err = db1.getNextRecord(file1, compressionDict)
+				rec1, err = db1.getNextRecord(file1, dict1)
 				if err != nil {
 					return cnt, err
 				}
 			} else if cmp > 0 {
-				err = db.writeRecord(file, rec2, compressionDict)
+				_, err = w.writeRecord(rec2, compressionDict)
 				if err != nil {
 					return cnt, err
 				}
-				rec2, err = db2.getNextRecord(file2, compressionDict)
+				rec2, err = db2.getNextRecord(file2, dict2)
 				if err != nil {
 					return cnt, err
 				}
 			} else if rec1.Timestamp > rec2.Timestamp {
-				err = db.writeRecord(file, rec1, compressionDict)
+				_, err = w.writeRecord(rec1, compressionDict)
 				if err != nil {
 					return cnt, err
 				}
-				rec1, err = db1.getNextRecord(file1, compressionDict)
+				rec1, err = db1.getNextRecord(file1, dict1)
 				if err != nil {
 					return cnt, err
 				}
-				rec2, err = db2.getNextRecord(file2, compressionDict)
+				rec2, err = db2.getNextRecord(file2, dict2)
 				if err != nil {
 					return cnt, err
 				}
 			} else {
-				err = db.writeRecord(file, rec2, compressionDict)
+				_, err = w.writeRecord(rec2, compressionDict)
 				if err != nil {
 					return cnt, err
 				}
-				rec1, err = db1.getNextRecord(file1, compressionDict)
+				rec1, err = db1.getNextRecord(file1, dict1)
 				if err != nil {
 					return cnt, err
 				}
-				rec2, err = db2.getNextRecord(file2, compressionDict)
+				rec2, err = db2.getNextRecord(file2, dict2)
 				if err != nil {
 					return cnt, err
 				}
@@ -628,11 +592,5 @@ func (db *DataBlock) WriteMerged(db1, db2 *DataBlock, compressionDict *compressi
 		cnt++
 	}
 
-	db.Size, err = file.Seek(0, 1)
-	if err != nil {
-		return cnt, err
-	}
-	db.Size -= db.StartOffset
-
-	return cnt, nil
+	return cnt, w.close()
 }
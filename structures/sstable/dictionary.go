@@ -0,0 +1,124 @@
+package sstable
+
+import (
+	"nasp-project/structures/block_cache"
+	"nasp-project/structures/compression"
+	"nasp-project/structures/encryption"
+	"nasp-project/util"
+	"os"
+)
+
+/*
+	=== DICTIONARY BLOCK ===
+
+	+------------------+---------+-...-+------------------+---------+
+	|  Key Size (VAR)  |   Key   |     |  Key Size (VAR)  |   Key   |
+	+------------------+---------+-...-+------------------+---------+
+	Key Size = Length of the Key data
+	Key = Key data
+
+	The i-th key is the key that is stored as the index i in the data, index and summary blocks of the SSTable.
+	NOTE: Keys are added while the records are written, so they are sorted like the records.
+	NOTE: Only SSTables written with compression have a dictionary block. Tables written before
+	the dictionaries were kept with the table use the global compression dictionary.
+*/
+
+// DictionaryBlock represents the compression dictionary of an SSTable.
+type DictionaryBlock struct {
+	util.BinaryFile
+	Dict *compression.Dictionary // Lazy loaded dictionary
+}
+
+// HasLoaded returns true if the dictionary has been loaded into memory.
+func (b *DictionaryBlock) HasLoaded() bool {
+	return b.Dict != nil
+}
+
+// Load reads the dictionary block from the block cache or disk and loads it into memory.
+func (b *DictionaryBlock) Load() error {
+	_, err := b.load()
+	return err
+}
+
+// load does the same as Load, and also returns true if the dictionary was found in the block cache.
+func (b *DictionaryBlock) load() (bool, error) {
+	if dict, ok := getCached(dictionaryKind, b.Filename, b.StartOffset); ok {
+		b.Dict = dict.(*compression.Dictionary)
+		return true, nil
+	}
+
+	file, err := openFile(b.Filename)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	_, err = file.Seek(b.StartOffset, 0)
+	if err != nil {
+		return false, err
+	}
+	bytes := make([]byte, b.Size)
+	_, err = file.Read(bytes)
+	if err != nil && b.Size > 0 {
+		return false, err
+	}
+	b.Dict = compression.Deserialize(bytes)
+	putCached(b.Filename, b.StartOffset, b.Dict, b.Size, block_cache.High)
+	return false, nil
+}
+
+// Write writes the dictionary block to disk.
+// It also sets the size of the dictionary block.
+func (b *DictionaryBlock) Write() error {
+	file, err := encryption.OpenFile(b.Filename, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Seek(b.StartOffset, 0)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(b.Dict.Serialize())
+	if err != nil {
+		return err
+	}
+
+	b.Size, err = file.Seek(0, 1)
+	if err != nil {
+		return err
+	}
+	b.Size -= b.StartOffset
+
+	return nil
+}
+
+// dictionary returns the compression dictionary of the SSTable, loading it if needed.
+// Tables without a dictionary block return compressionDict, which is the global dictionary
+// if compression is on and nil otherwise.
+func (sst *SSTable) dictionary(compressionDict *compression.Dictionary) (*compression.Dictionary, error) {
+	if sst.Dictionary.HasLoaded() {
+		return sst.Dictionary.Dict, nil
+	}
+	if !sst.HasDictionary() {
+		return compressionDict, nil
+	}
+	err := sst.Dictionary.Load()
+	if err != nil {
+		return nil, err
+	}
+	return sst.Dictionary.Dict, nil
+}
+
+// newDictionary returns an empty dictionary for a table that is about to be written,
+// or nil if compressionDict is nil because compression is off.
+func newDictionary(compressionDict *compression.Dictionary) *compression.Dictionary {
+	if compressionDict == nil {
+		return nil
+	}
+	return compression.NewDictionary()
+}
+
+// HasDictionary returns true if the SSTable keeps its own compression dictionary.
+func (sst *SSTable) HasDictionary() bool {
+	return sst.Dictionary.Filename != ""
+}
@@ -0,0 +1,157 @@
+package sstable
+
+import (
+	"fmt"
+	"nasp-project/model"
+	"nasp-project/structures/compression"
+	"nasp-project/util"
+	"os"
+	"testing"
+)
+
+func TestSSTable_Dictionary(t *testing.T) {
+	for _, singleFile := range []bool{false, true} {
+		t.Run(fmt.Sprintf("singleFile=%t", singleFile), func(t *testing.T) {
+			testSSTableDictionary(t, singleFile)
+		})
+	}
+}
+
+func testSSTableDictionary(t *testing.T, singleFile bool) {
+	tmpDir, err := os.MkdirTemp("", "sstable_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &util.SSTableConfig{
+		SavePath:            tmpDir,
+		SingleFile:          singleFile,
+		IndexDegree:         2,
+		SummaryDegree:       3,
+		FilterPrecision:     0.01,
+		MerkleTreeChunkSize: 16,
+		Compression:         true,
+	}
+	global := compression.NewDictionary() // turns the compression on, the tables don't add their keys to it
+	records := func(from, to, timestamp int, deleted bool) []model.Record {
+		var recs []model.Record
+		for i := from; i < to; i++ {
+			recs = append(recs, model.Record{
+				Key:       []byte(fmt.Sprintf("tenant/%03d/orders", i)),
+				Value:     []byte(fmt.Sprintf("value%d", i)),
+				Timestamp: uint64(timestamp),
+				Tombstone: deleted,
+			})
+		}
+		return recs
+	}
+	dictSize := func(table *SSTable) int {
+		t.Helper()
+		dict, err := table.dictionary(nil)
+		if err != nil || dict == nil {
+			t.Fatalf("Expected the table to have a dictionary, got %v", err)
+		}
+		return len(dict.Serialize())
+	}
+
+	table1, err := CreateSSTable(records(0, 50, 1, false), global, config)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable: %v", err)
+	}
+	table2, err := CreateSSTable(records(0, 20, 2, true), global, config)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable: %v", err)
+	}
+	if global.GetKey(0) != nil {
+		t.Errorf("Expected the global dictionary to stay empty")
+	}
+	table1, err = OpenSSTableFromToc(table1.TOCFilename)
+	if err != nil {
+		t.Fatalf("Failed to open SSTable: %v", err)
+	}
+	if !table1.HasDictionary() || table1.Dictionary.HasLoaded() {
+		t.Fatalf("Expected the dictionary to be in the TOC and not loaded")
+	}
+	if size := dictSize(table1); size != int(table1.Dictionary.Size) {
+		t.Errorf("Expected a dictionary of %d bytes, got %d", table1.Dictionary.Size, size)
+	}
+	small := dictSize(table2)
+	dict1 := table1.Dictionary.Dict.Serialize()
+
+	for i := 0; i < 50; i++ {
+		rec, err := table1.Read([]byte(fmt.Sprintf("tenant/%03d/orders", i)), global)
+		if err != nil || rec == nil || string(rec.Value) != fmt.Sprintf("value%d", i) {
+			t.Fatalf("Expected value%d, got %v, %v", i, rec, err)
+		}
+	}
+	recs, err := table1.RangeScan([]byte("tenant/010"), []byte("tenant/020"), -1, global)
+	if err != nil || len(recs) != 10 {
+		t.Errorf("Expected 10 records from the range scan, got %d, %v", len(recs), err)
+	}
+
+	// the merged table only holds the keys of its records, which are gone after the deleted records are dropped
+	run, err := MergeTableWithRun(global, config, &util.LSMTreeConfig{
+		MaxLevel: 3,
+		Leveled:  util.LeveledConfig{DataBlockSize: 1 << 20},
+	}, 3, table2, table1)
+	if err != nil {
+		t.Fatalf("Failed to merge into a run: %v", err)
+	}
+	if len(run) != 1 {
+		t.Fatalf("Expected a single table, got %d", len(run))
+	}
+	if size := dictSize(run[0]); size != len(dict1)-small {
+		t.Errorf("Expected the dictionary to lose the %d bytes of the deleted keys, got %d of %d bytes", small, size, len(dict1))
+	}
+	for i := 0; i < 50; i++ {
+		rec, err := run[0].Read([]byte(fmt.Sprintf("tenant/%03d/orders", i)), global)
+		if err != nil || (i < 20) != (rec == nil) {
+			t.Fatalf("Expected key %d to be deleted: %t, got %v, %v", i, i < 20, rec, err)
+		}
+	}
+
+	table3, err := CreateSSTable(records(0, 20, 3, false), global, config)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable: %v", err)
+	}
+	merged, err := MergeSSTables(run[0], table3, 4, config, global)
+	if err != nil {
+		t.Fatalf("Failed to merge SSTables: %v", err)
+	}
+	if size := dictSize(merged); size != len(dict1) {
+		t.Errorf("Expected the dictionary to hold the keys of both tables, got %d bytes", size)
+	}
+	cnt := 0
+	for it, err := merged.NewIterator(global); err == nil && it.Value() != nil; it.Next() {
+		cnt++
+	}
+	if cnt != 50 {
+		t.Errorf("Expected to iterate over 50 records, got %d", cnt)
+	}
+
+	// tables without a dictionary block keep using the global dictionary
+	legacy, err := OpenSSTableFromToc(merged.TOCFilename)
+	if err != nil {
+		t.Fatalf("Failed to open SSTable: %v", err)
+	}
+	dict, err := legacy.dictionary(nil)
+	if err != nil {
+		t.Fatalf("Failed to load the dictionary: %v", err)
+	}
+	legacy.Dictionary = DictionaryBlock{}
+	if err := legacy.writeTOCFile(); err != nil {
+		t.Fatalf("Failed to write the TOC: %v", err)
+	}
+	legacy, err = OpenSSTableFromToc(merged.TOCFilename)
+	if err != nil {
+		t.Fatalf("Failed to open SSTable: %v", err)
+	}
+	if legacy.HasDictionary() {
+		t.Fatalf("Expected the table to have no dictionary")
+	}
+	rec, err := legacy.Read([]byte("tenant/042/orders"), dict)
+	if err != nil || rec == nil || string(rec.Value) != "value42" {
+		t.Errorf("Expected value42, got %v, %v", rec, err)
+	}
+}
@@ -0,0 +1,7 @@
+package sstable
+
+// SetCrashPoint replaces the function that is called after every step of writing, publishing and deleting tables,
+// so the crash tests of the compactions can see the files after every step.
+func SetCrashPoint(f func(step string)) {
+	crashPoint = f
+}
@@ -0,0 +1,200 @@
+package sstable
+
+import (
+	"container/list"
+	"io"
+	"nasp-project/structures/encryption"
+	"os"
+	"sync"
+)
+
+// openFiles keeps the files of SSTables open for reading between reads, so that a read doesn't have to open,
+// and with encryption authenticate, every file again. At most max files are open at a time, in use or idle.
+var openFiles struct {
+	sync.Mutex
+	max    int // 0 turns keeping the files open off
+	open   int
+	idle   map[string][]*sharedFile // by filename, the most recently released last
+	lru    *list.List               // of the idle files, the most recently released at the front
+	inUse  map[*sharedFile]struct{}
+	reused uint64
+	opened uint64
+}
+
+// OpenFilesStats describes the files of SSTables that are kept open for reading.
+type OpenFilesStats struct {
+	Max    int    // maximum number of open files, 0 if the files are not kept open
+	Open   int    // number of open files, in use or idle
+	Idle   int    // number of open files that are not in use
+	Reused uint64 // number of reads that used a file that was already open
+	Opened uint64 // number of files that were opened
+}
+
+// sharedFile is a file opened for reading that goes back to openFiles when it is closed.
+type sharedFile struct {
+	encryption.File
+	filename string
+	info     os.FileInfo   // of the file when it was opened
+	element  *list.Element // in openFiles.lru while the file is idle
+	dropped  bool          // the file was deleted, renamed or rewritten while it was in use
+}
+
+// SetMaxOpenFiles sets the maximum number of SSTable files that are kept open for reading by all stores of the
+// process, 0 turns keeping the files open off. Idle files over the maximum are closed.
+func SetMaxOpenFiles(max int) {
+	openFiles.Lock()
+	defer openFiles.Unlock()
+
+	openFiles.max = max
+	if openFiles.idle == nil {
+		openFiles.idle = make(map[string][]*sharedFile)
+		openFiles.lru = list.New()
+		openFiles.inUse = make(map[*sharedFile]struct{})
+	}
+	closeIdleFiles()
+}
+
+// GetMaxOpenFiles returns the maximum number of SSTable files that are kept open, 0 if they are not kept open.
+func GetMaxOpenFiles() int {
+	openFiles.Lock()
+	defer openFiles.Unlock()
+
+	return openFiles.max
+}
+
+// GetOpenFilesStats returns the statistics of the SSTable files that are kept open.
+func GetOpenFilesStats() OpenFilesStats {
+	openFiles.Lock()
+	defer openFiles.Unlock()
+
+	stats := OpenFilesStats{
+		Max:    openFiles.max,
+		Open:   openFiles.open,
+		Reused: openFiles.reused,
+		Opened: openFiles.opened,
+	}
+	if openFiles.lru != nil {
+		stats.Idle = openFiles.lru.Len()
+	}
+	return stats
+}
+
+// openFile opens the file for reading, reusing an idle open file if it is still the same file on disk.
+// Closing the returned file keeps it open for the next read.
+func openFile(filename string) (encryption.File, error) {
+	if GetMaxOpenFiles() == 0 {
+		return encryption.Open(filename)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	openFiles.Lock()
+	for idle := openFiles.idle[filename]; len(idle) > 0; idle = openFiles.idle[filename] {
+		f := idle[len(idle)-1]
+		removeIdleFile(f)
+		if !sameFile(f.info, info) {
+			f.File.Close()
+			openFiles.open--
+			continue
+		}
+		openFiles.inUse[f] = struct{}{}
+		openFiles.reused++
+		openFiles.Unlock()
+
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return f, nil
+	}
+	openFiles.open++
+	openFiles.opened++
+	openFiles.Unlock()
+
+	file, err := encryption.Open(filename)
+	if err != nil {
+		openFiles.Lock()
+		openFiles.open--
+		openFiles.Unlock()
+		return nil, err
+	}
+	f := &sharedFile{File: file, filename: filename, info: info}
+	openFiles.Lock()
+	openFiles.inUse[f] = struct{}{}
+	openFiles.Unlock()
+	return f, nil
+}
+
+// sameFile returns true if the file wasn't replaced or modified between the two stats.
+func sameFile(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
+
+// Close keeps the file open for the next read, unless there are too many open files.
+func (f *sharedFile) Close() error {
+	openFiles.Lock()
+	defer openFiles.Unlock()
+
+	delete(openFiles.inUse, f)
+	if f.dropped || openFiles.max == 0 {
+		openFiles.open--
+		return f.File.Close()
+	}
+	openFiles.idle[f.filename] = append(openFiles.idle[f.filename], f)
+	f.element = openFiles.lru.PushFront(f)
+	closeIdleFiles()
+	return nil
+}
+
+// closeIdleFiles closes the least recently used idle files while there are too many open files.
+func closeIdleFiles() {
+	for openFiles.open > openFiles.max && openFiles.lru.Len() > 0 {
+		f := openFiles.lru.Back().Value.(*sharedFile)
+		removeIdleFile(f)
+		f.File.Close()
+		openFiles.open--
+	}
+}
+
+func removeIdleFile(f *sharedFile) {
+	openFiles.lru.Remove(f.element)
+	f.element = nil
+	idle := openFiles.idle[f.filename]
+	for i := range idle {
+		if idle[i] == f {
+			idle = append(idle[:i], idle[i+1:]...)
+			break
+		}
+	}
+	if len(idle) == 0 {
+		delete(openFiles.idle, f.filename)
+	} else {
+		openFiles.idle[f.filename] = idle
+	}
+}
+
+// dropOpenFiles closes the open files with the given names, files in use are closed when they are released.
+func dropOpenFiles(filenames ...string) {
+	openFiles.Lock()
+	defer openFiles.Unlock()
+
+	if openFiles.idle == nil {
+		return
+	}
+	for _, filename := range filenames {
+		for _, f := range append([]*sharedFile(nil), openFiles.idle[filename]...) {
+			removeIdleFile(f)
+			f.File.Close()
+			openFiles.open--
+		}
+	}
+	for f := range openFiles.inUse {
+		for _, filename := range filenames {
+			if f.filename == filename {
+				f.dropped = true
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package sstable
+
+import (
+	"fmt"
+	"nasp-project/model"
+	"nasp-project/util"
+	"os"
+	"testing"
+)
+
+func TestOpenFiles(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sstable_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	SetMaxOpenFiles(4)
+	defer SetMaxOpenFiles(0)
+
+	config := &util.SSTableConfig{
+		SavePath:            tmpDir,
+		SingleFile:          false,
+		IndexDegree:         4,
+		SummaryDegree:       3,
+		FilterPrecision:     0.01,
+		MerkleTreeChunkSize: 16,
+	}
+	records := func(from, to, timestamp int) []model.Record {
+		var recs []model.Record
+		for i := from; i < to; i++ {
+			recs = append(recs, model.Record{
+				Key:       []byte(fmt.Sprintf("key%04d", i)),
+				Value:     []byte(fmt.Sprintf("value%d-%d", i, timestamp)),
+				Timestamp: uint64(timestamp),
+			})
+		}
+		return recs
+	}
+	check := func(table *SSTable, from, to, timestamp int) {
+		t.Helper()
+		for i := from; i < to; i++ {
+			rec, err := table.Read([]byte(fmt.Sprintf("key%04d", i)), nil)
+			if err != nil || rec == nil || string(rec.Value) != fmt.Sprintf("value%d-%d", i, timestamp) {
+				t.Fatalf("Expected value%d-%d, got %v, %v", i, timestamp, rec, err)
+			}
+		}
+	}
+
+	// the files are opened once and reused by the following reads
+	table, err := CreateSSTable(records(0, 100, 1), nil, config)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable: %v", err)
+	}
+	before := GetOpenFilesStats()
+	check(table, 0, 100, 1)
+	stats := GetOpenFilesStats()
+	if stats.Opened-before.Opened != 4 || stats.Idle != 4 {
+		t.Errorf("Expected the 4 files of the table to be opened once, got %+v", stats)
+	}
+
+	// the least recently used files are closed to stay within the maximum
+	SetMaxOpenFiles(2)
+	check(table, 0, 100, 1)
+	if stats := GetOpenFilesStats(); stats.Open > 2 {
+		t.Errorf("Expected at most 2 open files, got %+v", stats)
+	}
+	SetMaxOpenFiles(4)
+
+	// the files of a table that is deleted and created again with the same label are opened again
+	newer, err := CreateSSTable(records(50, 150, 2), nil, config)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable: %v", err)
+	}
+	check(newer, 50, 150, 2)
+	if _, err := MergeSSTables(table, newer, 2, config, nil); err != nil {
+		t.Fatalf("Failed to merge SSTables: %v", err)
+	}
+	reused, err := CreateSSTable(records(0, 30, 3), nil, config)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable: %v", err)
+	}
+	if reused.TOCFilename != table.TOCFilename {
+		t.Fatalf("Expected the new table to reuse the label of %s, got %s", table.TOCFilename, reused.TOCFilename)
+	}
+	check(reused, 0, 30, 3)
+	if rec, err := reused.Read([]byte("key0050"), nil); err != nil || rec != nil {
+		t.Errorf("Expected key0050 not to be found, got %v, %v", rec, err)
+	}
+
+	// idle files are closed when they are turned off
+	SetMaxOpenFiles(0)
+	if stats := GetOpenFilesStats(); stats.Open != 0 {
+		t.Errorf("Expected no open files, got %+v", stats)
+	}
+	check(reused, 0, 30, 3)
+}
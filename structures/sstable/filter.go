@@ -1,8 +1,10 @@
 package sstable
 
 import (
+	"nasp-project/structures/block_cache"
 	"nasp-project/structures/bloom_filter"
 	"nasp-project/structures/compression"
+	"nasp-project/structures/encryption"
 	"nasp-project/util"
 	"os"
 )
@@ -18,24 +20,36 @@ func (fb *FilterBlock) HasLoaded() bool {
 	return fb.Filter != nil
 }
 
-// Load reads the filter block from disk and loads it into memory.
+// Load reads the filter block from the block cache or disk and loads it into memory.
 func (fb *FilterBlock) Load() error {
-	file, err := os.Open(fb.Filename)
+	_, err := fb.load()
+	return err
+}
+
+// load does the same as Load, and also returns true if the filter was found in the block cache.
+func (fb *FilterBlock) load() (bool, error) {
+	if filter, ok := getCached(filterKind, fb.Filename, fb.StartOffset); ok {
+		fb.Filter = filter.(*bloom_filter.BloomFilter)
+		return true, nil
+	}
+
+	file, err := openFile(fb.Filename)
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer file.Close()
 	_, err = file.Seek(fb.StartOffset, 0)
 	if err != nil {
-		return err
+		return false, err
 	}
 	bytes := make([]byte, fb.Size)
 	_, err = file.Read(bytes)
 	if err != nil {
-		return err
+		return false, err
 	}
 	fb.Filter = bloom_filter.Deserialize(bytes)
-	return nil
+	putCached(fb.Filename, fb.StartOffset, fb.Filter, fb.Size, block_cache.High)
+	return false, nil
 }
 
 // CreateFilter creates a filter from the given keys.
@@ -50,7 +64,7 @@ func (fb *FilterBlock) CreateFilter(keys [][]byte, p float64) {
 func (fb *FilterBlock) CreateFromDataBlock(n uint, p float64, db *DataBlock, compressionDict *compression.Dictionary) error {
 	fb.Filter = bloom_filter.NewBloomFilter(n, p)
 
-	file, err := os.Open(db.Filename)
+	file, err := db.open()
 	if err != nil {
 		return err
 	}
@@ -60,13 +74,15 @@ func (fb *FilterBlock) CreateFromDataBlock(n uint, p float64, db *DataBlock, com
 	if err != nil {
 		return err
 	}
+	end := db.end(file)
+	prefix := db.prefixKeys(compressionDict)
 
 	for {
 		offset, err := file.Seek(4, 1) // skip CRC
 		if err != nil {
 			return err
 		}
-		if offset >= db.StartOffset+db.Size {
+		if offset >= end {
 			break
 		}
 
@@ -81,9 +97,9 @@ func (fb *FilterBlock) CreateFromDataBlock(n uint, p float64, db *DataBlock, com
 			return err
 		}
 
-		var keySize uint64 // only if compression is turned off
+		var shared, keySize uint64 // only if compression is turned off
 		if compressionDict == nil {
-			keySize, err = util.ReadUvarint(file)
+			shared, keySize, _, err = readKeySizes(file, prefix)
 			if err != nil {
 				return err
 			}
@@ -98,7 +114,12 @@ func (fb *FilterBlock) CreateFromDataBlock(n uint, p float64, db *DataBlock, com
 		}
 
 		var key []byte
-		if compressionDict == nil {
+		if prefix {
+			key, err = readPrefixKey(file, shared, keySize)
+			if err != nil {
+				return err
+			}
+		} else if compressionDict == nil {
 			// compression is off, read the key as-is
 			key = make([]byte, keySize)
 			_, err = file.Read(key)
@@ -128,7 +149,7 @@ func (fb *FilterBlock) CreateFromDataBlock(n uint, p float64, db *DataBlock, com
 // Write writes the filter block to disk.
 // It also sets the size of the filter block.
 func (fb *FilterBlock) Write() error {
-	file, err := os.OpenFile(fb.Filename, os.O_WRONLY, 0644)
+	file, err := encryption.OpenFile(fb.Filename, os.O_WRONLY, 0644)
 	if err != nil {
 		return err
 	}
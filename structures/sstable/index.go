@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"nasp-project/structures/compression"
+	"nasp-project/structures/encryption"
 	"nasp-project/util"
 	"os"
 )
@@ -19,6 +20,8 @@ import (
 	Offset = Number of bytes from the start of data block to the start of the record
 
 	NOTE: Index records are sorted by Key
+	NOTE: Tables with prefix encoded keys store the keys as described in prefix.go. The index record of the
+	last data record then points at the last restart point if the last data record is not one.
 */
 
 type IndexRecord struct {
@@ -27,15 +30,56 @@ type IndexRecord struct {
 }
 
 type IndexBlock struct {
-	util.BinaryFile // Only file block because nothing is ever loaded into memory
+	util.BinaryFile      // Only file block because nothing is ever loaded into memory
+	PrefixKeys      bool // whether the keys are prefix encoded
+	restartInterval int  // number of records between the restart points of prefix encoded keys, used when writing
 }
 
-func (ir *IndexRecord) sizeOnDisk(compressionDict *compression.Dictionary) int {
-	buf := make([]byte, binary.MaxVarintLen64)
-	if compressionDict != nil {
-		return binary.PutUvarint(buf, uint64(compressionDict.GetIdx(ir.Key))) + binary.PutUvarint(buf, uint64(ir.Offset))
+// prefixKeys returns true if the keys are prefix encoded, which they are not if the table uses a compression dictionary.
+func (ib *IndexBlock) prefixKeys(compressionDict *compression.Dictionary) bool {
+	return ib.PrefixKeys && compressionDict == nil
+}
+
+// open opens the index block file for reading records.
+func (ib *IndexBlock) open() (encryption.File, error) {
+	file, err := openPaged(ib.Filename, indexKind, ib.StartOffset, ib.Size)
+	if err != nil || !ib.PrefixKeys {
+		return file, err
+	}
+	return &prefixFile{File: file}, nil
+}
+
+// sizeOnDisk returns the number of bytes that IndexRecord would occupy on disk.
+// If prefix is true, the key is prefix encoded against prevKey, which is nil at restart points.
+func (ir *IndexRecord) sizeOnDisk(compressionDict *compression.Dictionary, prefix bool, prevKey []byte) int {
+	return len(ir.encode(compressionDict, prefix, prevKey))
+}
+
+// encode returns the record in its format on disk.
+// If prefix is true, the key is prefix encoded against prevKey, which is nil at restart points.
+func (ir *IndexRecord) encode(compressionDict *compression.Dictionary, prefix bool, prevKey []byte) []byte {
+	var bytes []byte
+	if compressionDict == nil { // compression off
+		shared := 0
+		if prefix {
+			shared = sharedPrefixLen(prevKey, ir.Key)
+			bytes = binary.AppendUvarint(bytes, uint64(shared))
+		}
+		bytes = binary.AppendUvarint(bytes, uint64(len(ir.Key)-shared))
+		bytes = append(bytes, ir.Key[shared:]...)
+	} else { // compression on
+		bytes = binary.AppendUvarint(bytes, uint64(compressionDict.GetIdx(ir.Key)))
 	}
-	return binary.PutUvarint(buf, uint64(len(ir.Key))) + len(ir.Key) + binary.PutUvarint(buf, uint64(ir.Offset))
+	return binary.AppendUvarint(bytes, uint64(ir.Offset))
+}
+
+// restartKey returns the key that the cnt-th index record is prefix encoded against,
+// which is nil if the record is a restart point.
+func (ib *IndexBlock) restartKey(cnt int, prevKey []byte) []byte {
+	if cnt%max(ib.restartInterval, 1) == 0 {
+		return nil
+	}
+	return prevKey
 }
 
 // CreateFromDataBlock creates an index block from the given data block and writes it to disk.
@@ -43,7 +87,7 @@ func (ir *IndexRecord) sizeOnDisk(compressionDict *compression.Dictionary) int {
 // sparseDeg is the number of records to skip before adding the next record.
 // First and last records are always added.
 func (ib *IndexBlock) CreateFromDataBlock(sparseDeg int, db *DataBlock, compressionDict *compression.Dictionary) error {
-	file, err := os.OpenFile(ib.Filename, os.O_WRONLY, 0644)
+	file, err := encryption.OpenFile(ib.Filename, os.O_WRONLY, 0644)
 	if err != nil {
 		return err
 	}
@@ -54,7 +98,7 @@ func (ib *IndexBlock) CreateFromDataBlock(sparseDeg int, db *DataBlock, compress
 		return err
 	}
 
-	dbFile, err := os.Open(db.Filename)
+	dbFile, err := db.open()
 	if err != nil {
 		return err
 	}
@@ -64,14 +108,24 @@ func (ib *IndexBlock) CreateFromDataBlock(sparseDeg int, db *DataBlock, compress
 	if err != nil {
 		return err
 	}
+	end := db.end(dbFile)
+	starts := db.restarts
+	if starts == nil {
+		starts = blockStarts(dbFile)
+	}
+	prefix := db.prefixKeys(compressionDict)
 
+	var lastIndexed int64 // offset of the last indexed record
+	lastIndexedCnt := 0   // number of the last indexed record
+	var prevKey []byte    // key of the last index record
+	idxCnt := 0           // number of index records
 	for cnt := 0; ; cnt++ {
 		var recSize int64 = 4
 		offset, err := dbFile.Seek(4, 1) // skip CRC
 		if err != nil {
 			return err
 		}
-		if offset >= db.StartOffset+db.Size {
+		if offset >= end {
 			break
 		}
 
@@ -91,9 +145,9 @@ func (ib *IndexBlock) CreateFromDataBlock(sparseDeg int, db *DataBlock, compress
 		}
 		recSize += 1
 
-		var keySize uint64 // only if compression is turned off
+		var shared, keySize uint64 // only if compression is turned off
 		if compressionDict == nil {
-			keySize, n, err = util.ReadUvarintLen(dbFile)
+			shared, keySize, n, err = readKeySizes(dbFile, prefix)
 			if err != nil {
 				return err
 			}
@@ -110,7 +164,13 @@ func (ib *IndexBlock) CreateFromDataBlock(sparseDeg int, db *DataBlock, compress
 		}
 
 		var key []byte
-		if compressionDict == nil {
+		if prefix {
+			key, err = readPrefixKey(dbFile, shared, keySize)
+			if err != nil {
+				return err
+			}
+			recSize += int64(keySize)
+		} else if compressionDict == nil {
 			// compression is off, read the key as-is
 			key = make([]byte, keySize)
 			_, err = dbFile.Read(key)
@@ -135,14 +195,30 @@ func (ib *IndexBlock) CreateFromDataBlock(sparseDeg int, db *DataBlock, compress
 		recSize += int64(valueSize)
 
 		offset -= db.StartOffset
-		last := offset >= db.Size // whether this is the last record
+		last := offset >= end-db.StartOffset // whether this is the last record
 
-		if cnt%sparseDeg == 0 || last { // add every sparseDeg-th record and the last record
+		var indexed bool
+		if prefix && starts == nil {
+			// the restart points of a data block that was not written now are the records with whole keys
+			indexed = shared == 0 && (idxCnt == 0 || cnt-lastIndexedCnt >= sparseDeg)
+		} else {
+			indexed = isIndexed(cnt, offset-recSize, sparseDeg, &starts)
+		}
+		if indexed {
+			lastIndexed = offset - recSize
+			lastIndexedCnt = cnt
+		}
+		if indexed || last {
 			offset -= recSize
-			err = ib.writeRecord(file, IndexRecord{key, offset}, compressionDict)
+			if !indexed && prefix {
+				offset = lastIndexed // the last record can only be read starting from the last restart point
+			}
+			err = ib.writeRecord(file, IndexRecord{key, offset}, ib.restartKey(idxCnt, prevKey), compressionDict)
 			if err != nil {
 				return err
 			}
+			prevKey = key
+			idxCnt++
 		}
 	}
 
@@ -154,12 +230,12 @@ func (ib *IndexBlock) CreateFromDataBlock(sparseDeg int, db *DataBlock, compress
 	return nil
 }
 
-// CreateFromDataRecords creates an index block for the data block that would be created from the given records and writes it to disk.
+// CreateFromDataRecords creates an index block for the data block db that was written from the given records and writes it to disk.
 // It also sets the size of the index block.
 // sparseDeg is the number of records to skip before adding the next record.
 // First and last records are always added.
-func (ib *IndexBlock) CreateFromDataRecords(sparseDeg int, recs []DataRecord, compressionDict *compression.Dictionary) ([]IndexRecord, error) {
-	file, err := os.OpenFile(ib.Filename, os.O_WRONLY, 0644)
+func (ib *IndexBlock) CreateFromDataRecords(sparseDeg int, db *DataBlock, recs []DataRecord, compressionDict *compression.Dictionary) ([]IndexRecord, error) {
+	file, err := encryption.OpenFile(ib.Filename, os.O_WRONLY, 0644)
 	if err != nil {
 		return nil, err
 	}
@@ -173,16 +249,33 @@ func (ib *IndexBlock) CreateFromDataRecords(sparseDeg int, recs []DataRecord, co
 	}
 
 	var offset int64 = 0
+	var lastIndexed int64 // offset of the last indexed record
+	var prevKey []byte    // key of the last data record
+	starts := db.restarts
+	prefix := db.prefixKeys(compressionDict)
 	for cnt, rec := range recs {
-		if cnt%sparseDeg == 0 || cnt == len(recs)-1 { // add every sparseDeg-th record and the last record
+		indexed := isIndexed(cnt, offset, sparseDeg, &starts)
+		if indexed {
+			lastIndexed = offset
+			prevKey = nil // indexed records are the restart points
+		}
+		if indexed || cnt == len(recs)-1 {
 			ir := IndexRecord{rec.Key, offset}
-			err = ib.writeRecord(file, ir, compressionDict)
+			if !indexed && prefix {
+				ir.Offset = lastIndexed // the last record can only be read starting from the last restart point
+			}
+			var prevIdxKey []byte
+			if len(idxRecs) > 0 {
+				prevIdxKey = idxRecs[len(idxRecs)-1].Key
+			}
+			err = ib.writeRecord(file, ir, ib.restartKey(len(idxRecs), prevIdxKey), compressionDict)
 			if err != nil {
 				return idxRecs, err
 			}
 			idxRecs = append(idxRecs, ir)
 		}
-		offset += int64(rec.sizeOnDisk(compressionDict))
+		offset += int64(rec.sizeOnDisk(compressionDict, prefix, prevKey))
+		prevKey = rec.Key
 	}
 
 	ib.Size, err = file.Seek(0, 1)
@@ -193,36 +286,32 @@ func (ib *IndexBlock) CreateFromDataRecords(sparseDeg int, recs []DataRecord, co
 	return idxRecs, nil
 }
 
-// writeRecord write a single IndexRecord to the given file.
-func (ib *IndexBlock) writeRecord(file *os.File, ir IndexRecord, compressionDict *compression.Dictionary) error {
-	if compressionDict == nil { // compression off
-		// key-size
-		err := util.WriteUvarint(file, uint64(len(ir.Key)))
-		if err != nil {
-			return err
-		}
-		// key
-		_, err = file.Write(ir.Key)
-		if err != nil {
-			return err
-		}
-	} else { // compression on
-		err := util.WriteUvarint(file, uint64(compressionDict.GetIdx(ir.Key)))
-		if err != nil {
-			return err
-		}
+// isIndexed returns true if the record with the given number and offset in the data block gets an index record.
+// That is every sparseDeg-th record, or the first record of every compressed block or the restart point
+// of prefix encoded keys if restarts is not nil.
+// The records must be checked in order, as the checked offsets are removed from restarts.
+func isIndexed(cnt int, offset int64, sparseDeg int, restarts *[]int64) bool {
+	if *restarts == nil {
+		return cnt%sparseDeg == 0
 	}
-
-	err := util.WriteUvarint(file, uint64(ir.Offset))
-	if err != nil {
-		return err
+	if len(*restarts) > 0 && (*restarts)[0] == offset {
+		*restarts = (*restarts)[1:]
+		return true
 	}
+	return false
+}
 
-	return nil
+// writeRecord write a single IndexRecord to the given file.
+// prevKey is the key of the previous index record, or nil if the record is a restart point.
+func (ib *IndexBlock) writeRecord(file encryption.File, ir IndexRecord, prevKey []byte, compressionDict *compression.Dictionary) error {
+	_, err := file.Write(ir.encode(compressionDict, ib.prefixKeys(compressionDict), prevKey))
+	return err
 }
 
 // getRecordAtOffset returns the IndexRecord at the given offset in the index block file.
-func (ib *IndexBlock) getRecordAtOffset(file *os.File, offset int64, compressionDict *compression.Dictionary) (*IndexRecord, error) {
+// If the keys are prefix encoded, the file must be opened with open, and the record must be a restart
+// point or follow the record that was read last.
+func (ib *IndexBlock) getRecordAtOffset(file encryption.File, offset int64, compressionDict *compression.Dictionary) (*IndexRecord, error) {
 	_, err := file.Seek(ib.StartOffset+offset, 0)
 	if err != nil {
 		return nil, err
@@ -231,13 +320,17 @@ func (ib *IndexBlock) getRecordAtOffset(file *os.File, offset int64, compression
 	var key []byte
 	if compressionDict == nil { // compression off
 		// key-size
-		keySize, err := util.ReadUvarint(file)
+		shared, keySize, _, err := readKeySizes(file, ib.prefixKeys(compressionDict))
 		if err != nil {
 			return nil, err
 		}
 		// key
-		key = make([]byte, keySize)
-		_, err = file.Read(key)
+		if ib.prefixKeys(compressionDict) {
+			key, err = readPrefixKey(file, shared, keySize)
+		} else {
+			key = make([]byte, keySize)
+			_, err = file.Read(key)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -262,32 +355,46 @@ func (ib *IndexBlock) getRecordAtOffset(file *os.File, offset int64, compression
 // Returns the record if the key is found, or nil if the key is not found.
 // Returns an error if there is an error while reading the index block.
 func (ib *IndexBlock) GetRecordWithKeyFromOffset(key []byte, offset int64, compressionDict *compression.Dictionary) (*IndexRecord, error) {
-	file, err := os.Open(ib.Filename)
+	idxRec, _, err := ib.getRecordWithKeyFromOffsetLen(key, offset, compressionDict)
+	return idxRec, err
+}
+
+// getRecordWithKeyFromOffsetLen does the same as GetRecordWithKeyFromOffset,
+// and also returns the number of bytes read from the index block.
+func (ib *IndexBlock) getRecordWithKeyFromOffsetLen(key []byte, offset int64, compressionDict *compression.Dictionary) (*IndexRecord, int64, error) {
+	file, err := ib.open()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer file.Close()
 
+	var bytesRead int64 = 0
 	var lastFoundRecord *IndexRecord = nil
 	for {
 		idxRec, err := ib.getRecordAtOffset(file, offset, compressionDict)
 		if err != nil {
-			return nil, err
+			return nil, bytesRead, err
 		}
 		if idxRec == nil {
-			return lastFoundRecord, nil
+			return lastFoundRecord, bytesRead, nil
+		}
+		next, err := file.Seek(0, 1)
+		if err != nil {
+			return nil, bytesRead, err
 		}
+		next -= ib.StartOffset // offset of the next record
+		bytesRead += next - offset
 		cmp := bytes.Compare(idxRec.Key, key)
 		if cmp == 0 {
-			return idxRec, nil
+			return idxRec, bytesRead, nil
 		} else if cmp < 0 {
 			lastFoundRecord = idxRec
 		} else {
-			return lastFoundRecord, nil
+			return lastFoundRecord, bytesRead, nil
 		}
-		offset += int64(idxRec.sizeOnDisk(compressionDict)) // offset of the next record
+		offset = next
 		if offset >= ib.Size {
-			return lastFoundRecord, nil
+			return lastFoundRecord, bytesRead, nil
 		}
 	}
 }
@@ -2,10 +2,10 @@ package sstable
 
 import (
 	"bytes"
+	"fmt"
 	"nasp-project/model"
 	"nasp-project/structures/compression"
 	"nasp-project/util"
-	"os"
 )
 
 // Iterator through the records of an SSTable.
@@ -14,9 +14,15 @@ type Iterator struct {
 	offset          int64         // offset of the NEXT record in DataBlock
 	record          *model.Record // current record, nil if the end is reached
 	compressionDict *compression.Dictionary
+	err             error // the read error that stopped the iterator, if any
 }
 
 func (sst *SSTable) NewIterator(compressionDict *compression.Dictionary) (*Iterator, error) {
+	compressionDict, err := sst.dictionary(compressionDict)
+	if err != nil {
+		return nil, err
+	}
+
 	rec, offset, err := sst.GetFirstRecord(compressionDict)
 	if err != nil {
 		return nil, err
@@ -31,23 +37,27 @@ func (sst *SSTable) NewIterator(compressionDict *compression.Dictionary) (*Itera
 
 // Next moves the iterator to the next record and returns false if the move fails (because the end was reached or an error occurred)
 // Skips reserved keys. The key is reserved is util.IsReservedKey return true.
-// TODO: Refactor to return an error
+// The error that made the move fail is returned by Err.
 func (it *Iterator) Next() bool {
-	file, err := os.Open(it.table.Data.Filename)
+	file, err := it.table.Data.open()
 	if err != nil {
-		return false
+		return it.fail(err)
 	}
+	defer file.Close()
 
 	_, err = file.Seek(it.offset, 0)
 	if err != nil {
-		return false
+		return it.fail(err)
+	}
+	if it.record != nil {
+		setLastKey(file, it.record.Key) // the next key may be prefix encoded against the current one
 	}
 
 	var dr *DataRecord
 	for {
 		dr, err = it.table.Data.getNextRecord(file, it.compressionDict)
 		if err != nil {
-			return false
+			return it.fail(err)
 		}
 		if dr == nil || !util.IsReservedKey(dr.Key) {
 			break
@@ -56,7 +66,7 @@ func (it *Iterator) Next() bool {
 
 	it.offset, err = file.Seek(0, 1)
 	if err != nil {
-		return false
+		return it.fail(err)
 	}
 
 	if dr == nil { // reached the end
@@ -79,6 +89,17 @@ func (it *Iterator) Value() *model.Record {
 	return it.record
 }
 
+// Err returns the read error that stopped the iterator, or nil if it reached the end.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// fail records the read error that stopped the iterator and returns false.
+func (it *Iterator) fail(err error) bool {
+	it.err = fmt.Errorf("failed to read the data block of %s : %w", it.table.Data.Filename, err)
+	return false
+}
+
 // RangeIterator iterates through records in the SSTable in the range [startKey, endKey].
 type RangeIterator struct {
 	Iterator
@@ -87,6 +108,11 @@ type RangeIterator struct {
 }
 
 func (sst *SSTable) NewRangeIterator(startKey, endKey []byte, compressionDict *compression.Dictionary) (*RangeIterator, error) {
+	compressionDict, err := sst.dictionary(compressionDict)
+	if err != nil {
+		return nil, err
+	}
+
 	rec, offset, err := sst.GetNextRecordAtKey(startKey, compressionDict)
 	if err != nil {
 		return nil, err
@@ -145,6 +171,11 @@ type PrefixIterator struct {
 }
 
 func (sst *SSTable) NewPrefixIterator(prefix []byte, compressionDict *compression.Dictionary) (*PrefixIterator, error) {
+	compressionDict, err := sst.dictionary(compressionDict)
+	if err != nil {
+		return nil, err
+	}
+
 	rec, offset, err := sst.GetNextRecordAtKey(prefix, compressionDict)
 	if err != nil {
 		return nil, err
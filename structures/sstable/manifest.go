@@ -0,0 +1,280 @@
+package sstable
+
+import (
+	"errors"
+	"fmt"
+	"nasp-project/structures/manifest"
+	"nasp-project/util"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// manifests are the open manifests by the directory of the LSM tree. A manifest is opened on the first use of
+// its directory, which also deletes the tables that are not in it.
+var manifests struct {
+	sync.Mutex
+	open map[string]*manifest.Manifest
+}
+
+var (
+	levelDirRegexp = regexp.MustCompile(`^L(\d{3,})$`)
+	tableRegexp    = regexp.MustCompile(`^usertable-(\d+)-`)
+	labelRegexp    = regexp.MustCompile(`usertable-(\d+)-`)
+)
+
+// getManifest returns the manifest of the LSM tree in savePath, opening it if needed.
+func getManifest(savePath string) (*manifest.Manifest, error) {
+	savePath = filepath.Clean(savePath)
+
+	manifests.Lock()
+	defer manifests.Unlock()
+
+	if m, ok := manifests.open[savePath]; ok {
+		return m, nil
+	}
+	m, err := manifest.Open(savePath, func() ([]manifest.Table, error) {
+		return listTables(savePath)
+	}, func(t manifest.Table) {
+		deleteTable(savePath, t)
+	})
+	if err != nil {
+		return nil, err
+	}
+	version := m.Current()
+	err = collectGarbage(savePath, version)
+	version.Unref()
+	if err != nil {
+		return nil, err
+	}
+
+	if manifests.open == nil {
+		manifests.open = make(map[string]*manifest.Manifest)
+	}
+	manifests.open[savePath] = m
+	return m, nil
+}
+
+// closeManifest forgets the manifest of savePath, so that it's opened and replayed again on the next use.
+func closeManifest(savePath string) {
+	manifests.Lock()
+	defer manifests.Unlock()
+
+	delete(manifests.open, filepath.Clean(savePath))
+}
+
+// PinVersion returns the current version of the LSM tree in savePath. The tables of the version are not deleted
+// until the version is released with Unref.
+func PinVersion(savePath string) (*manifest.Version, error) {
+	m, err := getManifest(savePath)
+	if err != nil {
+		return nil, err
+	}
+	return m.Current(), nil
+}
+
+// TOCPath returns the path of the TOC file of the table in the LSM tree in savePath.
+func TOCPath(savePath string, t manifest.Table) string {
+	return filepath.Join(savePath, fmt.Sprintf("L%03d", t.Level), "TOC", t.Name)
+}
+
+// tableOf returns the directory of the LSM tree and the manifest table of the TOC file at tocPath.
+func tableOf(tocPath string) (string, manifest.Table, error) {
+	levelDir := filepath.Dir(filepath.Dir(tocPath))
+	match := levelDirRegexp.FindStringSubmatch(filepath.Base(levelDir))
+	if match == nil {
+		return "", manifest.Table{}, fmt.Errorf("%s: not in a level directory", tocPath)
+	}
+	level, err := strconv.Atoi(match[1])
+	if err != nil {
+		return "", manifest.Table{}, err
+	}
+	return filepath.Dir(levelDir), manifest.Table{Level: level, Name: filepath.Base(tocPath)}, nil
+}
+
+// applyEdit syncs the added tables to disk, and adds them to and removes the removed tables from the LSM tree in one step.
+// The files of the removed tables are deleted once no pinned version contains them.
+func applyEdit(added []*SSTable, removed []*SSTable) error {
+	for _, table := range added {
+		if err := table.persist(); err != nil {
+			return err
+		}
+	}
+
+	var savePath string
+	var edit manifest.Edit
+	for i, table := range append(append([]*SSTable(nil), added...), removed...) {
+		path, t, err := tableOf(table.TOCFilename)
+		if err != nil {
+			return err
+		}
+		if savePath != "" && path != savePath {
+			return fmt.Errorf("%s: not in the LSM tree in %s", table.TOCFilename, savePath)
+		}
+		savePath = path
+		if i < len(added) {
+			edit.Added = append(edit.Added, t)
+		} else {
+			edit.Removed = append(edit.Removed, t)
+		}
+	}
+	if savePath == "" {
+		return nil
+	}
+
+	m, err := getManifest(savePath)
+	if err != nil {
+		return err
+	}
+	if err := m.Apply(edit); err != nil {
+		return err
+	}
+	crashPoint("publish")
+	return nil
+}
+
+// listTables returns the tables of all TOC files in the LSM tree in savePath.
+func listTables(savePath string) ([]manifest.Table, error) {
+	levels, err := os.ReadDir(savePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var tables []manifest.Table
+	for _, levelDir := range levels {
+		match := levelDirRegexp.FindStringSubmatch(levelDir.Name())
+		if !levelDir.IsDir() || match == nil {
+			continue
+		}
+		level, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+		entries, err := os.ReadDir(filepath.Join(savePath, levelDir.Name(), "TOC"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, entry := range entries {
+			// tables with a temporary TOC file were never published
+			if !entry.IsDir() && tableRegexp.MatchString(entry.Name()) && !strings.HasSuffix(entry.Name(), tmpSuffix) {
+				tables = append(tables, manifest.Table{Level: level, Name: entry.Name()})
+			}
+		}
+	}
+	return tables, nil
+}
+
+// collectGarbage deletes the files of the tables in the level directories of savePath that are not in the
+// version, i.e. tables that were being written or deleted when the process stopped.
+func collectGarbage(savePath string, version *manifest.Version) error {
+	levels, err := os.ReadDir(savePath)
+	if err != nil {
+		return err
+	}
+
+	for _, levelDir := range levels {
+		match := levelDirRegexp.FindStringSubmatch(levelDir.Name())
+		if !levelDir.IsDir() || match == nil {
+			continue
+		}
+		level, err := strconv.Atoi(match[1])
+		if err != nil {
+			return err
+		}
+		live := make(map[string]bool) // labels of the tables in the version
+		for _, name := range version.Tables(level) {
+			if match := tableRegexp.FindStringSubmatch(name); match != nil {
+				live[match[1]] = true
+			}
+		}
+
+		for _, dir := range []string{filepath.Join(savePath, levelDir.Name()), filepath.Join(savePath, levelDir.Name(), "TOC")} {
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return err
+			}
+			for _, entry := range entries {
+				match := tableRegexp.FindStringSubmatch(entry.Name())
+				if entry.IsDir() || match == nil || live[match[1]] {
+					continue
+				}
+				path := filepath.Join(dir, entry.Name())
+				util.Logger().Warn("deleting a file of a table that is not in the manifest", "path", path)
+				dropCached(path)
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// deleteTable deletes the files of a table that was removed from the LSM tree in savePath.
+func deleteTable(savePath string, t manifest.Table) {
+	tocPath := TOCPath(savePath, t)
+	table, err := OpenSSTableFromToc(tocPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err == nil {
+		err = table.deleteFiles()
+	} else {
+		// a damaged TOC file doesn't name the files, they all start with the label of the table
+		err = deleteLabel(savePath, t)
+	}
+	if err != nil {
+		// the files are deleted as garbage when the manifest is opened again
+		util.Logger().Warn("failed to delete the files of a removed table", "path", tocPath, util.LogKeyError, err)
+	}
+}
+
+// deleteLabel deletes the files in the level directory of the table that have its label, the TOC file first.
+func deleteLabel(savePath string, t manifest.Table) error {
+	paths, err := labelFiles(savePath, t)
+	if err != nil {
+		return err
+	}
+	dropFilterStats(TOCPath(savePath, t))
+	for _, path := range paths {
+		dropCached(path)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// labelFiles returns the paths of the files in the level directory of the table that have its label, the TOC file first.
+func labelFiles(savePath string, t manifest.Table) ([]string, error) {
+	label := tableRegexp.FindString(t.Name)
+	if label == "" {
+		return nil, fmt.Errorf("%s: not a table", t.Name)
+	}
+	levelDir := filepath.Dir(filepath.Dir(TOCPath(savePath, t)))
+	var paths []string
+	for _, dir := range []string{filepath.Join(levelDir, "TOC"), levelDir} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.HasPrefix(entry.Name(), label) {
+				paths = append(paths, filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,138 @@
+package sstable
+
+import (
+	"nasp-project/model"
+	"nasp-project/structures/manifest"
+	"nasp-project/util"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sstable_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	defer closeManifest(tmpDir)
+
+	config := &util.SSTableConfig{
+		SavePath:            tmpDir,
+		IndexDegree:         2,
+		SummaryDegree:       3,
+		FilterPrecision:     0.01,
+		MerkleTreeChunkSize: 16,
+	}
+	create := func(key string, timestamp uint64) *SSTable {
+		t.Helper()
+		table, err := CreateSSTable([]model.Record{{Key: []byte(key), Value: []byte(key), Timestamp: timestamp}}, nil, config)
+		if err != nil {
+			t.Fatalf("Failed to create SSTable: %v", err)
+		}
+		return table
+	}
+	tables := func(level int, expected ...string) {
+		t.Helper()
+		version, err := PinVersion(tmpDir)
+		if err != nil {
+			t.Fatalf("Failed to pin the version: %v", err)
+		}
+		defer version.Unref()
+		if names := version.Tables(level); !reflect.DeepEqual(names, expected) {
+			t.Errorf("Expected %v on level %d, got %v", expected, level, names)
+		}
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	table1 := create("key1", 1)
+	table2 := create("key2", 2)
+	tables(1, "usertable-00001-TOC.txt", "usertable-00002-TOC.txt")
+
+	// the merged tables are deleted once the version that contains them is released
+	pinned, err := PinVersion(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to pin the version: %v", err)
+	}
+	merged, err := MergeSSTables(table1, table2, 2, config, nil)
+	if err != nil {
+		t.Fatalf("Failed to merge SSTables: %v", err)
+	}
+	tables(1)
+	tables(2, "usertable-00001-TOC.txt")
+	if !exists(table1.TOCFilename) || !exists(table2.Data.Filename) {
+		t.Errorf("Expected the merged tables to be kept while they are pinned")
+	}
+	if rec, err := table1.Read([]byte("key1"), nil); err != nil || rec == nil {
+		t.Errorf("Expected the pinned table to be readable, got %v, %v", rec, err)
+	}
+	pinned.Unref()
+	if exists(table1.TOCFilename) || exists(table2.Data.Filename) {
+		t.Errorf("Expected the merged tables to be deleted")
+	}
+
+	// renaming replaces the table in the manifest
+	if err := merged.Rename(5); err != nil {
+		t.Fatalf("Failed to rename SSTable: %v", err)
+	}
+	tables(2, "usertable-00005-TOC.txt")
+	if exists(filepath.Join(tmpDir, "L002", "usertable-00001-Data.db")) {
+		t.Errorf("Expected the old names to be deleted")
+	}
+	if rec, err := merged.Read([]byte("key2"), nil); err != nil || rec == nil {
+		t.Errorf("Expected to read the renamed table, got %v, %v", rec, err)
+	}
+
+	// tables that are not in the manifest are deleted as garbage when it's opened again
+	garbage := create("key3", 3)
+	if err := os.WriteFile(filepath.Join(tmpDir, "L001", "usertable-00009-Data.db"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to write a file: %v", err)
+	}
+	m, err := getManifest(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to open the manifest: %v", err)
+	}
+	if err := m.Apply(manifest.Edit{Removed: []manifest.Table{{Level: 1, Name: filepath.Base(garbage.TOCFilename)}}}); err != nil {
+		t.Fatalf("Failed to apply the edit: %v", err)
+	}
+	if exists(garbage.TOCFilename) {
+		t.Errorf("Expected the removed table to be deleted")
+	}
+	garbage = create("key4", 4)
+	os.Remove(filepath.Join(tmpDir, manifest.Filename))
+	closeManifest(tmpDir)
+	tables(1, filepath.Base(garbage.TOCFilename)) // adopted by the new manifest
+	tables(2, "usertable-00005-TOC.txt")
+
+	for _, name := range []string{"usertable-00009-Data.db", "usertable-00007-Data.db", "usertable-00007-Metadata.txt"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, "L001", name), []byte("data"), 0644); err != nil {
+			t.Fatalf("Failed to write a file: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "L001", "TOC", "usertable-00007-TOC.txt"), nil, 0644); err != nil {
+		t.Fatalf("Failed to write a file: %v", err)
+	}
+	closeManifest(tmpDir)
+	tables(1, filepath.Base(garbage.TOCFilename))
+	entries, err := os.ReadDir(filepath.Join(tmpDir, "L001"))
+	if err != nil {
+		t.Fatalf("Failed to read the level directory: %v", err)
+	}
+	label := tableRegexp.FindString(filepath.Base(garbage.TOCFilename))
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), label) {
+			t.Errorf("Expected %s to be deleted as garbage", entry.Name())
+		}
+	}
+	if exists(filepath.Join(tmpDir, "L001", "TOC", "usertable-00007-TOC.txt")) {
+		t.Errorf("Expected the TOC file of the garbage table to be deleted")
+	}
+	if rec, err := garbage.Read([]byte("key4"), nil); err != nil || rec == nil {
+		t.Errorf("Expected the table in the manifest to be kept, got %v, %v", rec, err)
+	}
+}
@@ -6,35 +6,51 @@ import (
 	"fmt"
 	"nasp-project/structures/compression"
 	"nasp-project/util"
-	"os"
 )
 
 // MergeSSTables merges the given SSTables and writes the result to disk.
-// Removes the input SSTables from disk.
+// Replaces the input SSTables with the new one in the manifest, which deletes them once they aren't pinned.
 // Returns the new SSTable.
 // Returns an error if the merge fails.
 func MergeSSTables(sst1, sst2 *SSTable, level int, config *util.SSTableConfig, compressionDict *compression.Dictionary) (*SSTable, error) {
+	var c Compaction
+	sstable, err := c.MergeSSTables(sst1, sst2, level, config, compressionDict)
+	if err = c.finish(err); err != nil {
+		return nil, err
+	}
+	return sstable, nil
+}
+
+// MergeSSTables merges the given SSTables into a new one that replaces them when the compaction is committed.
+func (c *Compaction) MergeSSTables(sst1, sst2 *SSTable, level int, config *util.SSTableConfig, compressionDict *compression.Dictionary) (*SSTable, error) {
 	sstable, err := initializeSSTable(level, config)
 	if err != nil {
 		return nil, err
 	}
+	c.add(sstable)
 
-	numRecords, err := sstable.Data.WriteMerged(&sst1.Data, &sst2.Data, compressionDict)
+	dict1, err := sst1.dictionary(compressionDict)
+	if err != nil {
+		return nil, err
+	}
+	dict2, err := sst2.dictionary(compressionDict)
 	if err != nil {
 		return nil, err
 	}
+	dict := newDictionary(compressionDict)
 
-	err = sstable.BuildFromDataBlock(numRecords, compressionDict, config)
+	numRecords, err := sstable.Data.WriteMerged(&sst1.Data, &sst2.Data, dict1, dict2, dict)
 	if err != nil {
 		return nil, err
 	}
 
-	err = sst1.deleteFiles()
+	err = sstable.BuildFromDataBlock(numRecords, dict, config)
 	if err != nil {
 		return nil, err
 	}
+	c.recordMerge(sst1.Size()+sst2.Size(), sstable.Size())
 
-	err = sst2.deleteFiles()
+	err = c.remove(sst1, sst2)
 	if err != nil {
 		return nil, err
 	}
@@ -43,13 +59,28 @@ func MergeSSTables(sst1, sst2 *SSTable, level int, config *util.SSTableConfig, c
 }
 
 // MergeMultipleSSTables merges the given SSTables and writes the result to disk.
-// Removes the input SSTables from disk.
+// Replaces the input SSTables with the new one in the manifest, which deletes them once they aren't pinned.
 // Returns the newly created SSTable.
 // Returns an error if the merge fails.
 func MergeMultipleSSTables(tables []*SSTable, level int, config *util.SSTableConfig, compressionDict *compression.Dictionary) (*SSTable, error) {
+	var c Compaction
+	sstable, err := c.MergeMultipleSSTables(tables, level, config, compressionDict)
+	if err = c.finish(err); err != nil {
+		return nil, err
+	}
+	return sstable, nil
+}
+
+// MergeMultipleSSTables merges the given SSTables into a new one that replaces them when the compaction is committed.
+// The tables are merged in pairs, the tables merged in the previous rounds are deleted as soon as they are merged again.
+// A single table is returned as it is.
+func (c *Compaction) MergeMultipleSSTables(tables []*SSTable, level int, config *util.SSTableConfig, compressionDict *compression.Dictionary) (*SSTable, error) {
 	if len(tables) < 1 {
 		return nil, errors.New("no tables to merge")
 	}
+	if len(tables) == 1 {
+		return tables[0], nil
+	}
 	var numRecs uint
 	for len(tables) > 1 {
 		var newTables []*SSTable
@@ -58,18 +89,27 @@ func MergeMultipleSSTables(tables []*SSTable, level int, config *util.SSTableCon
 			if err != nil {
 				return nil, err
 			}
-			numRecs, err = newTable.Data.WriteMerged(&tables[i].Data, &tables[i+1].Data, compressionDict)
+			c.add(newTable)
+			dict1, err := tables[i].dictionary(compressionDict)
 			if err != nil {
 				return nil, err
 			}
+			dict2, err := tables[i+1].dictionary(compressionDict)
+			if err != nil {
+				return nil, err
+			}
+			// the dictionary is only kept in memory until the last merged table is built
+			newTable.Dictionary.Dict = newDictionary(compressionDict)
 
-			newTables = append(newTables, newTable)
-
-			err = tables[i].deleteFiles()
+			numRecs, err = newTable.Data.WriteMerged(&tables[i].Data, &tables[i+1].Data, dict1, dict2, newTable.Dictionary.Dict)
 			if err != nil {
 				return nil, err
 			}
-			err = tables[i+1].deleteFiles()
+			c.recordMerge(tables[i].Size()+tables[i+1].Size(), newTable.Size())
+
+			newTables = append(newTables, newTable)
+
+			err = c.remove(tables[i], tables[i+1])
 			if err != nil {
 				return nil, err
 			}
@@ -79,7 +119,11 @@ func MergeMultipleSSTables(tables []*SSTable, level int, config *util.SSTableCon
 		}
 		tables = newTables
 	}
-	err := tables[0].BuildFromDataBlock(numRecs, compressionDict, config)
+	dict, err := tables[0].dictionary(compressionDict)
+	if err != nil {
+		return nil, err
+	}
+	err = tables[0].BuildFromDataBlock(numRecs, dict, config)
 	if err != nil {
 		return nil, err
 	}
@@ -233,11 +277,12 @@ func mergeGeneratorsWithLimit(
 
 	var tables []*SSTable
 	var currentTable *SSTable
-	var currentFile *os.File
+	var currentWriter *dataWriter
+	var currentDict *compression.Dictionary // dictionary of the current table
 
 	defer func() {
-		if currentFile != nil {
-			currentFile.Close()
+		if currentWriter != nil {
+			currentWriter.abort()
 		}
 	}()
 
@@ -248,8 +293,10 @@ func mergeGeneratorsWithLimit(
 		// we add it even if build fails because it is already written to disc
 		tables = append(tables, currentTable)
 
-		currentTable.Data.Size = currentWrittenBytes
-		if berr := currentTable.BuildFromDataBlock(currentWrittenRecords, compressionDict, sstableConfig); berr != nil {
+		if cerr := currentWriter.close(); cerr != nil {
+			return fmt.Errorf("failed to finish data block '%s' : %w", currentTable.Data.Filename, cerr)
+		}
+		if berr := currentTable.BuildFromDataBlock(currentWrittenRecords, currentDict, sstableConfig); berr != nil {
 			return fmt.Errorf("failed to build table at level %d from data block '%s' : %w", levelNum, currentTable.Data.Filename, berr)
 		}
 
@@ -271,22 +318,15 @@ func mergeGeneratorsWithLimit(
 		}
 
 		currentTable = nextTable
+		currentDict = newDictionary(compressionDict)
 		currentWrittenRecords = 0
 		currentWrittenBytes = 0
 
-		if currentFile != nil {
-			currentFile.Close() // should this err be checked?
-		}
-
-		currentFile, err = os.OpenFile(nextTable.Data.Filename, os.O_WRONLY, 0644)
+		currentWriter, err = nextTable.Data.newWriter()
 		if err != nil {
 			return fmt.Errorf("failed to switch to a new SSTable, couldn't open the data block file '%s' : %w", nextTable.Data.Filename, err)
 		}
 
-		if _, err = currentFile.Seek(nextTable.Data.StartOffset, 0); err != nil {
-			return fmt.Errorf("failed to switch to a new SSTable, couldn't seek data block file '%s' to start of the data block : %w", nextTable.Data.Filename, err)
-		}
-
 		return nil
 	}
 
@@ -297,7 +337,7 @@ func mergeGeneratorsWithLimit(
 
 	// write to current datablock and switch tables if limit is reached
 	writer := func(record *DataRecord) error {
-		numBytes, err := currentTable.Data.writeRecordLen(currentFile, record, compressionDict)
+		numBytes, err := currentWriter.writeRecord(record, currentDict)
 		if err != nil {
 			return fmt.Errorf("failed to write record : %w", err)
 		}
@@ -326,6 +366,7 @@ func mergeGeneratorsWithLimit(
 		}
 	} else {
 		// otherwise delete empty files
+		currentWriter.abort()
 		if derr := currentTable.deleteFiles(); derr != nil {
 			return tables, fmt.Errorf("failed to delete files for empty table '%s' : %w", currentTable.TOCFilename, derr)
 		}
@@ -338,7 +379,8 @@ func mergeGeneratorsWithLimit(
 // The newly generated tables are labeled with the first free label in the level, and their proxy objects are returned as a slice.
 // It skips deleted records if possible (when merging from a run into the last level run).
 // Returns error if merging or any part of the cleanup fails.
-// If merging is successfull the input table files are deleted, otherwise the lsm tree will stay unchanged.
+// If merging is successfull the input tables are replaced with the new ones in the manifest, which deletes them once they
+// aren't pinned, otherwise the lsm tree will stay unchanged.
 func MergeTableWithRun(
 	compressionDict *compression.Dictionary,
 	sstableConfig *util.SSTableConfig,
@@ -346,55 +388,40 @@ func MergeTableWithRun(
 	levelNum int,
 	table *SSTable,
 	run ...*SSTable,
+) ([]*SSTable, error) {
+	var c Compaction
+	newTables, err := c.MergeTableWithRun(compressionDict, sstableConfig, lsmConfig, levelNum, table, run...)
+	if err = c.finish(err); err != nil {
+		return nil, err
+	}
+	return newTables, nil
+}
+
+// MergeTableWithRun merges the table with run like the function MergeTableWithRun, the new tables replace the merged ones
+// when the compaction is committed. The tables written by a failed merge are deleted when the compaction is aborted.
+func (c *Compaction) MergeTableWithRun(
+	compressionDict *compression.Dictionary,
+	sstableConfig *util.SSTableConfig,
+	lsmConfig *util.LSMTreeConfig,
+	levelNum int,
+	table *SSTable,
+	run ...*SSTable,
 ) (newTables []*SSTable, err error) { // could make this take two runs
 	// used for merging
 	var tableGen, runGen *DataRecordGenerator
 
-	tableGen, err = NewDataRecordGenerator([]*DataBlock{&table.Data}, compressionDict)
+	tableGen, err = NewDataRecordGenerator([]*SSTable{table}, compressionDict)
 	if err != nil {
 		return
 	}
 
-	// sstable -> data block
-	runBlocks := make([]*DataBlock, 0, len(run))
-	for _, table := range run {
-		runBlocks = append(runBlocks, &table.Data) // just in case
-	}
-
-	runGen, err = NewDataRecordGenerator(runBlocks, compressionDict)
+	runGen, err = NewDataRecordGenerator(run, compressionDict)
 	if err != nil {
 		return
 	}
 
 	// cleanup after we are done
 	defer func() {
-		if err == nil {
-			// merge was successfull deleting files for the old SSTables from disc
-			for _, table := range append(run, table) {
-				if cerr := table.deleteFiles(); cerr != nil {
-					err = fmt.Errorf("%w && failed to delete files for SSTable '%s' from disc : %w", err, table.TOCFilename, cerr)
-				}
-				// we delete all tables even if one deletion fails
-			}
-		} else {
-			// merge was unsuccessfull deleting new tables that were created in the partial merge
-			var derr error
-			for _, newTable := range newTables {
-				if err := newTable.deleteFiles(); err != nil {
-					derr = fmt.Errorf("%w && failed to delete files for newly created SSTable '%s' ", derr, newTable.TOCFilename)
-				}
-				// we delete all tables even if one deletion fails
-			}
-
-			newTables = nil
-
-			if derr == nil {
-				err = fmt.Errorf("failed to merge the given tables : %w", err)
-			} else {
-				err = fmt.Errorf("failed to merge the given tables : %w [while handling the previous error new one occured : %w]", err, derr)
-			}
-		}
-
 		// clearing generators
 		for _, gen := range [...]*DataRecordGenerator{tableGen, runGen} {
 			if cerr := gen.Clear(); cerr != nil {
@@ -402,6 +429,29 @@ func MergeTableWithRun(
 			}
 			// we clear all generators even if one clearance fails
 		}
+
+		// the tables created in a partial merge are deleted when the compaction is aborted
+		c.add(newTables...)
+		if err != nil {
+			newTables = nil
+			err = fmt.Errorf("failed to merge the given tables : %w", err)
+			return
+		}
+
+		var bytesRead, bytesWritten int64
+		merged := append([]*SSTable{table}, run...)
+		for _, table := range merged {
+			bytesRead += table.Size()
+		}
+		for _, newTable := range newTables {
+			bytesWritten += newTable.Size()
+		}
+		c.recordMerge(bytesRead, bytesWritten)
+
+		if rerr := c.remove(merged...); rerr != nil {
+			newTables = nil
+			err = fmt.Errorf("failed to merge the given tables : %w", rerr)
+		}
 	}()
 
 	maxLevelNum := lsmConfig.MaxLevel - 1 + util.LSMFirstLevelNum
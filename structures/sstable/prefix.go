@@ -0,0 +1,97 @@
+package sstable
+
+import (
+	"errors"
+	"io"
+	"nasp-project/structures/encryption"
+	"nasp-project/util"
+)
+
+/*
+	=== PREFIX ENCODED KEYS ===
+
+	+------------------+-------------------+-...-+----------+
+	|  Shared (VAR)    |  Suffix Size (VAR) | ... |  Suffix  |
+	+------------------+-------------------+-...-+----------+
+	Shared = Number of leading bytes the key shares with the key of the previous record
+	Suffix Size = Length of the rest of the key
+	Suffix = The rest of the key
+
+	Data and index records of tables with prefix encoded keys store Shared in front of the Key Size,
+	which becomes the Suffix Size, and only the Suffix in place of the Key.
+	Records that are pointed at by index or summary records are restart points, which store the
+	whole key (Shared is 0), so the records can be read starting at them.
+	NOTE: The keys are not prefix encoded if the table uses a compression dictionary.
+*/
+
+// prefixFile is a block file with prefix encoded keys. It remembers the last key that was read from it,
+// which the next key is decoded against. Seeking to another position from the start forgets the key,
+// relative seeks are only used to skip parts of records.
+type prefixFile struct {
+	encryption.File
+	lastKey []byte
+}
+
+func (f *prefixFile) Seek(offset int64, whence int) (int64, error) {
+	if whence != io.SeekStart {
+		return f.File.Seek(offset, whence)
+	}
+	current, err := f.File.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if offset != current {
+		f.lastKey = nil
+	}
+	return f.File.Seek(offset, whence)
+}
+
+// setLastKey sets the key of the record before the current position of a file opened for reading
+// records, which is needed if the next record is not a restart point.
+func setLastKey(file encryption.File, key []byte) {
+	if f, ok := file.(*prefixFile); ok {
+		f.lastKey = key
+	}
+}
+
+// sharedPrefixLen returns the length of the common prefix of a and b.
+func sharedPrefixLen(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// readPrefixKey reads the suffix of a key that shares its first shared bytes with the last key read from file.
+func readPrefixKey(file encryption.File, shared, suffixSize uint64) ([]byte, error) {
+	f, ok := file.(*prefixFile)
+	if !ok {
+		return nil, errors.New("prefix encoded keys read from a plain file")
+	}
+	if shared > uint64(len(f.lastKey)) {
+		return nil, errors.New("prefix encoded key read without the previous key")
+	}
+	key := make([]byte, shared+suffixSize)
+	copy(key, f.lastKey[:shared])
+	_, err := io.ReadFull(file, key[shared:])
+	if err != nil {
+		return nil, err
+	}
+	f.lastKey = key
+	return key, nil
+}
+
+// readKeySizes reads Shared and Key Size, or Suffix Size if prefix is true, of a record.
+func readKeySizes(file encryption.File, prefix bool) (shared, size uint64, n int, err error) {
+	if prefix {
+		shared, n, err = util.ReadUvarintLen(file)
+		if err != nil {
+			return 0, 0, n, err
+		}
+	}
+	size, m, err := util.ReadUvarintLen(file)
+	return shared, size, n + m, err
+}
@@ -0,0 +1,206 @@
+package sstable
+
+import (
+	"fmt"
+	"nasp-project/model"
+	"nasp-project/structures/compression"
+	"nasp-project/util"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPrefixKeys(t *testing.T) {
+	for _, codec := range []string{"", "snappy"} {
+		for _, singleFile := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/singleFile=%t", codec, singleFile), func(t *testing.T) {
+				testPrefixKeys(t, codec, singleFile)
+			})
+		}
+	}
+}
+
+func testPrefixKeys(t *testing.T, codec string, singleFile bool) {
+	tmpDir, err := os.MkdirTemp("", "sstable_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &util.SSTableConfig{
+		SavePath:            tmpDir,
+		SingleFile:          singleFile,
+		IndexDegree:         4,
+		SummaryDegree:       3,
+		FilterPrecision:     0.01,
+		MerkleTreeChunkSize: 16,
+		BlockCompression:    codec,
+		BlockSize:           512,
+		KeyPrefixEncoding:   true,
+	}
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("tenant/%03d/orders/%05d", i/40, i))
+	}
+	records := func(from, to, timestamp int) []model.Record {
+		var recs []model.Record
+		for i := from; i < to; i++ {
+			recs = append(recs, model.Record{
+				Key:       key(i),
+				Value:     []byte(fmt.Sprintf("value%d-%d", i, timestamp)),
+				Timestamp: uint64(timestamp),
+				Tombstone: i%30 == 11,
+			})
+		}
+		return recs
+	}
+	check := func(table *SSTable, from, to, timestamp int, dict *compression.Dictionary) {
+		t.Helper()
+		for i := from; i < to; i++ {
+			rec, err := table.Read(key(i), dict)
+			value := fmt.Sprintf("value%d-%d", i, timestamp)
+			if err != nil || rec == nil || rec.Tombstone != (i%30 == 11) || !rec.Tombstone && string(rec.Value) != value {
+				t.Fatalf("Expected %s for %s, got %v, %v", value, key(i), rec, err)
+			}
+		}
+		for _, missing := range []string{"tenant/", "tenant/001/orders/0", "tenant/001/orders/00045x"} {
+			if rec, err := table.Read([]byte(missing), dict); err != nil || rec != nil {
+				t.Errorf("Expected no record for %s, got %v, %v", missing, rec, err)
+			}
+		}
+	}
+	count := func(table *SSTable, dict *compression.Dictionary) int {
+		t.Helper()
+		it, err := table.NewIterator(dict)
+		if err != nil {
+			t.Fatalf("Failed to create iterator: %v", err)
+		}
+		cnt := 0
+		for ; it.Value() != nil; it.Next() {
+			if want := string(key(cnt)); string(it.Value().Key) != want {
+				t.Fatalf("Expected key %s from the iterator, got %s", want, it.Value().Key)
+			}
+			cnt++
+		}
+		return cnt
+	}
+
+	table, err := CreateSSTable(records(0, 300, 1), nil, config)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable: %v", err)
+	}
+	check(table, 0, 300, 1, nil)
+	if cnt := count(table, nil); cnt != 300 {
+		t.Errorf("Expected to iterate over 300 records, got %d", cnt)
+	}
+
+	// the key encoding is kept in the TOC
+	table, err = OpenSSTableFromToc(table.TOCFilename)
+	if err != nil {
+		t.Fatalf("Failed to open SSTable: %v", err)
+	}
+	if !table.Data.PrefixKeys || !table.Index.PrefixKeys {
+		t.Fatalf("Expected the table to have prefix encoded keys")
+	}
+	check(table, 0, 300, 1, nil)
+	recs, err := table.RangeScan(key(41), key(78), -1, nil)
+	if err != nil || len(recs) != 38 || string(recs[37].Key) != string(key(78)) {
+		t.Errorf("Expected 38 records from the range scan, got %d, %v", len(recs), err)
+	}
+	recs, err = table.PrefixScan([]byte("tenant/003/"), -1, nil)
+	if err != nil || len(recs) != 40 {
+		t.Errorf("Expected 40 records from the prefix scan, got %d, %v", len(recs), err)
+	}
+	it, err := table.NewPrefixIterator([]byte("tenant/006/orders/0025"), nil)
+	if err != nil {
+		t.Fatalf("Failed to create prefix iterator: %v", err)
+	}
+	cnt := 0
+	for ; it.Value() != nil; it.Next() {
+		cnt++
+	}
+	if cnt != 10 {
+		t.Errorf("Expected 10 records from the prefix iterator, got %d", cnt)
+	}
+
+	// the shared prefixes are not stored
+	plainConfig := *config
+	plainConfig.KeyPrefixEncoding = false
+	plain, err := CreateSSTable(records(0, 300, 1), nil, &plainConfig)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable: %v", err)
+	}
+	if codec == "" && table.Data.Size+3000 > plain.Data.Size {
+		t.Errorf("Expected the data block to shrink, got %d of %d bytes", table.Data.Size, plain.Data.Size)
+	}
+	if table.Index.Size >= plain.Index.Size {
+		t.Errorf("Expected the index block to shrink, got %d of %d bytes", table.Index.Size, plain.Index.Size)
+	}
+
+	// merges read tables with both key encodings
+	newer, err := CreateSSTable(records(150, 450, 2), nil, &plainConfig)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable: %v", err)
+	}
+	merged, err := MergeSSTables(table, newer, 2, config, nil)
+	if err != nil {
+		t.Fatalf("Failed to merge SSTables: %v", err)
+	}
+	check(merged, 0, 150, 1, nil)
+	check(merged, 150, 450, 2, nil)
+	if cnt := count(merged, nil); cnt != 450 {
+		t.Errorf("Expected to iterate over 450 records, got %d", cnt)
+	}
+
+	run, err := MergeTableWithRun(nil, config, &util.LSMTreeConfig{
+		MaxLevel: 4,
+		Leveled:  util.LeveledConfig{DataBlockSize: 4000},
+	}, 3, merged)
+	if err != nil {
+		t.Fatalf("Failed to merge into a run: %v", err)
+	}
+	if len(run) < 2 {
+		t.Fatalf("Expected the run to be split into several tables, got %d", len(run))
+	}
+	total := 0
+	for _, table := range run {
+		table, err = OpenSSTableFromToc(table.TOCFilename)
+		if err != nil {
+			t.Fatalf("Failed to open SSTable: %v", err)
+		}
+		err = table.LoadRange(nil)
+		if err != nil {
+			t.Fatalf("Failed to load the range: %v", err)
+		}
+		var first, last int
+		fmt.Sscanf(string(table.Summary.StartKey[len("tenant/000/orders/"):]), "%05d", &first)
+		fmt.Sscanf(string(table.Summary.EndKey[len("tenant/000/orders/"):]), "%05d", &last)
+		if first < 150 {
+			check(table, first, min(last+1, 150), 1, nil)
+		}
+		if last >= 150 {
+			check(table, max(first, 150), last+1, 2, nil)
+		}
+		total += last - first + 1
+	}
+	if total != 450 {
+		t.Errorf("Expected 450 records in the run, got %d", total)
+	}
+
+	// tables with a compression dictionary store the indices of the keys instead
+	compressed, err := CreateSSTable(records(0, 300, 1), compression.NewDictionary(), config)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable: %v", err)
+	}
+	toc, err := os.ReadFile(compressed.TOCFilename)
+	if err != nil {
+		t.Fatalf("Failed to read the TOC: %v", err)
+	}
+	if strings.Contains(string(toc), "keys prefix") {
+		t.Errorf("Expected no prefix encoded keys with a dictionary, got %s", toc)
+	}
+	compressed, err = OpenSSTableFromToc(compressed.TOCFilename)
+	if err != nil {
+		t.Fatalf("Failed to open SSTable: %v", err)
+	}
+	check(compressed, 0, 300, 1, compression.NewDictionary())
+}
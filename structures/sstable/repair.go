@@ -0,0 +1,389 @@
+package sstable
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"nasp-project/structures/compression"
+	"nasp-project/structures/encryption"
+	"nasp-project/structures/manifest"
+	"nasp-project/structures/merkle_tree"
+	"nasp-project/util"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RepairReport describes what Repair found in an SSTable and what it did about it.
+type RepairReport struct {
+	TOCFilename string   // TOC file of the checked table
+	Repaired    string   // TOC file of the table that replaced it, empty if it wasn't replaced
+	Removed     bool     // true if no records could be read and the table was removed
+	Records     uint     // number of readable records
+	Problems    []string // missing or damaged files
+	Skipped     []string // records and parts of the data block that were left out
+	Quarantine  string   // directory with the files of the replaced table and the report, empty if it wasn't replaced
+}
+
+// quarantineDir is the directory in the directory of the LSM tree where Repair keeps the files of the tables it replaces.
+const quarantineDir = "quarantine"
+
+func (r *RepairReport) problem(format string, args ...any) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// skip adds the records that were left out, a nil report ignores them.
+func (r *RepairReport) skip(format string, args ...any) {
+	if r != nil {
+		r.Skipped = append(r.Skipped, fmt.Sprintf(format, args...))
+	}
+}
+
+// Healthy returns true if nothing was wrong with the table.
+func (r *RepairReport) Healthy() bool {
+	return len(r.Problems) == 0 && len(r.Skipped) == 0
+}
+
+func (r *RepairReport) String() string {
+	var b strings.Builder
+	switch {
+	case r.Repaired != "":
+		fmt.Fprintf(&b, "%s: repaired as %s with %d records\n", r.TOCFilename, filepath.Base(r.Repaired), r.Records)
+	case r.Removed:
+		fmt.Fprintf(&b, "%s: removed, no records could be read\n", r.TOCFilename)
+	case r.Healthy():
+		fmt.Fprintf(&b, "%s: healthy, %d records\n", r.TOCFilename, r.Records)
+	default:
+		fmt.Fprintf(&b, "%s: not repaired\n", r.TOCFilename)
+	}
+	for _, problem := range r.Problems {
+		fmt.Fprintf(&b, "  problem: %s\n", problem)
+	}
+	for _, skipped := range r.Skipped {
+		fmt.Fprintf(&b, "  skipped: %s\n", skipped)
+	}
+	if r.Quarantine != "" {
+		fmt.Fprintf(&b, "  damaged files kept in %s\n", r.Quarantine)
+	}
+	return b.String()
+}
+
+// Repair checks the SSTable of the TOC file at tocPath, which must be in the current version of its LSM tree.
+// A table with missing or damaged files is rebuilt from the records in its data block that can be read. Records with
+// a bad CRC are left out, as are the parts of the data block that can't be read. The rebuilt table replaces the damaged
+// one at its place in the level, the tables after it on the level are relabeled. A table without readable records
+// is removed. A healthy table is left as it is. The files of a replaced or removed table are kept in a directory of
+// the quarantine of the LSM tree, together with the report.
+// compressionDict is the global compression dictionary, see SSTable.Read.
+// Returns the report of the check, and an error if the table couldn't be checked or repaired.
+func Repair(tocPath string, compressionDict *compression.Dictionary, config *util.SSTableConfig) (*RepairReport, error) {
+	savePath, t, err := tableOf(tocPath)
+	if err != nil {
+		return nil, err
+	}
+	version, err := PinVersion(savePath)
+	if err != nil {
+		return nil, err
+	}
+	defer version.Unref()
+	if !version.Contains(t) {
+		return nil, fmt.Errorf("repairing %s: %w", tocPath, manifest.ErrTableNotFound)
+	}
+
+	report := &RepairReport{TOCFilename: tocPath}
+	table, err := OpenSSTableFromToc(tocPath)
+	if err != nil {
+		report.problem("TOC file can't be read: %v", err)
+		table, err = guessTable(tocPath, config)
+		if err != nil {
+			return report, err
+		}
+	}
+	filenames := []string{table.Data.Filename, table.Index.Filename, table.Summary.Filename, table.Filter.Filename,
+		table.Dictionary.Filename, table.MetadataFilename}
+	dropCached(filenames...) // the damaged blocks are read again from disk
+	missing := false
+	checked := make(map[string]bool)
+	for _, filename := range filenames {
+		if filename == "" || checked[filename] {
+			continue
+		}
+		checked[filename] = true
+		if _, err := os.Stat(filename); err != nil {
+			report.problem("%v", err)
+			missing = true
+		}
+	}
+
+	dict := compressionDict
+	if table.HasDictionary() {
+		// the keys of the records are the indices of the dictionary, they can't be read without it
+		if err := table.Dictionary.Load(); err != nil {
+			return report, fmt.Errorf("failed to read the dictionary of %s : %w", tocPath, err)
+		}
+		dict = table.Dictionary.Dict
+		defer func() {
+			table.Dictionary.Dict = nil
+		}()
+	}
+	err = table.salvage(dict, report, func(*DataRecord) error {
+		report.Records++
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to read the data block of %s : %w", tocPath, err)
+	}
+	if report.Healthy() {
+		if !missing && table.matchesMetadata(config.MerkleTreeChunkSize) {
+			return report, nil
+		}
+		report.problem("files don't match the merkle tree in %s", table.MetadataFilename)
+	}
+
+	// the damaged files are deleted once the table is replaced, the records that weren't salvaged
+	// may still be recovered from the quarantined ones
+	report.Quarantine, err = quarantine(savePath, t)
+	if err != nil {
+		return report, fmt.Errorf("failed to quarantine the files of %s : %w", tocPath, err)
+	}
+
+	var c Compaction
+	var repaired *SSTable
+	err = c.remove(table)
+	if err == nil && report.Records > 0 {
+		repaired, err = c.rebuild(table, t.Level, dict, compressionDict, config)
+		if err == nil {
+			err = c.relabelFollowing(version, savePath, t)
+		}
+	}
+	if err = c.finish(err); err != nil {
+		os.RemoveAll(report.Quarantine) // the table is still in place
+		report.Quarantine = ""
+		return report, err
+	}
+	if repaired == nil {
+		report.Removed = true
+	} else {
+		report.Repaired = repaired.TOCFilename
+	}
+	if err := os.WriteFile(filepath.Join(report.Quarantine, "report.txt"), []byte(report.String()), 0644); err != nil {
+		return report, fmt.Errorf("failed to write the report of %s : %w", tocPath, err)
+	}
+	return report, nil
+}
+
+// quarantine links the files of the table t into a new directory of the quarantine of the LSM tree in savePath,
+// where they are kept after the table is deleted. Returns the directory.
+func quarantine(savePath string, t manifest.Table) (string, error) {
+	paths, err := labelFiles(savePath, t)
+	if err != nil {
+		return "", err
+	}
+	label := tableRegexp.FindString(t.Name)
+	dir := filepath.Join(savePath, quarantineDir, fmt.Sprintf("L%03d", t.Level), label+time.Now().Format("20060102T150405.000000000"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	for _, path := range paths {
+		if err := os.Link(path, filepath.Join(dir, filepath.Base(path))); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+	if err := syncFile(dir); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// guessTable returns the table with the label of the TOC file at tocPath that can't be read, with the files it
+// would have if it were written with config. The blocks of a single file table can't be found without the TOC file.
+func guessTable(tocPath string, config *util.SSTableConfig) (*SSTable, error) {
+	label := tableRegexp.FindString(filepath.Base(tocPath))
+	if label == "" {
+		return nil, fmt.Errorf("%s: not a table", tocPath)
+	}
+	prefix := filepath.Join(filepath.Dir(filepath.Dir(tocPath)), strings.TrimSuffix(label, "-"))
+	if _, err := os.Stat(prefix + "-SSTable.db"); err == nil {
+		return nil, fmt.Errorf("%s: the blocks of a single file table can't be found without the TOC file", tocPath)
+	}
+
+	table := &SSTable{
+		TOCFilename:      tocPath,
+		MetadataFilename: prefix + "-Metadata.txt",
+	}
+	table.Data.Filename = prefix + "-Data.db"
+	table.Index.Filename = prefix + "-Index.db"
+	table.Summary.Filename = prefix + "-Summary.db"
+	table.Filter.Filename = prefix + "-Filter.db"
+	table.Data.Codec = config.BlockCompression
+	table.Data.PrefixKeys = config.KeyPrefixEncoding
+	size, err := fileSize(table.Data.Filename)
+	if err != nil {
+		return nil, err
+	}
+	table.Data.Size = size
+	if size, err := fileSize(prefix + "-Dictionary.db"); err == nil {
+		table.Dictionary.BinaryFile = util.BinaryFile{Filename: prefix + "-Dictionary.db", Size: size}
+		table.Data.PrefixKeys = false // the keys are stored as indices of the dictionary
+	}
+	return table, nil
+}
+
+// fileSize returns the size of the contents of a plain or encrypted file.
+func fileSize(filename string) (int64, error) {
+	file, err := encryption.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return file.Seek(0, io.SeekEnd)
+}
+
+// salvage reads the records of the data block in order and passes the readable ones to consume. Records with a bad
+// CRC or out of order are left out. After a record that can't be read, the reading continues with the next compressed
+// block, or stops if the data block isn't compressed. The records that were left out are added to report.
+func (sst *SSTable) salvage(compressionDict *compression.Dictionary, report *RepairReport, consume func(*DataRecord) error) error {
+	file, err := sst.Data.open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Seek(sst.Data.StartOffset, io.SeekStart); err != nil {
+		return err
+	}
+	end := sst.Data.end(file) - sst.Data.StartOffset
+
+	var lastKey []byte
+	for {
+		offset, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		offset -= sst.Data.StartOffset
+
+		rec, err := sst.Data.getNextRecord(file, compressionDict)
+		if errors.Is(err, errBadCRC) {
+			report.skip("record at offset %d with key %q: %v", offset, rec.Key, err)
+			continue
+		}
+		if err == nil && rec == nil {
+			if offset >= end {
+				return nil
+			}
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			next := end
+			for _, start := range blockStarts(file) {
+				if start > offset {
+					next = start
+					break
+				}
+			}
+			report.skip("%d bytes of records at offset %d: %v", next-offset, offset, err)
+			if next == end {
+				return nil
+			}
+			if _, err := file.Seek(sst.Data.StartOffset+next, io.SeekStart); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if lastKey != nil && bytes.Compare(rec.Key, lastKey) <= 0 {
+			report.skip("record at offset %d with key %q: out of order", offset, rec.Key)
+			continue
+		}
+		lastKey = rec.Key
+		if err := consume(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// matchesMetadata returns true if the merkle tree of the blocks of the table is the one in its metadata file.
+func (sst *SSTable) matchesMetadata(chunkSize int64) bool {
+	metadata, err := os.ReadFile(sst.MetadataFilename)
+	if err != nil {
+		return false
+	}
+	return merkle_tree.NewMerkleTree(sst.toBinaryFiles(), chunkSize).Serialize() == string(metadata)
+}
+
+// rebuild writes the readable records of the table to a new table on the level, which replaces it when the
+// compaction is committed. dict is the dictionary of the table, compressionDict is the global one.
+func (c *Compaction) rebuild(table *SSTable, level int, dict, compressionDict *compression.Dictionary, config *util.SSTableConfig) (*SSTable, error) {
+	sstable, err := initializeSSTable(level, config)
+	if err != nil {
+		return nil, err
+	}
+	c.add(sstable)
+
+	w, err := sstable.Data.newWriter()
+	if err != nil {
+		return nil, err
+	}
+	defer w.abort()
+	newDict := newDictionary(compressionDict)
+	var numRecords uint
+	err = table.salvage(dict, nil, func(rec *DataRecord) error {
+		numRecords++
+		_, err := w.writeRecord(rec, newDict)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := w.close(); err != nil {
+		return nil, err
+	}
+
+	err = sstable.BuildFromDataBlock(numRecords, newDict, config)
+	if err != nil {
+		return nil, err
+	}
+	return sstable, nil
+}
+
+// relabelFollowing relabels the tables that follow the table t on its level in the version, so they keep
+// following the table that replaces it.
+func (c *Compaction) relabelFollowing(version *manifest.Version, savePath string, t manifest.Table) error {
+	labelNum := func(name string) int {
+		match := tableRegexp.FindStringSubmatch(name)
+		if match == nil {
+			return -1
+		}
+		num, err := strconv.Atoi(match[1])
+		if err != nil {
+			return -1
+		}
+		return num
+	}
+
+	var following []string
+	for _, name := range version.Tables(t.Level) {
+		if labelNum(name) > labelNum(t.Name) {
+			following = append(following, name)
+		}
+	}
+	sort.Slice(following, func(i, j int) bool {
+		return labelNum(following[i]) < labelNum(following[j])
+	})
+
+	for _, name := range following {
+		table, err := OpenSSTableFromToc(TOCPath(savePath, manifest.Table{Level: t.Level, Name: name}))
+		if err != nil {
+			return fmt.Errorf("failed to relabel the table following the repaired one : %w", err)
+		}
+		if _, err := c.Relabel(table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"nasp-project/model"
+	"nasp-project/util"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRepair(t *testing.T) {
+	tests := []struct {
+		name    string
+		codec   string
+		damage  func(t *testing.T, table *SSTable)
+		healthy bool
+		removed bool
+		lost    []int // keys that can't be salvaged
+	}{
+		{
+			name:    "healthy",
+			damage:  func(*testing.T, *SSTable) {},
+			healthy: true,
+		},
+		{
+			name: "bad CRC",
+			damage: func(t *testing.T, table *SSTable) {
+				modifyFile(t, table.Data.Filename, func(data []byte) {
+					data[bytes.Index(data, []byte("value005"))+5] = 'X'
+				})
+			},
+			lost: []int{5},
+		},
+		{
+			name: "missing index",
+			damage: func(t *testing.T, table *SSTable) {
+				if err := os.Remove(table.Index.Filename); err != nil {
+					t.Fatalf("Failed to remove the index: %v", err)
+				}
+			},
+		},
+		{
+			name: "damaged TOC",
+			damage: func(t *testing.T, table *SSTable) {
+				if err := os.WriteFile(table.TOCFilename, []byte("0 0"), 0644); err != nil {
+					t.Fatalf("Failed to write the TOC file: %v", err)
+				}
+			},
+		},
+		{
+			name:  "damaged compressed block",
+			codec: "zlib",
+			damage: func(t *testing.T, table *SSTable) {
+				modifyFile(t, table.Data.Filename, func(data []byte) {
+					data[bytes.Index(data, []byte("value010"))+5] = 'X' // the short blocks are stored uncompressed
+				})
+			},
+			lost: []int{10, 11}, // the block with the damaged record
+		},
+		{
+			name: "no readable records",
+			damage: func(t *testing.T, table *SSTable) {
+				modifyFile(t, table.Data.Filename, func(data []byte) {
+					clear(data)
+				})
+			},
+			removed: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpDir, err := os.MkdirTemp("", "sstable_test_")
+			if err != nil {
+				t.Fatalf("Failed to create temporary directory: %v", err)
+			}
+			defer os.RemoveAll(tmpDir)
+			defer closeManifest(tmpDir)
+
+			config := &util.SSTableConfig{
+				SavePath:            tmpDir,
+				IndexDegree:         2,
+				SummaryDegree:       3,
+				FilterPrecision:     0.01,
+				MerkleTreeChunkSize: 16,
+				BlockCompression:    test.codec,
+				BlockSize:           64,
+			}
+			var records []model.Record
+			for i := 0; i < 20; i++ {
+				records = append(records, model.Record{
+					Key:       []byte(fmt.Sprintf("key%03d", i)),
+					Value:     []byte(fmt.Sprintf("value%03d", i)),
+					Timestamp: uint64(i),
+				})
+			}
+			table, err := CreateSSTable(records, nil, config)
+			if err != nil {
+				t.Fatalf("Failed to create SSTable: %v", err)
+			}
+			following, err := CreateSSTable([]model.Record{{Key: []byte("key100"), Value: []byte("value100")}}, nil, config)
+			if err != nil {
+				t.Fatalf("Failed to create SSTable: %v", err)
+			}
+			tocFilename := table.TOCFilename
+			test.damage(t, table)
+
+			report, err := Repair(tocFilename, nil, config)
+			if err != nil {
+				t.Fatalf("Failed to repair: %v\n%s", err, report)
+			}
+			if report.Healthy() != test.healthy || report.Removed != test.removed || (report.Repaired != "") != (!test.healthy && !test.removed) {
+				t.Fatalf("Unexpected report:\n%s", report)
+			}
+			if test.healthy {
+				if report.Records != 20 {
+					t.Errorf("Expected 20 records, got %d", report.Records)
+				}
+				return
+			}
+			if len(report.Skipped) == 0 && (len(test.lost) != 0 || test.removed) {
+				t.Errorf("Expected the lost records in the report:\n%s", report)
+			}
+
+			// the damaged files are kept in the quarantine with the report
+			if filepath.Dir(filepath.Dir(report.Quarantine)) != filepath.Join(tmpDir, quarantineDir) {
+				t.Fatalf("Expected a quarantine directory, got %q", report.Quarantine)
+			}
+			quarantined, err := os.ReadFile(filepath.Join(report.Quarantine, filepath.Base(table.Data.Filename)))
+			if err != nil {
+				t.Errorf("Expected the data block in the quarantine: %v", err)
+			}
+			if test.name == "bad CRC" && !bytes.Contains(quarantined, []byte("valueX05")) {
+				t.Errorf("Expected the damaged data block in the quarantine")
+			}
+			saved, err := os.ReadFile(filepath.Join(report.Quarantine, "report.txt"))
+			if err != nil || string(saved) != report.String() {
+				t.Errorf("Expected the report in the quarantine, got %q, %v", saved, err)
+			}
+
+			// the repaired table replaces the damaged one, and is followed by the tables that followed it
+			version, err := PinVersion(tmpDir)
+			if err != nil {
+				t.Fatalf("Failed to pin the version: %v", err)
+			}
+			names := version.Tables(1)
+			version.Unref()
+			expected := 2
+			if test.removed {
+				expected = 1
+			}
+			if len(names) != expected {
+				t.Fatalf("Expected %d tables, got %v", expected, names)
+			}
+			if test.removed {
+				if names[0] != filepath.Base(following.TOCFilename) {
+					t.Errorf("Expected only the following table, got %v", names)
+				}
+				return
+			}
+			if names[0] != filepath.Base(report.Repaired) || names[1] <= names[0] {
+				t.Errorf("Expected the repaired table to come first, got %v", names)
+			}
+			entries, err := os.ReadDir(filepath.Join(tmpDir, "L001"))
+			if err != nil {
+				t.Fatalf("Failed to read the level directory: %v", err)
+			}
+			for _, entry := range entries {
+				if strings.HasPrefix(entry.Name(), "usertable-00001-") {
+					t.Errorf("Expected the files of the damaged table to be deleted, found %s", entry.Name())
+				}
+			}
+
+			repaired, err := OpenSSTableFromToc(report.Repaired)
+			if err != nil {
+				t.Fatalf("Failed to open the repaired table: %v", err)
+			}
+			found := uint(0)
+			for i, rec := range records {
+				got, err := repaired.Read(rec.Key, nil)
+				if err != nil {
+					t.Fatalf("Failed to read %s: %v", rec.Key, err)
+				}
+				lost := false
+				for _, key := range test.lost {
+					lost = lost || key == i
+				}
+				if got == nil {
+					if !lost {
+						t.Errorf("Expected %s to be salvaged", rec.Key)
+					}
+					continue
+				}
+				found++
+				if lost {
+					t.Errorf("Expected %s to be left out", rec.Key)
+				}
+				if !bytes.Equal(got.Value, rec.Value) {
+					t.Errorf("Expected %s for %s, got %s", rec.Value, rec.Key, got.Value)
+				}
+			}
+			if found != report.Records || found == 0 {
+				t.Errorf("Expected the %d salvaged records to be readable, found %d", report.Records, found)
+			}
+
+			// the repaired table is healthy
+			report, err = Repair(report.Repaired, nil, config)
+			if err != nil || !report.Healthy() {
+				t.Errorf("Expected the repaired table to be healthy, got %v\n%s", err, report)
+			}
+		})
+	}
+}
+
+// modifyFile changes the contents of the file with modify.
+func modifyFile(t *testing.T, filename string, modify func([]byte)) {
+	t.Helper()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", filename, err)
+	}
+	modify(data)
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", filename, err)
+	}
+}
@@ -1,17 +1,22 @@
 package sstable
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
 	"nasp-project/model"
 	"nasp-project/structures/compression"
+	"nasp-project/structures/encryption"
+	"nasp-project/structures/manifest"
 	"nasp-project/structures/merkle_tree"
 	"nasp-project/util"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type SSTable struct {
@@ -19,11 +24,16 @@ type SSTable struct {
 	Index            IndexBlock
 	Summary          SummaryBlock
 	Filter           FilterBlock
+	Dictionary       DictionaryBlock // only if the SSTable was written with compression
 	TOCFilename      string
 	MetadataFilename string
 }
 
 func initializeSSTable(level int, config *util.SSTableConfig) (*SSTable, error) {
+	// the manifest is opened before the files are created, so they aren't deleted as garbage
+	if _, err := getManifest(config.SavePath); err != nil {
+		return nil, err
+	}
 	path := filepath.Join(config.SavePath, fmt.Sprintf("L%03d", level))
 
 	labelNum, err := GetNextSStableLabel(filepath.Join(path, "TOC"))
@@ -38,15 +48,21 @@ func initializeSSTable(level int, config *util.SSTableConfig) (*SSTable, error)
 		// Starting offset for each block is calculated after the previous block is written.
 		sstable = &SSTable{
 			Data: DataBlock{
-				util.BinaryFile{
+				BinaryFile: util.BinaryFile{
 					Filename:    filepath.Join(path, "usertable-"+label+"-SSTable.db"),
 					StartOffset: 0,
 				},
+				Codec:           config.BlockCompression,
+				BlockSize:       config.BlockSize,
+				PrefixKeys:      config.KeyPrefixEncoding,
+				restartInterval: config.IndexDegree,
 			},
 			Index: IndexBlock{
-				util.BinaryFile{
+				BinaryFile: util.BinaryFile{
 					Filename: filepath.Join(path, "usertable-"+label+"-SSTable.db"),
 				},
+				PrefixKeys:      config.KeyPrefixEncoding,
+				restartInterval: config.SummaryDegree,
 			},
 			Summary: SummaryBlock{
 				BinaryFile: util.BinaryFile{
@@ -58,22 +74,28 @@ func initializeSSTable(level int, config *util.SSTableConfig) (*SSTable, error)
 					Filename: filepath.Join(path, "usertable-"+label+"-SSTable.db"),
 				},
 			},
-			TOCFilename:      filepath.Join(path, "TOC", "usertable-"+label+"-TOC.txt"),
+			TOCFilename:      filepath.Join(path, "TOC", "usertable-"+label+"-TOC.txt"+tmpSuffix),
 			MetadataFilename: filepath.Join(path, "usertable-"+label+"-Metadata.txt"),
 		}
 	} else {
 		sstable = &SSTable{
 			Data: DataBlock{
-				util.BinaryFile{
+				BinaryFile: util.BinaryFile{
 					Filename:    filepath.Join(path, "usertable-"+label+"-Data.db"),
 					StartOffset: 0,
 				},
+				Codec:           config.BlockCompression,
+				BlockSize:       config.BlockSize,
+				PrefixKeys:      config.KeyPrefixEncoding,
+				restartInterval: config.IndexDegree,
 			},
 			Index: IndexBlock{
-				util.BinaryFile{
+				BinaryFile: util.BinaryFile{
 					Filename:    filepath.Join(path, "usertable-"+label+"-Index.db"),
 					StartOffset: 0,
 				},
+				PrefixKeys:      config.KeyPrefixEncoding,
+				restartInterval: config.SummaryDegree,
 			},
 			Summary: SummaryBlock{
 				BinaryFile: util.BinaryFile{
@@ -87,7 +109,7 @@ func initializeSSTable(level int, config *util.SSTableConfig) (*SSTable, error)
 					StartOffset: 0,
 				},
 			},
-			TOCFilename:      filepath.Join(path, "TOC", "usertable-"+label+"-TOC.txt"),
+			TOCFilename:      filepath.Join(path, "TOC", "usertable-"+label+"-TOC.txt"+tmpSuffix),
 			MetadataFilename: filepath.Join(path, "usertable-"+label+"-Metadata.txt"),
 		}
 	}
@@ -101,6 +123,8 @@ func initializeSSTable(level int, config *util.SSTableConfig) (*SSTable, error)
 		return nil, err
 	}
 
+	crashPoint("create")
+
 	return sstable, nil
 }
 
@@ -113,6 +137,7 @@ func CreateSSTable(records []model.Record, compressionDict *compression.Dictiona
 
 	recs := dataRecordsFromRecords(records)
 
+	compressionDict = newDictionary(compressionDict) // the table gets its own dictionary with the keys of its records
 	err = sstable.Data.Write(recs, compressionDict)
 	if err != nil {
 		return nil, err
@@ -121,7 +146,7 @@ func CreateSSTable(records []model.Record, compressionDict *compression.Dictiona
 	if config.SingleFile {
 		sstable.Index.StartOffset = sstable.Data.StartOffset + sstable.Data.Size
 	}
-	idxRecs, err := sstable.Index.CreateFromDataRecords(config.IndexDegree, recs, compressionDict)
+	idxRecs, err := sstable.Index.CreateFromDataRecords(config.IndexDegree, &sstable.Data, recs, compressionDict)
 	if err != nil {
 		return nil, err
 	}
@@ -129,7 +154,7 @@ func CreateSSTable(records []model.Record, compressionDict *compression.Dictiona
 	if config.SingleFile {
 		sstable.Summary.StartOffset = sstable.Index.StartOffset + sstable.Index.Size
 	}
-	err = sstable.Summary.CreateFromIndexRecords(config.SummaryDegree, idxRecs, compressionDict)
+	err = sstable.Summary.CreateFromIndexRecords(config.SummaryDegree, &sstable.Index, idxRecs, compressionDict)
 	if err != nil {
 		return nil, err
 	}
@@ -148,6 +173,11 @@ func CreateSSTable(records []model.Record, compressionDict *compression.Dictiona
 	}
 	sstable.Filter.Filter = nil
 
+	err = sstable.writeDictionary(compressionDict, config.SingleFile)
+	if err != nil {
+		return nil, err
+	}
+
 	files := sstable.toBinaryFiles()
 	merkleTree := merkle_tree.NewMerkleTree(files, config.MerkleTreeChunkSize)
 	file, err := os.Create(sstable.MetadataFilename)
@@ -161,12 +191,22 @@ func CreateSSTable(records []model.Record, compressionDict *compression.Dictiona
 	}
 
 	err = sstable.writeTOCFile()
+	if err != nil {
+		return nil, err
+	}
+
+	// publishing the table syncs it to disk, and deletes it if it can't be published
+	c := Compaction{added: []*SSTable{sstable}}
+	err = c.Commit()
+	if err != nil {
+		return nil, err
+	}
 
 	return sstable, nil
 }
 
 func (sst *SSTable) toBinaryFiles() []util.BinaryFile {
-	return []util.BinaryFile{
+	files := []util.BinaryFile{
 		{
 			Filename:    sst.Data.Filename,
 			StartOffset: sst.Data.StartOffset,
@@ -188,6 +228,10 @@ func (sst *SSTable) toBinaryFiles() []util.BinaryFile {
 			Size:        sst.Filter.Size,
 		},
 	}
+	if sst.HasDictionary() {
+		files = append(files, sst.Dictionary.BinaryFile)
+	}
+	return files
 }
 
 // GetNextSStableLabel finds the largest label number in the given path and returns the next label number.
@@ -241,79 +285,67 @@ func (sst *SSTable) createFiles(singleFile bool) error {
 	}
 	defer file.Close()
 
-	file, err = os.Create(sst.Data.Filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
+	// the blocks are encrypted if encryption is enabled, the TOC and metadata files stay readable
+	blocks := []string{sst.Data.Filename}
 	if !singleFile {
-		file, err = os.Create(sst.Index.Filename)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-
-		file, err = os.Create(sst.Summary.Filename)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-
-		file, err = os.Create(sst.Filter.Filename)
+		blocks = append(blocks, sst.Index.Filename, sst.Summary.Filename, sst.Filter.Filename)
+	}
+	dropCached(blocks...) // of a deleted table with the same label
+	for _, filename := range blocks {
+		block, err := encryption.Create(filename)
 		if err != nil {
 			return err
 		}
-		defer file.Close()
+		defer block.Close()
 	}
 
 	return nil
 }
 
 // deleteFiles deletes the files for the SSTable from disk.
+// The TOC file is deleted first, the other files of a table without it are deleted as garbage after a crash.
 func (sst *SSTable) deleteFiles() error {
-	err := os.Remove(sst.TOCFilename)
-	if err != nil {
-		return err
-	}
+	dropFilterStats(sst.TOCFilename)
+	dropCached(sst.Data.Filename, sst.Index.Filename, sst.Summary.Filename, sst.Filter.Filename, sst.Dictionary.Filename)
 
-	err = os.Remove(sst.MetadataFilename)
-	if err != nil {
-		return err
+	// the index, summary and filter are in the data file of a single file SSTable, and the dictionary may be too
+	filenames := []string{sst.TOCFilename, sst.MetadataFilename, sst.Data.Filename, sst.Index.Filename, sst.Summary.Filename, sst.Filter.Filename}
+	if sst.HasDictionary() {
+		filenames = append(filenames, sst.Dictionary.Filename)
 	}
-
-	err = os.Remove(sst.Data.Filename)
-	if err != nil {
-		return err
-	}
-
-	err = os.Remove(sst.Index.Filename)
-	if err != nil {
-		if !os.IsNotExist(err) {
+	for i, filename := range filenames {
+		err := os.Remove(filename)
+		if err != nil && (i < 3 || !os.IsNotExist(err)) {
 			return err
 		}
+		crashPoint("delete")
 	}
 
-	err = os.Remove(sst.Summary.Filename)
-	if err != nil {
-		if !os.IsNotExist(err) {
-			return err
-		}
-	}
+	return nil
+}
 
-	err = os.Remove(sst.Filter.Filename)
-	if err != nil {
-		if !os.IsNotExist(err) {
-			return err
-		}
+// writeDictionary writes the given dictionary of the records to the dictionary block, which follows
+// the filter block in a single file SSTable. Does nothing if compressionDict is nil.
+func (sst *SSTable) writeDictionary(compressionDict *compression.Dictionary, singleFile bool) error {
+	if compressionDict == nil {
+		return nil
 	}
-
-	return nil
+	if singleFile {
+		sst.Dictionary.Filename = sst.Filter.Filename
+		sst.Dictionary.StartOffset = sst.Filter.StartOffset + sst.Filter.Size
+	} else {
+		sst.Dictionary.Filename = strings.TrimSuffix(sst.Data.Filename, "-Data.db") + "-Dictionary.db"
+		sst.Dictionary.StartOffset = 0
+	}
+	sst.Dictionary.Dict = compressionDict
+	sst.Data.PrefixKeys = false // the keys are stored as indices of the dictionary
+	sst.Index.PrefixKeys = false
+	return sst.Dictionary.Write()
 }
 
 // writeTOCFile writes the TOC file to disk.
 func (sst *SSTable) writeTOCFile() error {
-	file, err := os.OpenFile(sst.TOCFilename, os.O_WRONLY, 0644)
+	file, err := os.OpenFile(sst.TOCFilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return err
 	}
@@ -352,90 +384,131 @@ func (sst *SSTable) writeTOCFile() error {
 		return err
 	}
 
-	return nil
-}
-
-// Rename renames all SSTable files with the new label number.
-// This modifies the position of the SSTable withing the same level of the LSM Tree.
-func (sst *SSTable) Rename(label int) error {
-	re := regexp.MustCompile(`.*usertable-(\d+)-TOC.txt`)
-	match := re.FindStringSubmatch(sst.TOCFilename)
-	if match != nil {
-		_, err := strconv.Atoi(match[1])
+	if sst.Data.Codec != "" {
+		_, err = file.WriteString("codec " + sst.Data.Codec + "\n")
 		if err != nil {
 			return err
 		}
-		prefix := match[0][:len(match[0])-len(match[1])-18] // 18 is the length of "usertable--TOC.txt"
-		newPath := fmt.Sprintf("%susertable-%05d-TOC.txt", prefix, label)
-		err = os.Rename(sst.TOCFilename, newPath)
+	}
+
+	if sst.Data.PrefixKeys {
+		_, err = file.WriteString("keys prefix\n")
 		if err != nil {
 			return err
 		}
-		sst.TOCFilename = newPath
-	} else {
-		return errors.New("malformed sstable")
 	}
 
-	// rename all other filenames
-	re = regexp.MustCompile(`.*usertable-(\d+)-Metadata.txt`)
-	match = re.FindStringSubmatch(sst.MetadataFilename)
-	if match == nil {
-		return errors.New("malformed sstable")
+	if sst.HasDictionary() {
+		startOffset = strconv.FormatInt(sst.Dictionary.StartOffset, 10)
+		size = strconv.FormatInt(sst.Dictionary.Size, 10)
+		_, err = file.WriteString("dictionary " + startOffset + " " + size + " " + sst.Dictionary.Filename + "\n")
+		if err != nil {
+			return err
+		}
 	}
-	prefix := match[0][:len(match[0])-len(match[1])-23] // 23 is the length of "usertable--Metadata.txt"
+	crashPoint("write")
 
-	singleFile := sst.Data.Filename == sst.Index.Filename
-	var newDataFilename, newIndexFilename, newSummaryFilename, newFilterFilename string
-	if singleFile {
-		newDataFilename = fmt.Sprintf("%susertable-%05d-SSTable.db", prefix, label)
-		newIndexFilename = fmt.Sprintf("%susertable-%05d-SSTable.db", prefix, label)
-		newSummaryFilename = fmt.Sprintf("%susertable-%05d-SSTable.db", prefix, label)
-		newFilterFilename = fmt.Sprintf("%susertable-%05d-SSTable.db", prefix, label)
-	} else {
-		newDataFilename = fmt.Sprintf("%susertable-%05d-Data.db", prefix, label)
-		newIndexFilename = fmt.Sprintf("%susertable-%05d-Index.db", prefix, label)
-		newSummaryFilename = fmt.Sprintf("%susertable-%05d-Summary.db", prefix, label)
-		newFilterFilename = fmt.Sprintf("%susertable-%05d-Filter.db", prefix, label)
-	}
-	newMetadataFilename := fmt.Sprintf("%susertable-%05d-Metadata.txt", prefix, label)
+	return nil
+}
 
-	err := os.Rename(sst.Data.Filename, newDataFilename)
+// Rename renames all SSTable files with the new label number.
+// This modifies the position of the SSTable withing the same level of the LSM Tree.
+// The files are linked under the new names and the table is replaced in the manifest in one step,
+// the old names are deleted once no pinned version contains the table with the old label.
+func (sst *SSTable) Rename(label int) error {
+	// the new names may still be used by the files of a removed table, which are replaced
+	savePath, target, err := tableOf(withLabel(sst.TOCFilename, label))
 	if err != nil {
 		return err
 	}
-	sst.Data.Filename = newDataFilename
-
-	err = os.Rename(sst.Index.Filename, newIndexFilename)
+	version, err := PinVersion(savePath)
 	if err != nil {
 		return err
 	}
-	sst.Index.Filename = newIndexFilename
+	exists := version.Contains(target)
+	version.Unref()
+	if exists {
+		return fmt.Errorf("renaming %s: %w", sst.TOCFilename, manifest.ErrTableExists)
+	}
 
-	err = os.Rename(sst.Summary.Filename, newSummaryFilename)
-	if err != nil {
+	var c Compaction
+	renamed, err := c.relabel(sst, label)
+	if err = c.finish(err); err != nil {
 		return err
 	}
-	sst.Summary.Filename = newSummaryFilename
+	*sst = *renamed
 
-	err = os.Rename(sst.Filter.Filename, newFilterFilename)
-	if err != nil {
-		return err
+	return nil
+}
+
+// withLabel returns the filename with the label of the table replaced by the given one.
+func withLabel(filename string, label int) string {
+	dir, name := filepath.Split(filename)
+	match := labelRegexp.FindStringSubmatchIndex(name)
+	if match == nil {
+		return filename
 	}
-	sst.Filter.Filename = newFilterFilename
+	return fmt.Sprintf("%s%s%05d%s", dir, name[:match[2]], label, name[match[3]:])
+}
 
-	err = os.Rename(sst.MetadataFilename, newMetadataFilename)
-	if err != nil {
-		return err
+// relabel links the files of the table under the given label. The TOC file of the relabeled table has
+// a temporary name until it's published.
+func (sst *SSTable) relabel(label int) (*SSTable, error) {
+	if !labelRegexp.MatchString(sst.TOCFilename) || !labelRegexp.MatchString(sst.MetadataFilename) {
+		return nil, errors.New("malformed sstable")
+	}
+	renamed := *sst
+	renamed.TOCFilename = withLabel(sst.TOCFilename, label) + tmpSuffix
+	renamed.MetadataFilename = withLabel(sst.MetadataFilename, label)
+	renamed.Data.Filename = withLabel(sst.Data.Filename, label)
+	renamed.Index.Filename = withLabel(sst.Index.Filename, label)
+	renamed.Summary.Filename = withLabel(sst.Summary.Filename, label)
+	renamed.Filter.Filename = withLabel(sst.Filter.Filename, label)
+	if sst.HasDictionary() {
+		renamed.Dictionary.Filename = withLabel(sst.Dictionary.Filename, label)
+	}
+
+	links := map[string]string{sst.MetadataFilename: renamed.MetadataFilename}
+	for _, files := range [][2]util.BinaryFile{
+		{sst.Data.BinaryFile, renamed.Data.BinaryFile},
+		{sst.Index.BinaryFile, renamed.Index.BinaryFile},
+		{sst.Summary.BinaryFile, renamed.Summary.BinaryFile},
+		{sst.Filter.BinaryFile, renamed.Filter.BinaryFile},
+		{sst.Dictionary.BinaryFile, renamed.Dictionary.BinaryFile},
+	} {
+		if files[0].Filename != "" {
+			links[files[0].Filename] = files[1].Filename
+		}
+	}
+	var linked []string
+	unlink := func() {
+		// a link left behind would share the data of the table with a new table that gets the label
+		for _, newname := range linked {
+			os.Remove(newname)
+		}
+	}
+	for oldname, newname := range links {
+		dropCached(newname)
+		if err := os.Remove(newname); err != nil && !os.IsNotExist(err) {
+			unlink()
+			return nil, err
+		}
+		if err := os.Link(oldname, newname); err != nil {
+			unlink()
+			return nil, err
+		}
+		linked = append(linked, newname)
+		crashPoint("link")
 	}
-	sst.MetadataFilename = newMetadataFilename
 
 	// write new toc file
-	err = sst.writeTOCFile()
-	if err != nil {
-		return err
+	if err := renamed.writeTOCFile(); err != nil {
+		unlink()
+		os.Remove(renamed.TOCFilename)
+		return nil, err
 	}
 
-	return nil
+	return &renamed, nil
 }
 
 // OpenSSTableFromToc opens an SSTable from the given TOC file.
@@ -463,7 +536,7 @@ func OpenSSTableFromToc(tocPath string) (*SSTable, error) {
 		switch i {
 		case 0:
 			sstable.Data = DataBlock{
-				util.BinaryFile{
+				BinaryFile: util.BinaryFile{
 					Filename:    filename,
 					StartOffset: startOffset,
 					Size:        size,
@@ -471,7 +544,7 @@ func OpenSSTableFromToc(tocPath string) (*SSTable, error) {
 			}
 		case 1:
 			sstable.Index = IndexBlock{
-				util.BinaryFile{
+				BinaryFile: util.BinaryFile{
 					Filename:    filename,
 					StartOffset: startOffset,
 					Size:        size,
@@ -501,30 +574,114 @@ func OpenSSTableFromToc(tocPath string) (*SSTable, error) {
 		return nil, err
 	}
 
+	// optional lines: the codec of the compressed blocks, the key encoding and the dictionary block
+	scanner := bufio.NewScanner(tocFile)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 2 && fields[0] == "codec":
+			sstable.Data.Codec = fields[1]
+		case len(fields) == 2 && fields[0] == "keys" && fields[1] == "prefix":
+			sstable.Data.PrefixKeys = true
+			sstable.Index.PrefixKeys = true
+		case len(fields) == 4 && fields[0] == "dictionary":
+			startOffset, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			size, err := strconv.ParseInt(fields[2], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			sstable.Dictionary.BinaryFile = util.BinaryFile{
+				Filename:    fields[3],
+				StartOffset: startOffset,
+				Size:        size,
+			}
+		case len(fields) != 0:
+			return nil, fmt.Errorf("%s: malformed TOC line %q", tocPath, scanner.Text())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
 	return sstable, nil
 }
 
 // Size returns the total size of files that make up the SSTable in bytes.
 func (sst *SSTable) Size() int64 {
-	return sst.Data.Size + sst.Index.Size + sst.Summary.Size + sst.Filter.Size
+	return sst.Data.Size + sst.Index.Size + sst.Summary.Size + sst.Filter.Size + sst.Dictionary.Size
+}
+
+// ReadTrace describes the work done by SSTable.Read while looking up a single key.
+type ReadTrace struct {
+	FilterMaybe         bool  // false if the Bloom filter ruled the key out
+	RangeExcluded       bool  // true if the key is outside the summary key range
+	FilterBytesRead     int64 // 0 if the filter was already in memory
+	DictionaryBytesRead int64 // 0 if the dictionary was already in memory or the table has none
+	SummaryBytesRead    int64 // 0 if the summary was already in memory
+	IndexBytesRead      int64
+	DataBytesRead       int64
+	Found               bool // true if a record with the key exists in the table
+	Duration            time.Duration
 }
 
 // Read returns the record with the given key from the SSTable.
 // Returns nil if the key does not exist.
 // Returns an error if the read fails.
 func (sst *SSTable) Read(key []byte, compressionDict *compression.Dictionary) (*model.Record, error) {
+	return sst.read(key, compressionDict, &ReadTrace{})
+}
+
+// ReadWithTrace does the same as Read, and also returns a ReadTrace of the lookup.
+func (sst *SSTable) ReadWithTrace(key []byte, compressionDict *compression.Dictionary) (*model.Record, *ReadTrace, error) {
+	trace := &ReadTrace{}
+	rec, err := sst.read(key, compressionDict, trace)
+	return rec, trace, err
+}
+
+// read implements Read and fills the given trace.
+func (sst *SSTable) read(key []byte, compressionDict *compression.Dictionary, trace *ReadTrace) (*model.Record, error) {
+	start := time.Now()
+	defer func() {
+		trace.Duration = time.Since(start)
+	}()
+
 	if !sst.Filter.HasLoaded() {
-		err := sst.Filter.Load()
+		hit, err := sst.Filter.load()
 		if err != nil {
 			return nil, err
 		}
+		if !hit {
+			trace.FilterBytesRead = sst.Filter.Size
+		}
 		defer func() {
 			sst.Filter.Filter = nil
 		}()
 	}
 	if !sst.Filter.Filter.HasKey(key) {
+		sst.recordFilterCheck(false, false)
 		return nil, nil
 	}
+	trace.FilterMaybe = true
+
+	if !sst.Dictionary.HasLoaded() && sst.HasDictionary() {
+		hit, err := sst.Dictionary.load()
+		if err != nil {
+			return nil, err
+		}
+		if !hit {
+			trace.DictionaryBytesRead = sst.Dictionary.Size
+		}
+		defer func() {
+			sst.Dictionary.Dict = nil
+		}()
+	}
+	compressionDict, err := sst.dictionary(compressionDict)
+	if err != nil {
+		return nil, err
+	}
 
 	if !sst.Summary.HasRangeLoaded() {
 		err := sst.Summary.LoadRange(compressionDict)
@@ -536,18 +693,20 @@ func (sst *SSTable) Read(key []byte, compressionDict *compression.Dictionary) (*
 			sst.Summary.EndKey = nil
 		}()
 	}
-	if bytes.Compare(key, sst.Summary.StartKey) < 0 {
-		return nil, nil
-	}
-	if bytes.Compare(key, sst.Summary.EndKey) > 0 {
+	if bytes.Compare(key, sst.Summary.StartKey) < 0 || bytes.Compare(key, sst.Summary.EndKey) > 0 {
+		trace.RangeExcluded = true
+		sst.recordFilterCheck(true, false)
 		return nil, nil
 	}
 
 	if !sst.Summary.HasLoaded() {
-		err := sst.Summary.Load(compressionDict)
+		hit, err := sst.Summary.load(compressionDict)
 		if err != nil {
 			return nil, err
 		}
+		if !hit {
+			trace.SummaryBytesRead = sst.Summary.Size
+		}
 		defer func() {
 			sst.Summary.Records = nil
 		}()
@@ -557,19 +716,23 @@ func (sst *SSTable) Read(key []byte, compressionDict *compression.Dictionary) (*
 		return nil, err
 	}
 
-	ir, err := sst.Index.GetRecordWithKeyFromOffset(key, sr.Offset, compressionDict)
+	ir, n, err := sst.Index.getRecordWithKeyFromOffsetLen(key, sr.Offset, compressionDict)
+	trace.IndexBytesRead = n
 	if err != nil {
 		return nil, err
 	}
 
-	dr, err := sst.Data.GetRecordWithKeyFromOffset(key, ir.Offset, compressionDict)
+	dr, n, err := sst.Data.getRecordWithKeyFromOffsetLen(key, ir.Offset, compressionDict)
+	trace.DataBytesRead = n
 	if err != nil {
 		return nil, err
 	}
 
+	sst.recordFilterCheck(true, dr != nil)
 	if dr == nil {
 		return nil, nil
 	}
+	trace.Found = true
 
 	return &model.Record{
 		Key:       dr.Key,
@@ -579,11 +742,21 @@ func (sst *SSTable) Read(key []byte, compressionDict *compression.Dictionary) (*
 	}, nil
 }
 
+// LoadRange loads the start and end keys of the SSTable into its summary block.
+func (sst *SSTable) LoadRange(compressionDict *compression.Dictionary) error {
+	compressionDict, err := sst.dictionary(compressionDict)
+	if err != nil {
+		return err
+	}
+	return sst.Summary.LoadRange(compressionDict)
+}
+
 // BuildFromDataBlock assumes that data block is correctly created and creates all other components of the SSTable.
 func (sst *SSTable) BuildFromDataBlock(numRecords uint, compressionDict *compression.Dictionary, config *util.SSTableConfig) error {
 	if config.SingleFile {
 		sst.Index.StartOffset = sst.Data.StartOffset + sst.Data.Size
 	}
+	sst.Index.restartInterval = config.SummaryDegree // the summary records point at the restart points
 	err := sst.Index.CreateFromDataBlock(config.IndexDegree, &sst.Data, compressionDict)
 	if err != nil {
 		return err
@@ -610,6 +783,11 @@ func (sst *SSTable) BuildFromDataBlock(numRecords uint, compressionDict *compres
 	}
 	sst.Filter.Filter = nil
 
+	err = sst.writeDictionary(compressionDict, config.SingleFile)
+	if err != nil {
+		return err
+	}
+
 	files := sst.toBinaryFiles()
 	merkleTree := merkle_tree.NewMerkleTree(files, config.MerkleTreeChunkSize)
 	file, err := os.Create(sst.MetadataFilename)
@@ -633,11 +811,17 @@ func (sst *SSTable) BuildFromDataBlock(numRecords uint, compressionDict *compres
 // GetFirstRecord returns the record with the lexicographically smallest key in the SSTable,
 // as well as the offset in the file at the end of the returned record.
 func (sst *SSTable) GetFirstRecord(compressionDict *compression.Dictionary) (*model.Record, int64, error) {
-	file, err := os.Open(sst.Data.Filename)
+	compressionDict, err := sst.dictionary(compressionDict)
 	if err != nil {
 		return nil, -1, err
 	}
 
+	file, err := sst.Data.open()
+	if err != nil {
+		return nil, -1, err
+	}
+	defer file.Close()
+
 	_, err = file.Seek(sst.Data.StartOffset, 0)
 	if err != nil {
 		return nil, -1, err
@@ -667,6 +851,11 @@ func (sst *SSTable) GetFirstRecord(compressionDict *compression.Dictionary) (*mo
 
 // GetNextRecordAtKey returns the first record with key lexicographically greater or equal to key.
 func (sst *SSTable) GetNextRecordAtKey(key []byte, compressionDict *compression.Dictionary) (*model.Record, int64, error) {
+	compressionDict, err := sst.dictionary(compressionDict)
+	if err != nil {
+		return nil, -1, err
+	}
+
 	if !sst.Summary.HasRangeLoaded() {
 		err := sst.Summary.LoadRange(compressionDict)
 		if err != nil {
@@ -2,6 +2,8 @@ package sstable
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"nasp-project/model"
 	"nasp-project/util"
 	"os"
@@ -374,6 +376,63 @@ func TestSSTable_Read(t *testing.T) {
 	}
 }
 
+// TestSSTable_ReadFilterStats tests that SSTable.Read counts Bloom filter outcomes.
+func TestSSTable_ReadFilterStats(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sstable_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &util.SSTableConfig{
+		SavePath:            tmpDir,
+		SingleFile:          false,
+		IndexDegree:         2,
+		SummaryDegree:       3,
+		FilterPrecision:     0.01,
+		MerkleTreeChunkSize: 16,
+		Compression:         false,
+	}
+
+	recs := []model.Record{
+		{Key: []byte("key1"), Value: []byte("value1"), Timestamp: 1},
+		{Key: []byte("key2"), Value: []byte("value2"), Timestamp: 2},
+	}
+
+	sstable, err := CreateSSTable(recs, nil, config)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable: %v", err)
+	}
+
+	for _, key := range []string{"key1", "key2", "key1"} {
+		if _, err := sstable.Read([]byte(key), nil); err != nil {
+			t.Fatalf("Failed to read record: %v", err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := sstable.Read([]byte(fmt.Sprintf("missing%d", i)), nil); err != nil {
+			t.Fatalf("Failed to read record: %v", err)
+		}
+	}
+
+	fs := GetFilterStats(sstable.TOCFilename)
+	if fs.TruePositives != 3 {
+		t.Errorf("Expected 3 true positives, got %d", fs.TruePositives)
+	}
+	if fs.Negatives+fs.FalsePositives != 100 {
+		t.Errorf("Expected 100 negatives and false positives in total, got %d", fs.Negatives+fs.FalsePositives)
+	}
+
+	err = sstable.deleteFiles()
+	if err != nil {
+		t.Fatalf("Failed to delete files: %v", err)
+	}
+	fs = GetFilterStats(sstable.TOCFilename)
+	if fs.TruePositives != 0 || fs.FalsePositives != 0 || fs.Negatives != 0 {
+		t.Errorf("Expected stats of a deleted table to be dropped, got %+v", fs)
+	}
+}
+
 // TestMergeSSTables tests merging two SSTables.
 func TestMergeSSTables(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "sstable_test_")
@@ -564,6 +623,52 @@ func TestIterator(t *testing.T) {
 	}
 }
 
+func TestIterator_Err(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sstable_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &util.SSTableConfig{
+		SavePath:            tmpDir,
+		SingleFile:          false,
+		IndexDegree:         2,
+		SummaryDegree:       3,
+		FilterPrecision:     0.01,
+		MerkleTreeChunkSize: 16,
+		Compression:         false,
+	}
+
+	recs := []model.Record{
+		{Key: []byte("key1"), Value: []byte("value1"), Timestamp: 1},
+		{Key: []byte("key2"), Value: []byte("value2"), Timestamp: 2},
+		{Key: []byte("key3"), Value: []byte("value3"), Timestamp: 3},
+	}
+
+	sstable, err := CreateSSTable(recs, nil, config)
+	if err != nil {
+		t.Fatalf("Failed to create SSTable: %v", err)
+	}
+	modifyFile(t, sstable.Data.Filename, func(data []byte) {
+		data[bytes.Index(data, []byte("value2"))+5] = 'X'
+	})
+
+	it, err := sstable.NewIterator(nil)
+	if err != nil {
+		t.Fatalf("Failed to create iterator: %v", err)
+	}
+	if it.Err() != nil {
+		t.Errorf("Expected no error before the damaged record, got %v", it.Err())
+	}
+	if it.Next() {
+		t.Errorf("Expected the iterator to stop at the damaged record, got %s", it.Value().Key)
+	}
+	if !errors.Is(it.Err(), errBadCRC) {
+		t.Errorf("Expected errBadCRC, got %v", it.Err())
+	}
+}
+
 func TestRangeIterator(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "sstable_test_")
 	if err != nil {
@@ -796,7 +901,7 @@ func TestDataRecordGenerator(t *testing.T) {
 	}
 
 	// Create a data record generator.
-	gen, err := NewDataRecordGenerator([]*DataBlock{&table1.Data, &table2.Data}, nil)
+	gen, err := NewDataRecordGenerator([]*SSTable{table1, table2}, nil)
 	if err != nil {
 		t.Errorf("Failed to create data record generator: %v", err)
 	}
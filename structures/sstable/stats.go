@@ -0,0 +1,89 @@
+package sstable
+
+import (
+	"sync"
+)
+
+// FilterStats counts the outcomes of Bloom filter checks made by SSTable.Read for a single SSTable.
+type FilterStats struct {
+	Negatives      uint64 // filter ruled the key out
+	TruePositives  uint64 // filter said "maybe" and the key was found
+	FalsePositives uint64 // filter said "maybe" but the key was not found
+}
+
+// MergeStats contains the totals of the SSTable merges done by a Compaction.
+type MergeStats struct {
+	Merges       uint64 // number of merge operations
+	BytesRead    uint64 // total size of the input SSTables
+	BytesWritten uint64 // total size of the output SSTables
+}
+
+var stats = struct {
+	sync.Mutex
+	filters map[string]*FilterStats // by TOC filename
+}{
+	filters: map[string]*FilterStats{},
+}
+
+// recordFilterCheck updates FilterStats of the given SSTable after a Read.
+// maybe is the Bloom filter answer, found is true if the record was found in the table.
+func (sst *SSTable) recordFilterCheck(maybe, found bool) {
+	stats.Lock()
+	defer stats.Unlock()
+
+	fs, ok := stats.filters[sst.TOCFilename]
+	if !ok {
+		fs = &FilterStats{}
+		stats.filters[sst.TOCFilename] = fs
+	}
+	if !maybe {
+		fs.Negatives++
+	} else if found {
+		fs.TruePositives++
+	} else {
+		fs.FalsePositives++
+	}
+}
+
+// moveFilterStats moves the statistics of a renamed SSTable to its new TOC filename.
+func moveFilterStats(oldTOCFilename, newTOCFilename string) {
+	stats.Lock()
+	defer stats.Unlock()
+
+	if fs, ok := stats.filters[oldTOCFilename]; ok {
+		stats.filters[newTOCFilename] = fs
+		delete(stats.filters, oldTOCFilename)
+	}
+}
+
+// dropFilterStats removes the statistics of a deleted SSTable.
+func dropFilterStats(tocFilename string) {
+	stats.Lock()
+	defer stats.Unlock()
+
+	delete(stats.filters, tocFilename)
+}
+
+// recordMerge adds a single merge of SSTables to the MergeStats of the compaction.
+func (c *Compaction) recordMerge(bytesRead, bytesWritten int64) {
+	c.merges.Merges++
+	c.merges.BytesRead += uint64(bytesRead)
+	c.merges.BytesWritten += uint64(bytesWritten)
+}
+
+// GetFilterStats returns a copy of FilterStats of the SSTable with the given TOC filename.
+// Returns zero FilterStats if the table has not been read yet.
+func GetFilterStats(tocFilename string) FilterStats {
+	stats.Lock()
+	defer stats.Unlock()
+
+	if fs, ok := stats.filters[tocFilename]; ok {
+		return *fs
+	}
+	return FilterStats{}
+}
+
+// MergeStats returns the totals of the merges done by the compaction, including the committed and the aborted ones.
+func (c *Compaction) MergeStats() MergeStats {
+	return c.merges
+}
@@ -3,7 +3,9 @@ package sstable
 import (
 	bytesUtil "bytes"
 	"encoding/binary"
+	"nasp-project/structures/block_cache"
 	"nasp-project/structures/compression"
+	"nasp-project/structures/encryption"
 	"nasp-project/util"
 	"os"
 )
@@ -45,9 +47,19 @@ func (sb *SummaryBlock) HasRangeLoaded() bool {
 	return sb.StartKey != nil && sb.EndKey != nil
 }
 
-// LoadRange reads the start and end keys from the summary block file and loads them into memory.
+// LoadRange reads the start and end keys from the block cache or the summary block file and loads them into memory.
 func (sb *SummaryBlock) LoadRange(compressionDict *compression.Dictionary) error {
-	file, err := os.Open(sb.Filename)
+	if cached, ok := getCached(summaryKind, sb.Filename, sb.StartOffset); ok {
+		sb.StartKey = cached.(*SummaryBlock).StartKey
+		sb.EndKey = cached.(*SummaryBlock).EndKey
+		return nil
+	}
+	return sb.loadRange(compressionDict)
+}
+
+// loadRange reads the start and end keys from the summary block file and loads them into memory.
+func (sb *SummaryBlock) loadRange(compressionDict *compression.Dictionary) error {
+	file, err := openFile(sb.Filename)
 	if err != nil {
 		return err
 	}
@@ -97,16 +109,43 @@ func (sb *SummaryBlock) LoadRange(compressionDict *compression.Dictionary) error
 	return nil
 }
 
-// Load reads the summary block from disk and loads it into memory.
+// Load reads the summary block from the block cache or disk and loads it into memory.
 func (sb *SummaryBlock) Load(compressionDict *compression.Dictionary) error {
+	_, err := sb.load(compressionDict)
+	return err
+}
+
+// load does the same as Load, and also returns true if the summary was found in the block cache.
+func (sb *SummaryBlock) load(compressionDict *compression.Dictionary) (bool, error) {
+	if cached, ok := getCached(summaryKind, sb.Filename, sb.StartOffset); ok {
+		sb.StartKey = cached.(*SummaryBlock).StartKey
+		sb.EndKey = cached.(*SummaryBlock).EndKey
+		sb.Records = cached.(*SummaryBlock).Records
+		return true, nil
+	}
+
+	err := sb.loadRecords(compressionDict)
+	if err != nil {
+		return false, err
+	}
+	putCached(sb.Filename, sb.StartOffset, &SummaryBlock{
+		StartKey: sb.StartKey,
+		EndKey:   sb.EndKey,
+		Records:  sb.Records,
+	}, sb.Size, block_cache.High)
+	return false, nil
+}
+
+// loadRecords reads the summary block from disk and loads it into memory.
+func (sb *SummaryBlock) loadRecords(compressionDict *compression.Dictionary) error {
 	if !sb.HasRangeLoaded() {
-		err := sb.LoadRange(compressionDict)
+		err := sb.loadRange(compressionDict)
 		if err != nil {
 			return err
 		}
 	}
 
-	file, err := os.Open(sb.Filename)
+	file, err := openFile(sb.Filename)
 	if err != nil {
 		return err
 	}
@@ -179,7 +218,7 @@ func (sb *SummaryBlock) Load(compressionDict *compression.Dictionary) error {
 // The sparseDeg parameter determines how many index records are skipped between each summary record.
 // It also sets the size of the summary block.
 func (sb *SummaryBlock) CreateFromIndexBlock(sparseDeg int, ib *IndexBlock, compressionDict *compression.Dictionary) error {
-	ibFile, err := os.Open(ib.Filename)
+	ibFile, err := ib.open()
 	if err != nil {
 		return err
 	}
@@ -198,7 +237,7 @@ func (sb *SummaryBlock) CreateFromIndexBlock(sparseDeg int, ib *IndexBlock, comp
 		var recSize int64 = 0
 		var key []byte
 		if compressionDict == nil {
-			keySize, n, err := util.ReadUvarintLen(ibFile)
+			shared, keySize, n, err := readKeySizes(ibFile, ib.prefixKeys(compressionDict))
 			if err != nil {
 				return err
 			}
@@ -207,8 +246,12 @@ func (sb *SummaryBlock) CreateFromIndexBlock(sparseDeg int, ib *IndexBlock, comp
 			}
 			recSize += int64(n)
 
-			key = make([]byte, keySize)
-			_, err = ibFile.Read(key)
+			if ib.prefixKeys(compressionDict) {
+				key, err = readPrefixKey(ibFile, shared, keySize)
+			} else {
+				key = make([]byte, keySize)
+				_, err = ibFile.Read(key)
+			}
 			if err != nil {
 				return err
 			}
@@ -238,20 +281,15 @@ func (sb *SummaryBlock) CreateFromIndexBlock(sparseDeg int, ib *IndexBlock, comp
 		}
 
 		if cnt%sparseDeg == 0 {
-			offset -= ib.StartOffset
-			offset -= recSize // Start of the record
-
 			sr := SummaryRecord{
 				Key:    key,
-				Offset: offset,
+				Offset: offset - ib.StartOffset - recSize, // Start of the record
 			}
 			bytes = append(bytes, sb.writeRecord(sr, compressionDict)...)
 
 			if startKey == nil { // First key
 				startKey = key
 			}
-
-			offset += recSize
 		}
 
 		// Last key
@@ -263,7 +301,7 @@ func (sb *SummaryBlock) CreateFromIndexBlock(sparseDeg int, ib *IndexBlock, comp
 	}
 
 	// Open the file and write the summary block
-	file, err := os.OpenFile(sb.Filename, os.O_WRONLY, 0644)
+	file, err := encryption.OpenFile(sb.Filename, os.O_WRONLY, 0644)
 	if err != nil {
 		return err
 	}
@@ -323,10 +361,10 @@ func (sb *SummaryBlock) CreateFromIndexBlock(sparseDeg int, ib *IndexBlock, comp
 	return nil
 }
 
-// CreateFromIndexRecords creates a summary block from the given index records and writes it to disk.
+// CreateFromIndexRecords creates a summary block from the given index records of the index block ib and writes it to disk.
 // The sparseDeg parameter determines how many index records are skipped between each summary record.
 // It also sets the size of the summary block.
-func (sb *SummaryBlock) CreateFromIndexRecords(sparseDeg int, recs []IndexRecord, compressionDict *compression.Dictionary) error {
+func (sb *SummaryBlock) CreateFromIndexRecords(sparseDeg int, ib *IndexBlock, recs []IndexRecord, compressionDict *compression.Dictionary) error {
 	var bytes []byte
 	sumRecs := make([]SummaryRecord, 0, len(recs)/sparseDeg)
 
@@ -341,11 +379,15 @@ func (sb *SummaryBlock) CreateFromIndexRecords(sparseDeg int, recs []IndexRecord
 			cb := sb.writeRecord(sr, compressionDict)
 			bytes = append(bytes, cb...)
 		}
-		offset += int64(rec.sizeOnDisk(compressionDict))
+		var prevKey []byte
+		if cnt > 0 {
+			prevKey = ib.restartKey(cnt, recs[cnt-1].Key)
+		}
+		offset += int64(rec.sizeOnDisk(compressionDict, ib.prefixKeys(compressionDict), prevKey))
 	}
 
 	// Open the file and write the summary block
-	file, err := os.OpenFile(sb.Filename, os.O_WRONLY, 0644)
+	file, err := encryption.OpenFile(sb.Filename, os.O_WRONLY, 0644)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,93 @@
+package token_bucket
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+const limiterSize = 24
+
+// Limiter is a rate limiter based on the generic cell rate algorithm (GCRA). It behaves like a token bucket
+// of limit tokens that refills continuously, one token every interval/limit, instead of all at once per interval,
+// so there are no bursts at interval boundaries. The whole state is the theoretical arrival time (TAT),
+// the time at which the bucket is full again.
+type Limiter struct {
+	limit    int64
+	interval time.Duration
+	tat      int64 // unix nanoseconds
+}
+
+// NewLimiter creates a full Limiter that allows limit tokens per interval.
+func NewLimiter(limit int64, interval time.Duration) *Limiter {
+	return &Limiter{
+		limit:    max(limit, 1),
+		interval: interval,
+	}
+}
+
+// Limit returns the number of tokens per interval.
+func (l *Limiter) Limit() int64 {
+	return l.limit
+}
+
+// Interval returns the time in which an empty Limiter refills.
+func (l *Limiter) Interval() time.Duration {
+	return l.interval
+}
+
+// emissionInterval returns the time in which a single token refills.
+func (l *Limiter) emissionInterval() int64 {
+	return max(int64(l.interval)/l.limit, 1)
+}
+
+// Allow takes n tokens at time now and returns true, or returns false and takes nothing if fewer are available.
+// Costs larger than the bucket are capped to its size, so that they still pass once the bucket is full.
+func (l *Limiter) Allow(n int64, now time.Time) bool {
+	n = min(max(n, 1), l.limit)
+	t := now.UnixNano()
+	tat := max(l.tat, t) + n*l.emissionInterval()
+	if tat-t > int64(l.interval) {
+		return false
+	}
+	l.tat = tat
+	return true
+}
+
+// Tokens returns the number of tokens available at time now.
+func (l *Limiter) Tokens(now time.Time) int64 {
+	t := now.UnixNano()
+	return (int64(l.interval) - (max(l.tat, t) - t)) / l.emissionInterval()
+}
+
+// Full returns true if no tokens are taken at time now, i.e. the Limiter is equal to a new one.
+func (l *Limiter) Full(now time.Time) bool {
+	return l.tat <= now.UnixNano()
+}
+
+// Serialize returns the binary representation of the Limiter.
+// Layout: limit (8B) | interval, nanoseconds (8B) | TAT, unix nanoseconds (8B).
+func (l *Limiter) Serialize() []byte {
+	bytes := make([]byte, limiterSize)
+	binary.LittleEndian.PutUint64(bytes[0:8], uint64(l.limit))
+	binary.LittleEndian.PutUint64(bytes[8:16], uint64(l.interval))
+	binary.LittleEndian.PutUint64(bytes[16:24], uint64(l.tat))
+	return bytes
+}
+
+// DeserializeLimiter creates a Limiter from the output of Serialize.
+// Returns an error if data is not a serialized Limiter.
+func DeserializeLimiter(data []byte) (*Limiter, error) {
+	if len(data) != limiterSize {
+		return nil, errors.New("invalid limiter size")
+	}
+	limit := int64(binary.LittleEndian.Uint64(data[0:8]))
+	if limit < 1 {
+		return nil, errors.New("invalid limiter limit")
+	}
+	return &Limiter{
+		limit:    limit,
+		interval: time.Duration(binary.LittleEndian.Uint64(data[8:16])),
+		tat:      int64(binary.LittleEndian.Uint64(data[16:24])),
+	}, nil
+}
@@ -0,0 +1,67 @@
+package token_bucket
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(10, time.Second)
+	now := time.Unix(1000, 0)
+
+	for i := 0; i < 10; i++ {
+		if !l.Allow(1, now) {
+			t.Fatalf("Expected token %d of a full limiter to be available", i)
+		}
+	}
+	if l.Allow(1, now) {
+		t.Errorf("Expected an empty limiter to reject")
+	}
+
+	// one token refills every 100ms, there is no burst at the interval boundary
+	if !l.Allow(1, now.Add(100*time.Millisecond)) {
+		t.Errorf("Expected a token to refill after 100ms")
+	}
+	if l.Allow(1, now.Add(150*time.Millisecond)) {
+		t.Errorf("Expected no token to be available after 150ms")
+	}
+	if got := l.Tokens(now.Add(time.Second)); got != 9 {
+		t.Errorf("Expected 9 tokens after an interval, got %d", got)
+	}
+	if l.Full(now.Add(time.Second)) || !l.Full(now.Add(2*time.Second)) {
+		t.Errorf("Expected the limiter to be full again after 1.1s")
+	}
+}
+
+func TestLimiterCost(t *testing.T) {
+	l := NewLimiter(10, time.Second)
+	now := time.Unix(1000, 0)
+
+	if !l.Allow(7, now) || l.Allow(4, now) || !l.Allow(3, now) {
+		t.Errorf("Expected 7 and 3 tokens to be taken and 4 to be rejected in between")
+	}
+
+	// a cost larger than the bucket takes the whole bucket
+	l = NewLimiter(10, time.Second)
+	if !l.Allow(100, now) || l.Allow(1, now) {
+		t.Errorf("Expected a cost of 100 to take the whole bucket of 10")
+	}
+}
+
+func TestLimiterSerialization(t *testing.T) {
+	l := NewLimiter(10, time.Minute)
+	l.Allow(3, time.Now())
+
+	deserialized, err := DeserializeLimiter(l.Serialize())
+	if err != nil {
+		t.Fatalf("Failed to deserialize: %v", err)
+	}
+	if !reflect.DeepEqual(l, deserialized) {
+		t.Errorf("Deserialized Limiter is not equal to the original.\nOriginal: %+v\nDeserialized: %+v", l, deserialized)
+	}
+
+	if _, err := DeserializeLimiter(NewTokenBucket(10, 1).Serialize()); err == nil {
+		t.Errorf("Expected an error for a serialized TokenBucket")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"nasp-project/model"
+	"nasp-project/structures/encryption"
 	"nasp-project/util"
 	"os"
 	"strconv"
@@ -27,6 +28,10 @@ import (
   Key = Key data
   Value = Value data
 
+  When encryption is enabled (see encryption.SetKeyProvider), bit 1 of Tombstone is set, Key Size is 0
+  and the Value is an encryption.Seal envelope of the uvarint key length, the key and the value.
+  The CRC is then computed over the envelope. Followers of a replicated log need the same keys.
+
   Each file starts with a header of 8 bytes storing start of the first whole record in that segment
   in bytes, from the beginning of the file.
 */
@@ -47,6 +52,8 @@ const (
 
 	HeaderSize = 8
 
+	encryptedFlag = 2 // set in the Tombstone byte of encrypted records
+
 	NumberStart = 4
 	NumberEnd   = 12
 
@@ -67,6 +74,7 @@ type Record struct {
 	ValueSize uint64
 	Key       string
 	Value     []byte
+	size      uint64 // size of the decoded record on disk
 }
 
 // WAL - Write ahead log
@@ -78,6 +86,13 @@ type WAL struct {
 	logsPath             string
 	memtableIndexingPath string
 	latestFileName       string
+	segmentListener      SegmentListener
+}
+
+// SegmentListener is notified when the WAL starts writing to a new segment file or deletes an old one.
+type SegmentListener interface {
+	OnSegmentCreated(fileName string)
+	OnSegmentDeleted(fileName string)
 }
 
 // NewWAL is constructor for the Write ahead log.
@@ -152,6 +167,12 @@ func NewWAL(walConfig *util.WALConfig, memtableInstances int) (*WAL, error) {
 	}, nil
 }
 
+// SetSegmentListener sets the listener that is notified about segment creation and deletion.
+// Passing nil removes the listener.
+func (wal *WAL) SetSegmentListener(listener SegmentListener) {
+	wal.segmentListener = listener
+}
+
 // PutCommit adds put commit to the WAL buffer.
 func (wal *WAL) PutCommit(key string, value []byte) error {
 	newRecord := createRecord(key, value, false)
@@ -172,6 +193,18 @@ func (wal *WAL) DeleteCommit(key string, value []byte) error {
 	return nil
 }
 
+// RecordCommit adds a put or, if the record has a tombstone, a delete commit of the record to the WAL buffer.
+// The commit keeps the timestamp of the record, so the record is replayed as it was written.
+func (wal *WAL) RecordCommit(record *model.Record) error {
+	value := record.Value
+	if record.Tombstone {
+		value = nil
+	}
+	newRecord := createRecord(string(record.Key), value, record.Tombstone)
+	newRecord.Timestamp = record.Timestamp
+	return wal.commitRecord(newRecord)
+}
+
 // commitRecord adds record to the buffer, and calls writeBuffer if it's full.
 func (wal *WAL) commitRecord(record *Record) error {
 	wal.buffer = append(wal.buffer, record)
@@ -187,6 +220,9 @@ func (wal *WAL) commitRecord(record *Record) error {
 
 // EmptyBuffer writes everything in the buffer and clears it.
 func (wal *WAL) EmptyBuffer() error {
+	if len(wal.buffer) == 0 {
+		return nil
+	}
 	err := wal.writeBuffer()
 	if err != nil {
 		return err
@@ -244,6 +280,10 @@ func (wal *WAL) FlushedMemtable(memtableIndex int) error {
 			if err != nil {
 				return err
 			}
+			util.Logger().Debug("wal segment deleted", "file", entry.Name())
+			if wal.segmentListener != nil {
+				wal.segmentListener.OnSegmentDeleted(entry.Name())
+			}
 		}
 	}
 
@@ -301,6 +341,133 @@ func (wal *WAL) FlushedMemtable(memtableIndex int) error {
 	return nil
 }
 
+// Stats returns the number of WAL segment files on disk and their total size in bytes.
+// Records that are still in the buffer are not counted.
+func (wal *WAL) Stats() (segments int, size int64, err error) {
+	dirEntries, err := os.ReadDir(wal.logsPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, entry := range dirEntries {
+		info, err := entry.Info()
+		if err != nil {
+			return 0, 0, err
+		}
+		segments++
+		size += info.Size()
+	}
+	return segments, size, nil
+}
+
+// ErrPositionUnavailable is returned by ReadFrom when the segment containing the position was already deleted.
+var ErrPositionUnavailable = errors.New("wal position unavailable")
+
+// segmentDataSize returns the number of record bytes a single segment holds.
+func (wal *WAL) segmentDataSize() uint64 {
+	return wal.segmentSize - HeaderSize
+}
+
+// segmentFileName returns the name of the segment file with the given index.
+func segmentFileName(index uint64) string {
+	stringNumber := strconv.FormatUint(index, 10)
+	return "wal_" + strings.Repeat("0", max((NumberEnd-NumberStart)-len(stringNumber), 0)) + stringNumber + ".log"
+}
+
+// Position returns the logical position of the end of the records written to the segment files.
+// Segments are concatenated without their headers, so segment i (counting from 1) holds the positions
+// [(i-1)*(SegmentSize-HeaderSize), i*(SegmentSize-HeaderSize)). Positions never decrease, also when old segments
+// are deleted. Records that are still in the buffer are not included, see EmptyBuffer.
+func (wal *WAL) Position() (uint64, error) {
+	index, err := strconv.ParseUint(wal.latestFileName[NumberStart:NumberEnd], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	fi, err := os.Stat(wal.logsPath + wal.latestFileName)
+	if os.IsNotExist(err) {
+		return (index - 1) * wal.segmentDataSize(), nil
+	} else if err != nil {
+		return 0, err
+	}
+	return (index-1)*wal.segmentDataSize() + uint64(max(fi.Size()-HeaderSize, 0)), nil
+}
+
+// ReadFrom reads whole records written to the segment files, starting at the logical position pos, which must be
+// the start of a record (see Position). Returns the records in their binary format, which DecodeRecords decodes,
+// and the position after them. Reads about maxBytes, but at least one record if any is available.
+// Returns ErrPositionUnavailable if the segment containing pos was deleted.
+func (wal *WAL) ReadFrom(pos uint64, maxBytes int) ([]byte, uint64, error) {
+	end, err := wal.Position()
+	if err != nil {
+		return nil, pos, err
+	}
+	if pos > end {
+		return nil, pos, fmt.Errorf("%w: position %d is after the end of the log %d", ErrPositionUnavailable, pos, end)
+	}
+
+	result := make([]byte, 0)
+	for pos < end {
+		header, err := wal.readAt(pos, KeyStart)
+		if err != nil {
+			return nil, pos, err
+		}
+		keySize := binary.LittleEndian.Uint64(header[KeySizeStart:ValueSizeStart])
+		valueSize := binary.LittleEndian.Uint64(header[ValueSizeStart:KeyStart])
+		size := KeyStart + keySize + valueSize
+		if pos+size > end || len(result) > 0 && uint64(len(result))+size > uint64(maxBytes) {
+			break
+		}
+		record, err := wal.readAt(pos, size)
+		if err != nil {
+			return nil, pos, err
+		}
+		result = append(result, record...)
+		pos += size
+	}
+	return result, pos, nil
+}
+
+// readAt reads n bytes starting at the logical position pos, possibly from several segments.
+func (wal *WAL) readAt(pos uint64, n uint64) ([]byte, error) {
+	result := make([]byte, 0, n)
+	for uint64(len(result)) < n {
+		index := pos/wal.segmentDataSize() + 1
+		offset := pos%wal.segmentDataSize() + HeaderSize
+		f, err := os.Open(wal.logsPath + segmentFileName(index))
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: segment %d was deleted", ErrPositionUnavailable, index)
+		} else if err != nil {
+			return nil, err
+		}
+		chunk := make([]byte, min(n-uint64(len(result)), wal.segmentSize-offset))
+		_, err = f.ReadAt(chunk, int64(offset))
+		_ = f.Close()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, chunk...)
+		pos += uint64(len(chunk))
+	}
+	return result, nil
+}
+
+// DecodeRecords decodes records in the binary format returned by ReadFrom.
+// Returns an error if the data is truncated or a CRC doesn't match.
+func DecodeRecords(data []byte) ([]*Record, error) {
+	records := make([]*Record, 0)
+	for offset := uint64(0); offset < uint64(len(data)); {
+		record, err := decodeRecord(offset, data)
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			return nil, errors.New("failed to decode records: truncated record")
+		}
+		records = append(records, record)
+		offset += record.encodedSize()
+	}
+	return records, nil
+}
+
 // incrementWALFileName increments WAL latestFileName by one.
 func (wal *WAL) incrementWALFileName() error {
 	number, err := strconv.Atoi(wal.latestFileName[NumberStart:NumberEnd])
@@ -311,6 +478,10 @@ func (wal *WAL) incrementWALFileName() error {
 	missing := (NumberEnd - NumberStart) - len(stringNumber)
 	stringNumber = strings.Repeat("0", missing) + stringNumber
 	wal.latestFileName = wal.latestFileName[:NumberStart] + stringNumber + wal.latestFileName[NumberEnd:]
+	util.Logger().Debug("wal segment created", util.LogKeyWALIndex, number+1)
+	if wal.segmentListener != nil {
+		wal.segmentListener.OnSegmentCreated(wal.latestFileName)
+	}
 	return nil
 }
 
@@ -348,7 +519,10 @@ func (wal *WAL) writeBuffer() error {
 
 	var nextHeader uint64 = 0
 	for _, element := range wal.buffer {
-		nextSlice := wal.recordToByteArray(element)
+		nextSlice, err := wal.recordToByteArray(element)
+		if err != nil {
+			return err
+		}
 		if uint64(len(toWrite))+uint64(len(nextSlice)) > (wal.segmentSize - uint64(fSize)) {
 			var offset uint64 = 0
 			combinedSlice := append(toWrite, nextSlice...)
@@ -568,7 +742,7 @@ func (wal *WAL) GetAllRecords() ([]*model.Record, []uint32, []uint64, error) {
 				break
 			} else {
 				records = append(records, record.ToModelRecord())
-				offset += KeyStart + record.KeySize + record.ValueSize
+				offset += record.encodedSize()
 				allFileIndexes = append(allFileIndexes, uint32(currentFileIndex))
 				allByteOffsets = append(allByteOffsets, offset)
 			}
@@ -615,6 +789,11 @@ func (wal *WAL) UpdateMemtableIndexing(fileIndexes []uint32, byteOffsets []uint6
 // readRecord reads Record from slice with offset. Returns the read Record and error if any occurred. Returns nil record
 // if it can't read the whole Record from the slice.
 func (wal *WAL) readRecordFromSlice(offset uint64, slice []byte) (*Record, error) {
+	return decodeRecord(offset, slice)
+}
+
+// decodeRecord implements readRecordFromSlice.
+func decodeRecord(offset uint64, slice []byte) (*Record, error) {
 	result := &Record{}
 
 	if uint64(len(slice)) < offset+KeyStart {
@@ -626,9 +805,11 @@ func (wal *WAL) readRecordFromSlice(offset uint64, slice []byte) (*Record, error
 		return nil, nil
 	}
 
+	result.size = KeyStart + result.KeySize + result.ValueSize
 	result.CRC = binary.LittleEndian.Uint32(slice[offset+CrcStart : offset+TimestampStart])
 	result.Timestamp = binary.LittleEndian.Uint64(slice[offset+TimestampStart : offset+TombstoneStart])
-	if slice[offset+TombstoneStart] == 0 {
+	flags := slice[offset+TombstoneStart]
+	if flags&1 == 0 {
 		result.Tombstone = false
 	} else {
 		result.Tombstone = true
@@ -640,26 +821,62 @@ func (wal *WAL) readRecordFromSlice(offset uint64, slice []byte) (*Record, error
 	if util.CRC32(result.Value) != result.CRC {
 		return nil, errors.New("failed to read record of offset" + strconv.FormatUint(offset, 10) + ": CRCs don't match")
 	}
+	if flags&encryptedFlag != 0 {
+		plaintext, err := encryption.Unseal(result.Value, slice[offset+TimestampStart:offset+KeySizeStart])
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt record of offset %d: %w", offset, err)
+		}
+		keySize, n := binary.Uvarint(plaintext)
+		if n <= 0 || uint64(len(plaintext)-n) < keySize {
+			return nil, fmt.Errorf("failed to decrypt record of offset %d: invalid record", offset)
+		}
+		result.Key = string(plaintext[n : uint64(n)+keySize])
+		result.Value = plaintext[uint64(n)+keySize:]
+		result.KeySize = keySize
+		result.ValueSize = uint64(len(result.Value))
+		result.CRC = util.CRC32(result.Value)
+	}
 	return result, nil
 }
 
-// recordToByteArray converts Record to byte array.
-func (wal *WAL) recordToByteArray(record *Record) []byte {
-	result := make([]byte, 0)
-	result = binary.LittleEndian.AppendUint32(result, util.CRC32(record.Value))
-	result = binary.LittleEndian.AppendUint64(result, record.Timestamp)
+// encodedSize returns the size of a decoded record on disk, which differs from its key and value sizes
+// if the record is encrypted.
+func (rec *Record) encodedSize() uint64 {
+	return rec.size
+}
+
+// recordToByteArray converts Record to byte array, encrypting its key and value if encryption is enabled.
+func (wal *WAL) recordToByteArray(record *Record) ([]byte, error) {
+	var flags byte
 	if record.Tombstone {
-		result = append(result, byte(1))
-	} else {
-		result = append(result, byte(0))
+		flags = 1
+	}
+	key, value := []byte(record.Key), record.Value
+	if encryption.Enabled() {
+		flags |= encryptedFlag
+		plaintext := binary.AppendUvarint(nil, uint64(len(key)))
+		plaintext = append(plaintext, key...)
+		plaintext = append(plaintext, value...)
+		// the timestamp and the flags are authenticated with the envelope
+		additionalData := binary.LittleEndian.AppendUint64(nil, record.Timestamp)
+		additionalData = append(additionalData, flags)
+		envelope, err := encryption.Seal(plaintext, additionalData)
+		if err != nil {
+			return nil, err
+		}
+		key, value = nil, envelope
 	}
-	result = binary.LittleEndian.AppendUint64(result, record.KeySize)
-	result = binary.LittleEndian.AppendUint64(result, record.ValueSize)
-	result = append(result, record.Key...)
-	result = append(result, record.Value...)
-	//result = append(result, make([]byte, wal.recordSize-uint64(len(result)))...)
 
-	return result
+	result := make([]byte, 0)
+	result = binary.LittleEndian.AppendUint32(result, util.CRC32(value))
+	result = binary.LittleEndian.AppendUint64(result, record.Timestamp)
+	result = append(result, flags)
+	result = binary.LittleEndian.AppendUint64(result, uint64(len(key)))
+	result = binary.LittleEndian.AppendUint64(result, uint64(len(value)))
+	result = append(result, key...)
+	result = append(result, value...)
+
+	return result, nil
 }
 
 // createRecord constructs Record.
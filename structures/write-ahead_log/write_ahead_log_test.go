@@ -1,9 +1,12 @@
 package write_ahead_log
 
 import (
+	"errors"
+	"nasp-project/model"
 	"nasp-project/util"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -426,3 +429,129 @@ func TestWAL_writeBufferLargerRecords(t *testing.T) {
 		t.Errorf("Expected:\n%s\n"+"Got:\n%s", expectedRecs[2].ToString(), recs[2].ToString())
 	}
 }
+
+// TestWAL_ReadFrom tests reading records by their logical position across segments.
+func TestWAL_ReadFrom(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "wal_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer func(path string) {
+		_ = os.RemoveAll(path)
+	}(tmpDir)
+
+	config := &util.WALConfig{
+		SegmentSize:   64,
+		BufferSize:    100,
+		WALFolderPath: tmpDir,
+	}
+
+	wal, err := NewWAL(config, 100)
+	if err != nil {
+		t.Fatalf("Failed to create Write Ahead Log: %v", err)
+	}
+
+	// every put is 39 bytes and the delete 33, so most of them span two segments
+	for i := 0; i < 10; i++ {
+		if err := wal.PutCommit("key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))); err != nil {
+			t.Fatalf("Failed to commit Put: %v", err)
+		}
+	}
+	if err := wal.DeleteCommit("key0", nil); err != nil {
+		t.Fatalf("Failed to commit Delete: %v", err)
+	}
+
+	pos, err := wal.Position()
+	if err != nil || pos != 0 {
+		t.Errorf("Expected buffered records to be excluded from the position, got %d, %v", pos, err)
+	}
+	if err := wal.EmptyBuffer(); err != nil {
+		t.Fatalf("Failed to empty the buffer: %v", err)
+	}
+	end, err := wal.Position()
+	if err != nil || end != 10*39+33 {
+		t.Errorf("Expected position %d, got %d, %v", 10*39+33, end, err)
+	}
+
+	var recs []*Record
+	for pos := uint64(0); pos < end; {
+		data, next, err := wal.ReadFrom(pos, 100)
+		if err != nil {
+			t.Fatalf("Failed to read from %d: %v", pos, err)
+		}
+		if len(data) > 100 || next <= pos {
+			t.Fatalf("Expected at most 100 bytes of progress, got %d bytes up to %d", len(data), next)
+		}
+		batch, err := DecodeRecords(data)
+		if err != nil {
+			t.Fatalf("Failed to decode records: %v", err)
+		}
+		recs = append(recs, batch...)
+		pos = next
+	}
+	if len(recs) != 11 || recs[9].Key != "key9" || string(recs[9].Value) != "value9" || !recs[10].Tombstone {
+		t.Errorf("Expected 10 puts and a delete, got %d records", len(recs))
+	}
+
+	if data, next, err := wal.ReadFrom(end, 100); err != nil || len(data) != 0 || next != end {
+		t.Errorf("Expected nothing to read at the end, got %d bytes, %d, %v", len(data), next, err)
+	}
+	if err := os.Remove(filepath.Join(wal.logsPath, segmentFileName(1))); err != nil {
+		t.Fatalf("Failed to remove a segment: %v", err)
+	}
+	if _, _, err := wal.ReadFrom(0, 100); !errors.Is(err, ErrPositionUnavailable) {
+		t.Errorf("Expected ErrPositionUnavailable, got %v", err)
+	}
+}
+
+// TestWAL_RecordCommit tests that the records committed with WAL.RecordCommit are replayed with their timestamps.
+func TestWAL_RecordCommit(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "wal_test_")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer func(path string) {
+		_ = os.RemoveAll(path)
+	}(tmpDir)
+
+	config := &util.WALConfig{
+		SegmentSize:   128,
+		BufferSize:    8,
+		WALFolderPath: tmpDir,
+	}
+
+	wal, err := NewWAL(config, 100)
+	if err != nil {
+		t.Fatalf("Failed to create Write Ahead Log: %v", err)
+	}
+	expected := []model.Record{
+		{Key: []byte("key1"), Value: []byte("value1"), Timestamp: 42},
+		{Key: []byte("key2"), Tombstone: true, Timestamp: 7},
+	}
+	for _, rec := range expected {
+		if err := wal.RecordCommit(&rec); err != nil {
+			t.Fatalf("Failed to commit the record: %v", err)
+		}
+	}
+	if err := wal.EmptyBuffer(); err != nil {
+		t.Fatalf("Failed to empty the buffer: %v", err)
+	}
+
+	wal, err = NewWAL(config, 100)
+	if err != nil {
+		t.Fatalf("Failed to open Write Ahead Log: %v", err)
+	}
+	recs, _, _, err := wal.GetAllRecords()
+	if err != nil {
+		t.Fatalf("Failed to read the records: %v", err)
+	}
+	if len(recs) != len(expected) {
+		t.Fatalf("Expected %d records, got %d", len(expected), len(recs))
+	}
+	for i, rec := range recs {
+		if string(rec.Key) != string(expected[i].Key) || string(rec.Value) != string(expected[i].Value) ||
+			rec.Tombstone != expected[i].Tombstone || rec.Timestamp != expected[i].Timestamp {
+			t.Errorf("Expected %v, got %v", expected[i], *rec)
+		}
+	}
+}
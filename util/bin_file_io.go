@@ -2,11 +2,11 @@ package util
 
 import (
 	"encoding/binary"
-	"os"
+	"io"
 )
 
 // WriteUvarint writes encodes num using variable-length encoding and writes it to file.
-func WriteUvarint(file *os.File, num uint64) error {
+func WriteUvarint(file io.Writer, num uint64) error {
 	bytes := make([]byte, binary.MaxVarintLen64)
 	n := binary.PutUvarint(bytes, num)
 	_, err := file.Write(bytes[:n])
@@ -18,7 +18,7 @@ func WriteUvarint(file *os.File, num uint64) error {
 
 // WriteUvarintLen writes encodes num using variable-length encoding, writes it to file
 // and returns the number of bytes written to file.
-func WriteUvarintLen(file *os.File, num uint64) (int, error) {
+func WriteUvarintLen(file io.Writer, num uint64) (int, error) {
 	bytes := make([]byte, binary.MaxVarintLen64)
 	n := binary.PutUvarint(bytes, num)
 	n, err := file.Write(bytes[:n])
@@ -30,7 +30,7 @@ func WriteUvarintLen(file *os.File, num uint64) (int, error) {
 
 // ReadUvarint reads variable-length encoded number from file.
 // The file pointer is set to the end of the read value bytes.
-func ReadUvarint(file *os.File) (uint64, error) {
+func ReadUvarint(file io.ReadSeeker) (uint64, error) {
 	bytes := make([]byte, binary.MaxVarintLen64)
 	k, err := file.Read(bytes)
 	if err != nil {
@@ -49,7 +49,7 @@ func ReadUvarint(file *os.File) (uint64, error) {
 // ReadUvarintLen reads variable-length encoded number from file
 // and returns the decoded number and the number of bytes read.
 // The file pointer is set to the end of the read value bytes.
-func ReadUvarintLen(file *os.File) (uint64, int, error) {
+func ReadUvarintLen(file io.ReadSeeker) (uint64, int, error) {
 	bytes := make([]byte, binary.MaxVarintLen64)
 	k, err := file.Read(bytes)
 	if err != nil {
@@ -16,6 +16,12 @@ type Config struct {
 	LSMTree     LSMTreeConfig     `yaml:"LSMTree"`
 	Cache       CacheConfig       `yaml:"Cache"`
 	TokenBucket TokenBucketConfig `yaml:"TokenBucket"`
+	Metrics     MetricsConfig     `yaml:"Metrics"`
+	Server      ServerConfig      `yaml:"Server"`
+	Auth        AuthConfig        `yaml:"Auth"`
+	Replication ReplicationConfig `yaml:"Replication"`
+	Cluster     ClusterConfig     `yaml:"Cluster"`
+	Encryption  EncryptionConfig  `yaml:"Encryption"`
 }
 
 type WALConfig struct {
@@ -49,6 +55,9 @@ type SSTableConfig struct {
 	FilterPrecision     float64 `yaml:"filterPrecision" validate:"float_between"`
 	MerkleTreeChunkSize int64   `yaml:"merkleTreeChunkSize" validate:"gte=1"`
 	CompressionFilename string  `yaml:"compressionFilename"`
+	BlockCompression    string  `yaml:"blockCompression" validate:"omitempty,oneof=none flate zlib snappy"`
+	BlockSize           int     `yaml:"blockSize" validate:"gte=1"`
+	KeyPrefixEncoding   bool    `yaml:"keyPrefixEncoding"`
 }
 
 type LSMTreeConfig struct {
@@ -69,12 +78,83 @@ type LeveledConfig struct {
 }
 
 type CacheConfig struct {
-	MaxSize uint64 `yaml:"maxSize" validate:"gte=1"`
+	MaxSize        uint64 `yaml:"maxSize" validate:"gte=1"`
+	BlockCacheSize uint64 `yaml:"blockCacheSize"` // bytes of SSTable blocks shared by all stores of the process, 0 turns it off
+	MaxOpenFiles   int    `yaml:"maxOpenFiles"`   // SSTable files kept open for reading by all stores of the process, 0 turns it off
 }
 
 type TokenBucketConfig struct {
-	MaxTokenSize int64 `yaml:"maxTokenSize" validate:"gte=1"`
-	Interval     int64 `yaml:"interval" validate:"gte=1"`
+	MaxTokenSize        int64               `yaml:"maxTokenSize" validate:"gte=1"` // tokens per interval for reads
+	Interval            int64               `yaml:"interval" validate:"gte=1"`
+	WriteMaxTokenSize   int64               `yaml:"writeMaxTokenSize" validate:"gte=0"`   // tokens per interval for writes, 0 for MaxTokenSize
+	ScanRecordsPerToken int64               `yaml:"scanRecordsPerToken" validate:"gte=0"` // a scan costs one token plus one per this many records, 0 for one token
+	CheckpointInterval  int64               `yaml:"checkpointInterval" validate:"gte=0"`  // seconds between writes of the in-memory buckets to the store while serving or in the console, 0 to write them on close only
+	Clients             []ClientLimitConfig `yaml:"clients" validate:"dive"`
+}
+
+// ClientLimitConfig overrides the bucket sizes of a single client of the network servers.
+type ClientLimitConfig struct {
+	Client            string `yaml:"client" validate:"required"` // user name, or IP address of an unauthenticated client
+	MaxTokenSize      int64  `yaml:"maxTokenSize" validate:"gte=1"`
+	WriteMaxTokenSize int64  `yaml:"writeMaxTokenSize" validate:"gte=0"`
+}
+
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address"`
+}
+
+type ServerConfig struct {
+	RESTAddress      string `yaml:"restAddress"`      // address of the HTTP server in serve mode, empty to disable
+	RESPAddress      string `yaml:"respAddress"`      // address of the Redis protocol server in serve mode, empty to disable
+	MemcachedAddress string `yaml:"memcachedAddress"` // address of the memcached protocol server in serve mode, empty to disable
+}
+
+type ReplicationConfig struct {
+	Address       string `yaml:"address"`       // address the WAL is streamed to followers on in serve mode, empty to disable
+	LeaderAddress string `yaml:"leaderAddress"` // replication address of the leader, makes the store a read-only follower
+}
+
+// ClusterConfig makes the store a member of a Raft cluster in serve mode, see app.KeyValueStore.StartCluster.
+type ClusterConfig struct {
+	ID      string              `yaml:"id"`      // ID of this member, empty to disable
+	Address string              `yaml:"address"` // address of the HTTP server for the Raft RPCs and the replicated /kv/ API
+	Dir     string              `yaml:"dir"`     // directory of the Raft log and snapshots
+	Peers   []ClusterPeerConfig `yaml:"peers" validate:"dive"`
+}
+
+// ClusterPeerConfig is a member of the Raft cluster. The peers of every member must list all members, itself included.
+type ClusterPeerConfig struct {
+	ID      string `yaml:"id" validate:"required"`
+	Address string `yaml:"address" validate:"required"` // address of the HTTP server of the member
+}
+
+// EncryptionConfig enables the encryption at rest of the WAL, the SSTable blocks and the compression dictionary.
+// Keys are rotated by adding a new key, tables are re-encrypted with it as compactions rewrite them.
+type EncryptionConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Provider string `yaml:"provider" validate:"omitempty,oneof=file env"`
+	KeyFile  string `yaml:"keyFile"` // file with a key per line in the form id:hex-encoded-key, the last one is current
+	KeyEnv   string `yaml:"keyEnv"`  // environment variable with comma separated keys in the same form
+}
+
+type AuthConfig struct {
+	Enabled bool         `yaml:"enabled"` // require the clients of the network servers to authenticate
+	Users   []UserConfig `yaml:"users" validate:"dive"`
+}
+
+// UserConfig is a user of the network servers. A user authenticates with the password, the token or either of them.
+type UserConfig struct {
+	Name        string             `yaml:"name" validate:"required"`
+	Password    string             `yaml:"password"`
+	Token       string             `yaml:"token"`
+	Permissions []PermissionConfig `yaml:"permissions" validate:"dive"`
+}
+
+// PermissionConfig grants access to the keys starting with Prefix, an empty prefix matches all keys.
+type PermissionConfig struct {
+	Prefix string `yaml:"prefix"`
+	Access string `yaml:"access" validate:"oneof=read write admin"`
 }
 
 var config = &Config{
@@ -103,6 +183,9 @@ var config = &Config{
 		FilterPrecision:     0.01,
 		MerkleTreeChunkSize: 1024,
 		CompressionFilename: "CompressionInfo.bin",
+		BlockCompression:    "",
+		BlockSize:           4096,
+		KeyPrefixEncoding:   false,
 	},
 	LSMTree: LSMTreeConfig{
 		MaxLevel:            4,
@@ -117,11 +200,34 @@ var config = &Config{
 		},
 	},
 	Cache: CacheConfig{
-		MaxSize: 1024,
+		MaxSize:        1024,
+		BlockCacheSize: 8 << 20, // 8 Mb
+		MaxOpenFiles:   500,
 	},
 	TokenBucket: TokenBucketConfig{
-		MaxTokenSize: 1024,
-		Interval:     60,
+		MaxTokenSize:        1024,
+		Interval:            60,
+		WriteMaxTokenSize:   0,
+		ScanRecordsPerToken: 100,
+		CheckpointInterval:  10,
+	},
+	Metrics: MetricsConfig{
+		Enabled: false,
+		Address: "localhost:9090",
+	},
+	Server: ServerConfig{
+		RESTAddress:      "localhost:8080",
+		RESPAddress:      "localhost:6379",
+		MemcachedAddress: "localhost:11211",
+	},
+	Auth: AuthConfig{
+		Enabled: false,
+	},
+	Encryption: EncryptionConfig{
+		Enabled:  false,
+		Provider: "file",
+		KeyFile:  "./keys.txt",
+		KeyEnv:   "NASP_ENCRYPTION_KEYS",
 	},
 }
 
@@ -134,7 +240,8 @@ func GetConfig() *Config {
 func LoadConfig(path string) *Config {
 	file, err := os.ReadFile(path)
 	if err != nil {
-		log.Println("warning: The configuration file is invalid. Using the default configuration.")
+		Logger().Warn("the configuration file can't be read, using the default configuration",
+			"path", path, LogKeyError, err)
 		return config
 	}
 	loadedConfig := Config{}
@@ -145,7 +252,8 @@ func LoadConfig(path string) *Config {
 	err = validate.Struct(loadedConfig)
 
 	if err != nil {
-		log.Println("warning: The configuration file is invalid. Using the default configuration.")
+		Logger().Warn("the configuration file is invalid, using the default configuration",
+			"path", path, LogKeyError, err)
 	} else {
 		config = &loadedConfig
 	}
@@ -2,11 +2,18 @@ package util
 
 const ConfigPath = "config.yaml"
 
-const RateLimiterKey = "__TB_RATE_LIMIT__"
+const RateLimiterKey = "__TB_RATE_LIMIT__" // prefix of the token buckets of the clients
 
 const BloomFilterPrefix = "__BF_"
 const CountMinSketchPrefix = "__CMS_"
 const HyperLogLogPrefix = "__HLL_"
 const SimHashPrefix = "__SH_"
+const MemcachedPrefix = "__MC_"
+const AuthPrefix = "__AUTH_"
+const AuthUserPrefix = AuthPrefix + "USER_"
+const AuthTokenPrefix = AuthPrefix + "TOKEN_"
+const AuthIndexKey = AuthPrefix + "INDEX"
+const ReplicationPrefix = "__REPL_"
+const ReplicationPositionKey = ReplicationPrefix + "POSITION" // WAL position of the leader applied by a follower
 
 const LSMFirstLevelNum = 1
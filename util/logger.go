@@ -0,0 +1,45 @@
+package util
+
+import (
+	"io"
+	"log/slog"
+	"sync/atomic"
+)
+
+// Keys of the structured fields attached to log records.
+const (
+	LogKeyLevel    = "level_num"
+	LogKeyLabel    = "table_label"
+	LogKeyWALIndex = "wal_file_index"
+	LogKeyError    = "error"
+)
+
+var logger atomic.Pointer[slog.Logger]
+
+func init() {
+	logger.Store(slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+// Logger returns the logger used for all engine diagnostics.
+// Returns a logger that discards everything if SetLogger is not called.
+func Logger() *slog.Logger {
+	return logger.Load()
+}
+
+// SetLogger sets the logger used for all engine diagnostics. Passing nil restores the quiet default logger.
+func SetLogger(l *slog.Logger) {
+	if l == nil {
+		l = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	logger.Store(l)
+}
+
+// NewConsoleLogger returns a text logger writing to w.
+// It logs warnings and errors, and also debug and info records if verbose is true.
+func NewConsoleLogger(w io.Writer, verbose bool) *slog.Logger {
+	level := slog.LevelWarn
+	if verbose {
+		level = slog.LevelDebug
+	}
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: level}))
+}